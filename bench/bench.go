@@ -0,0 +1,226 @@
+// Package bench measures the search quality and speed of the stores on a
+// dataset of base vectors and queries, such as the SIFT and GIST datasets
+// of the ANN benchmarks, read with the TEXMEX loaders of the vecio package.
+//
+// The base vectors are inserted under their position as key, and a search
+// is scored against the ids of the exact nearest neighbours of its query:
+// those of the dataset's ground truth file, or computed by brute force.
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"vectorDb/flat"
+	"vectorDb/ivf"
+	"vectorDb/store"
+	"vectorDb/vecio"
+)
+
+// Dataset is a set of base vectors to index and of queries to run.
+type Dataset struct {
+	Base    [][]float32
+	Queries [][]float32
+	// Neighbors holds, for each query, the ids of its nearest base vectors,
+	// nearest first. The id of a base vector is its position in Base.
+	Neighbors [][]int32
+}
+
+// LoadDataset reads the base vectors and queries of a dataset from .fvecs
+// or .bvecs files and its ground truth from an .ivecs file. Without a
+// ground truth file, groundTruth is empty and Neighbors is nil.
+func LoadDataset(base, queries, groundTruth string) (Dataset, error) {
+	var dataset Dataset
+	var err error
+	if dataset.Base, err = readVectors(base); err != nil {
+		return Dataset{}, err
+	}
+	if dataset.Queries, err = readVectors(queries); err != nil {
+		return Dataset{}, err
+	}
+	if len(dataset.Base) == 0 || len(dataset.Queries) == 0 {
+		return Dataset{}, errors.New("the dataset has no base vectors or no queries")
+	}
+	if len(dataset.Base[0]) != len(dataset.Queries[0]) {
+		return Dataset{}, fmt.Errorf("base vectors of dimension %d and queries of dimension %d", len(dataset.Base[0]), len(dataset.Queries[0]))
+	}
+	if groundTruth == "" {
+		return dataset, nil
+	}
+	if dataset.Neighbors, err = vecio.ReadIvecs(groundTruth, 0); err != nil {
+		return Dataset{}, err
+	}
+	if len(dataset.Neighbors) != len(dataset.Queries) {
+		return Dataset{}, fmt.Errorf("ground truth for %d queries, not %d", len(dataset.Neighbors), len(dataset.Queries))
+	}
+	return dataset, nil
+}
+
+// readVectors reads a .fvecs or .bvecs file.
+func readVectors(path string) ([][]float32, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".fvecs":
+		return vecio.ReadFvecs(path, 0)
+	case ".bvecs":
+		return vecio.ReadBvecs(path, 0)
+	}
+	return nil, fmt.Errorf("%s is not an .fvecs or .bvecs file", path)
+}
+
+// GroundTruth returns the ids of the k nearest base vectors of each query
+// under the named metric, nearest first, computed by brute force.
+func GroundTruth(base, queries [][]float32, k int, metric string) ([][]int32, error) {
+	index, err := flat.NewFlatIndex(metric)
+	if err != nil {
+		return nil, err
+	}
+	for id, vector := range base {
+		if err := index.Insert(strconv.Itoa(id), vector); err != nil {
+			return nil, err
+		}
+	}
+	neighbors := make([][]int32, len(queries))
+	for i, query := range queries {
+		results, err := index.Search(query, k)
+		if err != nil {
+			return nil, err
+		}
+		neighbors[i] = make([]int32, len(results))
+		for j, result := range results {
+			id, _ := strconv.Atoi(result.Key)
+			neighbors[i][j] = int32(id)
+		}
+	}
+	return neighbors, nil
+}
+
+// Recall returns the fraction of the k nearest neighbours found in the
+// keys a search returned, the keys being the ids of the base vectors.
+func Recall(keys []string, neighbors []int32, k int) float64 {
+	k = min(k, len(neighbors))
+	if k == 0 {
+		return 1
+	}
+	found := 0
+	for _, key := range keys {
+		id, err := strconv.Atoi(key)
+		if err == nil && slices.Contains(neighbors[:k], int32(id)) {
+			found++
+		}
+	}
+	return float64(found) / float64(k)
+}
+
+// Result holds the measures of a store on a dataset.
+type Result struct {
+	K int
+	// Recall is the mean recall@K of the queries.
+	Recall float64
+	// QPS is the number of queries answered per second, one at a time.
+	QPS float64
+	// P50 and P99 are percentiles of the latency of the queries.
+	P50, P99 time.Duration
+	// Build is the time taken to insert the base vectors, train the index
+	// if needed and save it.
+	Build time.Duration
+	// Memory is the growth of the live heap over the build, an estimate of
+	// the memory the collection takes.
+	Memory uint64
+}
+
+// Run builds a collection of the dataset's base vectors in the store,
+// saving its files under storeName, runs the queries for their k nearest
+// neighbours and measures the store. The collection is created with the
+// named metric and dropped afterwards. Without ground truth in the dataset,
+// it is computed by brute force.
+func Run(s store.Store, storeName string, dataset Dataset, k int, metric string) (Result, error) {
+	neighbors := dataset.Neighbors
+	if neighbors == nil {
+		var err error
+		if neighbors, err = GroundTruth(dataset.Base, dataset.Queries, k, metric); err != nil {
+			return Result{}, err
+		}
+	}
+	result := Result{K: k}
+	if err := s.CreateCollection(storeName, store.CollectionConfig{Metric: metric, Dim: len(dataset.Base[0])}); err != nil {
+		return Result{}, err
+	}
+	defer s.DropCollection(storeName)
+
+	before := heapAlloc()
+	start := time.Now()
+	if err := build(s, storeName, dataset.Base); err != nil {
+		return Result{}, err
+	}
+	result.Build = time.Since(start)
+	if after := heapAlloc(); after > before {
+		result.Memory = after - before
+	}
+
+	latencies := make([]time.Duration, len(dataset.Queries))
+	var total time.Duration
+	for i, query := range dataset.Queries {
+		start := time.Now()
+		keys, err := s.Search(storeName, query, k)
+		latencies[i] = time.Since(start)
+		if err != nil {
+			return Result{}, err
+		}
+		total += latencies[i]
+		result.Recall += Recall(keys, neighbors[i], k)
+	}
+	result.Recall /= float64(len(dataset.Queries))
+	result.QPS = float64(len(dataset.Queries)) / total.Seconds()
+	slices.Sort(latencies)
+	result.P50 = percentile(latencies, 0.50)
+	result.P99 = percentile(latencies, 0.99)
+	return result, nil
+}
+
+// build inserts the base vectors in bulk if the store supports it, trains
+// the index if it needs it and saves the collection.
+func build(s store.Store, storeName string, base [][]float32) error {
+	keys := make([]string, len(base))
+	for id := range keys {
+		keys[id] = strconv.Itoa(id)
+	}
+	if bulk, ok := s.(store.BulkInserter); ok {
+		if err := bulk.BulkInsert(storeName, base, keys, nil); err != nil {
+			return err
+		}
+	} else {
+		for id, vector := range base {
+			if err := s.Insert(storeName, vector, keys[id]); err != nil {
+				return err
+			}
+		}
+	}
+	if trainer, ok := s.(store.Trainer); ok {
+		// The ivf indexes train themselves once they hold enough vectors.
+		if err := trainer.Train(storeName); err != nil && !errors.Is(err, ivf.ErrAlreadyTrained) {
+			return err
+		}
+	}
+	return s.Save(storeName)
+}
+
+// heapAlloc returns the size of the live heap after a garbage collection.
+func heapAlloc() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// percentile returns the p-th percentile of sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[min(int(p*float64(len(sorted))), len(sorted)-1)]
+}
@@ -0,0 +1,182 @@
+// Package catalog records the collections of a data directory: the store
+// type each one uses, its metric, dimension and parameters, the embedding
+// model its vectors come from and when it was created.
+//
+// The catalog is kept in catalog.json at the root of the directory, next
+// to the store files of the collections, and rewritten atomically on every
+// change.
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"vectorDb/storefile"
+)
+
+// FileName is the name of the catalog file in a data directory.
+const FileName = "catalog.json"
+
+// Collection describes a collection of the catalog.
+type Collection struct {
+	Name string `json:"name"`
+	// Type is the store type, as given to the use command: hnsw, lsh,
+	// minhash, flat, ivf, ivfpq or vamana.
+	Type string `json:"type"`
+	// Metric names the distance function, and Dim is the dimension of the
+	// vectors, or 0 until the collection is first saved with some.
+	Metric string `json:"metric,omitempty"`
+	Dim    int    `json:"dim,omitempty"`
+	// Params holds the parameters the collection was created with, such as
+	// m for hnsw. Those left out have their default value.
+	Params map[string]string `json:"params,omitempty"`
+	// Model names the embedding model of the vectors, empty for stores
+	// that index the keys' text.
+	Model   string    `json:"model,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// Catalog is the catalog of a data directory.
+type Catalog struct {
+	dir         string
+	collections map[string]Collection
+}
+
+// Open reads the catalog of the data directory dir, creating the directory
+// if needed. A directory without a catalog file has an empty catalog.
+func Open(dir string) (*Catalog, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	c := &Catalog{dir: dir, collections: map[string]Collection{}}
+	data, err := os.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var collections []Collection
+	if err := json.Unmarshal(data, &collections); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", FileName, err)
+	}
+	for _, collection := range collections {
+		c.collections[collection.Name] = collection
+	}
+	return c, nil
+}
+
+// Dir returns the data directory of the catalog.
+func (c *Catalog) Dir() string {
+	return c.dir
+}
+
+// Path returns the prefix of the files of the named collection, which the
+// stores take as its storeName.
+func (c *Catalog) Path(name string) string {
+	return filepath.Join(c.dir, name)
+}
+
+// Get returns the named collection.
+func (c *Catalog) Get(name string) (Collection, bool) {
+	collection, ok := c.collections[name]
+	return collection, ok
+}
+
+// List returns the collections of the catalog sorted by name.
+func (c *Catalog) List() []Collection {
+	collections := make([]Collection, 0, len(c.collections))
+	for _, name := range slices.Sorted(maps.Keys(c.collections)) {
+		collections = append(collections, c.collections[name])
+	}
+	return collections
+}
+
+// CheckName returns an error unless name can name a collection: the
+// collection's files are named after it in the data directory, so it must
+// not be empty nor hold path separators or "..".
+func CheckName(name string) error {
+	if name == "" {
+		return errors.New("collection has no name")
+	}
+	if strings.ContainsAny(name, `/\`) || strings.Contains(name, "..") {
+		return fmt.Errorf("invalid collection name %q", name)
+	}
+	return nil
+}
+
+// Put adds the collection to the catalog, or replaces the one of the same
+// name, and saves the catalog.
+func (c *Catalog) Put(collection Collection) error {
+	if err := CheckName(collection.Name); err != nil {
+		return err
+	}
+	previous, existed := c.collections[collection.Name]
+	c.collections[collection.Name] = collection
+	if err := c.save(); err != nil {
+		if existed {
+			c.collections[collection.Name] = previous
+		} else {
+			delete(c.collections, collection.Name)
+		}
+		return err
+	}
+	return nil
+}
+
+// Remove removes the named collection from the catalog, saves the catalog
+// and reports whether the collection was there. The files of the
+// collection are left to the caller.
+func (c *Catalog) Remove(name string) (bool, error) {
+	collection, ok := c.collections[name]
+	if !ok {
+		return false, nil
+	}
+	delete(c.collections, name)
+	if err := c.save(); err != nil {
+		c.collections[name] = collection
+		return false, err
+	}
+	return true, nil
+}
+
+// Rename renames the named collection in the catalog, saves the catalog
+// and reports whether the collection was there. It fails if newName is
+// taken. The files of the collection are left to the caller.
+func (c *Catalog) Rename(oldName, newName string) (bool, error) {
+	if err := CheckName(newName); err != nil {
+		return false, err
+	}
+	collection, ok := c.collections[oldName]
+	if !ok {
+		return false, nil
+	}
+	if _, taken := c.collections[newName]; taken {
+		return false, fmt.Errorf("collection %s already exists", newName)
+	}
+	delete(c.collections, oldName)
+	collection.Name = newName
+	c.collections[newName] = collection
+	if err := c.save(); err != nil {
+		delete(c.collections, newName)
+		collection.Name = oldName
+		c.collections[oldName] = collection
+		return false, err
+	}
+	return true, nil
+}
+
+func (c *Catalog) save() error {
+	data, err := json.MarshalIndent(c.List(), "", "  ")
+	if err != nil {
+		return err
+	}
+	return storefile.WriteFile(filepath.Join(c.dir, FileName), append(data, '\n'))
+}
@@ -2,22 +2,46 @@ package cli
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
+	"maps"
 	"os"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
+	"vectorDb/bench"
+	"vectorDb/catalog"
 	"vectorDb/client"
 	"vectorDb/db"
+	"vectorDb/distance"
+	"vectorDb/hnsw"
 	"vectorDb/store"
+	"vectorDb/storefile"
+	"vectorDb/vecio"
+	"vectorDb/wal"
 
 	"github.com/spf13/cobra"
 )
 
 var vectorDb *db.Db
 var vectorClient client.Client
+
+// dataDir is the directory holding the files of the collections and their
+// catalog, set with the --data-dir flag.
+var dataDir string
+var vectorCatalog *catalog.Catalog
 var rootCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
+		var err error
+		vectorCatalog, err = catalog.Open(dataDir)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
 		runInteractiveMode()
 	},
 }
@@ -27,9 +51,32 @@ var commands = map[string]func([]string){
 	"help": func(args []string) {
 		fmt.Println("Available commands:")
 		fmt.Println(" help    - Show this help")
-		fmt.Println(" use storeName indexType -creates a database with either lsh or hnsw as the underlying data structure")
+		fmt.Println(" use storeName indexType -creates a database with lsh, hnsw, flat, ivf, ivfpq or minhash as the underlying data structure")
+		fmt.Println(" use storeName -opens a database of the catalog with the index type it was created with")
+		fmt.Println(" use storeName hnsw m efConstruction efSearch -creates an hnsw database with the given graph parameters")
+		fmt.Println(" use storeName vamana -creates a disk-resident graph database for collections larger than memory, with changes merged into it on save")
+		fmt.Println(" use storeName hnsw-mapped|flat-mapped -opens a frozen hnsw or flat database read-only, searched in place from disk")
 		fmt.Println(" search storeName key -searches for the key in the database")
+		fmt.Println(" range storeName radius maxResults key -searches for everything within radius of the key, 0 maxResults for no limit")
+		fmt.Println(" import storeName file -inserts every line of the file as a key, in bulk when the database supports it")
+		fmt.Println(" import storeName file jsonl|csv|npy -inserts the keys and vectors of an export, in batches, the format defaulting to the file extension")
+		fmt.Println(" export storeName file [jsonl|csv|npy] -writes the keys and vectors of a database, an npy array getting its keys in a .keys file")
 		fmt.Println(" save storename -saves the database to disk")
+		fmt.Println(" list -lists the databases of the catalog of the data directory")
+		fmt.Println(" describe storename -shows the index type, metric, dimension, parameters, embedding model and creation time of a database")
+		fmt.Println(" drop storename -removes a database from the catalog and deletes its files")
+		fmt.Println(" rename storename newname -renames a database in the catalog and its files")
+		fmt.Println(" retain n -keeps the n previous versions of every saved file as snapshots")
+		fmt.Println(" wal always|interval|none [interval] -sets when the write-ahead log of the databases used next is synced to disk")
+		fmt.Println(" train storename -trains the index of an ivf or ivfpq database on the vectors inserted so far")
+		fmt.Println(" compress storename subspaces|int8|binary -compresses an hnsw database with a product, int8 or mean-centred binary quantizer")
+		fmt.Println(" stats storename -shows the node and tombstone counts of an hnsw database")
+		fmt.Println(" compact storename -removes the tombstones left by deletes from an hnsw database")
+		fmt.Println(" check storename [repair] -checks the graph of the hnsw database in use, and repairs and saves it if asked")
+		fmt.Println(" freeze storename -saves an hnsw or flat database in the read-only layout opened by hnsw-mapped and flat-mapped")
+		fmt.Println(" thaw storename -converts a frozen database back and saves it for the hnsw or flat store")
+		fmt.Println(" bench types k base queries [groundtruth.ivecs] [metric] -measures recall@k, QPS, latency, build time and memory of the comma separated store types, or all, on .fvecs/.bvecs files")
+		fmt.Println(" verify storename -checks the header and checksums of the saved files of a database")
 		fmt.Println("  exit    - Exit the application")
 
 		fmt.Println("  version - Show version information")
@@ -43,10 +90,46 @@ var commands = map[string]func([]string){
 		os.Exit(0)
 	},
 	"use": func(args []string) {
-		switch strings.ToLower(args[1]) {
+		if len(args) < 1 {
+			log.Println("usage: use storeName [indexType] [parameters]")
+			return
+		}
+		name := strings.ToLower(args[0])
+		if err := catalog.CheckName(name); err != nil {
+			log.Println(err)
+			return
+		}
+		collection, known := vectorCatalog.Get(name)
+		typ, params := "", []string(nil)
+		if len(args) > 1 {
+			typ, params = strings.ToLower(args[1]), args[2:]
+		}
+		switch {
+		case typ == "" && !known:
+			log.Printf("no collection %s in the catalog, give its index type", name)
+			return
+		case typ == "":
+			typ = collection.Type
+		case known && typ != collection.Type && typ != collection.Type+"-mapped":
+			log.Printf("%s is a %s collection", name, collection.Type)
+			return
+		}
+		if typ == "hnsw" && len(params) == 0 {
+			params = hnswParamArgs(collection.Params)
+		}
+		storeName := storePath(name)
+		switch typ {
 		case "lsh":
 			lshStore, err := store.NewLshStore()
-			lshStore.Load(strings.ToLower(args[0]))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			err = lshStore.Load(storeName)
+			if errors.Is(err, store.ErrCollectionNotFound) {
+				// Never saved: start the collection empty.
+				err = lshStore.CreateCollection(storeName, store.CollectionConfig{})
+			}
 			if err != nil {
 				log.Println(err)
 				return
@@ -54,25 +137,516 @@ var commands = map[string]func([]string){
 			vectorDb.Store = lshStore
 		case "hnsw":
 			hnswStore, err := store.NewHnswStore()
-			hnswStore.Load(strings.ToLower(args[0]))
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			// Optional graph parameters: m efConstruction efSearch.
+			if len(params) > 0 {
+				params, err := parseHnswParams(params)
+				if err != nil {
+					log.Println(err)
+					return
+				}
+				err = hnswStore.CreateCollection(storeName, store.CollectionConfig{Params: params})
+				if err != nil {
+					log.Println(err)
+					return
+				}
+			}
+			err = hnswStore.Load(storeName)
 			if err != nil {
 				log.Println(err)
 				return
 			}
 			vectorDb.Store = hnswStore
+		case "minhash":
+			minHashStore, err := store.NewMinHashStore()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			err = minHashStore.Load(storeName)
+			if errors.Is(err, store.ErrCollectionNotFound) {
+				// Never saved: start the collection empty.
+				err = minHashStore.CreateCollection(storeName, store.CollectionConfig{})
+			}
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			vectorDb.Store = minHashStore
+		case "flat":
+			flatStore, err := store.NewFlatStore()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			err = flatStore.Load(storeName)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			vectorDb.Store = flatStore
+		case "ivf":
+			ivfStore, err := store.NewIvfStore()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			err = ivfStore.Load(storeName)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			vectorDb.Store = ivfStore
+		case "ivfpq":
+			ivfPqStore, err := store.NewIvfPqStore()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			err = ivfPqStore.Load(storeName)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			vectorDb.Store = ivfPqStore
+		case "vamana":
+			vamanaStore, err := store.NewVamanaStore()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			err = vamanaStore.Load(storeName)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			vectorDb.Store = vamanaStore
+		case "hnsw-mapped", "flat-mapped":
+			newStore := store.NewMappedHnswStore
+			if typ == "flat-mapped" {
+				newStore = store.NewMappedFlatStore
+			}
+			mappedStore, err := newStore()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			err = mappedStore.Load(storeName)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			vectorDb.Store = mappedStore
 		default:
-			log.Printf("store of type %s not availible", args[0])
+			log.Printf("store of type %s not availible", typ)
+			return
+		}
+		if !known {
+			if err := vectorCatalog.Put(newCollection(name, typ, params)); err != nil {
+				log.Println(err)
+			}
 		}
 	},
 	"save": func(args []string) {
-		err := vectorDb.Store.Save(strings.ToLower(args[0]))
+		name := strings.ToLower(args[0])
+		err := vectorDb.Store.Save(storePath(name))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		err = recordHeader(name)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"list": func(args []string) {
+		collections := vectorCatalog.List()
+		if len(collections) == 0 {
+			fmt.Printf("no collections in %s\n", vectorCatalog.Dir())
+		}
+		for _, collection := range collections {
+			fmt.Printf("%s\t%s\tdim %d\t%s\n", collection.Name, collection.Type, collection.Dim, collection.Metric)
+		}
+	},
+	"describe": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: describe storeName")
+			return
+		}
+		collection, ok := vectorCatalog.Get(strings.ToLower(args[0]))
+		if !ok {
+			fmt.Printf("no collection %s in the catalog\n", strings.ToLower(args[0]))
+			return
+		}
+		fmt.Printf("name: %s\ntype: %s\nmetric: %s\ndimension: %d\n",
+			collection.Name, collection.Type, collection.Metric, collection.Dim)
+		for _, param := range slices.Sorted(maps.Keys(collection.Params)) {
+			fmt.Printf("%s: %s\n", param, collection.Params[param])
+		}
+		if collection.Model != "" {
+			fmt.Printf("embedding model: %s\n", collection.Model)
+		}
+		fmt.Printf("created: %s\n", collection.Created.Format(time.RFC3339))
+	},
+	"drop": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: drop storeName")
+			return
+		}
+		name := strings.ToLower(args[0])
+		collection, ok := vectorCatalog.Get(name)
+		if !ok {
+			fmt.Printf("no collection %s in the catalog\n", name)
+			return
+		}
+		_, err := vectorCatalog.Remove(name)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		// The current store may hold the collection, or another one may.
+		if vectorDb.Store != nil {
+			err = vectorDb.Store.DropCollection(storePath(name))
+			if err != nil && !errors.Is(err, store.ErrCollectionNotFound) && !errors.Is(err, store.ErrReadOnly) {
+				log.Println(err)
+				return
+			}
+		}
+		err = store.RemoveFiles(storePath(name), store.Suffixes(collection.Type))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"rename": func(args []string) {
+		if len(args) < 2 {
+			log.Println("usage: rename storeName newName")
+			return
+		}
+		name, newName := strings.ToLower(args[0]), strings.ToLower(args[1])
+		if err := catalog.CheckName(newName); err != nil {
+			log.Println(err)
+			return
+		}
+		collection, ok := vectorCatalog.Get(name)
+		if !ok {
+			fmt.Printf("no collection %s in the catalog\n", name)
+			return
+		}
+		if _, taken := vectorCatalog.Get(newName); taken {
+			log.Printf("collection %s already exists", newName)
+			return
+		}
+		// The files are renamed first, and back if the catalog can't be
+		// saved, so that the catalog never names missing files.
+		if err := renameCollection(name, newName, collection.Type); err != nil {
+			log.Println(err)
+			return
+		}
+		if _, err := vectorCatalog.Rename(name, newName); err != nil {
+			log.Println(err)
+			if err := renameCollection(newName, name, collection.Type); err != nil {
+				log.Println(err)
+			}
+		}
+	},
+	"train": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: train storeName")
+			return
+		}
+		trainer, ok := vectorDb.Store.(store.Trainer)
+		if !ok {
+			log.Println("the current store does not need training")
+			return
+		}
+		err := trainer.Train(storePath(args[0]))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"compress": func(args []string) {
+		if len(args) < 2 {
+			log.Println("usage: compress storeName subspaces|int8|binary")
+			return
+		}
+		hnswStore, ok := vectorDb.Store.(*store.HnswStore)
+		if !ok {
+			log.Println("only hnsw stores can be compressed")
+			return
+		}
+		storeName := storePath(args[0])
+		mode := strings.ToLower(args[1])
+		if mode == hnsw.QuantizationInt8 || mode == hnsw.QuantizationBinary {
+			err := hnswStore.Quantize(storeName, mode)
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+		subspaces, err := strconv.Atoi(mode)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		err = hnswStore.CompressPQ(storeName, subspaces)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"stats": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: stats storeName")
+			return
+		}
+		hnswStore, ok := vectorDb.Store.(*store.HnswStore)
+		if !ok {
+			log.Println("only hnsw stores report stats")
+			return
+		}
+		stats, err := hnswStore.Stats(storePath(args[0]))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("nodes: %d\ntombstones: %d (%.1f%%)\ncompactions: %d\n",
+			stats.Nodes, stats.Tombstones, 100*stats.TombstoneRatio, stats.Compactions)
+	},
+	"compact": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: compact storeName")
+			return
+		}
+		hnswStore, ok := vectorDb.Store.(*store.HnswStore)
+		if !ok {
+			log.Println("only hnsw stores can be compacted")
+			return
+		}
+		if err := hnswStore.Compact(storePath(args[0])); err != nil {
+			log.Println(err)
+		}
+	},
+	"check": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: check storeName [repair]")
+			return
+		}
+		// Check the store in use, which owns the collection's write-ahead
+		// log, rather than a second copy loaded from disk.
+		checker, ok := vectorDb.Store.(*store.HnswStore)
+		if !ok {
+			log.Println("only hnsw stores can be checked")
+			return
+		}
+		storeName := storePath(args[0])
+		report, err := checker.Validate(storeName)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		printReport(report)
+		if report.OK() || len(args) < 2 || strings.ToLower(args[1]) != "repair" {
+			return
+		}
+		repaired, err := checker.Repair(storeName)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("repaired: entry point %v, %d dangling links, %d asymmetric links, %d orphans\n",
+			repaired.Entry, repaired.Dangling, repaired.Asymmetric, repaired.Orphans)
+		report, err = checker.Validate(storeName)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		printReport(report)
+		err = checker.Save(storeName)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"freeze": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: freeze storeName")
+			return
+		}
+		freezer, ok := vectorDb.Store.(store.Freezer)
+		if !ok {
+			log.Println("only hnsw and flat stores can be frozen")
+			return
+		}
+		err := freezer.SaveMapped(storePath(args[0]))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"thaw": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: thaw storeName")
+			return
+		}
+		thawer, ok := vectorDb.Store.(store.Thawer)
+		if !ok {
+			log.Println("only hnsw-mapped and flat-mapped stores can be thawed")
+			return
+		}
+		err := thawer.Thaw(storePath(args[0]))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"bench": func(args []string) {
+		if len(args) < 4 {
+			log.Println("usage: bench types k base queries [groundtruth.ivecs] [metric]")
+			return
+		}
+		types := slices.Sorted(maps.Keys(benchStores))
+		if args[0] != "all" {
+			types = strings.Split(args[0], ",")
+		}
+		k, err := strconv.Atoi(args[1])
+		if err != nil || k <= 0 {
+			log.Printf("invalid k %s", args[1])
+			return
+		}
+		groundTruth, metric := "", "euclidean"
+		for _, arg := range args[4:] {
+			if strings.HasSuffix(arg, ".ivecs") {
+				groundTruth = arg
+			} else {
+				metric = arg
+			}
+		}
+		dataset, err := bench.LoadDataset(args[2], args[3], groundTruth)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		dir, err := os.MkdirTemp("", "bench")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer os.RemoveAll(dir)
+		fmt.Printf("%d base vectors of dimension %d, %d queries, %s\n", len(dataset.Base), len(dataset.Base[0]), len(dataset.Queries), metric)
+		table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(table, "store\trecall@%d\tQPS\tp50\tp99\tbuild\tmemory\n", k)
+		for _, typ := range types {
+			newStore, ok := benchStores[typ]
+			if !ok {
+				log.Printf("cannot benchmark %s, expected one of %v", typ, slices.Sorted(maps.Keys(benchStores)))
+				continue
+			}
+			s, err := newStore()
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			result, err := bench.Run(s, filepath.Join(dir, typ), dataset, k, metric)
+			if err != nil {
+				log.Printf("%s: %v", typ, err)
+				continue
+			}
+			fmt.Fprintf(table, "%s\t%.4f\t%.0f\t%v\t%v\t%v\t%.1f MiB\n", typ, result.Recall, result.QPS,
+				result.P50, result.P99, result.Build.Round(time.Millisecond), float64(result.Memory)/(1<<20))
+		}
+		table.Flush()
+	},
+	"verify": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: verify storeName")
+			return
+		}
+		storeName := storePath(args[0])
+		found := false
+		for _, suffix := range store.FileSuffixes {
+			path := storeName + suffix + ".store"
+			if stat, err := os.Stat(path); err != nil || stat.Size() == 0 {
+				continue
+			}
+			found = true
+			info, err := storefile.Verify(path)
+			if err != nil {
+				fmt.Printf("%s: %v\n", path, err)
+				continue
+			}
+			fmt.Printf("%s: ok, %s index version %d, dim %d, metric %s, sections %v\n",
+				path, info.Header.Type, info.Header.Version, info.Header.Dim, info.Header.Metric, info.Sections)
+		}
+		if !found {
+			fmt.Printf("no saved files for %s\n", storeName)
+		}
+	},
+	"retain": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: retain n")
+			return
+		}
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			log.Println("retain takes a number of snapshots, 0 for none")
+			return
+		}
+		storefile.Retention = n
+	},
+	"wal": func(args []string) {
+		if len(args) < 1 {
+			log.Println("usage: wal always|interval|none [interval]")
+			return
+		}
+		policy, err := wal.ParseSyncPolicy(strings.ToLower(args[0]))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		options := wal.Options{Sync: policy}
+		if len(args) > 1 {
+			options.Interval, err = time.ParseDuration(args[1])
+			if err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		store.WriteAheadLog = &options
+	},
+	"range": func(args []string) {
+		if len(args) < 4 {
+			log.Println("usage: range storeName radius maxResults key")
+			return
+		}
+		storeName := storePath(args[0])
+		radius, err := strconv.ParseFloat(args[1], 32)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		maxResults, err := strconv.Atoi(args[2])
 		if err != nil {
 			log.Println(err)
 			return
 		}
+		query := strings.Join(args[3:], " ")
+		results, err := vectorDb.SearchRange(storeName, query, float32(radius), maxResults)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		for _, result := range results {
+			log.Println(result)
+		}
 	},
 	"insert": func(args []string) {
-		storeName := strings.ToLower(args[0])
+		storeName := storePath(args[0])
 		for _, key := range args[1:] {
 			err := vectorDb.Insert(storeName, key)
 			if err != nil {
@@ -81,8 +655,72 @@ var commands = map[string]func([]string){
 			}
 		}
 	},
+	"import": func(args []string) {
+		if len(args) < 2 {
+			log.Println("usage: import storeName file [jsonl|csv|npy|lines]")
+			return
+		}
+		storeName := storePath(args[0])
+		format, err := fileFormat(args[1], args[2:], true)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		if format != "" {
+			reader, err := vecio.Open(args[1], format)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			defer reader.Close()
+			_, err = vectorDb.Import(storeName, reader, db.ImportBatchSize, func(done int) {
+				fmt.Printf("\rimported %d", done)
+			})
+			fmt.Println()
+			if err != nil {
+				log.Println(err)
+			}
+			return
+		}
+		keys, err := readKeys(args[1])
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		err = vectorDb.BulkInsert(storeName, keys, func(done, total int) {
+			fmt.Printf("\rimported %d/%d", done, total)
+		})
+		fmt.Println()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+	},
+	"export": func(args []string) {
+		if len(args) < 2 {
+			log.Println("usage: export storeName file [jsonl|csv|npy]")
+			return
+		}
+		format, err := fileFormat(args[1], args[2:], false)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		writer, err := vecio.Create(args[1], format)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		n, err := vectorDb.Export(storePath(args[0]), writer)
+		err = errors.Join(err, writer.Close())
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		fmt.Printf("exported %d vectors to %s\n", n, args[1])
+	},
 	"delete": func(args []string) {
-		storeName := strings.ToLower(args[0])
+		storeName := storePath(args[0])
 		for _, key := range args[1:] {
 			_, err := vectorDb.Delete(storeName, key)
 			if err != nil {
@@ -92,7 +730,7 @@ var commands = map[string]func([]string){
 		}
 	},
 	"search": func(args []string) {
-		storeName := strings.ToLower(args[0])
+		storeName := storePath(args[0])
 
 		limit, err := strconv.Atoi(args[1])
 		if err != nil {
@@ -113,6 +751,166 @@ var commands = map[string]func([]string){
 	},
 }
 
+// parseHnswParams parses the m, efConstruction and efSearch arguments of
+// use, any of which may be omitted from the end, into the parameters of
+// CreateCollection.
+func parseHnswParams(args []string) (map[string]string, error) {
+	if len(args) > len(hnswParamNames) {
+		return nil, fmt.Errorf("expected at most %d hnsw parameters", len(hnswParamNames))
+	}
+	params := make(map[string]string, len(args))
+	for i, arg := range args {
+		if _, err := strconv.Atoi(arg); err != nil {
+			return nil, fmt.Errorf("invalid hnsw parameter %q: %w", arg, err)
+		}
+		params[hnswParamNames[i]] = arg
+	}
+	return params, nil
+}
+
+// hnswParamNames names the hnsw parameters of use in the catalog, in the
+// order use takes them.
+var hnswParamNames = []string{"m", "efConstruction", "efSearch"}
+
+// hnswParamArgs returns the hnsw parameters recorded in the catalog as
+// arguments of use.
+func hnswParamArgs(params map[string]string) []string {
+	var args []string
+	for _, name := range hnswParamNames {
+		value, ok := params[name]
+		if !ok {
+			break
+		}
+		args = append(args, value)
+	}
+	return args
+}
+
+// storePath returns the storeName of the named collection: the prefix of
+// its files in the data directory.
+func storePath(name string) string {
+	return vectorCatalog.Path(strings.ToLower(name))
+}
+
+// renameCollection renames the files of the named collection of the given
+// type, through the current store if it holds the collection.
+func renameCollection(name, newName, typ string) error {
+	if vectorDb.Store != nil {
+		err := vectorDb.Store.RenameCollection(storePath(name), storePath(newName))
+		if !errors.Is(err, store.ErrCollectionNotFound) && !errors.Is(err, store.ErrReadOnly) {
+			return err
+		}
+	}
+	return store.RenameFiles(storePath(name), storePath(newName), store.Suffixes(typ))
+}
+
+// newCollection returns the catalog entry of a collection created by use.
+// Its dimension is recorded when it is first saved.
+func newCollection(name, typ string, params []string) catalog.Collection {
+	collection := catalog.Collection{
+		Name:    name,
+		Type:    strings.TrimSuffix(typ, "-mapped"),
+		Metric:  distance.Canonical(""),
+		Model:   client.GeminiModel,
+		Created: time.Now(),
+	}
+	if typ == "minhash" {
+		collection.Metric, collection.Model = "jaccard", ""
+	}
+	if typ == "hnsw" && len(params) > 0 {
+		collection.Params = make(map[string]string, len(params))
+		for i, param := range params {
+			collection.Params[hnswParamNames[i]] = param
+		}
+	}
+	return collection
+}
+
+// recordHeader records in the catalog the dimension and metric of the
+// named collection, read from the header of its saved file.
+func recordHeader(name string) error {
+	collection, ok := vectorCatalog.Get(name)
+	if !ok {
+		return nil
+	}
+	header, err := storefile.ReadHeader(storePath(name) + "_" + collection.Type + ".store")
+	if err != nil {
+		return err
+	}
+	if header.Dim == collection.Dim && header.Metric == collection.Metric {
+		return nil
+	}
+	collection.Dim, collection.Metric = header.Dim, header.Metric
+	return vectorCatalog.Put(collection)
+}
+
+// printReport prints the result of an hnsw graph check, level by level.
+func printReport(report hnsw.Report[string]) {
+	if report.Entry != nil {
+		fmt.Printf("entry point: %s\n", *report.Entry)
+	}
+	if report.BadEntry {
+		fmt.Println("the entry point is missing from the top level")
+	}
+	for i, level := range report.Levels {
+		fmt.Printf("level %d: %d nodes, %d reachable, %d orphans, %d dangling links, %d asymmetric links\n",
+			i, level.Nodes, level.Reachable, len(level.Orphans), len(level.Dangling), len(level.Asymmetric))
+		degrees := slices.Sorted(maps.Keys(level.Degrees))
+		histogram := make([]string, len(degrees))
+		for j, degree := range degrees {
+			histogram[j] = fmt.Sprintf("%d:%d", degree, level.Degrees[degree])
+		}
+		fmt.Printf("  degrees: %s\n", strings.Join(histogram, " "))
+	}
+	if report.OK() {
+		fmt.Println("ok")
+	}
+}
+
+// fileFormat returns the format of the file of import or export, given
+// after it or from its extension. For import, lines or an unknown
+// extension give "", for a file of keys to embed one per line.
+func fileFormat(path string, args []string, keys bool) (vecio.Format, error) {
+	if len(args) > 0 {
+		if keys && args[0] == "lines" {
+			return "", nil
+		}
+		return vecio.ParseFormat(args[0])
+	}
+	format, ok := vecio.FormatOf(path)
+	if !ok && !keys {
+		return "", fmt.Errorf("cannot tell the format of %s from its extension, give one of %v", path, vecio.Formats)
+	}
+	return format, nil
+}
+
+// benchStores creates the stores bench compares.
+var benchStores = map[string]func() (store.Store, error){
+	"lsh":    store.NewLshStore,
+	"hnsw":   store.NewHnswStore,
+	"flat":   store.NewFlatStore,
+	"ivf":    store.NewIvfStore,
+	"ivfpq":  store.NewIvfPqStore,
+	"vamana": store.NewVamanaStore,
+}
+
+// readKeys returns the non-empty lines of the file at path.
+func readKeys(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var keys []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if key := strings.TrimSpace(scanner.Text()); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys, scanner.Err()
+}
+
 func runInteractiveMode() {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println("Welcome to VectoyDb")
@@ -149,6 +947,9 @@ func runInteractiveMode() {
 func Execute() {
 	vectorClient = client.NewGeminiClient()
 	vectorDb = db.NewVectorDbWithClient(vectorClient)
+	// Log every mutation between saves so that a crash loses none of them.
+	store.WriteAheadLog = &wal.Options{Sync: wal.SyncAlways}
+	rootCmd.Flags().StringVar(&dataDir, "data-dir", ".", "directory holding the files of the collections and their catalog")
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
@@ -10,6 +10,9 @@ import (
 	"google.golang.org/api/option"
 )
 
+// GeminiModel is the embedding model GeminiClient uses.
+const GeminiModel = "gemini-embedding-exp-03-07"
+
 // GeminiClient provides access to Gemini API
 type GeminiClient struct {
 	Model  *genai.EmbeddingModel
@@ -30,7 +33,7 @@ func NewGeminiClient() Client {
 		log.Fatal(err)
 	}
 
-	model := client.EmbeddingModel("gemini-embedding-exp-03-07")
+	model := client.EmbeddingModel(GeminiModel)
 	return &GeminiClient{
 		Model:  model,
 	}
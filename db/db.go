@@ -1,6 +1,7 @@
 package db
 
 import (
+	"fmt"
 	"vectorDb/client"
 	"vectorDb/store"
 )
@@ -23,14 +24,26 @@ func NewVectorDbWithClientAndStore(client client.Client,store store.Store) *Db {
 }
 
 
+// embed returns the embedding of text, or nil when the store indexes
+// raw text and has no use for one.
+func (db *Db) embed(text string) ([]float32, error) {
+	if _, ok := db.Store.(store.TextStore); ok {
+		return nil, nil
+	}
+	return db.Client.Embed(text)
+}
+
 func (db *Db) Search(storeName string, query string, limit int) ([]string, error) {
+	if limit == -1 {
+		limit = 3
+	}
+	if textStore, ok := db.Store.(store.TextStore); ok {
+		return textStore.SearchText(storeName, query, limit)
+	}
 	embedding, err := db.Client.Embed(query)
 	if err != nil {
 		return nil, err
 	}
-	if limit == -1 {
-		limit = 3
-	}
 	queryResult,err := db.Store.Search(storeName,(embedding),limit)
 	if err!=nil{
 		return nil,err
@@ -40,8 +53,19 @@ func (db *Db) Search(storeName string, query string, limit int) ([]string, error
 
 }
 
+// SearchRange returns the keys within radius of the query text's embedding,
+// nearest first, and at most maxResults of them if maxResults > 0.
+// See store.Store for the units of radius.
+func (db *Db) SearchRange(storeName string, query string, radius float32, maxResults int) ([]string, error) {
+	embedding, err := db.embed(query)
+	if err != nil {
+		return nil, err
+	}
+	return db.Store.SearchRange(storeName, embedding, radius, maxResults)
+}
+
 func (db *Db) Insert(storeName string, key string) error {
-	embedding, err := db.Client.Embed(key)
+	embedding, err := db.embed(key)
 	if err != nil {
 		return err
 	}
@@ -49,8 +73,40 @@ func (db *Db) Insert(storeName string, key string) error {
 	return err
 }
 
+// BulkInsert embeds and inserts all the keys, in one batch when the store
+// is a store.BulkInserter and one by one otherwise. progress, if not nil, is
+// called with the number of keys inserted so far and the total. As with
+// Import, the collection of a store.Spiller is saved whenever SpillSize
+// vectors are pending.
+func (db *Db) BulkInsert(storeName string, keys []string, progress func(done, total int)) error {
+	bulk, ok := db.Store.(store.BulkInserter)
+	if !ok {
+		for i, key := range keys {
+			if err := db.Insert(storeName, key); err != nil {
+				return fmt.Errorf("inserting %q: %w", key, err)
+			}
+			if err := db.spill(storeName); err != nil {
+				return err
+			}
+			if progress != nil {
+				progress(i+1, len(keys))
+			}
+		}
+		return nil
+	}
+	embeddings := make([][]float32, len(keys))
+	for i, key := range keys {
+		embedding, err := db.embed(key)
+		if err != nil {
+			return fmt.Errorf("embedding %q: %w", key, err)
+		}
+		embeddings[i] = embedding
+	}
+	return bulk.BulkInsert(storeName, embeddings, keys, progress)
+}
+
 func (db *Db) Lookup(storeName string, key string) ([]float32, error) {
-	embedding, err := db.Client.Embed(key)
+	embedding, err := db.embed(key)
 	if err != nil {
 		return nil, err
 	}
@@ -62,7 +118,7 @@ func (db *Db) Lookup(storeName string, key string) ([]float32, error) {
 }
 
 func (db *Db) Delete(storeName string, key string) (bool, error) {
-	embedding, err := db.Client.Embed(key)
+	embedding, err := db.embed(key)
 	if err != nil {
 		return false,err
 	}
@@ -80,3 +136,23 @@ func (db *Db) Load(storeName string) (error) {
 	return err
 }
 
+
+func (db *Db) CreateCollection(storeName string, config store.CollectionConfig) error {
+	return db.Store.CreateCollection(storeName, config)
+}
+
+func (db *Db) ListCollections() []string {
+	return db.Store.ListCollections()
+}
+
+func (db *Db) DescribeCollection(storeName string) (store.CollectionInfo, error) {
+	return db.Store.DescribeCollection(storeName)
+}
+
+func (db *Db) DropCollection(storeName string) error {
+	return db.Store.DropCollection(storeName)
+}
+
+func (db *Db) RenameCollection(oldName string, newName string) error {
+	return db.Store.RenameCollection(oldName, newName)
+}
@@ -0,0 +1,97 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"vectorDb/store"
+	"vectorDb/vecio"
+)
+
+// ImportBatchSize is the number of records Import inserts at once.
+const ImportBatchSize = 1000
+
+// SpillSize is the number of unsaved vectors after which bulk loads save
+// the collection of a store.Spiller, so that they don't outgrow memory.
+const SpillSize = 100000
+
+// Export writes every vector of the collection and its key to w, and
+// returns the number written. The store must be a store.Exporter.
+func (db *Db) Export(storeName string, w vecio.Writer) (int, error) {
+	exporter, ok := db.Store.(store.Exporter)
+	if !ok {
+		return 0, errors.New("the current store cannot export its vectors")
+	}
+	n := 0
+	err := exporter.Export(storeName, func(key string, embedding []float32) error {
+		if err := w.Write(vecio.Record{Key: key, Vector: embedding}); err != nil {
+			return fmt.Errorf("writing %q: %w", key, err)
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// Import inserts the records of r into the collection as they are, without
+// embedding their keys, in batches of batchSize: with one BulkInsert each
+// when the store is a store.BulkInserter and one by one otherwise. It
+// returns the number of records inserted, and progress, if not nil, is
+// called with it after each batch. The collection of a store.Spiller is
+// saved whenever SpillSize vectors are pending.
+func (db *Db) Import(storeName string, r vecio.Reader, batchSize int, progress func(done int)) (int, error) {
+	if batchSize <= 0 {
+		return 0, fmt.Errorf("invalid batch size %d", batchSize)
+	}
+	done := 0
+	keys := make([]string, 0, batchSize)
+	embeddings := make([][]float32, 0, batchSize)
+	for {
+		record, err := r.Read()
+		if err != nil && err != io.EOF {
+			return done, err
+		}
+		if err == nil {
+			keys = append(keys, record.Key)
+			embeddings = append(embeddings, record.Vector)
+		}
+		if len(keys) == batchSize || (err == io.EOF && len(keys) > 0) {
+			if err := db.insertBatch(storeName, embeddings, keys); err != nil {
+				return done, err
+			}
+			if err := db.spill(storeName); err != nil {
+				return done, err
+			}
+			done += len(keys)
+			keys, embeddings = keys[:0], embeddings[:0]
+			if progress != nil {
+				progress(done)
+			}
+		}
+		if err == io.EOF {
+			return done, nil
+		}
+	}
+}
+
+func (db *Db) insertBatch(storeName string, embeddings [][]float32, keys []string) error {
+	if bulk, ok := db.Store.(store.BulkInserter); ok {
+		return bulk.BulkInsert(storeName, embeddings, keys, nil)
+	}
+	for i, key := range keys {
+		if err := db.Store.Insert(storeName, embeddings[i], key); err != nil {
+			return fmt.Errorf("inserting %q: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// spill saves the collection of a store.Spiller once SpillSize vectors are
+// pending.
+func (db *Db) spill(storeName string) error {
+	spiller, ok := db.Store.(store.Spiller)
+	if !ok || spiller.Pending(storeName) < SpillSize {
+		return nil
+	}
+	return db.Store.Save(storeName)
+}
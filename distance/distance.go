@@ -0,0 +1,164 @@
+// Package distance holds the distance functions shared by every index.
+//
+// Every function returns a distance: smaller values mean closer vectors, so
+// similarity measures are turned around (cosine distance is 1 - cosine
+// similarity, inner product distance is the negated inner product).
+package distance
+
+import (
+	"math"
+	"reflect"
+)
+
+// Func computes the distance between two vectors of the same dimension.
+type Func func(a, b []float32) float32
+
+const (
+	// NameEuclidean is the L2 distance.
+	NameEuclidean = "euclidean"
+	// NameSquareDistance is the squared L2 distance. It ranks like L2 but
+	// skips the square root.
+	NameSquareDistance = "squareDistance"
+	// NameManhattan is the L1 distance.
+	NameManhattan = "manhattan"
+	// NameCosine is one minus the cosine similarity.
+	NameCosine = "cosine"
+	// NameNormalizedCosine is cosine distance for vectors normalised to unit
+	// length, computed as one minus their inner product. Indexes normalise
+	// vectors on insert and query when using it.
+	NameNormalizedCosine = "normalizedCosine"
+	// NameInnerProduct is the negated inner product, for maximum inner
+	// product search.
+	NameInnerProduct = "innerProduct"
+	// NameHamming is the number of dimensions in which two vectors differ,
+	// meant for binary vectors stored as 0/1 values.
+	NameHamming = "hamming"
+)
+
+var funcs = map[string]Func{
+	NameEuclidean:        Euclidean,
+	NameSquareDistance:   SquareDistance,
+	NameManhattan:        Manhattan,
+	NameCosine:           Cosine,
+	NameNormalizedCosine: NormalizedCosine,
+	NameInnerProduct:     NegativeInnerProduct,
+	NameHamming:          Hamming,
+}
+
+// aliases maps alternative names to the registered name they stand for.
+var aliases = map[string]string{
+	"l2":   NameEuclidean,
+	"l2sq": NameSquareDistance,
+	"l1":   NameManhattan,
+	"ip":   NameInnerProduct,
+}
+
+// Canonical resolves an alias to the registered name it stands for.
+// An empty name resolves to NameEuclidean, the default metric.
+func Canonical(name string) string {
+	if name == "" {
+		return NameEuclidean
+	}
+	if canonical, ok := aliases[name]; ok {
+		return canonical
+	}
+	return name
+}
+
+// Lookup returns the distance function registered under name or one of its aliases.
+func Lookup(name string) (Func, bool) {
+	fn, ok := funcs[Canonical(name)]
+	return fn, ok
+}
+
+// Name returns the registered name of a distance function.
+func Name(fn Func) (string, bool) {
+	fnptr := reflect.ValueOf(fn).Pointer()
+	for name, f := range funcs {
+		if reflect.ValueOf(f).Pointer() == fnptr {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// NeedsNormalization reports whether vectors must be normalised to unit
+// length before being compared with the named metric.
+func NeedsNormalization(name string) bool {
+	return Canonical(name) == NameNormalizedCosine
+}
+
+// IsInnerProduct reports whether the named metric is an inner product
+// distance, which decomposes over sub-vectors as a sum of negated partial
+// inner products rather than of squared differences.
+func IsInnerProduct(name string) bool {
+	name = Canonical(name)
+	return name == NameInnerProduct || name == NameNormalizedCosine
+}
+
+// Normalize returns a copy of v scaled to unit length.
+// The zero vector is returned unchanged.
+func Normalize(v []float32) []float32 {
+	out := make([]float32, len(v))
+	norm := float32(math.Sqrt(float64(Dot(v, v))))
+	if norm == 0 {
+		copy(out, v)
+		return out
+	}
+	for i, x := range v {
+		out[i] = x / norm
+	}
+	return out
+}
+
+// Dot returns the inner product of a and b.
+func Dot(a, b []float32) float32 {
+	return dotKernel(a, b)
+}
+
+// Euclidean returns the L2 distance between a and b.
+func Euclidean(a, b []float32) float32 {
+	return float32(math.Sqrt(float64(SquareDistance(a, b))))
+}
+
+// SquareDistance returns the squared L2 distance between a and b.
+// It's computationally cheaper than Euclidean and ranks the same way.
+func SquareDistance(a, b []float32) float32 {
+	return squareDistanceKernel(a, b)
+}
+
+// Manhattan returns the L1 distance between a and b.
+func Manhattan(a, b []float32) float32 {
+	return ManhattanGeneric(a, b)
+}
+
+// Cosine returns one minus the cosine similarity of a and b, in [0, 2].
+// A zero vector is at distance 1 from everything.
+func Cosine(a, b []float32) float32 {
+	dot, na, nb := Dot(a, b), Dot(a, a), Dot(b, b)
+	if na == 0 || nb == 0 {
+		return 1
+	}
+	return 1 - dot/float32(math.Sqrt(float64(na)*float64(nb)))
+}
+
+// NormalizedCosine returns the cosine distance of two unit vectors.
+func NormalizedCosine(a, b []float32) float32 {
+	return 1 - Dot(a, b)
+}
+
+// NegativeInnerProduct returns -a·b, so that larger inner products rank closer.
+func NegativeInnerProduct(a, b []float32) float32 {
+	return -Dot(a, b)
+}
+
+// Hamming returns the number of dimensions in which a and b differ.
+func Hamming(a, b []float32) float32 {
+	var n float32
+	for i := range a {
+		if a[i] != b[i] {
+			n++
+		}
+	}
+	return n
+}
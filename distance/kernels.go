@@ -0,0 +1,93 @@
+package distance
+
+import "math"
+
+// Kernels in use for Dot and SquareDistance. They start out as the portable
+// unrolled versions and are replaced at init by assembly when the CPU
+// supports it; see kernels_amd64.go.
+var (
+	dotKernel            = DotGeneric
+	squareDistanceKernel = SquareDistanceGeneric
+	kernelName           = "generic"
+)
+
+// Implementation names the kernels selected for this CPU: "avx2" when the
+// amd64 assembly is in use, "generic" for the unrolled Go fallbacks.
+func Implementation() string {
+	return kernelName
+}
+
+// DotGeneric is the portable Go implementation of Dot. It unrolls the loop
+// eight ways with independent accumulators so the additions can pipeline.
+func DotGeneric(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3, s4, s5, s6, s7 float32
+	i := 0
+	for ; i <= len(a)-8; i += 8 {
+		x := a[i : i+8 : i+8]
+		y := b[i : i+8 : i+8]
+		s0 += x[0] * y[0]
+		s1 += x[1] * y[1]
+		s2 += x[2] * y[2]
+		s3 += x[3] * y[3]
+		s4 += x[4] * y[4]
+		s5 += x[5] * y[5]
+		s6 += x[6] * y[6]
+		s7 += x[7] * y[7]
+	}
+	for ; i < len(a); i++ {
+		s0 += a[i] * b[i]
+	}
+	return ((s0 + s1) + (s2 + s3)) + ((s4 + s5) + (s6 + s7))
+}
+
+// SquareDistanceGeneric is the portable Go implementation of SquareDistance,
+// unrolled like DotGeneric.
+func SquareDistanceGeneric(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3, s4, s5, s6, s7 float32
+	i := 0
+	for ; i <= len(a)-8; i += 8 {
+		x := a[i : i+8 : i+8]
+		y := b[i : i+8 : i+8]
+		d0, d1, d2, d3 := x[0]-y[0], x[1]-y[1], x[2]-y[2], x[3]-y[3]
+		d4, d5, d6, d7 := x[4]-y[4], x[5]-y[5], x[6]-y[6], x[7]-y[7]
+		s0 += d0 * d0
+		s1 += d1 * d1
+		s2 += d2 * d2
+		s3 += d3 * d3
+		s4 += d4 * d4
+		s5 += d5 * d5
+		s6 += d6 * d6
+		s7 += d7 * d7
+	}
+	for ; i < len(a); i++ {
+		d := a[i] - b[i]
+		s0 += d * d
+	}
+	return ((s0 + s1) + (s2 + s3)) + ((s4 + s5) + (s6 + s7))
+}
+
+// ManhattanGeneric is the portable Go implementation of Manhattan,
+// unrolled four ways. The absolute value clears the sign bit directly.
+func ManhattanGeneric(a, b []float32) float32 {
+	b = b[:len(a)]
+	var s0, s1, s2, s3 float32
+	i := 0
+	for ; i <= len(a)-4; i += 4 {
+		x := a[i : i+4 : i+4]
+		y := b[i : i+4 : i+4]
+		s0 += abs(x[0] - y[0])
+		s1 += abs(x[1] - y[1])
+		s2 += abs(x[2] - y[2])
+		s3 += abs(x[3] - y[3])
+	}
+	for ; i < len(a); i++ {
+		s0 += abs(a[i] - b[i])
+	}
+	return (s0 + s1) + (s2 + s3)
+}
+
+func abs(x float32) float32 {
+	return math.Float32frombits(math.Float32bits(x) &^ (1 << 31))
+}
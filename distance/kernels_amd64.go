@@ -0,0 +1,30 @@
+//go:build amd64 && !purego
+
+package distance
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	if cpu.X86.HasAVX2 && cpu.X86.HasFMA {
+		dotKernel = dotAVX2
+		squareDistanceKernel = squareDistanceAVX2
+		kernelName = "avx2"
+	}
+}
+
+// The assembly reads len(a) elements from both slices, so the wrappers
+// reslice b first to panic on short input like the Go versions do.
+
+func dotAVX2(a, b []float32) float32 {
+	return dotAVX2Asm(a, b[:len(a)])
+}
+
+func squareDistanceAVX2(a, b []float32) float32 {
+	return squareDistanceAVX2Asm(a, b[:len(a)])
+}
+
+//go:noescape
+func dotAVX2Asm(a, b []float32) float32
+
+//go:noescape
+func squareDistanceAVX2Asm(a, b []float32) float32
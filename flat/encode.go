@@ -0,0 +1,163 @@
+package flat
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"vectorDb/distance"
+	"vectorDb/storefile"
+)
+
+var byteOrder = binary.LittleEndian
+
+// encodingVersion is the version of the layout written by Save.
+const encodingVersion = 1
+
+// legacyLayout reports whether prefix starts like a file written before
+// headers: with a dimension and the name of a registered distance function.
+func legacyLayout(prefix []byte) bool {
+	if len(prefix) < 8 || int32(byteOrder.Uint32(prefix)) < 0 {
+		return false
+	}
+	n := int32(byteOrder.Uint32(prefix[4:]))
+	if n < 0 || int(n) > len(prefix)-8 {
+		return false
+	}
+	_, ok := distance.Lookup(string(prefix[8 : 8+n]))
+	return ok
+}
+
+// Save serializes the index to <storeName>_flat.store.
+//
+// The layout is the header, a section with the parameters and the keys, and
+// a section with the vector arena.
+func (f *FlatIndex) Save(storeName string) error {
+	header := storefile.Header{Type: "flat", Version: encodingVersion, Dim: f.dim, Metric: f.distance}
+	return storefile.Write(storeName+"_flat"+".store", header, f.write)
+}
+
+// write encodes the index to writer.
+func (f *FlatIndex) write(writer *storefile.Writer) error {
+	if err := binary.Write(writer, byteOrder, int32(f.dim)); err != nil {
+		return err
+	}
+	if err := writeString(writer, f.distance); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, byteOrder, int32(len(f.keys))); err != nil {
+		return err
+	}
+	for _, key := range f.keys {
+		if err := writeString(writer, key); err != nil {
+			return err
+		}
+	}
+	if err := writer.EndSection(); err != nil {
+		return err
+	}
+	// The arena is written as one block in row order, matching keys.
+	if err := binary.Write(writer, byteOrder, f.data); err != nil {
+		return err
+	}
+	return writer.EndSection()
+}
+
+// Load deserializes the index from <storeName>_flat.store.
+// A missing or empty file leaves the index unchanged.
+func (f *FlatIndex) Load(storeName string) error {
+	file, err := os.OpenFile(storeName+"_flat"+".store", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	reader, err := storefile.NewReader(file, info.Size(), "flat", encodingVersion, legacyLayout)
+	if err != nil {
+		return err
+	}
+
+	var dim, count int32
+	if err := binary.Read(reader, byteOrder, &dim); err != nil {
+		return err
+	}
+	distName, err := readString(reader)
+	if err != nil {
+		return err
+	}
+	dFunc, ok := distance.Lookup(distName)
+	if !ok {
+		return fmt.Errorf("unknown distance function %q", distName)
+	}
+	if err := binary.Read(reader, byteOrder, &count); err != nil {
+		return err
+	}
+	if dim < 0 || count < 0 {
+		return errors.New("invalid flat index header")
+	}
+	if err := storefile.CheckLen(reader, int64(count), 4); err != nil {
+		return fmt.Errorf("decoding keys: %w", err)
+	}
+
+	keys := make([]string, count)
+	positions := make(map[string]int, count)
+	for i := range keys {
+		if keys[i], err = readString(reader); err != nil {
+			return err
+		}
+		positions[keys[i]] = i
+	}
+	if err := reader.EndSection(); err != nil {
+		return err
+	}
+	if err := storefile.CheckLen(reader, int64(dim)*int64(count), 4); err != nil {
+		return fmt.Errorf("decoding vectors: %w", err)
+	}
+	data := make([]float32, int(dim)*int(count))
+	if err := binary.Read(reader, byteOrder, data); err != nil {
+		return err
+	}
+	if err := reader.EndSection(); err != nil {
+		return err
+	}
+
+	f.dim = int(dim)
+	f.distance = distName
+	f.dFunc = dFunc
+	f.keys = keys
+	f.positions = positions
+	f.data = data
+	return nil
+}
+
+// writeString writes a length-prefixed string.
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, byteOrder, int32(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// readString reads a length-prefixed string.
+func readString(r io.Reader) (string, error) {
+	var length int32
+	if err := binary.Read(r, byteOrder, &length); err != nil {
+		return "", err
+	}
+	if err := storefile.CheckLen(r, int64(length), 1); err != nil {
+		return "", fmt.Errorf("decoding string: %w", err)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
@@ -0,0 +1,241 @@
+package flat
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"sync"
+	"vectorDb/distance"
+)
+
+// parallelThreshold is the number of vectors below which a search is run on
+// a single goroutine; for small collections the fan-out costs more than it saves.
+const parallelThreshold = 4096
+
+// Result is a single search result with its distance to the query.
+type Result struct {
+	Key      string
+	Distance float32
+}
+
+// FlatIndex is an exact, brute-force index.
+// Vectors are kept back to back in a single arena so a scan walks memory
+// sequentially, and every search compares the query with every vector.
+// It is the ground truth the approximate indexes are measured against.
+type FlatIndex struct {
+	dim      int
+	distance string
+	dFunc    distance.Func
+	// data holds vector i at data[i*dim : (i+1)*dim].
+	data []float32
+	keys []string
+	// positions maps a key to its row in data.
+	positions map[string]int
+}
+
+// NewFlatIndex creates an empty flat index using the named distance function.
+// The dimension is fixed by the first inserted vector.
+func NewFlatIndex(distanceFunc string) (*FlatIndex, error) {
+	distanceFunc = distance.Canonical(distanceFunc)
+	dFunc, ok := distance.Lookup(distanceFunc)
+	if !ok {
+		return nil, fmt.Errorf("unknown distance function %q", distanceFunc)
+	}
+	return &FlatIndex{
+		distance:  distanceFunc,
+		dFunc:     dFunc,
+		positions: make(map[string]int),
+	}, nil
+}
+
+// Len returns the number of vectors in the index.
+func (f *FlatIndex) Len() int {
+	return len(f.keys)
+}
+
+// Dims returns the dimension of the vectors, or 0 if the index is empty.
+func (f *FlatIndex) Dims() int {
+	return f.dim
+}
+
+// Metric returns the name of the distance function of the index.
+func (f *FlatIndex) Metric() string {
+	return f.distance
+}
+
+func (f *FlatIndex) row(i int) []float32 {
+	return f.data[i*f.dim : (i+1)*f.dim : (i+1)*f.dim]
+}
+
+// Insert adds the vector under key.
+// If key is already present its vector is overwritten in place.
+func (f *FlatIndex) Insert(key string, vector []float32) error {
+	if len(f.keys) == 0 {
+		f.dim = len(vector)
+	}
+	if len(vector) != f.dim {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", f.dim, len(vector))
+	}
+	if distance.NeedsNormalization(f.distance) {
+		vector = distance.Normalize(vector)
+	}
+	if i, ok := f.positions[key]; ok {
+		copy(f.row(i), vector)
+		return nil
+	}
+	f.positions[key] = len(f.keys)
+	f.keys = append(f.keys, key)
+	f.data = append(f.data, vector...)
+	return nil
+}
+
+// Delete removes key from the index and reports whether it was present.
+// The last vector is moved into the freed slot to keep the arena dense.
+func (f *FlatIndex) Delete(key string) bool {
+	i, ok := f.positions[key]
+	if !ok {
+		return false
+	}
+	last := len(f.keys) - 1
+	if i != last {
+		copy(f.row(i), f.row(last))
+		f.keys[i] = f.keys[last]
+		f.positions[f.keys[i]] = i
+	}
+	f.keys = f.keys[:last]
+	f.data = f.data[:last*f.dim]
+	delete(f.positions, key)
+	return true
+}
+
+// Lookup returns a copy of the vector stored under key.
+func (f *FlatIndex) Lookup(key string) ([]float32, bool) {
+	i, ok := f.positions[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]float32(nil), f.row(i)...), true
+}
+
+// Each calls fn with every key and its vector, stopping at and returning
+// the first error fn returns. The vector must not be modified or kept past
+// the call.
+func (f *FlatIndex) Each(fn func(key string, vector []float32) error) error {
+	for i, key := range f.keys {
+		if err := fn(key, f.row(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search returns the k vectors closest to the query, nearest first.
+func (f *FlatIndex) Search(query []float32, k int) ([]Result, error) {
+	return f.SearchFilter(query, k, nil)
+}
+
+// SearchFilter is like Search but only considers keys for which filter
+// returns true. A nil filter accepts every key.
+func (f *FlatIndex) SearchFilter(query []float32, k int, filter func(key string) bool) ([]Result, error) {
+	return f.search(query, k, float32(math.Inf(1)), filter)
+}
+
+// SearchRange returns the vectors within radius of the query, nearest
+// first, and at most maxResults of them if maxResults > 0. Distances are
+// those of the index's distance function, smaller meaning closer.
+func (f *FlatIndex) SearchRange(query []float32, radius float32, maxResults int) ([]Result, error) {
+	if maxResults <= 0 {
+		maxResults = len(f.keys)
+	}
+	return f.search(query, maxResults, radius, nil)
+}
+
+// search returns the k nearest vectors within radius accepted by filter.
+func (f *FlatIndex) search(query []float32, k int, radius float32, filter func(key string) bool) ([]Result, error) {
+	if len(f.keys) == 0 || k <= 0 {
+		return nil, nil
+	}
+	if len(query) != f.dim {
+		return nil, fmt.Errorf("embedding dimension mismatch: %d != %d", f.dim, len(query))
+	}
+	if distance.NeedsNormalization(f.distance) {
+		query = distance.Normalize(query)
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if len(f.keys) < parallelThreshold || workers < 2 {
+		workers = 1
+	}
+	chunk := (len(f.keys) + workers - 1) / workers
+
+	partials := make([]resultHeap, workers)
+	var wg sync.WaitGroup
+	for w := range workers {
+		start, end := w*chunk, min((w+1)*chunk, len(f.keys))
+		if start >= end {
+			continue
+		}
+		wg.Add(1)
+		go func(w, start, end int) {
+			defer wg.Done()
+			partials[w] = f.scan(query, k, radius, start, end, filter)
+		}(w, start, end)
+	}
+	wg.Wait()
+
+	merged := make(resultHeap, 0, min(k, len(f.keys)))
+	for _, partial := range partials {
+		for _, r := range partial {
+			merged.offer(r, k)
+		}
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].Distance < merged[j].Distance
+	})
+	return merged, nil
+}
+
+// scan computes the k nearest rows within radius in [start, end).
+func (f *FlatIndex) scan(query []float32, k int, radius float32, start, end int, filter func(string) bool) resultHeap {
+	h := make(resultHeap, 0, min(k, end-start))
+	for i := start; i < end; i++ {
+		if filter != nil && !filter(f.keys[i]) {
+			continue
+		}
+		dist := f.dFunc(query, f.row(i))
+		if dist > radius {
+			continue
+		}
+		h.offer(Result{Key: f.keys[i], Distance: dist}, k)
+	}
+	return h
+}
+
+// resultHeap is a max-heap on distance, bounded to the k best results seen,
+// so the worst of them sits at the root ready to be evicted.
+type resultHeap []Result
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h[i].Distance > h[j].Distance }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x any)        { *h = append(*h, x.(Result)) }
+func (h *resultHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// offer adds r if the heap holds fewer than k results or r beats the worst one.
+func (h *resultHeap) offer(r Result, k int) {
+	if h.Len() < k {
+		heap.Push(h, r)
+		return
+	}
+	if r.Distance < (*h)[0].Distance {
+		(*h)[0] = r
+		heap.Fix(h, 0)
+	}
+}
@@ -0,0 +1,209 @@
+package flat
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"vectorDb/distance"
+	"vectorDb/storefile"
+)
+
+// mappedVersion is the version of the layout written by SaveMapped.
+const mappedVersion = 1
+
+// MappedIndex is a read-only flat index scanned in place in a memory mapped
+// file, written by SaveMapped. Opening it maps the vector arena instead of
+// reading it, so only the keys are loaded into memory.
+//
+// The rows are stored in key order, which lets Lookup binary search the
+// keys rather than build a map of them.
+type MappedIndex struct {
+	// index views the mapping; its positions map is nil and it must never
+	// be modified, as the arena is read-only memory.
+	index   FlatIndex
+	mapping *storefile.Mapping
+}
+
+// SaveMapped writes the index to <storeName>_flatmap.store in the layout of
+// MappedIndex: the header, a section with the keys and one with the arena.
+func (f *FlatIndex) SaveMapped(storeName string) error {
+	header := storefile.Header{Type: "flatmap", Version: mappedVersion, Dim: f.dim, Metric: f.distance}
+	return storefile.Write(storeName+"_flatmap"+".store", header, f.writeMapped)
+}
+
+// writeMapped encodes the index with its rows in key order.
+func (f *FlatIndex) writeMapped(writer *storefile.Writer) error {
+	keys := slices.Sorted(slices.Values(f.keys))
+	offsets := make([]uint64, 0, len(keys)+1)
+	var keyBytes []byte
+	data := make([]float32, 0, len(f.data))
+	for _, key := range keys {
+		offsets = append(offsets, uint64(len(keyBytes)))
+		keyBytes = append(keyBytes, key...)
+		data = append(data, f.row(f.positions[key])...)
+	}
+	offsets = append(offsets, uint64(len(keyBytes)))
+
+	if err := binary.Write(writer, byteOrder, uint64(len(keys))); err != nil {
+		return err
+	}
+	if err := writer.WriteArray(offsets); err != nil {
+		return err
+	}
+	if err := writer.WriteArray(keyBytes); err != nil {
+		return err
+	}
+	if err := writer.EndSection(); err != nil {
+		return err
+	}
+	if err := writer.WriteArray(data); err != nil {
+		return err
+	}
+	return writer.EndSection()
+}
+
+// OpenMapped maps the index saved by SaveMapped under storeName. The index
+// must be closed once no longer used.
+func OpenMapped(storeName string) (*MappedIndex, error) {
+	mapping, err := storefile.Map(storeName+"_flatmap"+".store", "flatmap", mappedVersion)
+	if err != nil {
+		return nil, err
+	}
+	m := &MappedIndex{mapping: mapping}
+	if err := m.decode(); err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// decode reads the keys and maps the arena.
+func (m *MappedIndex) decode() error {
+	header := m.mapping.Header
+	dFunc, ok := distance.Lookup(header.Metric)
+	if !ok {
+		return fmt.Errorf("unknown distance function %q", header.Metric)
+	}
+	m.index = FlatIndex{dim: header.Dim, distance: header.Metric, dFunc: dFunc}
+	if m.mapping.Sections() != 2 {
+		return fmt.Errorf("expected 2 sections, found %d", m.mapping.Sections())
+	}
+
+	r, err := m.mapping.Section(0)
+	if err != nil {
+		return err
+	}
+	var n uint64
+	if err := binary.Read(r, byteOrder, &n); err != nil {
+		return err
+	}
+	if err := storefile.CheckLen(r, int64(n), 8); err != nil {
+		return fmt.Errorf("decoding keys: %w", err)
+	}
+	offsets, err := r.Uint64s(int(n) + 1)
+	if err != nil {
+		return fmt.Errorf("decoding keys: %w", err)
+	}
+	if offsets[0] != 0 || offsets[n] > uint64(r.Len()) {
+		return fmt.Errorf("invalid keys")
+	}
+	keyBytes, err := r.Bytes(int(offsets[n]))
+	if err != nil {
+		return fmt.Errorf("decoding keys: %w", err)
+	}
+	m.index.keys = make([]string, n)
+	for i := range m.index.keys {
+		if offsets[i] > offsets[i+1] {
+			return fmt.Errorf("invalid key %d", i)
+		}
+		m.index.keys[i] = string(keyBytes[offsets[i]:offsets[i+1]])
+		if i > 0 && m.index.keys[i] <= m.index.keys[i-1] {
+			return fmt.Errorf("keys are not sorted at %d", i)
+		}
+	}
+
+	r, err = m.mapping.Section(1)
+	if err != nil {
+		return err
+	}
+	if err := storefile.CheckLen(r, int64(n)*int64(m.index.dim), 4); err != nil || m.index.dim < 0 {
+		return fmt.Errorf("decoding vectors: %w", err)
+	}
+	if m.index.data, err = r.Float32s(int(n) * m.index.dim); err != nil {
+		return fmt.Errorf("decoding vectors: %w", err)
+	}
+	return nil
+}
+
+// Close unmaps the index. It must not be used afterwards.
+func (m *MappedIndex) Close() error {
+	return m.mapping.Close()
+}
+
+// Len returns the number of vectors in the index.
+func (m *MappedIndex) Len() int {
+	return m.index.Len()
+}
+
+// Dims returns the dimension of the vectors, or 0 if the index is empty.
+func (m *MappedIndex) Dims() int {
+	if m.index.Len() == 0 {
+		return 0
+	}
+	return m.index.Dims()
+}
+
+// Metric returns the name of the distance function of the index.
+func (m *MappedIndex) Metric() string {
+	return m.index.distance
+}
+
+// Lookup returns a copy of the vector stored under key.
+func (m *MappedIndex) Lookup(key string) ([]float32, bool) {
+	i, ok := slices.BinarySearch(m.index.keys, key)
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(m.index.row(i)), true
+}
+
+// Each calls fn with every key and its vector, in key order, stopping at
+// and returning the first error fn returns. The vector is read-only memory
+// and must not be kept past the call.
+func (m *MappedIndex) Each(fn func(key string, vector []float32) error) error {
+	return m.index.Each(fn)
+}
+
+// Search returns the k vectors closest to the query, nearest first.
+func (m *MappedIndex) Search(query []float32, k int) ([]Result, error) {
+	return m.index.Search(query, k)
+}
+
+// SearchFilter is like Search but only considers keys for which filter
+// returns true. A nil filter accepts every key.
+func (m *MappedIndex) SearchFilter(query []float32, k int, filter func(key string) bool) ([]Result, error) {
+	return m.index.SearchFilter(query, k, filter)
+}
+
+// SearchRange returns the vectors within radius of the query, nearest
+// first, and at most maxResults of them if maxResults > 0.
+func (m *MappedIndex) SearchRange(query []float32, radius float32, maxResults int) ([]Result, error) {
+	return m.index.SearchRange(query, radius, maxResults)
+}
+
+// Index converts the mapped index back to a mutable one. The vectors are
+// copied, so the index stays usable after Close.
+func (m *MappedIndex) Index() *FlatIndex {
+	f := &FlatIndex{
+		dim:       m.index.dim,
+		distance:  m.index.distance,
+		dFunc:     m.index.dFunc,
+		data:      slices.Clone(m.index.data),
+		keys:      slices.Clone(m.index.keys),
+		positions: make(map[string]int, len(m.index.keys)),
+	}
+	for i, key := range f.keys {
+		f.positions[key] = i
+	}
+	return f
+}
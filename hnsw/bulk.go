@@ -0,0 +1,110 @@
+package hnsw
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"vectorDb/distance"
+)
+
+// BulkOptions configures BulkInsert.
+type BulkOptions struct {
+	// Workers is the number of goroutines inserting nodes.
+	// Zero means runtime.GOMAXPROCS(0).
+	Workers int
+	// Progress, if set, is called with the number of nodes inserted so far
+	// and the total, about every percent and once at the end. Calls are
+	// serialized and done never decreases.
+	Progress func(done, total int)
+}
+
+// BulkInsert inserts nodes like Insert, spreading the work over a pool of
+// workers that link nodes under per-node locks. When several nodes share a
+// key, the last one wins.
+//
+// Levels are drawn from Rng in input order before any insert, but the
+// workers see each other's nodes in no fixed order, so the graph is not
+// reproducible even when seeded; use Insert for that. Like Insert, it holds
+// the graph exclusively, so other methods wait for it to finish.
+func (g *HNSWGraph[K]) BulkInsert(nodes []Node[K], opts BulkOptions) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.Distance == nil {
+		panic("(*Graph).Distance must be set")
+	}
+
+	// Keep the last node of each key, in input order.
+	last := make(map[K]int, len(nodes))
+	for i, node := range nodes {
+		last[node.Key] = i
+	}
+	batch := make([]Node[K], 0, len(last))
+	for i, node := range nodes {
+		if last[node.Key] == i {
+			batch = append(batch, node)
+		}
+	}
+
+	// Validate the batch, drop the nodes it replaces and draw the levels
+	// up front, so that the workers only search and link.
+	dims := g.dims()
+	for i, node := range batch {
+		if g.normalizes() {
+			batch[i].Embed = distance.Normalize(node.Embed)
+		}
+		if dims == 0 {
+			dims = len(node.Embed)
+		} else if len(node.Embed) != dims {
+			panic(fmt.Sprint("embedding dimension mismatch: ", dims, " != ", len(node.Embed)))
+		}
+		if len(g.levels) > 0 && g.levels[0].nodes[node.Key] != nil {
+			g.remove(node.Key)
+		}
+	}
+	size := 0
+	if len(g.levels) > 0 {
+		size = g.levels[0].size()
+	}
+	levels := make([]int, len(batch))
+	for i := range batch {
+		levels[i] = g.randomLevel(size + i)
+	}
+
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	step := max(len(batch)/100, 1)
+	var (
+		next, done atomic.Int64
+		progressMu sync.Mutex
+		reported   int
+		wg         sync.WaitGroup
+	)
+	report := func(n int) {
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		if n > reported {
+			reported = n
+			opts.Progress(n, len(batch))
+		}
+	}
+	for range min(workers, len(batch)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(batch) {
+					return
+				}
+				g.insert(batch[i].Key, batch[i].Embed, levels[i])
+				if n := int(done.Add(1)); opts.Progress != nil && (n%step == 0 || n == len(batch)) {
+					report(n)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
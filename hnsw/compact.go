@@ -0,0 +1,207 @@
+package hnsw
+
+import (
+	"maps"
+	"slices"
+)
+
+// Stats reports the size of the graph and the state of its tombstones.
+type Stats struct {
+	// Nodes is the number of live nodes.
+	Nodes int
+	// Tombstones is the number of deleted nodes still linked into the graph.
+	Tombstones int
+	// TombstoneRatio is Tombstones over all the nodes in the graph.
+	TombstoneRatio float64
+	// Compactions is the number of compactions run since the graph was
+	// created or loaded, not counting one still running.
+	Compactions int
+}
+
+// Stats returns the current metrics of the graph.
+func (h *HNSWGraph[K]) Stats() Stats {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return Stats{
+		Nodes:          h.liveLen(),
+		Tombstones:     len(h.tombstones),
+		TombstoneRatio: h.tombstoneRatio(),
+		Compactions:    h.compactions,
+	}
+}
+
+// TombstoneRatio returns the fraction of the nodes in the graph that are
+// tombstones, or 0 if the graph is empty.
+func (h *HNSWGraph[K]) TombstoneRatio() float64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.tombstoneRatio()
+}
+
+// tombstoneRatio is TombstoneRatio for the methods of the graph, which
+// hold mu already.
+func (h *HNSWGraph[K]) tombstoneRatio() float64 {
+	if len(h.levels) == 0 || h.levels[0].size() == 0 {
+		return 0
+	}
+	return float64(len(h.tombstones)) / float64(h.levels[0].size())
+}
+
+// live reports whether a node is not a tombstone.
+func (h *HNSWGraph[K]) live(n *Node[K]) bool {
+	return !h.tombstones[n.Key]
+}
+
+// liveNodes returns the live nodes of the base layer in key order.
+func (h *HNSWGraph[K]) liveNodes() []*Node[K] {
+	if len(h.levels) == 0 {
+		return nil
+	}
+	out := make([]*Node[K], 0, h.liveLen())
+	for _, node := range h.levels[0].sortedNodes() {
+		if h.live(node) {
+			out = append(out, node)
+		}
+	}
+	return out
+}
+
+// Compact removes the tombstones from the graph, waiting for a compaction
+// Delete started in the background to finish first. Delete starts one once
+// CompactionThreshold is reached, and Compact can be called at any time.
+//
+// By default, every live node that was linked to a tombstone is relinked
+// to the nearest of its live neighbours and of the live neighbours of its
+// deleted ones, which the tombstones connected it through. With
+// RebuildOnCompaction, the graph is instead rebuilt from its live nodes.
+func (h *HNSWGraph[K]) Compact() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.compact()
+}
+
+// WaitCompaction waits for the compaction Delete started in the background,
+// if any, to finish.
+func (h *HNSWGraph[K]) WaitCompaction() {
+	h.compaction.Wait()
+}
+
+// compactInBackground starts compacting the graph on another goroutine,
+// unless a compaction is pending or running already. The caller holds mu,
+// so the compaction starts after it returns.
+func (h *HNSWGraph[K]) compactInBackground() {
+	if !h.compacting.CompareAndSwap(false, true) {
+		return
+	}
+	h.compaction.Add(1)
+	go func() {
+		defer h.compaction.Done()
+		defer h.compacting.Store(false)
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.compact()
+	}()
+}
+
+// compact is Compact with mu held for writing.
+func (h *HNSWGraph[K]) compact() {
+	if len(h.tombstones) == 0 {
+		return
+	}
+	h.compactions++
+	if h.RebuildOnCompaction {
+		h.rebuild()
+		return
+	}
+
+	for i, layer := range h.levels {
+		m := h.maxNeighbours(i)
+
+		// Gather the replacement candidates of the affected nodes before
+		// unlinking anything, while the tombstones still connect them.
+		affected := make(map[K][]*Node[K])
+		var order []*Node[K]
+		for _, node := range layer.sortedNodes() {
+			if !h.live(node) {
+				continue
+			}
+			var candidates []*Node[K]
+			seen := map[K]bool{node.Key: true}
+			add := func(n *Node[K]) {
+				if !seen[n.Key] && h.live(n) {
+					seen[n.Key] = true
+					candidates = append(candidates, n)
+				}
+			}
+			lost := false
+			for _, neighbour := range node.sortedNeighbours() {
+				if h.live(neighbour) {
+					add(neighbour)
+					continue
+				}
+				lost = true
+				for _, next := range neighbour.sortedNeighbours() {
+					add(next)
+				}
+			}
+			if lost {
+				affected[node.Key] = candidates
+				order = append(order, node)
+			}
+		}
+
+		for key := range h.tombstones {
+			node, ok := layer.nodes[key]
+			if !ok {
+				continue
+			}
+			for _, neighbour := range node.neighbours {
+				delete(neighbour.neighbours, key)
+			}
+			delete(layer.nodes, key)
+			if i == 0 {
+				h.release(node)
+			}
+		}
+
+		for _, node := range order {
+			candidates := make([]searchCandidate[K], 0, len(affected[node.Key]))
+			for _, c := range affected[node.Key] {
+				candidates = append(candidates, searchCandidate[K]{node: c, dist: h.between(c, node)})
+			}
+			sortCandidates(candidates)
+			for _, c := range selectNeighbours(node, candidates, m, h.between, false, true) {
+				if _, ok := node.neighbours[c.node.Key]; ok {
+					continue
+				}
+				link(node, c.node)
+				c.node.shrink(m, h.between)
+			}
+			node.shrink(m, h.between)
+		}
+	}
+
+	h.tombstones = nil
+	h.trimLevels()
+	if len(h.levels) == 0 || h.levels[len(h.levels)-1].nodes[*h.entry] == nil {
+		h.entry = h.topNode()
+	}
+}
+
+// rebuild replaces the graph with one built from its live nodes, inserted
+// in key order. The slots of the old nodes are only released once all are
+// inserted, so that none is overwritten before it is read.
+func (h *HNSWGraph[K]) rebuild() {
+	nodes := h.liveNodes()
+	var old []*Node[K]
+	if len(h.levels) > 0 {
+		old = slices.Collect(maps.Values(h.levels[0].nodes))
+	}
+	h.levels, h.entry, h.tombstones = nil, nil, nil
+	for _, node := range nodes {
+		h.insertNodes([]Node[K]{MakeNode(node.Key, h.embedding(node))})
+	}
+	for _, node := range old {
+		h.release(node)
+	}
+}
@@ -0,0 +1,408 @@
+package hnsw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"vectorDb/distance"
+	"vectorDb/pq"
+)
+
+// compressor encodes embeddings into compact codes that graph traversal can
+// score directly, so a search touches a few bytes per visited node instead
+// of a full float32 vector.
+type compressor interface {
+	// name identifies the compression scheme in the store file.
+	name() string
+	// dims is the dimension of the embeddings it encodes.
+	dims() int
+	encode(e Embedding) []byte
+	// scorer returns a function estimating the distance between the query
+	// and the embedding a code stands for.
+	scorer(query Embedding) func(code []byte) float32
+	// between estimates the distance between the embeddings two codes
+	// stand for, on the scale of scorer.
+	between(a, b []byte) float32
+}
+
+// pqCompressor compresses embeddings with a product quantizer and scores
+// codes with asymmetric distance computation.
+type pqCompressor struct {
+	quantizer *pq.Quantizer
+}
+
+func (c pqCompressor) name() string {
+	return QuantizationPQ
+}
+
+func (c pqCompressor) dims() int {
+	return c.quantizer.Dims()
+}
+
+func (c pqCompressor) encode(e Embedding) []byte {
+	return c.quantizer.Encode(e)
+}
+
+func (c pqCompressor) scorer(query Embedding) func([]byte) float32 {
+	return c.quantizer.DistanceTable(query).Distance
+}
+
+func (c pqCompressor) between(a, b []byte) float32 {
+	return c.quantizer.CodeDistance(a, b)
+}
+
+// CompressPQ trains a product quantizer with the given number of subspaces on
+// the embeddings already in the graph and encodes every node with it.
+// Subsequent searches traverse the graph on the codes and re-score the best
+// Rerank candidates with the full-precision embeddings, which move out of
+// memory into a temporary file; inserts then link nodes by their codes.
+func (h *HNSWGraph[K]) CompressPQ(subspaces int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.liveLen() == 0 {
+		return errors.New("cannot train a quantizer on an empty graph")
+	}
+	distName, ok := distance.Name(h.Distance)
+	if !ok {
+		return errors.New("distance function must be registered")
+	}
+	quantizer, err := pq.NewQuantizer(h.dims(), subspaces, pq.MaxCentroids, distName)
+	if err != nil {
+		return err
+	}
+	sample := make([][]float32, 0, h.liveLen())
+	for _, node := range h.liveNodes() {
+		embedding, err := h.readEmbedding(node)
+		if err != nil {
+			return err
+		}
+		sample = append(sample, embedding)
+	}
+	if h.Rng == nil {
+		h.Rng = defaultRand()
+	}
+	if err := quantizer.Train(sample, h.Rng); err != nil {
+		return err
+	}
+	return h.compress(pqCompressor{quantizer: quantizer})
+}
+
+// compress sets the graph's compressor and encodes every node with it. The
+// full-precision embeddings move to the graph's vector file, so that the
+// nodes only hold their codes. The graph is left as it was on error.
+func (h *HNSWGraph[K]) compress(c compressor) error {
+	vectors := h.vectors
+	if vectors == nil {
+		var err error
+		vectors, err = newVectorFile(c.dims())
+		if err != nil {
+			return err
+		}
+	}
+	type encoded struct {
+		code []byte
+		slot int64
+	}
+	var nodes map[K]encoded
+	if len(h.levels) > 0 {
+		nodes = make(map[K]encoded, h.levels[0].size())
+		for key, node := range h.levels[0].nodes {
+			embedding, err := h.readEmbedding(node)
+			if err != nil {
+				return err
+			}
+			slot := node.slot
+			if node.Embed != nil {
+				slot, err = vectors.put(embedding)
+				if err != nil {
+					return err
+				}
+			}
+			nodes[key] = encoded{code: c.encode(embedding), slot: slot}
+		}
+	}
+	for _, level := range h.levels {
+		for key, node := range level.nodes {
+			node.code, node.slot, node.Embed = nodes[key].code, nodes[key].slot, nil
+		}
+	}
+	h.compressor, h.vectors = c, vectors
+	return nil
+}
+
+// rerank re-scores candidates found on compressed codes with the
+// full-precision distance and returns the k best, nearest first.
+func (h *HNSWGraph[K]) rerank(candidates []searchCandidate[K], k int, near Embedding) []searchCandidate[K] {
+	for i := range candidates {
+		candidates[i].dist = h.Distance(h.embedding(candidates[i].node), near)
+	}
+	sortCandidates(candidates)
+	if len(candidates) > k {
+		candidates = candidates[:k]
+	}
+	return candidates
+}
+
+// between returns the distance between two nodes of the graph, estimated
+// from their codes when it is compressed.
+func (h *HNSWGraph[K]) between(a, b *Node[K]) float32 {
+	if h.compressor != nil {
+		return h.compressor.between(a.code, b.code)
+	}
+	return h.Distance(a.Embed, b.Embed)
+}
+
+// readEmbedding returns the full-precision embedding of a node, reading it
+// from the vector file when the graph is compressed.
+func (h *HNSWGraph[K]) readEmbedding(n *Node[K]) (Embedding, error) {
+	if n.Embed != nil || h.vectors == nil {
+		return n.Embed, nil
+	}
+	return h.vectors.get(n.slot)
+}
+
+// embedding is readEmbedding for callers that can't return an error. The
+// vector file is written by the graph itself, so failing to read it back
+// is not recoverable.
+func (h *HNSWGraph[K]) embedding(n *Node[K]) Embedding {
+	embedding, err := h.readEmbedding(n)
+	if err != nil {
+		panic(err)
+	}
+	return embedding
+}
+
+// release frees the slot of a node removed from the graph.
+func (h *HNSWGraph[K]) release(n *Node[K]) {
+	if h.vectors != nil {
+		h.vectors.release(n.slot)
+	}
+}
+
+// releaseAll frees the slots of every node, before the graph drops them.
+func (h *HNSWGraph[K]) releaseAll() {
+	if len(h.levels) == 0 {
+		return
+	}
+	for _, node := range h.levels[0].nodes {
+		h.release(node)
+	}
+}
+
+const (
+	// QuantizationPQ compresses embeddings with a product quantizer.
+	QuantizationPQ = "pq"
+	// QuantizationInt8 stores each dimension as a byte, linearly scaled
+	// between the minimum and maximum seen for that dimension.
+	QuantizationInt8 = "int8"
+	// QuantizationBinary stores the sign of each dimension as a single bit,
+	// after centring the embedding on the per-dimension mean of the graph,
+	// and compares codes by Hamming distance. Centring keeps the bits
+	// informative for embeddings whose values share a sign, which plain
+	// sign bits would map to a single code.
+	QuantizationBinary = "binary"
+)
+
+// Quantization returns the compression scheme of the graph, or "" if the
+// graph searches on full-precision embeddings.
+func (h *HNSWGraph[K]) Quantization() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.compressor == nil {
+		return ""
+	}
+	return h.compressor.name()
+}
+
+// Quantize compresses the graph with QuantizationInt8 or QuantizationBinary,
+// with per-dimension statistics taken from the embeddings already in the
+// graph. As with CompressPQ, traversal then runs on the codes and the best
+// candidates are re-scored at full precision unless SkipRerank is set.
+func (h *HNSWGraph[K]) Quantize(mode string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.liveLen() == 0 {
+		return errors.New("cannot train a quantizer on an empty graph")
+	}
+	dims := h.dims()
+	lo := make([]float32, dims)
+	hi := make([]float32, dims)
+	mean := make([]float64, dims)
+	first := true
+	for _, node := range h.liveNodes() {
+		embedding, err := h.readEmbedding(node)
+		if err != nil {
+			return err
+		}
+		for i, v := range embedding {
+			if first || v < lo[i] {
+				lo[i] = v
+			}
+			if first || v > hi[i] {
+				hi[i] = v
+			}
+			mean[i] += float64(v)
+		}
+		first = false
+	}
+
+	switch mode {
+	case QuantizationInt8:
+		distName, _ := distance.Name(h.Distance)
+		return h.compress(newScalarCompressor(lo, hi, distance.IsInnerProduct(distName)))
+	case QuantizationBinary:
+		means := make([]float32, dims)
+		for i := range mean {
+			means[i] = float32(mean[i] / float64(h.liveLen()))
+		}
+		return h.compress(binaryCompressor{means: means})
+	default:
+		return fmt.Errorf("unknown quantization %q", mode)
+	}
+}
+
+// scalarCompressor maps each dimension linearly from [min, max] onto the
+// 256 values of a byte, so code value c stands for min + c*scale.
+type scalarCompressor struct {
+	min   []float32
+	scale []float32
+	// inner selects negated inner-product scoring instead of squared L2.
+	inner bool
+}
+
+func newScalarCompressor(lo, hi []float32, inner bool) scalarCompressor {
+	scale := make([]float32, len(lo))
+	for i := range lo {
+		scale[i] = (hi[i] - lo[i]) / 255
+	}
+	return scalarCompressor{min: lo, scale: scale, inner: inner}
+}
+
+func (c scalarCompressor) name() string {
+	return QuantizationInt8
+}
+
+func (c scalarCompressor) dims() int {
+	return len(c.min)
+}
+
+func (c scalarCompressor) encode(e Embedding) []byte {
+	code := make([]byte, len(e))
+	for i, v := range e {
+		if c.scale[i] == 0 {
+			continue
+		}
+		q := math.Round(float64((v - c.min[i]) / c.scale[i]))
+		code[i] = byte(max(0, min(255, q)))
+	}
+	return code
+}
+
+func (c scalarCompressor) scorer(query Embedding) func([]byte) float32 {
+	return func(code []byte) float32 {
+		var sum float32
+		for i, q := range code {
+			v := c.min[i] + float32(q)*c.scale[i]
+			if c.inner {
+				sum -= v * query[i]
+			} else {
+				d := v - query[i]
+				sum += d * d
+			}
+		}
+		return sum
+	}
+}
+
+func (c scalarCompressor) between(a, b []byte) float32 {
+	var sum float32
+	for i := range a {
+		if c.inner {
+			sum -= (c.min[i] + float32(a[i])*c.scale[i]) * (c.min[i] + float32(b[i])*c.scale[i])
+		} else {
+			d := (float32(a[i]) - float32(b[i])) * c.scale[i]
+			sum += d * d
+		}
+	}
+	return sum
+}
+
+// binaryCompressor keeps the sign bit of each dimension of the embedding
+// minus means, set when the centred value is positive, and scores codes by
+// Hamming distance.
+type binaryCompressor struct {
+	means []float32
+}
+
+func (c binaryCompressor) name() string {
+	return QuantizationBinary
+}
+
+func (c binaryCompressor) dims() int {
+	return len(c.means)
+}
+
+func (c binaryCompressor) encode(e Embedding) []byte {
+	code := make([]byte, (len(e)+7)/8)
+	for i, v := range e {
+		if v-c.means[i] > 0 {
+			code[i/8] |= 1 << (i % 8)
+		}
+	}
+	return code
+}
+
+func (c binaryCompressor) scorer(query Embedding) func([]byte) float32 {
+	q := c.encode(query)
+	return func(code []byte) float32 {
+		return c.between(code, q)
+	}
+}
+
+// between returns the Hamming distance between the codes.
+func (c binaryCompressor) between(a, b []byte) float32 {
+	distance := 0
+	for i := range a {
+		distance += bits.OnesCount8(a[i] ^ b[i])
+	}
+	return float32(distance)
+}
+
+// writeCompressor encodes the parameters of a compressor after its name.
+func writeCompressor(w io.Writer, c compressor) error {
+	var err error
+	switch c := c.(type) {
+	case pqCompressor:
+		_, err = binaryWrite(w, c.quantizer)
+	case scalarCompressor:
+		_, err = multiBinaryWrite(w, Embedding(c.min), Embedding(c.scale), c.inner)
+	case binaryCompressor:
+		_, err = binaryWrite(w, Embedding(c.means))
+	}
+	return err
+}
+
+// readCompressor decodes the compressor with the given name.
+func readCompressor(r io.Reader, name string) (compressor, error) {
+	switch name {
+	case QuantizationPQ:
+		quantizer := &pq.Quantizer{}
+		_, err := binaryRead(r, quantizer)
+		return pqCompressor{quantizer: quantizer}, err
+	case QuantizationInt8:
+		var lo, scale Embedding
+		var inner bool
+		_, err := multiBinaryRead(r, &lo, &scale, &inner)
+		if err == nil && len(lo) != len(scale) {
+			err = errors.New("scalar quantizer dimensions do not match")
+		}
+		return scalarCompressor{min: lo, scale: scale, inner: inner}, err
+	case QuantizationBinary:
+		var means Embedding
+		_, err := binaryRead(r, &means)
+		return binaryCompressor{means: means}, err
+	}
+	return nil, fmt.Errorf("unknown compression %q", name)
+}
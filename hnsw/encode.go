@@ -5,8 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
-	"reflect"
+	"vectorDb/storefile"
 )
 
 // errorEncoder is a helper type to encode multiple values
@@ -37,6 +36,9 @@ func binaryRead(r io.Reader, data any) (int, error) {
 			return 0, err
 		}
 
+		if err := storefile.CheckLen(r, int64(ln), 1); err != nil {
+			return 0, err
+		}
 		s := make([]byte, ln)
 		_, err = binaryRead(r, &s)
 		*v = string(s)
@@ -49,6 +51,9 @@ func binaryRead(r io.Reader, data any) (int, error) {
 			return 0, err
 		}
 
+		if err := storefile.CheckLen(r, int64(ln), 4); err != nil {
+			return 0, err
+		}
 		*v = make([]float32, ln)
 		return binary.Size(*v), binary.Read(r, byteOrder, *v)
 	case io.ReaderFrom:
@@ -87,13 +92,9 @@ func binaryWrite(w io.Writer, data any) (int, error) {
 		if err != nil {
 			return n, err
 		}
-		log.Println("INSIDE WRITING float")
 		return n + binary.Size(v), binary.Write(w, byteOrder, v)
 
 	default:
-		log.Println("Printing v",v)
-		t := reflect.TypeOf(data)
-		log.Println(t)
 		sz := binary.Size(data)
 		err := binary.Write(w, byteOrder, data)
 		if err != nil {
@@ -106,9 +107,7 @@ func binaryWrite(w io.Writer, data any) (int, error) {
 func multiBinaryWrite(w io.Writer, data ...any) (int, error) {
 	var written int
 	for _, d := range data {
-		log.Println(d)
 		n, err := binaryWrite(w, d)
-		log.Println(n)
 		written += n
 		if err != nil {
 			return written, err
@@ -129,7 +128,14 @@ func multiBinaryRead(r io.Reader, data ...any) (int, error) {
 	return read, nil
 }
 
-const encodingVersion = 1
+const encodingVersion = 8
+
+// legacyLayout reports whether prefix starts like a file written before
+// version 8 added the header, with an earlier encoding version.
+func legacyLayout(prefix []byte) bool {
+	version, n := binary.Varint(prefix)
+	return n > 0 && version >= 1 && version < 8
+}
 
 
 // SavedGraph is a wrapper around a graph that persists
@@ -1,17 +1,20 @@
 package hnsw
 
 import (
-	"bufio"
 	"cmp"
+	"errors"
 	"fmt"
-	"log"
+	"io/fs"
 	"maps"
 	"math"
 	"math/rand"
 	"os"
-	"reflect"
 	"slices"
+	"sync"
+	"sync/atomic"
 	"time"
+	"vectorDb/distance"
+	"vectorDb/storefile"
 )
 
 type Embedding []float32
@@ -19,207 +22,324 @@ type Node[K cmp.Ordered] struct {
 	Key        K
 	Embed      Embedding
 	neighbours map[K]*Node[K]
+	// mu guards neighbours while BulkInsert links nodes concurrently.
+	mu *sync.Mutex
+	// code is the compressed form of the embedding, set when the graph is
+	// compressed. Embed is nil then, and slot locates the embedding in the
+	// graph's vector file.
+	code []byte
+	slot int64
 }
 
 func MakeNode[K cmp.Ordered](key K, embed Embedding) Node[K] {
 	return Node[K]{Key: key, Embed: embed}
 }
 
-type DistanceFunc func(vec1 Embedding, vec2 Embedding) float32
-
-// EuclideanDistance computes the Euclidean distance between two vectors.
-func EuclideanDistance(a, b Embedding) float32 {
-	// TODO: can we speedup with vek?
-	var sum float32 = 0
-	for i := range a {
-		diff := a[i] - b[i]
-		sum += diff * diff
+// newNode returns an unlinked node to store in a level of the graph.
+func newNode[K cmp.Ordered](key K, embed Embedding, code []byte) *Node[K] {
+	return &Node[K]{
+		Key:        key,
+		Embed:      embed,
+		neighbours: make(map[K]*Node[K]),
+		mu:         new(sync.Mutex),
+		code:       code,
 	}
-	return float32(math.Sqrt(float64(sum)))
 }
 
-// EuclideanDistSquare calculates the squared Euclidean distance between two vectors.
-// It's computationally cheaper than Euclidean distance and often sufficient for comparisons.
-func EuclideanDistSquare(p1 Embedding, p2 Embedding) float32 {
-	var sum float32 = 0
-	for i := range p1 {
-		d := p2[i] - p1[i] // Calculate the difference between corresponding coordinates.
-		sum += d * d       // Square the difference and add to the sum.
-	}
-	return sum
+// DistanceFunc is the distance function used to compare embeddings.
+// The available functions are registered in the distance package.
+type DistanceFunc = distance.Func
+
+type searchCandidate[K cmp.Ordered] struct {
+	node *Node[K]
+	dist float32
 }
 
-// DotProduct computes the DotProduct distance between two vectors.
-func DotProduct(vec1 Embedding, vec2 Embedding) float32 {
-	// TODO: can we speedup with vek?
-	var sum float32 = 0
-	for i := range vec1 {
-		prod := vec1[i] * vec2[i]
-		sum += prod
-	}
-	return sum
+func (s searchCandidate[K]) Less(o searchCandidate[K]) bool {
+	return s.dist < o.dist
 }
 
-var distanceFuncs = map[string]DistanceFunc{
-	"euclidean":      EuclideanDistance,
-	"dotProduct":     DotProduct,
-	"squareDistance": EuclideanDistSquare,
+// sortCandidates orders candidates nearest first, breaking ties by key so
+// that the order never depends on map iteration.
+func sortCandidates[K cmp.Ordered](candidates []searchCandidate[K]) {
+	slices.SortFunc(candidates, func(a, b searchCandidate[K]) int {
+		return cmp.Or(cmp.Compare(a.dist, b.dist), cmp.Compare(a.node.Key, b.node.Key))
+	})
 }
 
-func distanceFuncToName(fn DistanceFunc) (string, bool) {
-	for name, f := range distanceFuncs {
-		fnptr := reflect.ValueOf(fn).Pointer()
-		fptr := reflect.ValueOf(f).Pointer()
-		if fptr == fnptr {
-			return name, true
-		}
+// sortedNeighbours returns the neighbours of a node in key order.
+func (n *Node[K]) sortedNeighbours() []*Node[K] {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	out := make([]*Node[K], 0, len(n.neighbours))
+	for _, key := range slices.Sorted(maps.Keys(n.neighbours)) {
+		out = append(out, n.neighbours[key])
 	}
-	return "", false
+	return out
 }
 
-type searchCandidate[K cmp.Ordered] struct {
-	node *Node[K]
-	dist float32
+// neighbourList returns the neighbours of a node in no particular order,
+// for callers that sort them by distance anyway.
+func (n *Node[K]) neighbourList() []*Node[K] {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return slices.Collect(maps.Values(n.neighbours))
 }
 
-func (s searchCandidate[K]) Less(o searchCandidate[K]) bool {
-	return s.dist < o.dist
+// degree returns the number of neighbours of a node.
+func (n *Node[K]) degree() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.neighbours)
 }
 
-// search returns the node closest to the target node
-// within the same level.
+// lockPair locks two distinct nodes in key order, so that concurrent
+// links never deadlock, and returns the function unlocking them.
+func lockPair[K cmp.Ordered](a, b *Node[K]) func() {
+	if b.Key < a.Key {
+		a, b = b, a
+	}
+	a.mu.Lock()
+	b.mu.Lock()
+	return func() {
+		b.mu.Unlock()
+		a.mu.Unlock()
+	}
+}
+
+// farCandidate orders search candidates farthest first, so that a Heap of
+// them keeps the worst of a bounded result set on top.
+type farCandidate[K cmp.Ordered] searchCandidate[K]
+
+func (s farCandidate[K]) Less(o farCandidate[K]) bool {
+	return s.dist > o.dist
+}
+
+// search runs the greedy beam search of the HNSW paper (SEARCH-LAYER)
+// from n within its level, and returns the ef nodes closest to the target
+// found along the way, nearest first.
 func (n *Node[K]) search(
-	// k is the number of candidates in the result set.
-	k int,
-	efSearch int,
-	target Embedding,
-	distance DistanceFunc,
+	// ef is the size of the dynamic candidate list, and so of the result.
+	ef int,
+	// distance scores a node against the search target.
+	distance func(*Node[K]) float32,
+	// accept filters the result. Rejected nodes are still traversed, so
+	// that tombstones keep their neighbourhoods connected. nil accepts all.
+	accept func(*Node[K]) bool,
 ) []searchCandidate[K] {
-	// This is a basic greedy algorithm to find the entry point at the given level
-	// that is closest to the target node.
-	candidates := Heap[searchCandidate[K]]{}
-	candidates.Init(make([]searchCandidate[K], 0, efSearch))
-	candidates.Push(
-		searchCandidate[K]{
-			node: n,
-			dist: distance(n.Embed, target),
-		},
-	)
-	var (
-		result  = Heap[searchCandidate[K]]{}
-		visited = make(map[K]bool)
-	)
-	result.Init(make([]searchCandidate[K], 0, k))
+	ef = max(ef, 1)
+	start := searchCandidate[K]{node: n, dist: distance(n)}
 
-	// Begin with the entry node in the result set.
-	result.Push(candidates.Min())
-	visited[n.Key] = true
+	// candidates are the nodes left to expand, nearest first; result holds
+	// the best ef nodes seen so far, farthest first.
+	candidates := Heap[searchCandidate[K]]{}
+	candidates.Init(make([]searchCandidate[K], 0, ef))
+	candidates.Push(start)
+	result := Heap[farCandidate[K]]{}
+	result.Init(make([]farCandidate[K], 0, ef+1))
+	if accept == nil || accept(n) {
+		result.Push(farCandidate[K](start))
+	}
+	visited := map[K]bool{n.Key: true}
 
 	for candidates.Len() > 0 {
-		var (
-			current  = candidates.Pop().node
-			improved = false
-		)
+		current := candidates.Pop()
+		// Every remaining candidate is farther than the whole result set.
+		if result.Len() >= ef && current.dist > result.Min().dist {
+			break
+		}
 
 		// We iterate the map in a sorted, deterministic fashion for
 		// tests.
-		neighborKeys := maps.Keys(current.neighbours)
-		sortedNeighborKeys:=slices.Sorted(neighborKeys)
-		for _, neighborID := range sortedNeighborKeys {
-			neighbor := current.neighbours[neighborID]
-			if visited[neighborID] {
+		for _, neighbor := range current.node.sortedNeighbours() {
+			if visited[neighbor.Key] {
 				continue
 			}
-			visited[neighborID] = true
-
-			dist := distance(neighbor.Embed, target)
-			improved = improved || dist < result.Min().dist
-			if result.Len() < k {
-				result.Push(searchCandidate[K]{node: neighbor, dist: dist})
-			} else if dist < result.Max().dist {
-				result.PopLast()
-				result.Push(searchCandidate[K]{node: neighbor, dist: dist})
+			visited[neighbor.Key] = true
+
+			dist := distance(neighbor)
+			if result.Len() < ef || dist < result.Min().dist {
+				candidate := searchCandidate[K]{node: neighbor, dist: dist}
+				candidates.Push(candidate)
+				if accept != nil && !accept(neighbor) {
+					continue
+				}
+				result.Push(farCandidate[K](candidate))
+				if result.Len() > ef {
+					result.Pop()
+				}
 			}
+		}
+	}
 
-			candidates.Push(searchCandidate[K]{node: neighbor, dist: dist})
-			// Always store candidates if we haven't reached the limit.
-			if candidates.Len() > efSearch {
-				candidates.PopLast()
+	out := make([]searchCandidate[K], 0, result.Len())
+	for _, candidate := range result.Slice() {
+		out = append(out, searchCandidate[K](candidate))
+	}
+	sortCandidates(out)
+	return out
+}
+
+
+// selectNeighbours picks up to m neighbours for target among candidates,
+// which must be sorted nearest first with their distance to target, using
+// the heuristic of the HNSW paper (Algorithm 4): a candidate is kept only if
+// it is closer to target than to every neighbour kept so far. This favours
+// neighbours in different directions over a tight cluster of near
+// duplicates, which keeps clusters connected to each other.
+//
+// With extend, the neighbours of the candidates are considered too. With
+// keepPruned, discarded candidates fill any free slots, nearest first.
+func selectNeighbours[K cmp.Ordered](
+	target *Node[K],
+	candidates []searchCandidate[K],
+	m int,
+	dist func(a, b *Node[K]) float32,
+	extend, keepPruned bool,
+) []searchCandidate[K] {
+	if extend {
+		seen := make(map[K]bool, len(candidates))
+		for _, c := range candidates {
+			seen[c.node.Key] = true
+		}
+		extended := slices.Clone(candidates)
+		for _, c := range candidates {
+			for _, neighbour := range c.node.sortedNeighbours() {
+				key := neighbour.Key
+				if seen[key] || key == target.Key {
+					continue
+				}
+				seen[key] = true
+				extended = append(extended, searchCandidate[K]{node: neighbour, dist: dist(neighbour, target)})
 			}
 		}
+		sortCandidates(extended)
+		candidates = extended
+	}
 
-		// Termination condition: no improvement in distance and at least
-		// kMin candidates in the result set.
-		if !improved && result.Len() >= k {
+	selected := make([]searchCandidate[K], 0, m)
+	var pruned []searchCandidate[K]
+	for _, c := range candidates {
+		if len(selected) == m {
 			break
 		}
+		keep := true
+		for _, s := range selected {
+			if dist(c.node, s.node) < c.dist {
+				keep = false
+				break
+			}
+		}
+		if keep {
+			selected = append(selected, c)
+		} else {
+			pruned = append(pruned, c)
+		}
 	}
-
-	return result.Slice()
+	if keepPruned {
+		for _, c := range pruned {
+			if len(selected) == m {
+				break
+			}
+			selected = append(selected, c)
+		}
+	}
+	return selected
 }
 
-
-func (node *Node[K]) addNeighbour(neighbor *Node[K], m int, dist DistanceFunc) {
-	if node.neighbours == nil {
-		node.neighbours = make(map[K]*Node[K])
+// link connects the two nodes in both directions.
+func link[K cmp.Ordered](a, b *Node[K]) {
+	defer lockPair(a, b)()
+	if a.neighbours == nil {
+		a.neighbours = make(map[K]*Node[K])
+	}
+	if b.neighbours == nil {
+		b.neighbours = make(map[K]*Node[K])
 	}
+	a.neighbours[b.Key] = b
+	b.neighbours[a.Key] = a
+}
+
+// unlink removes the connection between the two nodes in both directions.
+func unlink[K cmp.Ordered](a, b *Node[K]) {
+	defer lockPair(a, b)()
+	delete(a.neighbours, b.Key)
+	delete(b.neighbours, a.Key)
+}
 
-	node.neighbours[neighbor.Key] = neighbor
-	if len(node.neighbours) <= m {
+// shrink reduces the neighbours of an over-connected node to m, keeping
+// those selectNeighbours prefers and filling up with the nearest pruned
+// ones so that only the excess links are dropped. Links stay symmetric, so
+// a dropped neighbour loses its link back too and is replenished.
+func (node *Node[K]) shrink(m int, dist func(a, b *Node[K]) float32) {
+	if node.degree() <= m {
 		return
 	}
-
-	// Find the neighbor with the worst distance.
-	var (
-		worstDist = float32(math.Inf(-1))
-		worst     *Node[K]
-	)
-	for _, neighbor := range node.neighbours {
-		d := dist(neighbor.Embed, node.Embed)
-		// d > worstDist may always be false if the distance function
-		// returns NaN, e.g., when the embeddings are zero.
-		if d > worstDist || worst == nil {
-			worstDist = d
-			worst = neighbor
+	neighbours := node.neighbourList()
+	candidates := make([]searchCandidate[K], 0, len(neighbours))
+	for _, neighbour := range neighbours {
+		candidates = append(candidates, searchCandidate[K]{node: neighbour, dist: dist(neighbour, node)})
+	}
+	sortCandidates(candidates)
+	keep := make(map[K]bool, m)
+	for _, c := range selectNeighbours(node, candidates, m, dist, false, true) {
+		keep[c.node.Key] = true
+	}
+	for _, c := range candidates {
+		if !keep[c.node.Key] {
+			unlink(node, c.node)
+			c.node.replenish(m, dist)
 		}
 	}
-
-	delete(node.neighbours, worst.Key)
-	// Delete backlink from the worst neighbor.
-	delete(worst.neighbours, node.Key)
-	worst.replenish(m)
 }
 
-func (node *Node[K]) replenish(m int) {
-	if len(node.neighbours) >= m {
+// replenish restores the connectivity of a node that lost neighbours by
+// linking it to the nearest neighbours of its neighbours, by the graph's
+// distance function. Only nodes with a free slot are linked so that
+// replenishing never displaces other links.
+func (node *Node[K]) replenish(m int, dist func(a, b *Node[K]) float32) {
+	if node.degree() >= m {
 		return
 	}
-	// Restore connectivity by adding new neighbors.
-	// This is a naive implementation that could be improved by
-	// using a priority queue to find the best candidates.
-	for _, neighbour := range node.neighbours {
-		for key, candidate := range neighbour.neighbours {
-			if _, ok := node.neighbours[key]; ok {
-				// do not add duplicates
+	neighbours := node.neighbourList()
+	var candidates []searchCandidate[K]
+	seen := map[K]bool{node.Key: true}
+	for _, neighbour := range neighbours {
+		seen[neighbour.Key] = true
+	}
+	for _, neighbour := range neighbours {
+		for _, candidate := range neighbour.neighbourList() {
+			if seen[candidate.Key] {
 				continue
 			}
-			if candidate == node {
+			seen[candidate.Key] = true
+			if candidate.degree() >= m {
 				continue
 			}
-			node.addNeighbour(candidate, m, EuclideanDistSquare)
-			if len(node.neighbours) >= m {
-				return
-			}
+			candidates = append(candidates, searchCandidate[K]{node: candidate, dist: dist(candidate, node)})
+		}
+	}
+	sortCandidates(candidates)
+	for _, c := range candidates {
+		if node.degree() >= m {
+			return
 		}
+		link(node, c.node)
 	}
 }
 
 // isolates remove the node from the graph by removing all connections
 // to neighbors.
-func (node *Node[K]) isolate(m int) {
-	for _, neighbor := range node.neighbours {
-		delete(neighbor.neighbours, node.Key)
-		neighbor.replenish(m)
+func (node *Node[K]) isolate(m int, dist func(a, b *Node[K]) float32) {
+	// Unlink from every neighbour before replenishing any, so that none
+	// picks the node back up through another neighbour. Replenishing in
+	// key order keeps the result independent of map iteration.
+	neighbours := node.sortedNeighbours()
+	for _, neighbor := range neighbours {
+		unlink(node, neighbor)
+	}
+	for _, neighbor := range neighbours {
+		neighbor.replenish(m, dist)
 	}
 }
 
@@ -232,18 +352,13 @@ type level[K cmp.Ordered] struct {
 	nodes map[K]*Node[K]
 }
 
-// entry returns the entry node of the layer.
-// It doesn't matter which node is returned, even that the
-// entry node is consistent, so we just return the first node
-// in the map to avoid tracking extra state.
-func (l *level[K]) entry() *Node[K] {
-	if l == nil {
-		return nil
-	}
-	for _, node := range l.nodes {
-		return node
+// sortedNodes returns the nodes of the level in key order.
+func (l *level[K]) sortedNodes() []*Node[K] {
+	out := make([]*Node[K], 0, len(l.nodes))
+	for _, key := range slices.Sorted(maps.Keys(l.nodes)) {
+		out = append(out, l.nodes[key])
 	}
-	return nil
+	return out
 }
 
 func (l *level[K]) size() int {
@@ -267,23 +382,139 @@ type HNSWGraph[K cmp.Ordered] struct {
 
 	// EfSearch is the number of nodes to consider in the search phase.
 	// 20 is a reasonable default. Higher values improve search accuracy at
-	// the expense of memory.
+	// the expense of memory. It can be overridden per query with SearchEf.
 	EfSearch int
 
+	// EfConstruction is the number of nodes to consider when choosing the
+	// neighbours of an inserted node. Higher values build a better graph,
+	// and so improve recall at any EfSearch, at the expense of insert time.
+	EfConstruction int
+
+	// M0 is the maximum number of neighbors to keep for each node in the
+	// base layer, which holds every node. Zero means 2*M.
+	M0 int
+
+	// ExtendCandidates makes neighbour selection also consider the
+	// neighbours of the candidates found on insert. It helps on heavily
+	// clustered data at a significant insert cost.
+	ExtendCandidates bool
+
+	// KeepPrunedConnections fills the free neighbour slots left by the
+	// selection heuristic with the nearest discarded candidates.
+	KeepPrunedConnections bool
+
+	// Rerank is the number of candidates re-scored with full-precision
+	// embeddings when the graph is compressed. Values below k or the
+	// query's ef are raised to them.
+	Rerank int
+
+	// SkipRerank returns the results of a compressed graph in the order of
+	// their compressed distances, skipping the full-precision pass.
+	SkipRerank bool
+
+	// CompactionThreshold is the fraction of tombstoned nodes at which
+	// Delete starts a compaction in the background. Zero disables
+	// automatic compaction.
+	CompactionThreshold float64
+
+	// RebuildOnCompaction makes compaction rebuild the graph from its live
+	// nodes rather than repair the neighbourhoods of the tombstones. It is
+	// slower but restores the quality of a freshly built graph.
+	RebuildOnCompaction bool
+
+	// compressor encodes node embeddings for traversal, or is nil when
+	// the graph searches on full-precision embeddings.
+	compressor compressor
+	// vectors holds the full-precision embeddings of a compressed graph.
+	vectors *vectorFile
+
+	// buildMu is held by every insert, exclusively by those that raise the
+	// top level, and levelsMu guards the level maps and the entry point
+	// while BulkInsert inserts concurrently.
+	buildMu  sync.RWMutex
+	levelsMu sync.Mutex
+
+	// mu is held for reading by the methods that only read the graph, and
+	// for writing by those that modify it and by compactions, so that a
+	// method never sees the graph half changed. Methods only call the
+	// unexported helpers of one another, as a read lock taken twice
+	// deadlocks once a writer is waiting.
+	mu sync.RWMutex
+	// compacting is set while a background compaction is pending or
+	// running, so that Delete starts at most one at a time, and
+	// compaction is done with it.
+	compacting atomic.Bool
+	compaction sync.WaitGroup
+
+	// tombstones holds the keys of deleted nodes, which stay linked into
+	// the graph for traversal until the next compaction removes them.
+	tombstones map[K]bool
+	// compactions counts the compactions run so far, for Stats.
+	compactions int
+
+	// seed seeds Rng when seeded is set; see NewHNSWGraphWithSeed.
+	seed   int64
+	seeded bool
+
+	// entry is the key of the entry point: a node of the top level, from
+	// which inserts and searches descend. It is nil iff the graph is empty.
+	entry *K
+
 	levels []*level[K]
 }
 
+// NewHNSWGraph creates an empty graph using the distance function registered
+// under the given name in the distance package, euclidean if empty.
 func NewHNSWGraph[K cmp.Ordered](distanceFunc string) *HNSWGraph[K] {
-	if(distanceFunc==""){
-		distanceFunc="euclidean"
-	}
+	dist, _ := distance.Lookup(distanceFunc)
 	return &HNSWGraph[K]{
-		M:        16,
-		Ml:       0.25,
-		EfSearch: 20,
-		Rng:      defaultRand(),
-		Distance: distanceFuncs[distanceFunc],
+		M:              16,
+		Ml:             0.25,
+		EfSearch:       20,
+		EfConstruction: 100,
+		Rng:            defaultRand(),
+		Distance:       dist,
+
+		CompactionThreshold: 0.25,
+	}
+}
+
+// NewHNSWGraphWithSeed is like NewHNSWGraph with the level generator seeded
+// with seed, so that inserting the same nodes in the same order always
+// builds the same graph. The seed is saved with the graph, and a loaded
+// graph reseeds its generator with it.
+func NewHNSWGraphWithSeed[K cmp.Ordered](distanceFunc string, seed int64) *HNSWGraph[K] {
+	g := NewHNSWGraph[K](distanceFunc)
+	g.seed, g.seeded = seed, true
+	g.Rng = rand.New(rand.NewSource(seed))
+	return g
+}
+
+// Seed returns the seed of the graph's generator, and false if the graph
+// was not created with one.
+func (g *HNSWGraph[K]) Seed() (int64, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.seed, g.seeded
+}
+
+// maxNeighbours returns the maximum number of neighbours of a node in the
+// given level: M0 in the base layer and M above.
+func (g *HNSWGraph[K]) maxNeighbours(level int) int {
+	if level > 0 {
+		return g.M
+	}
+	if g.M0 > 0 {
+		return g.M0
 	}
+	return 2 * g.M
+}
+
+// normalizes reports whether the graph's metric expects unit vectors,
+// in which case embeddings are normalised on insert and search.
+func (g *HNSWGraph[K]) normalizes() bool {
+	name, _ := distance.Name(g.Distance)
+	return distance.NeedsNormalization(name)
 }
 
 func defaultRand() *rand.Rand {
@@ -309,16 +540,17 @@ func maxLevel(ml float64, numNodes int) int {
 	return m
 }
 
-// randomLevel generates a random level for a new node.
-func (g *HNSWGraph[K]) randomLevel() int {
+// randomLevel generates a random level for a new node, given the size of
+// the base layer it is inserted into.
+func (g *HNSWGraph[K]) randomLevel(size int) int {
 	// max avoids having to accept an additional parameter for the maximum level
 	// by calculating a probably good one from the size of the base layer.
 	max := 1
-	if len(g.levels) > 0 {
+	if size > 0 {
 		if g.Ml == 0 {
 			panic("(*Graph).Ml must be greater than 0")
 		}
-		max = maxLevel(g.Ml, g.levels[0].size())
+		max = maxLevel(g.Ml, size)
 	}
 
 	for level := range max {
@@ -338,7 +570,7 @@ func (g *HNSWGraph[K]) assertDims(n Embedding) {
 	if len(g.levels) == 0 {
 		return
 	}
-	hasDims := g.Dims()
+	hasDims := g.dims()
 	if hasDims != len(n) {
 		panic(fmt.Sprint("embedding dimension mismatch: ", hasDims, " != ", len(n)))
 	}
@@ -347,18 +579,45 @@ func (g *HNSWGraph[K]) assertDims(n Embedding) {
 // Dims returns the number of dimensions in the graph, or
 // 0 if the graph is empty.
 func (g *HNSWGraph[K]) Dims() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.dims()
+}
+
+// dims is Dims for the methods of the graph, which hold mu already.
+func (g *HNSWGraph[K]) dims() int {
 	if len(g.levels) == 0 {
 		return 0
 	}
-	return len(g.levels[0].entry().Embed)
+	if g.compressor != nil {
+		return g.compressor.dims()
+	}
+	return len(g.levels[0].nodes[*g.entry].Embed)
 }
 
-// Len returns the number of nodes in the graph.
+// Len returns the number of live nodes in the graph, not counting
+// tombstones.
 func (g *HNSWGraph[K]) Len() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.liveLen()
+}
+
+// liveLen is Len for the methods of the graph, which hold mu already.
+func (g *HNSWGraph[K]) liveLen() int {
 	if len(g.levels) == 0 {
 		return 0
 	}
-	return g.levels[0].size()
+	return g.levels[0].size() - len(g.tombstones)
+}
+
+// Metric returns the name of the graph's distance function, or an empty
+// string if it is not registered in the distance package.
+func (g *HNSWGraph[K]) Metric() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	name, _ := distance.Name(g.Distance)
+	return name
 }
 
 func ptr[T any](v T) *T {
@@ -368,172 +627,397 @@ func ptr[T any](v T) *T {
 // inserts nodes into the graph.
 // If another node with the same ID exists, it is replaced.
 func (g *HNSWGraph[K]) Insert(nodes ...Node[K]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.insertNodes(nodes)
+}
+
+// insertNodes is Insert for the methods of the graph, which hold mu
+// already.
+func (g *HNSWGraph[K]) insertNodes(nodes []Node[K]) {
+	if g.Distance == nil {
+		panic("(*Graph).Distance must be set")
+	}
 	for _, node := range nodes {
 		key := node.Key
 		embedding := node.Embed
+		if g.normalizes() {
+			embedding = distance.Normalize(embedding)
+		}
 
 		g.assertDims(embedding)
-		insertLevel := g.randomLevel()
-		// Create layers that don't exist yet.
-		for insertLevel >= len(g.levels) {
-			g.levels = append(g.levels, &level[K]{})
+
+		// Remove the node being replaced, or its tombstone, before
+		// descending so that no search lands on it.
+		if len(g.levels) > 0 && g.levels[0].nodes[key] != nil {
+			g.remove(key)
 		}
+		preLen := g.liveLen()
 
+		size := 0
+		if len(g.levels) > 0 {
+			size = g.levels[0].size()
+		}
+		insertLevel := g.randomLevel(size)
 		if insertLevel < 0 {
 			panic("invalid level")
 		}
+		g.insert(key, embedding, insertLevel)
 
-		var elevator *K
-
-		preLen := g.Len()
+		// Invariant check: the node should have been added to the graph.
+		if g.liveLen() != preLen+1 {
+			panic("node not added")
+		}
+	}
+}
 
-		// Insert node at each level, beginning with the highest.
-		for i := len(g.levels) - 1; i >= 0; i-- {
-			level := g.levels[i]
-			newNode :=&Node[K]{
-				Key: key,
-				Embed: embedding,
-				neighbours: make(map[K]*Node[K]),
-			}
-			
+// insert adds a node that is not in the graph yet at the given level and
+// all levels below it, linking it to its nearest neighbours in each. It is
+// safe to call from several goroutines at once, as BulkInsert does, but not
+// concurrently with any other method.
+func (g *HNSWGraph[K]) insert(key K, embedding Embedding, insertLevel int) {
+	// Inserts that raise the top level take the graph exclusively, so
+	// that no other insert descends from the new entry point before it
+	// is linked in.
+	g.buildMu.RLock()
+	unlock := g.buildMu.RUnlock
+	if insertLevel >= len(g.levels) {
+		g.buildMu.RUnlock()
+		g.buildMu.Lock()
+		unlock = g.buildMu.Unlock
+	}
+	defer unlock()
 
-			// Insert the new node into the layer.
-			if level.entry() == nil {
-				level.nodes = make(map[K]*Node[K])
-				level.nodes[key]=newNode
-				continue
-			}
+	// A compressed graph links the node by its code and keeps the
+	// embedding in the vector file.
+	score := func(n *Node[K]) float32 {
+		return g.Distance(n.Embed, embedding)
+	}
+	var code []byte
+	var slot int64
+	embed := embedding
+	if g.compressor != nil {
+		code = g.compressor.encode(embedding)
+		scoreCode := g.compressor.scorer(embedding)
+		score = func(n *Node[K]) float32 {
+			return scoreCode(n.code)
+		}
+		var err error
+		if slot, err = g.vectors.put(embedding); err != nil {
+			panic(err)
+		}
+		embed = nil
+	}
 
-			// Now at the highest level with more than one node, so we can begin
-			// searching for the best way to enter the graph.
-			searchPoint := level.entry()
+	// Add the node to all its levels up front, unlinked, so that an insert
+	// that reaches it through its links in one level finds it in the
+	// levels below. Levels above the current top hold only the new node,
+	// which becomes the entry point.
+	g.levelsMu.Lock()
+	elevator := g.entry
+	topLevel := len(g.levels) - 1
+	inserted := make([]*Node[K], insertLevel+1)
+	for i := range inserted {
+		inserted[i] = newNode(key, embed, code)
+		inserted[i].slot = slot
+		if i < len(g.levels) {
+			g.levels[i].nodes[key] = inserted[i]
+		} else {
+			g.levels = append(g.levels, &level[K]{nodes: map[K]*Node[K]{key: inserted[i]}})
+		}
+	}
+	if insertLevel > topLevel {
+		g.entry = ptr(key)
+	}
+	g.levelsMu.Unlock()
+
+	// Descend from the entry point, beginning with the top level. The
+	// elevator is the closest node found so far, from which the search
+	// in the next level starts.
+	for i := topLevel; i >= 0; i-- {
+		g.levelsMu.Lock()
+		start := g.levels[i].nodes[*elevator]
+		g.levelsMu.Unlock()
+
+		// Above the node's own levels we only need the closest node to
+		// descend from; below, EfConstruction candidates to pick
+		// neighbours among.
+		ef := 1
+		if insertLevel >= i {
+			ef = max(g.EfConstruction, g.M)
+		}
+		neighborhood := start.search(ef, score, nil)
+		if len(neighborhood) == 0 {
+			// This should never happen because the search point itself
+			// should be in the result set.
+			panic("no nodes found")
+		}
 
-			// On subsequent layers, we use the elevator node to enter the graph
-			// at the best point.
-			if elevator != nil {
-				searchPoint = level.nodes[*elevator]
+		// Re-set the elevator node for the next layer.
+		elevator = ptr(neighborhood[0].node.Key)
+
+		if insertLevel >= i {
+			n := inserted[i]
+			mMax := g.maxNeighbours(i)
+			selected := selectNeighbours(n, neighborhood, g.M, g.between, g.ExtendCandidates, g.KeepPrunedConnections)
+			for _, node := range selected {
+				// Create a bi-directional edge between the new node and the selected node.
+				link(n, node.node)
+				node.node.shrink(mMax, g.between)
 			}
+		}
+	}
+}
 
-			if g.Distance == nil {
-				panic("(*Graph).Distance must be set")
-			}
+// Search finds the k nearest neighbors from the target node,
+// considering EfSearch candidates at the base layer.
+func (h *HNSWGraph[K]) Search(near Embedding, k int) []Node[K] {
+	return h.SearchEf(near, k, h.EfSearch)
+}
 
-			neighborhood := searchPoint.search(g.M, g.EfSearch, embedding, g.Distance)
-			if len(neighborhood) == 0 {
-				// This should never happen because the searchPoint itself
-				// should be in the result set.
-				panic("no nodes found")
-			}
+// SearchEf is like Search with an explicit number of candidates to consider
+// at the base layer. ef is raised to k if smaller, since the search can't
+// return more nodes than it considers.
+func (h *HNSWGraph[K]) SearchEf(near Embedding, k int, ef int) []Node[K] {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.assertDims(near)
+	if len(h.levels) == 0 || k <= 0 {
+		return nil
+	}
+	if h.normalizes() {
+		near = distance.Normalize(near)
+	}
+	return h.resultNodes(h.search(near, k, ef))
+}
 
-			// Re-set the elevator node for the next layer.
-			elevator = ptr(neighborhood[0].node.Key)
+// SearchRange returns the nodes within radius of near, nearest first, and
+// at most maxResults of them if maxResults > 0. Distances are those of
+// Distance, smaller meaning closer, at full precision even on a compressed
+// graph. The search widens ef until nodes beyond radius make up half of
+// its results, but like Search it can miss nodes that it never reaches.
+func (h *HNSWGraph[K]) SearchRange(near Embedding, radius float32, maxResults int) []Node[K] {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	h.assertDims(near)
+	if len(h.levels) == 0 {
+		return nil
+	}
+	if h.normalizes() {
+		near = distance.Normalize(near)
+	}
 
-			if insertLevel >= i {
-				if _, ok := level.nodes[key]; ok {
-					g.Delete(key)
-				}
-				// Insert the new node into the layer.
-				level.nodes[key] = newNode
-				for _, node := range neighborhood {
-					// Create a bi-directional edge between the new node and the best node.
-					node.node.addNeighbour(newNode, g.M, g.Distance)
-					newNode.addNeighbour(node.node, g.M, g.Distance)
-				}
-			}
+	limit := h.liveLen()
+	if maxResults > 0 {
+		limit = min(limit, maxResults)
+	}
+	var within []searchCandidate[K]
+	for k := min(max(h.EfSearch, 1), limit); ; k = min(2*k, limit) {
+		found := h.search(near, k, k)
+		if h.compressor != nil && h.SkipRerank {
+			found = h.rerank(found, k, near)
 		}
-
-		// Invariant check: the node should have been added to the graph.
-		if g.Len() != preLen+1 {
-			panic("node not added")
+		n := slices.IndexFunc(found, func(c searchCandidate[K]) bool {
+			return c.dist > radius
+		})
+		if n < 0 {
+			n = len(found)
+		}
+		within = found[:n]
+		// Stop once nodes beyond radius fill half the results, which
+		// leaves a margin for the approximate search, or ef can't grow.
+		if 2*n <= len(found) || len(found) < k || k == limit {
+			break
 		}
 	}
+	return h.resultNodes(within)
 }
 
-
-// Search finds the k nearest neighbors from the target node.
-func (h *HNSWGraph[K]) Search(near Embedding, k int) []Node[K] {
-	h.assertDims(near)
-	if len(h.levels) == 0 {
-		return nil
+// resultNodes copies the nodes of search results out of the graph, with
+// their embeddings read back if it is compressed.
+func (h *HNSWGraph[K]) resultNodes(candidates []searchCandidate[K]) []Node[K] {
+	out := make([]Node[K], 0, len(candidates))
+	for _, c := range candidates {
+		node := *c.node
+		node.Embed = h.embedding(c.node)
+		out = append(out, node)
 	}
+	return out
+}
 
-	var (
-		efSearch = h.EfSearch
+// search returns the k live nodes nearest to near, which must be normalised
+// if the metric expects it, considering ef candidates at the base layer.
+// The distances are full-precision ones unless the graph is compressed with
+// SkipRerank set.
+func (h *HNSWGraph[K]) search(near Embedding, k int, ef int) []searchCandidate[K] {
+	efSearch := max(ef, k)
 
-		elevator *K
-	)
+	score := func(n *Node[K]) float32 {
+		return h.Distance(n.Embed, near)
+	}
+	// On a compressed graph, traverse on the codes and keep enough
+	// candidates at the base layer to re-score them at full precision.
+	if h.compressor != nil {
+		scoreCode := h.compressor.scorer(near)
+		score = func(n *Node[K]) float32 {
+			return scoreCode(n.code)
+		}
+		efSearch = max(efSearch, h.Rerank)
+	}
 
+	// Tombstones are traversed at every level but only live nodes are
+	// returned.
+	var accept func(*Node[K]) bool
+	if len(h.tombstones) > 0 {
+		accept = h.live
+	}
+
+	// Descend from the entry point, keeping the closest node of each
+	// level as the starting point of the next.
+	elevator := *h.entry
 	for level := len(h.levels) - 1; level >= 0; level-- {
-		searchPoint := h.levels[level].entry()
-		if elevator != nil {
-			searchPoint = h.levels[level].nodes[*elevator]
-		}
+		searchPoint := h.levels[level].nodes[elevator]
 
 		// Descending hierarchies
 		if level > 0 {
-			nodes := searchPoint.search(1, efSearch, near, h.Distance)
-			elevator = ptr(nodes[0].node.Key)
+			nodes := searchPoint.search(1, score, nil)
+			elevator = nodes[0].node.Key
 			continue
 		}
 
-		nodes := searchPoint.search(k, efSearch, near, h.Distance)
-		out := make([]Node[K], 0, len(nodes))
-
-		for _, node := range nodes {
-			out = append(out, *node.node)
+		nodes := searchPoint.search(efSearch, score, accept)
+		if h.compressor != nil && !h.SkipRerank {
+			return h.rerank(nodes, k, near)
 		}
-
-		return out
+		return nodes[:min(k, len(nodes))]
 	}
 
 	panic("unreachable")
 }
 
 // Delete removes a node from the graph by key.
-// It tries to preserve the clustering properties of the graph by
-// replenishing connectivity in the affected neighborhoods.
+// The node is only marked with a tombstone: it is no longer returned by
+// searches and lookups but stays in the graph for traversal, so deletes are
+// cheap and leave neighbourhoods connected. Once the tombstones reach
+// CompactionThreshold of the nodes, Delete starts compacting the graph on
+// another goroutine and returns; see Compact. The methods called meanwhile
+// wait for the compaction to finish.
 func (h *HNSWGraph[K]) Delete(key K) bool {
-	if len(h.levels) == 0 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.lookup(key); !ok {
 		return false
 	}
+	if h.tombstones == nil {
+		h.tombstones = make(map[K]bool)
+	}
+	h.tombstones[key] = true
+
+	switch {
+	case h.liveLen() == 0:
+		// Nothing is left to traverse to.
+		h.releaseAll()
+		h.levels, h.entry, h.tombstones = nil, nil, nil
+	case h.CompactionThreshold > 0 && h.tombstoneRatio() >= h.CompactionThreshold:
+		h.compactInBackground()
+	}
+	return true
+}
 
-	var deleted bool
-	for _, layer := range h.levels {
+// remove unlinks a node from every level of the graph right away.
+// It tries to preserve the clustering properties of the graph by
+// replenishing connectivity in the affected neighborhoods.
+func (h *HNSWGraph[K]) remove(key K) {
+	for i, layer := range h.levels {
 		node, ok := layer.nodes[key]
 		if !ok {
 			continue
 		}
 		delete(layer.nodes, key)
-		node.isolate(h.M)
-		deleted = true
+		node.isolate(h.maxNeighbours(i), h.between)
+		if i == 0 {
+			h.release(node)
+		}
 	}
+	delete(h.tombstones, key)
+	h.trimLevels()
+	if h.entry != nil && *h.entry == key {
+		h.entry = h.topNode()
+	}
+}
+
+// trimLevels drops the empty levels at the top of the graph, so that the
+// top level always holds the entry point. Lower levels can't be empty
+// while a higher one isn't, since every node is in all levels below its own.
+func (h *HNSWGraph[K]) trimLevels() {
+	for len(h.levels) > 0 && h.levels[len(h.levels)-1].size() == 0 {
+		h.levels = h.levels[:len(h.levels)-1]
+	}
+}
 
-	return deleted
+// topNode returns the smallest key in the top level, the deterministic
+// choice of entry point when the current one goes away, or nil if the
+// graph is empty.
+func (h *HNSWGraph[K]) topNode() *K {
+	if len(h.levels) == 0 {
+		return nil
+	}
+	return ptr(slices.Min(slices.Collect(maps.Keys(h.levels[len(h.levels)-1].nodes))))
 }
 
 // Lookup returns the vector with the given key.
 func (h *HNSWGraph[K]) Lookup(key K) (Embedding, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.lookup(key)
+}
+
+// lookup is Lookup for the methods of the graph, which hold mu already.
+func (h *HNSWGraph[K]) lookup(key K) (Embedding, bool) {
 	if len(h.levels) == 0 {
 		return nil, false
 	}
 
 	node, ok := h.levels[0].nodes[key]
-	if !ok {
+	if !ok || h.tombstones[key] {
 		return nil, false
 	}
-	return node.Embed, ok
+	return h.embedding(node), ok
 }
 
+// Each calls fn with every live key and its vector in key order, stopping
+// at and returning the first error fn returns. The vector must not be
+// modified.
+func (h *HNSWGraph[K]) Each(fn func(key K, vector Embedding) error) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, node := range h.liveNodes() {
+		embedding, err := h.readEmbedding(node)
+		if err != nil {
+			return err
+		}
+		if err := fn(node.Key, embedding); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
 // Import reads the graph from a reader.
 // T must implement io.ReaderFrom.
 // The imported graph does not have to match the exported graph's parameters (except for
 // dimensionality). The graph will converge onto the new parameters.
 func (h *HNSWGraph[K]) Load(storeName string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
 
 	// r:=bufio.NewReader()
 
-	f, err := os.OpenFile(storeName+"_hnsw"+".store", os.O_RDWR|os.O_CREATE, 0o600)
+	// A graph never saved has no file, and nothing to load.
+	f, err := os.Open(storeName + "_hnsw" + ".store")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -542,90 +1026,258 @@ func (h *HNSWGraph[K]) Load(storeName string) error {
 	if err != nil {
 		return err
 	}
-	log.Println(info.Size())
 	if (info.Size() > 0) {
-		r := bufio.NewReader(f)
-		var (
-			version int
-			dist    string
-		)
-		_, err = multiBinaryRead(r, &version, &h.M, &h.Ml, &h.EfSearch,
-			&dist,
-		)
+		r, err := storefile.NewReader(f, info.Size(), "hnsw", encodingVersion, legacyLayout)
 		if err != nil {
 			return err
 		}
-		log.Println(h.M,h.Ml,h.EfSearch,dist)
-		var ok bool
-		h.Distance, ok = distanceFuncs[dist]
-		if !ok {
-			return fmt.Errorf("unknown distance function %q", dist)
+		// Decode into a separate graph, so that a file that fails to
+		// decode leaves this one unchanged. Older versions don't save
+		// every parameter, which then keep their current value.
+		loaded := &HNSWGraph[K]{
+			M:                     h.M,
+			Ml:                    h.Ml,
+			EfSearch:              h.EfSearch,
+			EfConstruction:        h.EfConstruction,
+			M0:                    h.M0,
+			ExtendCandidates:      h.ExtendCandidates,
+			KeepPrunedConnections: h.KeepPrunedConnections,
+			Rng:                   h.Rng,
+			Rerank:                h.Rerank,
+			CompactionThreshold:   h.CompactionThreshold,
+			RebuildOnCompaction:   h.RebuildOnCompaction,
 		}
-		if h.Rng == nil {
-			h.Rng = defaultRand()
+		err = loaded.read(r)
+		if err != nil {
+			return err
 		}
+		h.M, h.Ml, h.EfSearch, h.EfConstruction, h.M0 = loaded.M, loaded.Ml, loaded.EfSearch, loaded.EfConstruction, loaded.M0
+		h.ExtendCandidates, h.KeepPrunedConnections = loaded.ExtendCandidates, loaded.KeepPrunedConnections
+		h.Rng, h.seed, h.seeded = loaded.Rng, loaded.seed, loaded.seeded
+		h.Distance, h.Rerank = loaded.Distance, loaded.Rerank
+		h.CompactionThreshold, h.RebuildOnCompaction = loaded.CompactionThreshold, loaded.RebuildOnCompaction
+		h.compressor, h.vectors = loaded.compressor, loaded.vectors
+		h.levels, h.entry = loaded.levels, loaded.entry
+		h.tombstones, h.compactions = loaded.tombstones, 0
+	}
 
-		if version != encodingVersion {
-			return fmt.Errorf("incompatible encoding version: %d", version)
+	return nil
+}
+
+// read decodes a graph saved by Save into h, which holds the parameters
+// that files of older versions don't save.
+func (h *HNSWGraph[K]) read(r *storefile.Reader) error {
+	var (
+		version int
+		dist    string
+	)
+	_, err := binaryRead(r, &version)
+	if err != nil {
+		return err
+	}
+	if version < 1 || version > encodingVersion {
+		return fmt.Errorf("incompatible encoding version: %d", version)
+	}
+	_, err = multiBinaryRead(r, &h.M, &h.Ml, &h.EfSearch)
+	if err != nil {
+		return err
+	}
+	// Version 3 added EfConstruction after EfSearch, and version 4
+	// the neighbour selection parameters.
+	if version >= 3 {
+		_, err = binaryRead(r, &h.EfConstruction)
+		if err != nil {
+			return err
 		}
+	}
+	if version >= 4 {
+		_, err = multiBinaryRead(r, &h.M0, &h.ExtendCandidates, &h.KeepPrunedConnections)
+		if err != nil {
+			return err
+		}
+	}
+	// Version 6 added the seed.
+	if version >= 6 {
+		_, err = multiBinaryRead(r, &h.seeded, &h.seed)
+		if err != nil {
+			return err
+		}
+		if h.seeded {
+			h.Rng = rand.New(rand.NewSource(h.seed))
+		}
+	}
+	// Version 7 added the compaction parameters.
+	if version >= 7 {
+		_, err = multiBinaryRead(r, &h.CompactionThreshold, &h.RebuildOnCompaction)
+		if err != nil {
+			return err
+		}
+	}
+	_, err = binaryRead(r, &dist)
+	if err != nil {
+		return err
+	}
+	// Version 1 graphs could use "dotProduct", which ranked the
+	// largest products farthest, unlike any registered metric.
+	if dist == "dotProduct" {
+		return fmt.Errorf("distance function %q is no longer supported: rebuild the graph with %q", dist, distance.NameInnerProduct)
+	}
+	var ok bool
+	h.Distance, ok = distance.Lookup(dist)
+	if !ok {
+		return fmt.Errorf("unknown distance function %q", dist)
+	}
+	if h.Rng == nil {
+		h.Rng = defaultRand()
+	}
 
-		var nLayers int
-		_, err = binaryRead(r, &nLayers)
-		log.Println(nLayers)
+	// Version 2 added compression after the parameters.
+	if version >= 2 {
+		var compression string
+		_, err = multiBinaryRead(r, &h.Rerank, &compression)
 		if err != nil {
 			return err
 		}
+		if compression != "" {
+			h.compressor, err = readCompressor(r, compression)
+			if err != nil {
+				return fmt.Errorf("decoding %s quantizer: %w", compression, err)
+			}
+		}
+	}
+	// Version 8 split the file into checksummed sections: the
+	// parameters, the levels and the tombstones.
+	err = r.EndSection()
+	if err != nil {
+		return err
+	}
 
-		h.levels = make([]*level[K], nLayers)
-		for i := range nLayers {
-			var nNodes int
-			_, err = binaryRead(r, &nNodes)
+	var nLayers int
+	_, err = binaryRead(r, &nLayers)
+	if err != nil {
+		return err
+	}
+	err = storefile.CheckLen(r, int64(nLayers), 1)
+	if err != nil {
+		return fmt.Errorf("decoding number of layers: %w", err)
+	}
+	// Version 5 added the entry point after the number of layers.
+	if version >= 5 && nLayers > 0 {
+		var entry K
+		_, err = binaryRead(r, &entry)
+		if err != nil {
+			return fmt.Errorf("decoding entry point: %w", err)
+		}
+		h.entry = &entry
+	}
+
+	h.levels = make([]*level[K], nLayers)
+	dims := 0
+	for i := range nLayers {
+		var nNodes int
+		_, err = binaryRead(r, &nNodes)
+		if err != nil {
+			return err
+		}
+		err = storefile.CheckLen(r, int64(nNodes), 1)
+		if err != nil {
+			return fmt.Errorf("decoding number of nodes: %w", err)
+		}
+
+		nodes := make(map[K]*Node[K], nNodes)
+		for j := range nNodes {
+			var key K
+			var embed Embedding
+			var nNeighbors int
+			_, err = multiBinaryRead(r, &key, &embed, &nNeighbors)
 			if err != nil {
-				return err
+				return fmt.Errorf("decoding node %d: %w", j, err)
 			}
-			log.Println(nNodes)
-
-			nodes := make(map[K]*Node[K], nNodes)
-			for j := range nNodes {
-				var key K
-				var embed Embedding
-				var nNeighbors int
-				_, err = multiBinaryRead(r, &key, &embed, &nNeighbors)
+			if dims == 0 {
+				dims = len(embed)
+			} else if len(embed) != dims {
+				return fmt.Errorf("node %v has dimension %d, not %d", key, len(embed), dims)
+			}
+			err = storefile.CheckLen(r, int64(nNeighbors), 1)
+			if err != nil {
+				return fmt.Errorf("decoding neighbors for node %d: %w", j, err)
+			}
+			neighbours := make([]K, nNeighbors)
+			for k := 0; k < nNeighbors; k++ {
+				var neighbor K
+				_, err = binaryRead(r, &neighbor)
 				if err != nil {
-					return fmt.Errorf("decoding node %d: %w", j, err)
-				}
-				log.Println(key,embed,nNeighbors)
-				neighbours := make([]K, nNeighbors)
-				for k := 0; k < nNeighbors; k++ {
-					var neighbor K
-					_, err = binaryRead(r, &neighbor)
-					if err != nil {
-						return fmt.Errorf("decoding neighbor %d for node %d: %w", k, j, err)
-					}
-					neighbours[k] = neighbor
+					return fmt.Errorf("decoding neighbor %d for node %d: %w", k, j, err)
 				}
+				neighbours[k] = neighbor
+			}
 
-				node := &Node[K]{
-					Key:        key,
-					Embed:      embed,
-					neighbours: make(map[K]*Node[K]),
-				}
+			node := newNode(key, embed, nil)
 
-				nodes[key] = node
-				for _, neighbour := range neighbours {
-					node.neighbours[neighbour] = nil
-				}
+			nodes[key] = node
+			for _, neighbour := range neighbours {
+				node.neighbours[neighbour] = nil
 			}
-			// Fill in neighbor pointers
-			for _, node := range nodes {
-				for key := range node.neighbours {
-					node.neighbours[key] = nodes[key]
-				}
+		}
+		// Fill in neighbor pointers
+		for _, node := range nodes {
+			for key := range node.neighbours {
+				node.neighbours[key] = nodes[key]
 			}
-			h.levels[i] = &level[K]{nodes: nodes}
+		}
+		h.levels[i] = &level[K]{nodes: nodes}
+	}
+	err = r.EndSection()
+	if err != nil {
+		return err
+	}
+	if h.compressor != nil && dims != 0 && h.compressor.dims() != dims {
+		return fmt.Errorf("%s quantizer has dimension %d, not %d", h.compressor.name(), h.compressor.dims(), dims)
+	}
+	// Version 7 added the tombstones after the levels.
+	if version >= 7 {
+		var nTombstones int
+		_, err = binaryRead(r, &nTombstones)
+		if err != nil {
+			return err
+		}
+		err = storefile.CheckLen(r, int64(nTombstones), 1)
+		if err != nil {
+			return fmt.Errorf("decoding number of tombstones: %w", err)
+		}
+		for j := range nTombstones {
+			var key K
+			_, err = binaryRead(r, &key)
+			if err != nil {
+				return fmt.Errorf("decoding tombstone %d: %w", j, err)
+			}
+			if len(h.levels) == 0 || h.levels[0].nodes[key] == nil {
+				return fmt.Errorf("tombstone %v is not in the graph", key)
+			}
+			if h.tombstones == nil {
+				h.tombstones = make(map[K]bool, nTombstones)
+			}
+			h.tombstones[key] = true
+		}
+	}
+	err = r.EndSection()
+	if err != nil {
+		return err
+	}
+	// Older versions could save empty top levels and did not track an
+	// entry point.
+	h.trimLevels()
+	if h.entry == nil {
+		h.entry = h.topNode()
+	} else if len(h.levels) == 0 || h.levels[len(h.levels)-1].nodes[*h.entry] == nil {
+		return fmt.Errorf("entry point %v is not in the top level", *h.entry)
+	}
+	if h.compressor != nil {
+		err = h.compress(h.compressor)
+		if err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
 
@@ -634,44 +1286,86 @@ func (h *HNSWGraph[K]) Load(storeName string) error {
 //
 // T must implement io.WriterTo.
 func (h *HNSWGraph[K]) Save(storeName string) error {
-	f, err := os.OpenFile(storeName+"_hnsw"+".store", os.O_RDWR|os.O_CREATE, 0o600)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	w := bufio.NewWriter(f)
-	distFuncName, ok := distanceFuncToName(h.Distance)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	distFuncName, ok := distance.Name(h.Distance)
 	if !ok {
-		return fmt.Errorf("distance function %v must be registered with RegisterDistanceFunc", h.Distance)
+		return fmt.Errorf("distance function %v must be registered in the distance package", h.Distance)
 	}
-	_, err = multiBinaryWrite(
+	header := storefile.Header{Type: "hnsw", Version: encodingVersion, Dim: h.dims(), Metric: distFuncName}
+	return storefile.Write(storeName+"_hnsw"+".store", header, func(w *storefile.Writer) error {
+		return h.write(w, distFuncName)
+	})
+}
+
+// write encodes the graph to w in three sections: the parameters, the
+// levels and the tombstones.
+func (h *HNSWGraph[K]) write(w *storefile.Writer, distFuncName string) error {
+	_, err := multiBinaryWrite(
 		w,
 		encodingVersion,
 		h.M,
 		h.Ml,
 		h.EfSearch,
+		h.EfConstruction,
+		h.M0,
+		h.ExtendCandidates,
+		h.KeepPrunedConnections,
+		h.seeded,
+		h.seed,
+		h.CompactionThreshold,
+		h.RebuildOnCompaction,
 		distFuncName,
 	)
 	if err != nil {
 		return fmt.Errorf("encode parameters: %w", err)
 	}
+	compression := ""
+	if h.compressor != nil {
+		compression = h.compressor.name()
+	}
+	_, err = multiBinaryWrite(w, h.Rerank, compression)
+	if err != nil {
+		return fmt.Errorf("encode compression: %w", err)
+	}
+	if h.compressor != nil {
+		err = writeCompressor(w, h.compressor)
+		if err != nil {
+			return fmt.Errorf("encode quantizer: %w", err)
+		}
+	}
+	err = w.EndSection()
+	if err != nil {
+		return err
+	}
 	_, err = binaryWrite(w, len(h.levels))
 	if err != nil {
 		return fmt.Errorf("encode number of layers: %w", err)
 	}
+	if h.entry != nil {
+		_, err = binaryWrite(w, *h.entry)
+		if err != nil {
+			return fmt.Errorf("encode entry point: %w", err)
+		}
+	}
 	for _, level := range h.levels {
 		_, err = binaryWrite(w, len(level.nodes))
 		if err != nil {
 			return fmt.Errorf("encode number of nodes: %w", err)
 		}
-		for _, node := range level.nodes {
-			log.Println(node.Key,node.Embed,len(node.neighbours))
-			_, err = multiBinaryWrite(w, node.Key, node.Embed, len(node.neighbours))
+		// Nodes and neighbours are written in key order, so that equal
+		// graphs produce identical files.
+		for _, node := range level.sortedNodes() {
+			embedding, err := h.readEmbedding(node)
+			if err != nil {
+				return err
+			}
+			_, err = multiBinaryWrite(w, node.Key, embedding, len(node.neighbours))
 			if err != nil {
 				return fmt.Errorf("encode node data: %w", err)
 			}
 
-			for neighbor := range node.neighbours {
+			for _, neighbor := range slices.Sorted(maps.Keys(node.neighbours)) {
 				_, err = binaryWrite(w, neighbor)
 				if err != nil {
 					return fmt.Errorf("encode neighbor %v: %w", neighbor, err)
@@ -679,13 +1373,19 @@ func (h *HNSWGraph[K]) Save(storeName string) error {
 			}
 		}
 	}
-	err=w.Flush()
-	if err!=nil{
+	err = w.EndSection()
+	if err != nil {
 		return err
 	}
-	err=f.Sync()
-	if err!=nil{
-		return err
+	_, err = binaryWrite(w, len(h.tombstones))
+	if err != nil {
+		return fmt.Errorf("encode number of tombstones: %w", err)
 	}
-	return nil
+	for _, key := range slices.Sorted(maps.Keys(h.tombstones)) {
+		_, err = binaryWrite(w, key)
+		if err != nil {
+			return fmt.Errorf("encode tombstone %v: %w", key, err)
+		}
+	}
+	return w.EndSection()
 }
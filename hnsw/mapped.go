@@ -0,0 +1,632 @@
+package hnsw
+
+import (
+	"fmt"
+	"maps"
+	"math/rand"
+	"slices"
+	"vectorDb/distance"
+	"vectorDb/storefile"
+)
+
+// mappedVersion is the version of the layout written by SaveMapped.
+const mappedVersion = 1
+
+// mappedParams are the graph parameters kept in the mapped layout, so that
+// a graph converted back with MappedGraph.Graph behaves as it did before.
+type mappedParams struct {
+	M                     int
+	Ml                    float64
+	EfSearch              int
+	EfConstruction        int
+	M0                    int
+	ExtendCandidates      bool
+	KeepPrunedConnections bool
+	seeded                bool
+	seed                  int64
+	CompactionThreshold   float64
+	RebuildOnCompaction   bool
+}
+
+// values returns the parameters in the order they are encoded.
+func (p *mappedParams) values() []any {
+	return []any{
+		p.M, p.Ml, p.EfSearch, p.EfConstruction, p.M0,
+		p.ExtendCandidates, p.KeepPrunedConnections, p.seeded, p.seed,
+		p.CompactionThreshold, p.RebuildOnCompaction,
+	}
+}
+
+// fields returns pointers to the parameters, in the order of values.
+func (p *mappedParams) fields() []any {
+	return []any{
+		&p.M, &p.Ml, &p.EfSearch, &p.EfConstruction, &p.M0,
+		&p.ExtendCandidates, &p.KeepPrunedConnections, &p.seeded, &p.seed,
+		&p.CompactionThreshold, &p.RebuildOnCompaction,
+	}
+}
+
+// csrLevel holds the links of a level in compressed sparse row form: the
+// neighbours of the i-th node of the level are
+// neighbours[offsets[i]:offsets[i+1]], as node IDs.
+type csrLevel struct {
+	// ids lists the IDs of the nodes in the level in increasing order, or
+	// is nil in the base layer, which holds every node.
+	ids        []uint32
+	offsets    []uint32
+	neighbours []uint32
+}
+
+// neighboursOf returns the neighbours of a node in the level, or nil if the
+// node is not in it or its links are out of bounds in a corrupt file.
+func (l *csrLevel) neighboursOf(id uint32) []uint32 {
+	i := int(id)
+	if l.ids != nil {
+		var ok bool
+		if i, ok = slices.BinarySearch(l.ids, id); !ok {
+			return nil
+		}
+	}
+	if i+1 >= len(l.offsets) {
+		return nil
+	}
+	start, end := l.offsets[i], l.offsets[i+1]
+	if start > end || int(end) > len(l.neighbours) {
+		return nil
+	}
+	return l.neighbours[start:end]
+}
+
+// contains reports whether a node is in the level.
+func (l *csrLevel) contains(id uint32) bool {
+	if l.ids == nil {
+		return int(id)+1 < len(l.offsets)
+	}
+	_, ok := slices.BinarySearch(l.ids, id)
+	return ok
+}
+
+// MappedGraph is a read-only HNSW graph searched in place in a memory
+// mapped file, written by SaveMapped. Opening it only maps the file and
+// reads the keys, so a large graph is ready to search almost at once and
+// its vectors and links are shared with the page cache rather than copied
+// to the heap.
+//
+// Nodes are numbered in key order, vectors are kept back to back in one
+// arena and the links of each level are stored as CSR arrays. Searches
+// return the same nodes as those of the graph it was saved from, unless
+// that graph was compressed: the mapped graph keeps the full-precision
+// vectors only.
+type MappedGraph struct {
+	// EfSearch is the number of candidates Search considers at the base
+	// layer, that of the saved graph by default.
+	EfSearch int
+
+	params   mappedParams
+	metric   string
+	distance DistanceFunc
+	dim      int
+	// keys holds the key of each node, in increasing order.
+	keys []string
+	// vectors holds the vector of node i at vectors[i*dim : (i+1)*dim].
+	vectors []float32
+	// deleted flags the tombstones, which are traversed but not returned.
+	deleted []byte
+	live    int
+	// entry is the ID of the entry point, in the top level.
+	entry   uint32
+	levels  []csrLevel
+	mapping *storefile.Mapping
+}
+
+// SaveMapped writes the graph to <storeName>_hnswmap.store in the layout
+// of MappedGraph. The graph itself is left unchanged.
+func SaveMapped(g *HNSWGraph[string], storeName string) error {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	metric, ok := distance.Name(g.Distance)
+	if !ok {
+		return fmt.Errorf("distance function %v must be registered in the distance package", g.Distance)
+	}
+	header := storefile.Header{Type: "hnswmap", Version: mappedVersion, Dim: g.dims(), Metric: metric}
+	return storefile.Write(storeName+"_hnswmap"+".store", header, func(w *storefile.Writer) error {
+		return writeMapped(w, g)
+	})
+}
+
+// writeMapped encodes the graph in sections: the parameters, the keys, the
+// vectors and tombstones, then one section per level.
+func writeMapped(w *storefile.Writer, g *HNSWGraph[string]) error {
+	var keys []string
+	if len(g.levels) > 0 {
+		keys = slices.Sorted(maps.Keys(g.levels[0].nodes))
+	}
+	ids := make(map[string]uint32, len(keys))
+	for i, key := range keys {
+		ids[key] = uint32(i)
+	}
+	entry := 0
+	if g.entry != nil {
+		entry = int(ids[*g.entry])
+	}
+
+	params := mappedParams{
+		M:                     g.M,
+		Ml:                    g.Ml,
+		EfSearch:              g.EfSearch,
+		EfConstruction:        g.EfConstruction,
+		M0:                    g.M0,
+		ExtendCandidates:      g.ExtendCandidates,
+		KeepPrunedConnections: g.KeepPrunedConnections,
+		seeded:                g.seeded,
+		seed:                  g.seed,
+		CompactionThreshold:   g.CompactionThreshold,
+		RebuildOnCompaction:   g.RebuildOnCompaction,
+	}
+	if _, err := multiBinaryWrite(w, append([]any{len(keys), len(g.levels), entry}, params.values()...)...); err != nil {
+		return fmt.Errorf("encode parameters: %w", err)
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	offsets := make([]uint64, 0, len(keys)+1)
+	var keyBytes []byte
+	for _, key := range keys {
+		offsets = append(offsets, uint64(len(keyBytes)))
+		keyBytes = append(keyBytes, key...)
+	}
+	offsets = append(offsets, uint64(len(keyBytes)))
+	if err := w.WriteArray(offsets); err != nil {
+		return fmt.Errorf("encode keys: %w", err)
+	}
+	if err := w.WriteArray(keyBytes); err != nil {
+		return fmt.Errorf("encode keys: %w", err)
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	vectors := make([]float32, 0, len(keys)*g.dims())
+	deleted := make([]byte, len(keys))
+	for i, key := range keys {
+		embedding, err := g.readEmbedding(g.levels[0].nodes[key])
+		if err != nil {
+			return err
+		}
+		vectors = append(vectors, embedding...)
+		if g.tombstones[key] {
+			deleted[i] = 1
+		}
+	}
+	if err := w.WriteArray(vectors); err != nil {
+		return fmt.Errorf("encode vectors: %w", err)
+	}
+	if err := w.WriteArray(deleted); err != nil {
+		return fmt.Errorf("encode tombstones: %w", err)
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	for i, level := range g.levels {
+		nodes := level.sortedNodes()
+		levelIDs := make([]uint32, 0, len(nodes))
+		offsets := make([]uint32, 0, len(nodes)+1)
+		var neighbours []uint32
+		for _, node := range nodes {
+			levelIDs = append(levelIDs, ids[node.Key])
+			offsets = append(offsets, uint32(len(neighbours)))
+			for _, neighbour := range node.sortedNeighbours() {
+				neighbours = append(neighbours, ids[neighbour.Key])
+			}
+		}
+		offsets = append(offsets, uint32(len(neighbours)))
+		if _, err := binaryWrite(w, len(nodes)); err != nil {
+			return fmt.Errorf("encode level %d: %w", i, err)
+		}
+		// The base layer holds every node, in ID order.
+		if i > 0 {
+			if err := w.WriteArray(levelIDs); err != nil {
+				return fmt.Errorf("encode level %d: %w", i, err)
+			}
+		}
+		if err := w.WriteArray(offsets); err != nil {
+			return fmt.Errorf("encode level %d: %w", i, err)
+		}
+		if err := w.WriteArray(neighbours); err != nil {
+			return fmt.Errorf("encode level %d: %w", i, err)
+		}
+		if err := w.EndSection(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenMapped maps the graph saved by SaveMapped under storeName. The graph
+// must be closed once no longer used.
+func OpenMapped(storeName string) (*MappedGraph, error) {
+	mapping, err := storefile.Map(storeName+"_hnswmap"+".store", "hnswmap", mappedVersion)
+	if err != nil {
+		return nil, err
+	}
+	m := &MappedGraph{mapping: mapping, metric: mapping.Header.Metric, dim: mapping.Header.Dim}
+	if err := m.decode(); err != nil {
+		mapping.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// decode reads the sections of the mapping. Only the parameters and the
+// keys are read at once; the vectors and links are used in place, and
+// checked as they are traversed.
+func (m *MappedGraph) decode() error {
+	var ok bool
+	m.distance, ok = distance.Lookup(m.metric)
+	if !ok {
+		return fmt.Errorf("unknown distance function %q", m.metric)
+	}
+
+	r, err := m.mapping.Section(0)
+	if err != nil {
+		return err
+	}
+	var nNodes, nLevels, entry int
+	if _, err := multiBinaryRead(r, append([]any{&nNodes, &nLevels, &entry}, m.params.fields()...)...); err != nil {
+		return fmt.Errorf("decoding parameters: %w", err)
+	}
+	m.EfSearch = m.params.EfSearch
+	if nNodes < 0 || nNodes > 1<<32-1 || nLevels < 0 || (nNodes == 0) != (nLevels == 0) ||
+		entry < 0 || (nNodes > 0 && entry >= nNodes) {
+		return fmt.Errorf("invalid graph size")
+	}
+	if m.mapping.Sections() != 3+nLevels {
+		return fmt.Errorf("expected %d sections, found %d", 3+nLevels, m.mapping.Sections())
+	}
+	m.entry = uint32(entry)
+
+	r, err = m.mapping.Section(1)
+	if err != nil {
+		return err
+	}
+	offsets, err := r.Uint64s(nNodes + 1)
+	if err != nil {
+		return fmt.Errorf("decoding keys: %w", err)
+	}
+	if offsets[0] != 0 || offsets[nNodes] > uint64(r.Len()) {
+		return fmt.Errorf("invalid keys")
+	}
+	keyBytes, err := r.Bytes(int(offsets[nNodes]))
+	if err != nil {
+		return fmt.Errorf("decoding keys: %w", err)
+	}
+	m.keys = make([]string, nNodes)
+	for i := range m.keys {
+		if offsets[i] > offsets[i+1] {
+			return fmt.Errorf("invalid key %d", i)
+		}
+		m.keys[i] = string(keyBytes[offsets[i]:offsets[i+1]])
+		if i > 0 && m.keys[i] <= m.keys[i-1] {
+			return fmt.Errorf("keys are not sorted at %d", i)
+		}
+	}
+
+	r, err = m.mapping.Section(2)
+	if err != nil {
+		return err
+	}
+	if err := storefile.CheckLen(r, int64(nNodes)*int64(m.dim), 4); err != nil || m.dim < 0 {
+		return fmt.Errorf("decoding vectors: %w", err)
+	}
+	if m.vectors, err = r.Float32s(nNodes * m.dim); err != nil {
+		return fmt.Errorf("decoding vectors: %w", err)
+	}
+	if m.deleted, err = r.Bytes(nNodes); err != nil {
+		return fmt.Errorf("decoding tombstones: %w", err)
+	}
+	for _, deleted := range m.deleted {
+		if deleted == 0 {
+			m.live++
+		}
+	}
+
+	m.levels = make([]csrLevel, nLevels)
+	for i := range m.levels {
+		r, err := m.mapping.Section(3 + i)
+		if err != nil {
+			return err
+		}
+		var size int
+		if _, err := binaryRead(r, &size); err != nil {
+			return fmt.Errorf("decoding level %d: %w", i, err)
+		}
+		if size < 0 || size > nNodes || (i == 0 && size != nNodes) {
+			return fmt.Errorf("level %d has %d nodes", i, size)
+		}
+		level := &m.levels[i]
+		if i > 0 {
+			if level.ids, err = r.Uint32s(size); err != nil {
+				return fmt.Errorf("decoding level %d: %w", i, err)
+			}
+		}
+		if level.offsets, err = r.Uint32s(size + 1); err != nil {
+			return fmt.Errorf("decoding level %d: %w", i, err)
+		}
+		if level.neighbours, err = r.Uint32s(int(level.offsets[size])); err != nil {
+			return fmt.Errorf("decoding level %d: %w", i, err)
+		}
+	}
+	if nLevels > 0 && !m.levels[nLevels-1].contains(m.entry) {
+		return fmt.Errorf("entry point %d is not in the top level", m.entry)
+	}
+	return nil
+}
+
+// Close unmaps the graph. It must not be used afterwards.
+func (m *MappedGraph) Close() error {
+	return m.mapping.Close()
+}
+
+// Len returns the number of live nodes in the graph.
+func (m *MappedGraph) Len() int {
+	return m.live
+}
+
+// Dims returns the number of dimensions in the graph, or 0 if it is empty.
+func (m *MappedGraph) Dims() int {
+	if len(m.keys) == 0 {
+		return 0
+	}
+	return m.dim
+}
+
+// Metric returns the name of the distance function of the graph.
+func (m *MappedGraph) Metric() string {
+	return m.metric
+}
+
+func (m *MappedGraph) vector(id uint32) Embedding {
+	return m.vectors[int(id)*m.dim : (int(id)+1)*m.dim : (int(id)+1)*m.dim]
+}
+
+// Lookup returns a copy of the vector with the given key.
+func (m *MappedGraph) Lookup(key string) (Embedding, bool) {
+	id, ok := slices.BinarySearch(m.keys, key)
+	if !ok || m.deleted[id] != 0 {
+		return nil, false
+	}
+	return slices.Clone(m.vector(uint32(id))), true
+}
+
+// Each calls fn with every live key and its vector in key order, stopping
+// at and returning the first error fn returns. The vector must not be
+// modified or kept past the call.
+func (m *MappedGraph) Each(fn func(key string, vector Embedding) error) error {
+	for id, key := range m.keys {
+		if m.deleted[id] != 0 {
+			continue
+		}
+		if err := fn(key, m.vector(uint32(id))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search finds the k nearest neighbors of near, considering EfSearch
+// candidates at the base layer.
+func (m *MappedGraph) Search(near Embedding, k int) []Node[string] {
+	return m.SearchEf(near, k, m.EfSearch)
+}
+
+// SearchEf is like Search with an explicit number of candidates to
+// consider at the base layer.
+func (m *MappedGraph) SearchEf(near Embedding, k int, ef int) []Node[string] {
+	m.assertDims(near)
+	if m.live == 0 || k <= 0 {
+		return nil
+	}
+	if distance.NeedsNormalization(m.metric) {
+		near = distance.Normalize(near)
+	}
+	return m.resultNodes(m.search(near, k, ef))
+}
+
+// SearchRange returns the nodes within radius of near, nearest first, and
+// at most maxResults of them if maxResults > 0, widening the search like
+// HNSWGraph.SearchRange.
+func (m *MappedGraph) SearchRange(near Embedding, radius float32, maxResults int) []Node[string] {
+	m.assertDims(near)
+	if m.live == 0 {
+		return nil
+	}
+	if distance.NeedsNormalization(m.metric) {
+		near = distance.Normalize(near)
+	}
+
+	limit := m.live
+	if maxResults > 0 {
+		limit = min(limit, maxResults)
+	}
+	var within []mappedCandidate
+	for k := min(max(m.EfSearch, 1), limit); ; k = min(2*k, limit) {
+		found := m.search(near, k, k)
+		n := slices.IndexFunc(found, func(c mappedCandidate) bool {
+			return c.dist > radius
+		})
+		if n < 0 {
+			n = len(found)
+		}
+		within = found[:n]
+		if 2*n <= len(found) || len(found) < k || k == limit {
+			break
+		}
+	}
+	return m.resultNodes(within)
+}
+
+func (m *MappedGraph) assertDims(near Embedding) {
+	if len(m.keys) > 0 && len(near) != m.dim {
+		panic(fmt.Sprint("embedding dimension mismatch: ", m.dim, " != ", len(near)))
+	}
+}
+
+// resultNodes copies the nodes of search results out of the mapping.
+func (m *MappedGraph) resultNodes(candidates []mappedCandidate) []Node[string] {
+	out := make([]Node[string], 0, len(candidates))
+	for _, c := range candidates {
+		out = append(out, MakeNode(m.keys[c.id], slices.Clone(m.vector(c.id))))
+	}
+	return out
+}
+
+type mappedCandidate struct {
+	id   uint32
+	dist float32
+}
+
+func (c mappedCandidate) Less(o mappedCandidate) bool {
+	return c.dist < o.dist
+}
+
+// farMappedCandidate orders candidates farthest first, like farCandidate.
+type farMappedCandidate mappedCandidate
+
+func (c farMappedCandidate) Less(o farMappedCandidate) bool {
+	return c.dist > o.dist
+}
+
+// search descends the levels like HNSWGraph.search and returns the k live
+// nodes nearest to near found among ef candidates at the base layer.
+func (m *MappedGraph) search(near Embedding, k int, ef int) []mappedCandidate {
+	efSearch := max(ef, k)
+	elevator := m.entry
+	for level := len(m.levels) - 1; level > 0; level-- {
+		elevator = m.searchLevel(level, elevator, 1, near, false)[0].id
+	}
+	nodes := m.searchLevel(0, elevator, efSearch, near, m.live < len(m.keys))
+	return nodes[:min(k, len(nodes))]
+}
+
+// searchLevel runs the beam search of Node.search from start within a
+// level, skipping tombstones in the result if liveOnly is set.
+func (m *MappedGraph) searchLevel(level int, start uint32, ef int, near Embedding, liveOnly bool) []mappedCandidate {
+	ef = max(ef, 1)
+	first := mappedCandidate{id: start, dist: m.distance(m.vector(start), near)}
+
+	candidates := Heap[mappedCandidate]{}
+	candidates.Init(make([]mappedCandidate, 0, ef))
+	candidates.Push(first)
+	result := Heap[farMappedCandidate]{}
+	result.Init(make([]farMappedCandidate, 0, ef+1))
+	if !liveOnly || m.deleted[start] == 0 {
+		result.Push(farMappedCandidate(first))
+	}
+	visited := map[uint32]bool{start: true}
+
+	for candidates.Len() > 0 {
+		current := candidates.Pop()
+		if result.Len() >= ef && current.dist > result.Min().dist {
+			break
+		}
+		// Neighbours are stored in ID order, which is key order, so the
+		// search visits them in the same order as the in-memory graph.
+		for _, neighbour := range m.levels[level].neighboursOf(current.id) {
+			if visited[neighbour] || int(neighbour) >= len(m.keys) {
+				continue
+			}
+			visited[neighbour] = true
+
+			dist := m.distance(m.vector(neighbour), near)
+			if result.Len() < ef || dist < result.Min().dist {
+				candidate := mappedCandidate{id: neighbour, dist: dist}
+				candidates.Push(candidate)
+				if liveOnly && m.deleted[neighbour] != 0 {
+					continue
+				}
+				result.Push(farMappedCandidate(candidate))
+				if result.Len() > ef {
+					result.Pop()
+				}
+			}
+		}
+	}
+
+	out := make([]mappedCandidate, 0, result.Len())
+	for _, candidate := range result.Slice() {
+		out = append(out, mappedCandidate(candidate))
+	}
+	// Ties are broken by ID, which is key order, as in sortCandidates.
+	slices.SortFunc(out, func(a, b mappedCandidate) int {
+		if a.dist != b.dist {
+			if a.dist < b.dist {
+				return -1
+			}
+			return 1
+		}
+		return int(a.id) - int(b.id)
+	})
+	return out
+}
+
+// Graph converts the mapped graph back to a mutable in-memory graph, with
+// the parameters, links and tombstones it was saved with. The vectors are
+// copied, so the graph stays usable after Close.
+func (m *MappedGraph) Graph() *HNSWGraph[string] {
+	g := NewHNSWGraph[string](m.metric)
+	p := m.params
+	g.M, g.Ml, g.EfSearch, g.EfConstruction, g.M0 = p.M, p.Ml, m.EfSearch, p.EfConstruction, p.M0
+	g.ExtendCandidates, g.KeepPrunedConnections = p.ExtendCandidates, p.KeepPrunedConnections
+	g.CompactionThreshold, g.RebuildOnCompaction = p.CompactionThreshold, p.RebuildOnCompaction
+	g.seed, g.seeded = p.seed, p.seeded
+	if g.seeded {
+		g.Rng = rand.New(rand.NewSource(g.seed))
+	}
+	if len(m.keys) == 0 {
+		return g
+	}
+
+	embeds := make([]Embedding, len(m.keys))
+	for id := range embeds {
+		embeds[id] = slices.Clone(m.vector(uint32(id)))
+		if m.deleted[id] != 0 {
+			if g.tombstones == nil {
+				g.tombstones = make(map[string]bool)
+			}
+			g.tombstones[m.keys[id]] = true
+		}
+	}
+	g.levels = make([]*level[string], len(m.levels))
+	for i := range m.levels {
+		csr := &m.levels[i]
+		ids := csr.ids
+		if ids == nil {
+			ids = make([]uint32, len(m.keys))
+			for id := range ids {
+				ids[id] = uint32(id)
+			}
+		}
+		nodes := make(map[string]*Node[string], len(ids))
+		for _, id := range ids {
+			if int(id) < len(m.keys) {
+				nodes[m.keys[id]] = newNode(m.keys[id], embeds[id], nil)
+			}
+		}
+		for _, id := range ids {
+			if int(id) >= len(m.keys) {
+				continue
+			}
+			node := nodes[m.keys[id]]
+			for _, neighbour := range csr.neighboursOf(id) {
+				if int(neighbour) < len(m.keys) && nodes[m.keys[neighbour]] != nil {
+					node.neighbours[m.keys[neighbour]] = nodes[m.keys[neighbour]]
+				}
+			}
+		}
+		g.levels[i] = &level[string]{nodes: nodes}
+	}
+	g.entry = ptr(m.keys[m.entry])
+	return g
+}
@@ -0,0 +1,203 @@
+package hnsw
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+)
+
+// Edge is a link from one node to another within a level.
+type Edge[K cmp.Ordered] struct {
+	From, To K
+}
+
+// LevelReport describes the connectivity of one level of the graph.
+type LevelReport[K cmp.Ordered] struct {
+	// Nodes is the number of nodes in the level, tombstones included.
+	Nodes int
+	// Reachable is the number of nodes reachable from the entry point.
+	Reachable int
+	// Degrees maps a number of neighbours to the number of nodes with it.
+	Degrees map[int]int
+	// Dangling lists the links to nodes that are not in the level.
+	Dangling []Edge[K]
+	// Asymmetric lists the links whose reverse link is missing.
+	Asymmetric []Edge[K]
+	// Orphans lists the nodes unreachable from the entry point, in key
+	// order. Searches never return them.
+	Orphans []K
+}
+
+// Report is the result of Validate, with one LevelReport per level from the
+// base layer up.
+type Report[K cmp.Ordered] struct {
+	// Entry is the entry point, or nil if the graph is empty.
+	Entry *K
+	// BadEntry is set when the entry point is missing from the top level,
+	// or missing while the graph isn't empty.
+	BadEntry bool
+	Levels   []LevelReport[K]
+}
+
+// OK reports whether Validate found no problem.
+func (r Report[K]) OK() bool {
+	if r.BadEntry {
+		return false
+	}
+	for _, level := range r.Levels {
+		if len(level.Dangling) > 0 || len(level.Asymmetric) > 0 || len(level.Orphans) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Validate checks the structure of the graph: that every node of every
+// level is reachable from the entry point, and that every link points to a
+// node of the same level which links back.
+func (h *HNSWGraph[K]) Validate() Report[K] {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	report := Report[K]{Entry: h.entry, Levels: make([]LevelReport[K], len(h.levels))}
+	if h.entry == nil {
+		report.BadEntry = len(h.levels) > 0
+	} else {
+		report.BadEntry = len(h.levels) == 0 || h.levels[len(h.levels)-1].nodes[*h.entry] == nil
+	}
+
+	for i, level := range h.levels {
+		reached := h.reachable(level, nil)
+		r := LevelReport[K]{
+			Nodes:     len(level.nodes),
+			Reachable: len(reached),
+			Degrees:   make(map[int]int),
+		}
+		for _, node := range level.sortedNodes() {
+			r.Degrees[len(node.neighbours)]++
+			if !reached[node.Key] {
+				r.Orphans = append(r.Orphans, node.Key)
+			}
+			for _, key := range slices.Sorted(maps.Keys(node.neighbours)) {
+				neighbour := node.neighbours[key]
+				switch {
+				case !level.holds(key, neighbour):
+					r.Dangling = append(r.Dangling, Edge[K]{From: node.Key, To: key})
+				case neighbour.neighbours[node.Key] != node:
+					r.Asymmetric = append(r.Asymmetric, Edge[K]{From: node.Key, To: key})
+				}
+			}
+		}
+		report.Levels[i] = r
+	}
+	return report
+}
+
+// Repaired counts the problems fixed by Repair.
+type Repaired struct {
+	// Entry is set when the entry point had to be replaced.
+	Entry bool
+	// Dangling is the number of dangling links dropped.
+	Dangling int
+	// Asymmetric is the number of missing reverse links added.
+	Asymmetric int
+	// Orphans is the number of unreachable nodes linked back to the
+	// reachable part of their level.
+	Orphans int
+}
+
+// Repair fixes the problems Validate reports: it resets a bad entry point,
+// drops dangling links, adds the missing reverse links, and links every
+// orphan to its nearest reachable nodes. Nodes over their neighbour limit
+// afterwards are shrunk, which keeps them connected.
+func (h *HNSWGraph[K]) Repair() Repaired {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var repaired Repaired
+	h.trimLevels()
+	if h.entry == nil || len(h.levels) == 0 || h.levels[len(h.levels)-1].nodes[*h.entry] == nil {
+		repaired.Entry = h.entry != nil || len(h.levels) > 0
+		h.entry = h.topNode()
+	}
+
+	for i, level := range h.levels {
+		m := h.maxNeighbours(i)
+		nodes := level.sortedNodes()
+		for _, node := range nodes {
+			for key, neighbour := range node.neighbours {
+				if !level.holds(key, neighbour) {
+					delete(node.neighbours, key)
+					repaired.Dangling++
+				}
+			}
+		}
+		for _, node := range nodes {
+			for _, neighbour := range node.sortedNeighbours() {
+				if neighbour.neighbours[node.Key] != node {
+					link(node, neighbour)
+					repaired.Asymmetric++
+				}
+			}
+		}
+
+		// Link each orphan to the nearest nodes found from the entry point,
+		// which brings the rest of its component along.
+		reached := h.reachable(level, nil)
+		start := level.nodes[*h.entry]
+		for _, orphan := range nodes {
+			if reached[orphan.Key] || start == nil {
+				continue
+			}
+			score := func(n *Node[K]) float32 {
+				return h.between(n, orphan)
+			}
+			neighborhood := start.search(max(h.EfConstruction, h.M), score, nil)
+			for _, c := range selectNeighbours(orphan, neighborhood, h.M, h.between, false, true) {
+				link(orphan, c.node)
+			}
+			repaired.Orphans++
+			h.reachable(level, reached, orphan)
+		}
+
+		for _, node := range nodes {
+			node.shrink(m, h.between)
+		}
+	}
+	return repaired
+}
+
+// reachable adds to reached, allocated if nil, the keys of the nodes of
+// the level reachable from the given nodes, or from the entry point if
+// none are given, and returns it. Dangling links are not followed.
+func (h *HNSWGraph[K]) reachable(l *level[K], reached map[K]bool, from ...*Node[K]) map[K]bool {
+	if reached == nil {
+		reached = make(map[K]bool, len(l.nodes))
+	}
+	if len(from) == 0 && h.entry != nil {
+		if entry := l.nodes[*h.entry]; entry != nil {
+			from = []*Node[K]{entry}
+		}
+	}
+	var stack []*Node[K]
+	for _, node := range from {
+		if !reached[node.Key] {
+			reached[node.Key] = true
+			stack = append(stack, node)
+		}
+	}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for key, neighbour := range node.neighbours {
+			if !reached[key] && l.holds(key, neighbour) {
+				reached[key] = true
+				stack = append(stack, neighbour)
+			}
+		}
+	}
+	return reached
+}
+
+// holds reports whether node is the node of the level with the given key.
+func (l *level[K]) holds(key K, node *Node[K]) bool {
+	return node != nil && l.nodes[key] == node
+}
@@ -0,0 +1,82 @@
+package hnsw
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+)
+
+// vectorFile holds the full-precision embeddings of a compressed graph in
+// fixed-size slots of a temporary file, so that they take no memory. Only
+// reranking, saving and the methods returning embeddings read them back.
+type vectorFile struct {
+	file *os.File
+	dims int
+
+	// mu guards the slots, which BulkInsert takes concurrently.
+	mu sync.Mutex
+	// slots is the number of slots in the file, and free holds those of
+	// removed nodes, which are reused first.
+	slots int64
+	free  []int64
+}
+
+// newVectorFile creates the file of a graph of the given dimension. It is
+// removed right away, so that the system reclaims its space once the graph
+// is collected; systems that can't remove open files leave it in the
+// temporary directory.
+func newVectorFile(dims int) (*vectorFile, error) {
+	file, err := os.CreateTemp("", "hnsw-vectors-*")
+	if err != nil {
+		return nil, err
+	}
+	os.Remove(file.Name())
+	return &vectorFile{file: file, dims: dims}, nil
+}
+
+// put writes the embedding to a free slot and returns it.
+func (v *vectorFile) put(e Embedding) (int64, error) {
+	if len(e) != v.dims {
+		return 0, fmt.Errorf("embedding dimension mismatch: %d != %d", v.dims, len(e))
+	}
+	v.mu.Lock()
+	var slot int64
+	if n := len(v.free); n > 0 {
+		slot, v.free = v.free[n-1], v.free[:n-1]
+	} else {
+		slot = v.slots
+		v.slots++
+	}
+	v.mu.Unlock()
+
+	buf := make([]byte, 4*len(e))
+	for i, x := range e {
+		byteOrder.PutUint32(buf[4*i:], math.Float32bits(x))
+	}
+	if _, err := v.file.WriteAt(buf, slot*int64(len(buf))); err != nil {
+		v.release(slot)
+		return 0, err
+	}
+	return slot, nil
+}
+
+// get reads the embedding in the slot.
+func (v *vectorFile) get(slot int64) (Embedding, error) {
+	buf := make([]byte, 4*v.dims)
+	if _, err := v.file.ReadAt(buf, slot*int64(len(buf))); err != nil {
+		return nil, fmt.Errorf("reading embedding %d: %w", slot, err)
+	}
+	e := make(Embedding, v.dims)
+	for i := range e {
+		e[i] = math.Float32frombits(byteOrder.Uint32(buf[4*i:]))
+	}
+	return e, nil
+}
+
+// release frees the slot for another embedding.
+func (v *vectorFile) release(slot int64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.free = append(v.free, slot)
+}
@@ -0,0 +1,420 @@
+package ivf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+	"vectorDb/pq"
+	"vectorDb/storefile"
+)
+
+var byteOrder = binary.LittleEndian
+
+// encodingVersion is the version of the layouts written by Save.
+const encodingVersion = 1
+
+// legacyLayout returns the check of NewReader for files written before
+// headers, which start with params non-negative int32 parameters, then the
+// name of a registered distance function.
+func legacyLayout(params int) func(prefix []byte) bool {
+	return func(prefix []byte) bool {
+		if len(prefix) < 4*params+4 {
+			return false
+		}
+		for i := range params {
+			if int32(byteOrder.Uint32(prefix[4*i:])) < 0 {
+				return false
+			}
+		}
+		prefix = prefix[4*params:]
+		n := int32(byteOrder.Uint32(prefix))
+		if n < 0 || int(n) > len(prefix)-4 {
+			return false
+		}
+		_, err := lookupDistanceFunc(string(prefix[4 : 4+n]))
+		return err == nil
+	}
+}
+
+// Save serializes the index to <storeName>_ivf.store.
+//
+// The layout is the header, a section with the parameters and the
+// centroids, then a section with every inverted list followed by the
+// pending list, each as its keys and its vector arena.
+func (ivf *IVFFlat) Save(storeName string) error {
+	header := storefile.Header{Type: "ivf", Version: encodingVersion, Dim: ivf.dim, Metric: ivf.distance}
+	return storefile.Write(storeName+"_ivf"+".store", header, ivf.write)
+}
+
+// write encodes the index to w.
+func (ivf *IVFFlat) write(w *storefile.Writer) error {
+	// Write parameters
+	for _, v := range []int32{int32(ivf.dim), int32(ivf.NList), int32(ivf.NProbe), int32(ivf.TrainSize)} {
+		if err := binary.Write(w, byteOrder, v); err != nil {
+			return err
+		}
+	}
+	if err := writeString(w, ivf.distance); err != nil {
+		return err
+	}
+
+	// Write centroids
+	if err := binary.Write(w, byteOrder, int32(len(ivf.centroids))); err != nil {
+		return err
+	}
+	for _, centroid := range ivf.centroids {
+		if err := binary.Write(w, byteOrder, centroid); err != nil {
+			return err
+		}
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	// Write lists, the pending list last
+	for i := range ivf.lists {
+		if err := writeList(w, &ivf.lists[i]); err != nil {
+			return err
+		}
+	}
+	if err := writeList(w, &ivf.pending); err != nil {
+		return err
+	}
+	return w.EndSection()
+}
+
+// Load deserializes the index from <storeName>_ivf.store.
+// A missing or empty file leaves the index unchanged.
+func (ivf *IVFFlat) Load(storeName string) error {
+	f, err := os.OpenFile(storeName+"_ivf"+".store", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	r, err := storefile.NewReader(f, info.Size(), "ivf", encodingVersion, legacyLayout(4))
+	if err != nil {
+		return err
+	}
+
+	// Read parameters
+	var dim, nlist, nprobe, trainSize, nCentroids int32
+	for _, v := range []*int32{&dim, &nlist, &nprobe, &trainSize} {
+		if err := binary.Read(r, byteOrder, v); err != nil {
+			return err
+		}
+	}
+	distName, err := readString(r)
+	if err != nil {
+		return err
+	}
+	dFunc, err := lookupDistanceFunc(distName)
+	if err != nil {
+		return err
+	}
+
+	// Read centroids
+	if err := binary.Read(r, byteOrder, &nCentroids); err != nil {
+		return err
+	}
+	if dim < 0 || nCentroids < 0 {
+		return errors.New("invalid ivf header")
+	}
+	if err := storefile.CheckLen(r, int64(nCentroids)*int64(dim), 4); err != nil {
+		return fmt.Errorf("decoding centroids: %w", err)
+	}
+	centroids := make([][]float32, nCentroids)
+	for i := range centroids {
+		centroids[i] = make([]float32, dim)
+		if err := binary.Read(r, byteOrder, centroids[i]); err != nil {
+			return err
+		}
+	}
+
+	loaded := &IVFFlat{
+		dim:       int(dim),
+		distance:  distName,
+		dFunc:     dFunc,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		NList:     int(nlist),
+		NProbe:    int(nprobe),
+		TrainSize: int(trainSize),
+		centroids: centroids,
+		lists:     make([]invertedList, nCentroids),
+		locations: make(map[string]location),
+	}
+	if err := r.EndSection(); err != nil {
+		return err
+	}
+
+	// Read lists, the pending list last
+	for list := range int(nCentroids) + 1 {
+		if list == int(nCentroids) {
+			list = -1
+		}
+		l := loaded.list(list)
+		if err := readList(r, l, loaded.dim); err != nil {
+			return fmt.Errorf("decoding list %d: %w", list, err)
+		}
+		for row, key := range l.keys {
+			loaded.locations[key] = location{list: list, row: row}
+		}
+	}
+	if err := r.EndSection(); err != nil {
+		return err
+	}
+
+	*ivf = *loaded
+	return nil
+}
+
+func writeList(w io.Writer, l *invertedList) error {
+	if err := binary.Write(w, byteOrder, int32(len(l.keys))); err != nil {
+		return err
+	}
+	for _, key := range l.keys {
+		if err := writeString(w, key); err != nil {
+			return err
+		}
+	}
+	return binary.Write(w, byteOrder, l.data)
+}
+
+func readList(r io.Reader, l *invertedList, dim int) error {
+	var n int32
+	if err := binary.Read(r, byteOrder, &n); err != nil {
+		return err
+	}
+	if err := storefile.CheckLen(r, int64(n), 4); err != nil {
+		return fmt.Errorf("decoding list length: %w", err)
+	}
+	l.keys = make([]string, n)
+	for i := range l.keys {
+		key, err := readString(r)
+		if err != nil {
+			return err
+		}
+		l.keys[i] = key
+	}
+	if err := storefile.CheckLen(r, int64(n)*int64(dim), 4); err != nil {
+		return fmt.Errorf("decoding list vectors: %w", err)
+	}
+	l.data = make([]float32, int(n)*dim)
+	return binary.Read(r, byteOrder, l.data)
+}
+
+// writeString writes a length-prefixed string.
+func writeString(w io.Writer, s string) error {
+	if err := binary.Write(w, byteOrder, int32(len(s))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, s)
+	return err
+}
+
+// readString reads a length-prefixed string.
+func readString(r io.Reader) (string, error) {
+	var length int32
+	if err := binary.Read(r, byteOrder, &length); err != nil {
+		return "", err
+	}
+	if err := storefile.CheckLen(r, int64(length), 1); err != nil {
+		return "", fmt.Errorf("decoding string: %w", err)
+	}
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Save serializes the index to <storeName>_ivfpq.store.
+//
+// The layout is the header, a section with the parameters, the centroids
+// and the product quantizer if trained, then a section with every code list
+// followed by the pending list.
+func (ivf *IVFPQ) Save(storeName string) error {
+	header := storefile.Header{Type: "ivfpq", Version: encodingVersion, Dim: ivf.dim, Metric: ivf.distance}
+	return storefile.Write(storeName+"_ivfpq"+".store", header, ivf.write)
+}
+
+// write encodes the index to w.
+func (ivf *IVFPQ) write(w *storefile.Writer) error {
+	// Write parameters
+	for _, v := range []int32{int32(ivf.dim), int32(ivf.NList), int32(ivf.NProbe), int32(ivf.TrainSize), int32(ivf.Subspaces)} {
+		if err := binary.Write(w, byteOrder, v); err != nil {
+			return err
+		}
+	}
+	if err := writeString(w, ivf.distance); err != nil {
+		return err
+	}
+
+	// Write centroids and quantizer
+	if err := binary.Write(w, byteOrder, int32(len(ivf.centroids))); err != nil {
+		return err
+	}
+	for _, centroid := range ivf.centroids {
+		if err := binary.Write(w, byteOrder, centroid); err != nil {
+			return err
+		}
+	}
+	if ivf.Trained() {
+		if _, err := ivf.quantizer.WriteTo(w); err != nil {
+			return err
+		}
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	// Write code lists, the pending list last
+	for _, l := range ivf.lists {
+		if err := binary.Write(w, byteOrder, int32(len(l.keys))); err != nil {
+			return err
+		}
+		for _, key := range l.keys {
+			if err := writeString(w, key); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(l.codes); err != nil {
+			return err
+		}
+	}
+	if err := writeList(w, &ivf.pending); err != nil {
+		return err
+	}
+	return w.EndSection()
+}
+
+// Load deserializes the index from <storeName>_ivfpq.store.
+// A missing or empty file leaves the index unchanged.
+func (ivf *IVFPQ) Load(storeName string) error {
+	f, err := os.OpenFile(storeName+"_ivfpq"+".store", os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	r, err := storefile.NewReader(f, info.Size(), "ivfpq", encodingVersion, legacyLayout(5))
+	if err != nil {
+		return err
+	}
+
+	// Read parameters
+	var dim, nlist, nprobe, trainSize, subspaces, nCentroids int32
+	for _, v := range []*int32{&dim, &nlist, &nprobe, &trainSize, &subspaces} {
+		if err := binary.Read(r, byteOrder, v); err != nil {
+			return err
+		}
+	}
+	distName, err := readString(r)
+	if err != nil {
+		return err
+	}
+	dFunc, err := lookupDistanceFunc(distName)
+	if err != nil {
+		return err
+	}
+
+	// Read centroids and quantizer
+	if err := binary.Read(r, byteOrder, &nCentroids); err != nil {
+		return err
+	}
+	if dim < 0 || nCentroids < 0 {
+		return errors.New("invalid ivfpq header")
+	}
+	if err := storefile.CheckLen(r, int64(nCentroids)*int64(dim), 4); err != nil {
+		return fmt.Errorf("decoding centroids: %w", err)
+	}
+	centroids := make([][]float32, nCentroids)
+	for i := range centroids {
+		centroids[i] = make([]float32, dim)
+		if err := binary.Read(r, byteOrder, centroids[i]); err != nil {
+			return err
+		}
+	}
+	var quantizer *pq.Quantizer
+	if nCentroids > 0 {
+		quantizer = &pq.Quantizer{}
+		if _, err := quantizer.ReadFrom(r); err != nil {
+			return fmt.Errorf("decoding quantizer: %w", err)
+		}
+		if quantizer.Dims() != int(dim) {
+			return errors.New("quantizer dimension does not match the index")
+		}
+	}
+	if err := r.EndSection(); err != nil {
+		return err
+	}
+
+	loaded := &IVFPQ{
+		dim:       int(dim),
+		distance:  distName,
+		dFunc:     dFunc,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		NList:     int(nlist),
+		NProbe:    int(nprobe),
+		TrainSize: int(trainSize),
+		Subspaces: int(subspaces),
+		centroids: centroids,
+		quantizer: quantizer,
+		lists:     make([]codeList, nCentroids),
+		locations: make(map[string]location),
+	}
+
+	// Read code lists, the pending list last
+	for i := range loaded.lists {
+		l := &loaded.lists[i]
+		var n int32
+		if err := binary.Read(r, byteOrder, &n); err != nil {
+			return err
+		}
+		if err := storefile.CheckLen(r, int64(n), 4); err != nil {
+			return fmt.Errorf("decoding list %d: %w", i, err)
+		}
+		l.keys = make([]string, n)
+		for row := range l.keys {
+			if l.keys[row], err = readString(r); err != nil {
+				return err
+			}
+			loaded.locations[l.keys[row]] = location{list: i, row: row}
+		}
+		if err := storefile.CheckLen(r, int64(n), int64(quantizer.CodeSize())); err != nil {
+			return fmt.Errorf("decoding list %d: %w", i, err)
+		}
+		l.codes = make([]byte, int(n)*quantizer.CodeSize())
+		if _, err := io.ReadFull(r, l.codes); err != nil {
+			return fmt.Errorf("decoding list %d: %w", i, err)
+		}
+	}
+	if err := readList(r, &loaded.pending, loaded.dim); err != nil {
+		return fmt.Errorf("decoding pending list: %w", err)
+	}
+	for row, key := range loaded.pending.keys {
+		loaded.locations[key] = location{list: -1, row: row}
+	}
+	if err := r.EndSection(); err != nil {
+		return err
+	}
+
+	*ivf = *loaded
+	return nil
+}
@@ -0,0 +1,365 @@
+package ivf
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+	"vectorDb/distance"
+	"vectorDb/kmeans"
+)
+
+// ErrAlreadyTrained is returned by Train on an index already trained,
+// either explicitly or once TrainSize vectors were added.
+var ErrAlreadyTrained = errors.New("index is already trained")
+
+// Result is a single search result with its distance to the query.
+type Result struct {
+	Key      string
+	Distance float32
+}
+
+// invertedList holds the vectors assigned to one centroid.
+// Vector i is stored at data[i*dim : (i+1)*dim].
+type invertedList struct {
+	keys []string
+	data []float32
+}
+
+// location is the position of a key inside the index.
+// list is -1 for vectors waiting for the index to be trained.
+type location struct {
+	list int
+	row  int
+}
+
+// IVFFlat is an inverted file index. A k-means coarse quantizer partitions
+// the space into NList cells, each vector is stored in the list of its
+// nearest centroid, and a search only scans the NProbe closest lists.
+//
+// Vectors added before the index is trained are kept in a pending list that
+// is scanned exhaustively. Once TrainSize vectors are pending the index
+// trains itself on them.
+type IVFFlat struct {
+	dim      int
+	distance string
+	dFunc    kmeans.DistanceFunc
+	rng      *rand.Rand
+
+	// NList is the number of centroids, and so of inverted lists.
+	NList int
+	// NProbe is the number of lists scanned by Search.
+	// Higher values improve recall at the expense of speed.
+	NProbe int
+	// TrainSize is the number of pending vectors that triggers training.
+	// Zero disables automatic training.
+	TrainSize int
+
+	centroids [][]float32
+	lists     []invertedList
+	pending   invertedList
+	locations map[string]location
+}
+
+// NewIVFFlat creates an empty, untrained index.
+// nlist is the number of centroids and nprobe the number of lists searched.
+func NewIVFFlat(nlist, nprobe int, distanceFunc string) (*IVFFlat, error) {
+	distanceFunc = distance.Canonical(distanceFunc)
+	dFunc, err := lookupDistanceFunc(distanceFunc)
+	if err != nil {
+		return nil, err
+	}
+	if nlist <= 0 || nprobe <= 0 {
+		return nil, errors.New("nlist and nprobe must be greater than 0")
+	}
+	return &IVFFlat{
+		distance: distanceFunc,
+		dFunc:    dFunc,
+		rng:      rand.New(rand.NewSource(time.Now().UnixNano())),
+		NList:    nlist,
+		NProbe:   nprobe,
+		// 39 points per centroid is the smallest sample k-means
+		// reliably converges on.
+		TrainSize: 39 * nlist,
+		locations: make(map[string]location),
+	}, nil
+}
+
+// Len returns the number of vectors in the index.
+func (ivf *IVFFlat) Len() int {
+	return len(ivf.locations)
+}
+
+// Dims returns the dimension of the vectors, or 0 if the index is empty.
+func (ivf *IVFFlat) Dims() int {
+	return ivf.dim
+}
+
+// Metric returns the name of the distance function of the index.
+func (ivf *IVFFlat) Metric() string {
+	return ivf.distance
+}
+
+// Trained reports whether the coarse quantizer has been trained.
+func (ivf *IVFFlat) Trained() bool {
+	return len(ivf.centroids) > 0
+}
+
+func (ivf *IVFFlat) list(i int) *invertedList {
+	if i < 0 {
+		return &ivf.pending
+	}
+	return &ivf.lists[i]
+}
+
+func (ivf *IVFFlat) row(l *invertedList, i int) []float32 {
+	return l.data[i*ivf.dim : (i+1)*ivf.dim : (i+1)*ivf.dim]
+}
+
+// Train runs k-means on the sample to build the coarse quantizer and moves
+// every pending vector into its inverted list. A nil sample trains on the
+// pending vectors. Training an already trained index is an error.
+func (ivf *IVFFlat) Train(sample [][]float32) error {
+	if ivf.Trained() {
+		return ErrAlreadyTrained
+	}
+	if sample == nil {
+		sample = make([][]float32, len(ivf.pending.keys))
+		for i := range sample {
+			sample[i] = ivf.row(&ivf.pending, i)
+		}
+	} else {
+		sample = normalizeSample(sample, ivf.distance)
+	}
+	if len(sample) == 0 {
+		return errors.New("cannot train on an empty sample")
+	}
+	if ivf.dim == 0 {
+		ivf.dim = len(sample[0])
+	}
+	for _, point := range sample {
+		if len(point) != ivf.dim {
+			return fmt.Errorf("embedding dimension mismatch: %d != %d", ivf.dim, len(point))
+		}
+	}
+
+	k := min(ivf.NList, len(sample))
+	ivf.centroids = kmeans.Train(sample, k, ivf.dFunc, ivf.rng)
+	ivf.NList = k
+	ivf.lists = make([]invertedList, k)
+
+	pending := ivf.pending
+	ivf.pending = invertedList{}
+	for i, key := range pending.keys {
+		ivf.append(key, ivf.row(&pending, i))
+	}
+	return nil
+}
+
+// append stores the vector in the list of its nearest centroid, or in the
+// pending list if the index is untrained.
+func (ivf *IVFFlat) append(key string, vector []float32) {
+	list := -1
+	if ivf.Trained() {
+		list, _ = kmeans.Nearest(ivf.centroids, vector, ivf.dFunc)
+	}
+	l := ivf.list(list)
+	ivf.locations[key] = location{list: list, row: len(l.keys)}
+	l.keys = append(l.keys, key)
+	l.data = append(l.data, vector...)
+}
+
+// Add inserts the vector under key, replacing any previous vector.
+// Adding to an untrained index may trigger training; see TrainSize.
+func (ivf *IVFFlat) Add(key string, vector []float32) error {
+	if ivf.dim == 0 {
+		ivf.dim = len(vector)
+	}
+	if len(vector) != ivf.dim {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", ivf.dim, len(vector))
+	}
+	if distance.NeedsNormalization(ivf.distance) {
+		vector = distance.Normalize(vector)
+	}
+	ivf.Delete(key)
+	ivf.append(key, vector)
+	if !ivf.Trained() && ivf.TrainSize > 0 && len(ivf.pending.keys) >= ivf.TrainSize {
+		return ivf.Train(nil)
+	}
+	return nil
+}
+
+// Delete removes key from the index and reports whether it was present.
+func (ivf *IVFFlat) Delete(key string) bool {
+	loc, ok := ivf.locations[key]
+	if !ok {
+		return false
+	}
+	l := ivf.list(loc.list)
+	last := len(l.keys) - 1
+	if loc.row != last {
+		copy(ivf.row(l, loc.row), ivf.row(l, last))
+		l.keys[loc.row] = l.keys[last]
+		ivf.locations[l.keys[loc.row]] = loc
+	}
+	l.keys = l.keys[:last]
+	l.data = l.data[:last*ivf.dim]
+	delete(ivf.locations, key)
+	return true
+}
+
+// Lookup returns a copy of the vector stored under key.
+func (ivf *IVFFlat) Lookup(key string) ([]float32, bool) {
+	loc, ok := ivf.locations[key]
+	if !ok {
+		return nil, false
+	}
+	return append([]float32(nil), ivf.row(ivf.list(loc.list), loc.row)...), true
+}
+
+// Each calls fn with every key and its vector, the pending ones first,
+// stopping at and returning the first error fn returns. The vector must not
+// be modified or kept past the call.
+func (ivf *IVFFlat) Each(fn func(key string, vector []float32) error) error {
+	for l := -1; l < len(ivf.lists); l++ {
+		list := ivf.list(l)
+		for i, key := range list.keys {
+			if err := fn(key, ivf.row(list, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Search returns the approximate k nearest neighbours of the query,
+// scanning the NProbe closest inverted lists.
+func (ivf *IVFFlat) Search(query []float32, k int) ([]Result, error) {
+	return ivf.SearchNProbe(query, k, ivf.NProbe)
+}
+
+// SearchNProbe is like Search with an explicit number of lists to scan.
+func (ivf *IVFFlat) SearchNProbe(query []float32, k int, nprobe int) ([]Result, error) {
+	return ivf.search(query, k, nprobe, float32(math.Inf(1)))
+}
+
+// SearchRange returns the vectors within radius of the query found in the
+// NProbe closest inverted lists, nearest first, and at most maxResults of
+// them if maxResults > 0. Distances are those of the index's distance
+// function, smaller meaning closer.
+func (ivf *IVFFlat) SearchRange(query []float32, radius float32, maxResults int) ([]Result, error) {
+	if maxResults <= 0 {
+		maxResults = ivf.Len()
+	}
+	return ivf.search(query, maxResults, ivf.NProbe, radius)
+}
+
+// search returns the k nearest vectors within radius in the nprobe closest
+// lists and the pending list.
+func (ivf *IVFFlat) search(query []float32, k int, nprobe int, radius float32) ([]Result, error) {
+	if ivf.Len() == 0 || k <= 0 {
+		return nil, nil
+	}
+	if len(query) != ivf.dim {
+		return nil, fmt.Errorf("embedding dimension mismatch: %d != %d", ivf.dim, len(query))
+	}
+	if distance.NeedsNormalization(ivf.distance) {
+		query = distance.Normalize(query)
+	}
+
+	results := make(resultHeap, 0, min(k, ivf.Len()))
+	ivf.scan(&ivf.pending, query, k, radius, &results)
+	for _, list := range probe(ivf.centroids, query, nprobe, ivf.dFunc) {
+		ivf.scan(&ivf.lists[list], query, k, radius, &results)
+	}
+	out := make([]Result, len(results))
+	for i, r := range results {
+		out[i] = r.Result
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Distance < out[j].Distance
+	})
+	return out, nil
+}
+
+// probe returns the indexes of the nprobe centroids closest to the query.
+func probe(centroids [][]float32, query []float32, nprobe int, distance kmeans.DistanceFunc) []int {
+	if nprobe > len(centroids) {
+		nprobe = len(centroids)
+	}
+	closest := make(resultHeap, 0, nprobe)
+	for c, centroid := range centroids {
+		closest.offer(Result{Distance: distance(query, centroid)}, nprobe, c)
+	}
+	lists := make([]int, len(closest))
+	for i, r := range closest {
+		lists[i] = r.list
+	}
+	return lists
+}
+
+func (ivf *IVFFlat) scan(l *invertedList, query []float32, k int, radius float32, results *resultHeap) {
+	for i, key := range l.keys {
+		if dist := ivf.dFunc(query, ivf.row(l, i)); dist <= radius {
+			results.offer(Result{Key: key, Distance: dist}, k, 0)
+		}
+	}
+}
+
+// scoredResult is a Result tagged with the inverted list it came from.
+type scoredResult struct {
+	Result
+	list int
+}
+
+// resultHeap is a max-heap on distance bounded to the k best results, so
+// the worst of them sits at the root ready to be evicted.
+type resultHeap []scoredResult
+
+func (h resultHeap) Len() int           { return len(h) }
+func (h resultHeap) Less(i, j int) bool { return h[i].Distance > h[j].Distance }
+func (h resultHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *resultHeap) Push(x any)        { *h = append(*h, x.(scoredResult)) }
+func (h *resultHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// offer adds r if the heap holds fewer than k results or r beats the worst one.
+func (h *resultHeap) offer(r Result, k int, list int) {
+	if h.Len() < k {
+		heap.Push(h, scoredResult{Result: r, list: list})
+		return
+	}
+	if r.Distance < (*h)[0].Distance {
+		(*h)[0] = scoredResult{Result: r, list: list}
+		heap.Fix(h, 0)
+	}
+}
+
+// lookupDistanceFunc returns the distance function registered under name.
+func lookupDistanceFunc(name string) (kmeans.DistanceFunc, error) {
+	fn, ok := distance.Lookup(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown distance function %q", name)
+	}
+	return fn, nil
+}
+
+// normalizeSample returns the sample normalised to unit length if the
+// metric expects unit vectors, and the sample itself otherwise.
+func normalizeSample(sample [][]float32, distanceFunc string) [][]float32 {
+	if !distance.NeedsNormalization(distanceFunc) {
+		return sample
+	}
+	normalized := make([][]float32, len(sample))
+	for i, v := range sample {
+		normalized[i] = distance.Normalize(v)
+	}
+	return normalized
+}
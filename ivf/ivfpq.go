@@ -0,0 +1,372 @@
+package ivf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+	"vectorDb/distance"
+	"vectorDb/kmeans"
+	"vectorDb/pq"
+)
+
+// codeList holds the product quantization codes of the vectors assigned to
+// one centroid. The code of vector i is codes[i*codeSize : (i+1)*codeSize].
+type codeList struct {
+	keys  []string
+	codes []byte
+}
+
+// IVFPQ is an inverted file index whose lists store product quantization
+// codes instead of full vectors. Each vector is encoded as its residual from
+// its list's centroid, which spreads the codebooks over the fine structure
+// of every cell rather than over the whole space.
+//
+// As with IVFFlat, vectors added before training are kept in full in a
+// pending list, and the index trains itself once TrainSize are pending.
+type IVFPQ struct {
+	dim      int
+	distance string
+	dFunc    kmeans.DistanceFunc
+	rng      *rand.Rand
+
+	// NList is the number of centroids, and so of inverted lists.
+	NList int
+	// NProbe is the number of lists scanned by Search.
+	NProbe int
+	// TrainSize is the number of pending vectors that triggers training.
+	// Zero disables automatic training.
+	TrainSize int
+	// Subspaces is the number of product quantization subspaces, which is
+	// also the size of a code in bytes. Zero picks a quarter of the
+	// dimension, rounded down to a divisor of it, at training time.
+	Subspaces int
+
+	centroids [][]float32
+	quantizer *pq.Quantizer
+	lists     []codeList
+	pending   invertedList
+	locations map[string]location
+}
+
+// NewIVFPQ creates an empty, untrained index.
+// nlist is the number of centroids, nprobe the number of lists searched and
+// subspaces the number of bytes per code, or 0 to choose it from the dimension.
+func NewIVFPQ(nlist, nprobe, subspaces int, distanceFunc string) (*IVFPQ, error) {
+	distanceFunc = distance.Canonical(distanceFunc)
+	dFunc, err := lookupDistanceFunc(distanceFunc)
+	if err != nil {
+		return nil, err
+	}
+	switch distanceFunc {
+	case distance.NameEuclidean, distance.NameSquareDistance, distance.NameInnerProduct, distance.NameNormalizedCosine:
+	default:
+		// ADC needs a distance that decomposes over subspaces.
+		return nil, fmt.Errorf("distance function %q is not supported by product quantization", distanceFunc)
+	}
+	if nlist <= 0 || nprobe <= 0 || subspaces < 0 {
+		return nil, errors.New("nlist and nprobe must be greater than 0 and subspaces not negative")
+	}
+	return &IVFPQ{
+		distance:  distanceFunc,
+		dFunc:     dFunc,
+		rng:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		NList:     nlist,
+		NProbe:    nprobe,
+		TrainSize: max(39*nlist, pq.MaxCentroids),
+		Subspaces: subspaces,
+		locations: make(map[string]location),
+	}, nil
+}
+
+// Len returns the number of vectors in the index.
+func (ivf *IVFPQ) Len() int {
+	return len(ivf.locations)
+}
+
+// Dims returns the dimension of the vectors, or 0 if the index is empty.
+func (ivf *IVFPQ) Dims() int {
+	return ivf.dim
+}
+
+// Metric returns the name of the distance function of the index.
+func (ivf *IVFPQ) Metric() string {
+	return ivf.distance
+}
+
+// Trained reports whether the coarse quantizer and codebooks have been trained.
+func (ivf *IVFPQ) Trained() bool {
+	return ivf.quantizer != nil
+}
+
+// inner reports whether the index ranks by inner product, in which case
+// residual codes are scored against the query itself rather than against
+// its own residual.
+func (ivf *IVFPQ) inner() bool {
+	return distance.IsInnerProduct(ivf.distance)
+}
+
+func (ivf *IVFPQ) code(l *codeList, i int) []byte {
+	size := ivf.quantizer.CodeSize()
+	return l.codes[i*size : (i+1)*size : (i+1)*size]
+}
+
+func (ivf *IVFPQ) pendingRow(i int) []float32 {
+	return ivf.pending.data[i*ivf.dim : (i+1)*ivf.dim : (i+1)*ivf.dim]
+}
+
+// Train learns the coarse centroids from the sample, then the product
+// quantizer from the residuals of the sample to their centroids, and encodes
+// every pending vector. A nil sample trains on the pending vectors.
+func (ivf *IVFPQ) Train(sample [][]float32) error {
+	if ivf.Trained() {
+		return ErrAlreadyTrained
+	}
+	if sample == nil {
+		sample = make([][]float32, len(ivf.pending.keys))
+		for i := range sample {
+			sample[i] = ivf.pendingRow(i)
+		}
+	} else {
+		sample = normalizeSample(sample, ivf.distance)
+	}
+	if len(sample) == 0 {
+		return errors.New("cannot train on an empty sample")
+	}
+	if ivf.dim == 0 {
+		ivf.dim = len(sample[0])
+	}
+	for _, point := range sample {
+		if len(point) != ivf.dim {
+			return fmt.Errorf("embedding dimension mismatch: %d != %d", ivf.dim, len(point))
+		}
+	}
+
+	subspaces := ivf.Subspaces
+	if subspaces == 0 {
+		subspaces = max(ivf.dim/4, 1)
+		for ivf.dim%subspaces != 0 {
+			subspaces--
+		}
+	}
+	quantizer, err := pq.NewQuantizer(ivf.dim, subspaces, pq.MaxCentroids, ivf.distance)
+	if err != nil {
+		return err
+	}
+
+	k := min(ivf.NList, len(sample))
+	centroids := kmeans.Train(sample, k, ivf.dFunc, ivf.rng)
+	residuals := make([][]float32, len(sample))
+	for i, point := range sample {
+		c, _ := kmeans.Nearest(centroids, point, ivf.dFunc)
+		residuals[i] = residual(point, centroids[c])
+	}
+	if err := quantizer.Train(residuals, ivf.rng); err != nil {
+		return err
+	}
+
+	ivf.centroids = centroids
+	ivf.quantizer = quantizer
+	ivf.NList = k
+	ivf.Subspaces = subspaces
+	ivf.lists = make([]codeList, k)
+
+	pending := ivf.pending
+	ivf.pending = invertedList{}
+	for i, key := range pending.keys {
+		ivf.append(key, pending.data[i*ivf.dim:(i+1)*ivf.dim])
+	}
+	return nil
+}
+
+// residual returns v minus the centroid.
+func residual(v, centroid []float32) []float32 {
+	r := make([]float32, len(v))
+	for i := range v {
+		r[i] = v[i] - centroid[i]
+	}
+	return r
+}
+
+// append encodes the vector into the list of its nearest centroid, or keeps
+// it in full in the pending list if the index is untrained.
+func (ivf *IVFPQ) append(key string, vector []float32) {
+	if !ivf.Trained() {
+		ivf.locations[key] = location{list: -1, row: len(ivf.pending.keys)}
+		ivf.pending.keys = append(ivf.pending.keys, key)
+		ivf.pending.data = append(ivf.pending.data, vector...)
+		return
+	}
+	c, _ := kmeans.Nearest(ivf.centroids, vector, ivf.dFunc)
+	l := &ivf.lists[c]
+	ivf.locations[key] = location{list: c, row: len(l.keys)}
+	l.keys = append(l.keys, key)
+	l.codes = append(l.codes, ivf.quantizer.Encode(residual(vector, ivf.centroids[c]))...)
+}
+
+// Add inserts the vector under key, replacing any previous vector.
+// Adding to an untrained index may trigger training; see TrainSize.
+func (ivf *IVFPQ) Add(key string, vector []float32) error {
+	if ivf.dim == 0 {
+		ivf.dim = len(vector)
+	}
+	if len(vector) != ivf.dim {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", ivf.dim, len(vector))
+	}
+	if distance.NeedsNormalization(ivf.distance) {
+		vector = distance.Normalize(vector)
+	}
+	ivf.Delete(key)
+	ivf.append(key, vector)
+	if !ivf.Trained() && ivf.TrainSize > 0 && len(ivf.pending.keys) >= ivf.TrainSize {
+		return ivf.Train(nil)
+	}
+	return nil
+}
+
+// Delete removes key from the index and reports whether it was present.
+func (ivf *IVFPQ) Delete(key string) bool {
+	loc, ok := ivf.locations[key]
+	if !ok {
+		return false
+	}
+	if loc.list < 0 {
+		last := len(ivf.pending.keys) - 1
+		if loc.row != last {
+			copy(ivf.pendingRow(loc.row), ivf.pendingRow(last))
+			ivf.pending.keys[loc.row] = ivf.pending.keys[last]
+			ivf.locations[ivf.pending.keys[loc.row]] = loc
+		}
+		ivf.pending.keys = ivf.pending.keys[:last]
+		ivf.pending.data = ivf.pending.data[:last*ivf.dim]
+	} else {
+		l := &ivf.lists[loc.list]
+		last := len(l.keys) - 1
+		if loc.row != last {
+			copy(ivf.code(l, loc.row), ivf.code(l, last))
+			l.keys[loc.row] = l.keys[last]
+			ivf.locations[l.keys[loc.row]] = loc
+		}
+		l.keys = l.keys[:last]
+		l.codes = l.codes[:last*ivf.quantizer.CodeSize()]
+	}
+	delete(ivf.locations, key)
+	return true
+}
+
+// Lookup returns the vector stored under key. Once the index is trained
+// this is the reconstruction from the vector's code, not the original.
+func (ivf *IVFPQ) Lookup(key string) ([]float32, bool) {
+	loc, ok := ivf.locations[key]
+	if !ok {
+		return nil, false
+	}
+	if loc.list < 0 {
+		return append([]float32(nil), ivf.pendingRow(loc.row)...), true
+	}
+	v := ivf.quantizer.Decode(ivf.code(&ivf.lists[loc.list], loc.row))
+	for i, c := range ivf.centroids[loc.list] {
+		v[i] += c
+	}
+	return v, true
+}
+
+// Each calls fn with every key and its vector as Lookup returns it, the
+// pending ones first, stopping at and returning the first error fn returns.
+func (ivf *IVFPQ) Each(fn func(key string, vector []float32) error) error {
+	for i, key := range ivf.pending.keys {
+		if err := fn(key, ivf.pendingRow(i)); err != nil {
+			return err
+		}
+	}
+	for l := range ivf.lists {
+		for _, key := range ivf.lists[l].keys {
+			vector, _ := ivf.Lookup(key)
+			if err := fn(key, vector); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Search returns the approximate k nearest neighbours of the query,
+// scanning the NProbe closest inverted lists.
+func (ivf *IVFPQ) Search(query []float32, k int) ([]Result, error) {
+	return ivf.SearchNProbe(query, k, ivf.NProbe)
+}
+
+// SearchNProbe is like Search with an explicit number of lists to scan.
+// Distances to encoded vectors are ADC estimates.
+func (ivf *IVFPQ) SearchNProbe(query []float32, k int, nprobe int) ([]Result, error) {
+	return ivf.search(query, k, nprobe, float32(math.Inf(1)))
+}
+
+// SearchRange returns the vectors within radius of the query found in the
+// NProbe closest inverted lists, nearest first, and at most maxResults of
+// them if maxResults > 0. Distances are those of the index's distance
+// function, smaller meaning closer, and ADC estimates for encoded vectors.
+func (ivf *IVFPQ) SearchRange(query []float32, radius float32, maxResults int) ([]Result, error) {
+	if maxResults <= 0 {
+		maxResults = ivf.Len()
+	}
+	return ivf.search(query, maxResults, ivf.NProbe, radius)
+}
+
+// search returns the k nearest vectors within radius in the nprobe closest
+// lists and the pending list.
+func (ivf *IVFPQ) search(query []float32, k int, nprobe int, radius float32) ([]Result, error) {
+	if ivf.Len() == 0 || k <= 0 {
+		return nil, nil
+	}
+	if len(query) != ivf.dim {
+		return nil, fmt.Errorf("embedding dimension mismatch: %d != %d", ivf.dim, len(query))
+	}
+	if distance.NeedsNormalization(ivf.distance) {
+		query = distance.Normalize(query)
+	}
+
+	results := make(resultHeap, 0, min(k, ivf.Len()))
+	for i, key := range ivf.pending.keys {
+		if dist := ivf.dFunc(query, ivf.pendingRow(i)); dist <= radius {
+			results.offer(Result{Key: key, Distance: dist}, k, 0)
+		}
+	}
+	if ivf.Trained() {
+		var table *pq.DistanceTable
+		if ivf.inner() {
+			table = ivf.quantizer.DistanceTable(query)
+		}
+		for _, list := range probe(ivf.centroids, query, nprobe, ivf.dFunc) {
+			// -q·(c+r) = -q·c - q·r, while |q-(c+r)|² = |(q-c)-r|².
+			// For normalized cosine the constant 1 rides on the offset.
+			var offset float32
+			if ivf.inner() {
+				offset = ivf.dFunc(query, ivf.centroids[list])
+			} else {
+				table = ivf.quantizer.DistanceTable(residual(query, ivf.centroids[list]))
+			}
+			l := &ivf.lists[list]
+			for i, key := range l.keys {
+				dist := offset + table.Distance(ivf.code(l, i))
+				if ivf.distance == distance.NameEuclidean {
+					dist = float32(math.Sqrt(float64(dist)))
+				}
+				if dist <= radius {
+					results.offer(Result{Key: key, Distance: dist}, k, list)
+				}
+			}
+		}
+	}
+
+	out := make([]Result, len(results))
+	for i, r := range results {
+		out[i] = r.Result
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Distance < out[j].Distance
+	})
+	return out, nil
+}
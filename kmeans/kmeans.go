@@ -0,0 +1,115 @@
+// Package kmeans implements the k-means clustering used to train the coarse
+// quantizer of IVF indexes and the sub-codebooks of product quantizers.
+package kmeans
+
+import (
+	"math"
+	"math/rand"
+	"vectorDb/distance"
+)
+
+// DistanceFunc computes the distance between two vectors.
+type DistanceFunc = distance.Func
+
+// iterations bounds the number of Lloyd iterations run by Train.
+const iterations = 25
+
+// Train clusters the sample into k centroids using k-means++ seeding
+// followed by Lloyd iterations. Clusters that end up empty are re-seeded
+// from the point furthest from its centroid.
+func Train(sample [][]float32, k int, distance DistanceFunc, rng *rand.Rand) [][]float32 {
+	dim := len(sample[0])
+	centroids := seedCentroids(sample, k, distance, rng)
+	assignments := make([]int, len(sample))
+
+	for range iterations {
+		changed := false
+		for i, point := range sample {
+			c, _ := Nearest(centroids, point, distance)
+			if c != assignments[i] {
+				assignments[i] = c
+				changed = true
+			}
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for c := range sums {
+			sums[c] = make([]float64, dim)
+		}
+		for i, point := range sample {
+			c := assignments[i]
+			counts[c]++
+			for d, v := range point {
+				sums[c][d] += float64(v)
+			}
+		}
+		for c := range centroids {
+			if counts[c] == 0 {
+				centroids[c] = append([]float32(nil), furthest(sample, centroids, assignments, distance)...)
+				changed = true
+				continue
+			}
+			for d := range centroids[c] {
+				centroids[c][d] = float32(sums[c][d] / float64(counts[c]))
+			}
+		}
+
+		if !changed {
+			break
+		}
+	}
+	return centroids
+}
+
+// seedCentroids picks k initial centroids with k-means++: each new centroid
+// is drawn with probability proportional to its distance from the closest
+// centroid picked so far.
+func seedCentroids(sample [][]float32, k int, distance DistanceFunc, rng *rand.Rand) [][]float32 {
+	centroids := make([][]float32, 0, k)
+	centroids = append(centroids, append([]float32(nil), sample[rng.Intn(len(sample))]...))
+	weights := make([]float64, len(sample))
+	for len(centroids) < k {
+		var total float64
+		for i, point := range sample {
+			_, d := Nearest(centroids, point, distance)
+			weights[i] = math.Max(float64(d), 0)
+			total += weights[i]
+		}
+		next := rng.Intn(len(sample))
+		if total > 0 {
+			r := rng.Float64() * total
+			for i, w := range weights {
+				r -= w
+				if r <= 0 {
+					next = i
+					break
+				}
+			}
+		}
+		centroids = append(centroids, append([]float32(nil), sample[next]...))
+	}
+	return centroids
+}
+
+// Nearest returns the index of the centroid closest to point and its distance.
+func Nearest(centroids [][]float32, point []float32, distance DistanceFunc) (int, float32) {
+	best, bestDist := 0, float32(math.Inf(1))
+	for c, centroid := range centroids {
+		if d := distance(point, centroid); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best, bestDist
+}
+
+// furthest returns the sample point that is furthest from its assigned centroid.
+func furthest(sample [][]float32, centroids [][]float32, assignments []int, distance DistanceFunc) []float32 {
+	worst, worstDist := 0, float32(math.Inf(-1))
+	for i, point := range sample {
+		if d := distance(point, centroids[assignments[i]]); d > worstDist {
+			worst, worstDist = i, d
+		}
+	}
+	return sample[worst]
+}
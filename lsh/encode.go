@@ -1,24 +1,67 @@
 package lsh
 
 import (
-	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"os"
+	"vectorDb/distance"
+	"vectorDb/storefile"
 )
 
 // var byteOrder=binary.LittleEndian
 
-// encode serializes the CosineLsh index to a file
-func (lsh *CosineLsh) Save(storeName string) error {
-	f, err := os.OpenFile(storeName+"_lsh"+".store", os.O_RDWR|os.O_CREATE, 0o600)
-	if err != nil {
-		return err
+// encodingVersion is the version of the layouts written by Save.
+const encodingVersion = 1
+
+// legacyLayout reports whether prefix starts like a CosineLsh file written
+// before headers: with a positive dimension and numbers of tables and
+// hashes, l*m hyperplanes, and a registered distance function.
+func legacyLayout(prefix []byte) bool {
+	if len(prefix) < 16 {
+		return false
+	}
+	var params [4]int64
+	for i := range params {
+		params[i] = int64(int32(binary.LittleEndian.Uint32(prefix[4*i:])))
+	}
+	dim, l, m, h := params[0], params[1], params[2], params[3]
+	if dim <= 0 || l <= 0 || m <= 0 || h != l*m {
+		return false
+	}
+	name, ok := legacyString(prefix[16:])
+	if !ok {
+		return false
+	}
+	_, ok = distance.Lookup(name)
+	return ok
+}
+
+// legacyString decodes a string written by writeString at the start of
+// prefix, if it fits.
+func legacyString(prefix []byte) (string, bool) {
+	if len(prefix) < 4 {
+		return "", false
+	}
+	n := int32(binary.LittleEndian.Uint32(prefix))
+	if n < 0 || int(n) > len(prefix)-4 {
+		return "", false
 	}
-	defer f.Close()
+	return string(prefix[4 : 4+n]), true
+}
+
+// encode serializes the CosineLsh index to a file.
+// The header is followed by a section with the parameters and the
+// hyperplanes, then a section with the hash tables.
+func (lsh *CosineLsh) Save(storeName string) error {
+	header := storefile.Header{Type: "lsh", Version: encodingVersion, Dim: int(lsh.dim), Metric: lsh.dFunc}
+	return storefile.Write(storeName+"_lsh"+".store", header, lsh.write)
+}
+
+// write encodes the index to writer.
+func (lsh *CosineLsh) write(writer *storefile.Writer) error {
 	var byteOrder = binary.LittleEndian
-	writer := bufio.NewWriter(f)
 
 	// Write scalar fields
 	if err := binary.Write(writer, byteOrder, lsh.dim); err != nil {
@@ -71,6 +114,9 @@ func (lsh *CosineLsh) Save(storeName string) error {
 	if err := binary.Write(writer, byteOrder, lsh.nextID); err != nil {
 		return err
 	}
+	if err := writer.EndSection(); err != nil {
+		return err
+	}
 
 	// Write tables
 	// First write the number of tables
@@ -123,20 +169,15 @@ func (lsh *CosineLsh) Save(storeName string) error {
 			}
 		}
 	}
-	err = writer.Flush()
-	if err != nil {
-		return err
-	}
-	err = f.Sync()
-	if err != nil {
-		return nil
-	}
-	return nil
+	return writer.EndSection()
 }
 
-// decode deserializes the CosineLsh index from a file
+// decode deserializes the CosineLsh index from a file.
+// A missing file is an error wrapping fs.ErrNotExist, and no file is
+// created. An empty file leaves the index unchanged, and so does a file
+// that fails to decode.
 func (lsh *CosineLsh) Load(filename string) error {
-	file, err := os.OpenFile(filename+"_lsh"+".store", os.O_RDWR|os.O_CREATE, 0o600)
+	file, err := os.Open(filename + "_lsh" + ".store")
 	if err != nil {
 		return err
 	}
@@ -147,20 +188,17 @@ func (lsh *CosineLsh) Load(filename string) error {
 	}
 	if info.Size() > 0 {
 		var byteOrder = binary.LittleEndian
-		reader := bufio.NewReader(file)
-
-		// Read scalar fields
-		if err := binary.Read(reader, byteOrder, &lsh.dim); err != nil {
-			return err
-		}
-		if err := binary.Read(reader, byteOrder, &lsh.l); err != nil {
-			return err
-		}
-		if err := binary.Read(reader, byteOrder, &lsh.m); err != nil {
+		reader, err := storefile.NewReader(file, info.Size(), "lsh", encodingVersion, legacyLayout)
+		if err != nil {
 			return err
 		}
-		if err := binary.Read(reader, byteOrder, &lsh.h); err != nil {
-			return err
+
+		// Read scalar fields
+		var dim, l, m, h int32
+		for _, v := range []*int32{&dim, &l, &m, &h} {
+			if err := binary.Read(reader, byteOrder, v); err != nil {
+				return err
+			}
 		}
 
 		// Read dFunc string
@@ -168,7 +206,13 @@ func (lsh *CosineLsh) Load(filename string) error {
 		if err != nil {
 			return err
 		}
-		lsh.dFunc = dFunc
+		// Files without a header were written when euclidean was the only
+		// metric. Their hyperplanes, drawn uniformly from [0, 1) rather
+		// than from a normal distribution, are kept as saved so that the
+		// points keep their buckets.
+		if reader.Legacy() && distance.Canonical(dFunc) != distance.NameEuclidean {
+			return fmt.Errorf("lsh file without header uses distance function %q, not %q", dFunc, distance.NameEuclidean)
+		}
 
 		// Read hyperplanes
 		var rows, cols int32
@@ -178,20 +222,29 @@ func (lsh *CosineLsh) Load(filename string) error {
 		if err := binary.Read(reader, byteOrder, &cols); err != nil {
 			return err
 		}
+		// The hashing assumes h = l*m hyperplanes of dim values each.
+		if dim < 0 || l < 0 || m < 0 || int64(h) != int64(l)*int64(m) || rows != h || (rows > 0 && cols != dim) {
+			return errors.New("invalid lsh parameters")
+		}
+		if err := storefile.CheckLen(reader, int64(rows)*int64(cols), 4); err != nil {
+			return fmt.Errorf("decoding hyperplanes: %w", err)
+		}
 
 		// Initialize and fill hyperplanes
-		lsh.hyperplanes = make([][]float32, rows)
-		for i := int32(0); i < rows; i++ {
-			lsh.hyperplanes[i] = make([]float32, cols)
-			for j := int32(0); j < cols; j++ {
-				if err := binary.Read(reader, byteOrder, &lsh.hyperplanes[i][j]); err != nil {
-					return err
-				}
+		hyperplanes := make([][]float32, rows)
+		for i := range hyperplanes {
+			hyperplanes[i] = make([]float32, cols)
+			if err := binary.Read(reader, byteOrder, hyperplanes[i]); err != nil {
+				return err
 			}
 		}
 
 		// Read nextId
-		if err := binary.Read(reader, byteOrder, &lsh.nextID); err != nil {
+		var nextID uint64
+		if err := binary.Read(reader, byteOrder, &nextID); err != nil {
+			return err
+		}
+		if err := reader.EndSection(); err != nil {
 			return err
 		}
 
@@ -200,18 +253,23 @@ func (lsh *CosineLsh) Load(filename string) error {
 		if err := binary.Read(reader, byteOrder, &numTables); err != nil {
 			return err
 		}
-
-		// Initialize tables
-		lsh.tables = make([]hashTable, numTables)
+		if numTables != l {
+			return errors.New("number of tables does not match the parameters")
+		}
+		tables := make([]hashTable, numTables)
 
 		// Read each table
-		for i := int32(0); i < numTables; i++ {
+		for i := range tables {
 			var numEntries int32
 			if err := binary.Read(reader, byteOrder, &numEntries); err != nil {
 				return err
 			}
+			// Each entry is at least its key and its number of points.
+			if err := storefile.CheckLen(reader, int64(numEntries), 12); err != nil {
+				return fmt.Errorf("decoding table %d: %w", i, err)
+			}
 
-			lsh.tables[i] = make(hashTable)
+			tables[i] = make(hashTable, numEntries)
 
 			// Read each key-value pair
 			for j := int32(0); j < numEntries; j++ {
@@ -224,11 +282,15 @@ func (lsh *CosineLsh) Load(filename string) error {
 				if err := binary.Read(reader, byteOrder, &numPoints); err != nil {
 					return err
 				}
+				// Each point is at least its ID and two lengths.
+				if err := storefile.CheckLen(reader, int64(numPoints), 16); err != nil {
+					return fmt.Errorf("decoding bucket %d of table %d: %w", key, i, err)
+				}
 
 				points := make([]Point, numPoints)
 
 				// Read each point
-				for k := int32(0); k < numPoints; k++ {
+				for k := range points {
 					// Read point ID
 					if err := binary.Read(reader, byteOrder, &points[k].ID); err != nil {
 						return err
@@ -239,13 +301,14 @@ func (lsh *CosineLsh) Load(filename string) error {
 					if err := binary.Read(reader, byteOrder, &vectorLen); err != nil {
 						return err
 					}
+					if err := storefile.CheckLen(reader, int64(vectorLen), 4); err != nil {
+						return fmt.Errorf("decoding point %d: %w", points[k].ID, err)
+					}
 
 					// Initialize and fill vector
 					points[k].Vector = make([]float32, vectorLen)
-					for v := int32(0); v < vectorLen; v++ {
-						if err := binary.Read(reader, byteOrder, &points[k].Vector[v]); err != nil {
-							return err
-						}
+					if err := binary.Read(reader, byteOrder, points[k].Vector); err != nil {
+						return err
 					}
 
 					// Read extra data
@@ -256,9 +319,20 @@ func (lsh *CosineLsh) Load(filename string) error {
 					points[k].ExtraData = extraData
 				}
 
-				lsh.tables[i][key] = points
+				tables[i][key] = points
 			}
 		}
+		if err := reader.EndSection(); err != nil {
+			return err
+		}
+
+		param, err := newCosineLshParam(dim, l, m, h, dFunc, hyperplanes)
+		if err != nil {
+			return err
+		}
+		lsh.cosineLshParam = param
+		lsh.tables = tables
+		lsh.nextID = nextID
 	}
 
 	return nil
@@ -287,8 +361,8 @@ func readString(r io.Reader) (string, error) {
 	if err := binary.Read(r, byteOrder, &length); err != nil {
 		return "", err
 	}
-	if length < 0 {
-		return "", errors.New("invalid string length")
+	if err := storefile.CheckLen(r, int64(length), 1); err != nil {
+		return "", fmt.Errorf("decoding string: %w", err)
 	}
 
 	// Read the string data
@@ -299,3 +373,157 @@ func readString(r io.Reader) (string, error) {
 
 	return string(bytes), nil
 }
+
+// Save serializes the MinHashLsh index to a file: the header, a section
+// with the parameters and the hash seeds, then a section with the
+// signatures. Band tables are not written; they are rebuilt from the
+// signatures on Load.
+func (mh *MinHashLsh) Save(storeName string) error {
+	// MinHash has no vector dimension; the number of seeds is in the body.
+	header := storefile.Header{Type: "minhash", Version: encodingVersion, Dim: 0, Metric: "jaccard"}
+	return storefile.Write(storeName+"_minhash"+".store", header, mh.write)
+}
+
+// write encodes the index to writer.
+func (mh *MinHashLsh) write(writer *storefile.Writer) error {
+	var byteOrder = binary.LittleEndian
+
+	// Write parameters
+	if err := binary.Write(writer, byteOrder, mh.bands); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, byteOrder, mh.rows); err != nil {
+		return err
+	}
+	if err := writeString(writer, mh.shingler.Unit); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, byteOrder, int32(mh.shingler.K)); err != nil {
+		return err
+	}
+
+	// Write hash seeds
+	if err := binary.Write(writer, byteOrder, int32(len(mh.seeds))); err != nil {
+		return err
+	}
+	if err := binary.Write(writer, byteOrder, mh.seeds); err != nil {
+		return err
+	}
+	if err := writer.EndSection(); err != nil {
+		return err
+	}
+
+	// Write signatures, each prefixed by its key
+	if err := binary.Write(writer, byteOrder, int32(len(mh.signatures))); err != nil {
+		return err
+	}
+	for key, sig := range mh.signatures {
+		if err := writeString(writer, key); err != nil {
+			return err
+		}
+		if err := binary.Write(writer, byteOrder, sig); err != nil {
+			return err
+		}
+	}
+	return writer.EndSection()
+}
+
+// Load deserializes the MinHashLsh index from a file.
+// A missing file is an error wrapping fs.ErrNotExist, and no file is
+// created. An empty file leaves the index unchanged.
+func (mh *MinHashLsh) Load(storeName string) error {
+	file, err := os.Open(storeName + "_minhash" + ".store")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return nil
+	}
+	var byteOrder = binary.LittleEndian
+	reader, err := storefile.NewReader(file, info.Size(), "minhash", encodingVersion, nil)
+	if err != nil {
+		return err
+	}
+
+	// Read parameters
+	var bands, rows, k int32
+	if err := binary.Read(reader, byteOrder, &bands); err != nil {
+		return err
+	}
+	if err := binary.Read(reader, byteOrder, &rows); err != nil {
+		return err
+	}
+	unit, err := readString(reader)
+	if err != nil {
+		return err
+	}
+	if err := binary.Read(reader, byteOrder, &k); err != nil {
+		return err
+	}
+
+	// Read hash seeds
+	var numSeeds int32
+	if err := binary.Read(reader, byteOrder, &numSeeds); err != nil {
+		return err
+	}
+	if bands <= 0 || rows <= 0 || int64(numSeeds) != int64(bands)*int64(rows) {
+		return errors.New("invalid minhash parameters")
+	}
+	if err := storefile.CheckLen(reader, int64(numSeeds), 16); err != nil {
+		return fmt.Errorf("decoding seeds: %w", err)
+	}
+	seeds := make([][2]uint64, numSeeds)
+	if err := binary.Read(reader, byteOrder, seeds); err != nil {
+		return err
+	}
+	if err := reader.EndSection(); err != nil {
+		return err
+	}
+
+	// Read signatures
+	var numKeys int32
+	if err := binary.Read(reader, byteOrder, &numKeys); err != nil {
+		return err
+	}
+	// Each key is at least its length and its signature.
+	if err := storefile.CheckLen(reader, int64(numKeys), 4+8*int64(numSeeds)); err != nil {
+		return fmt.Errorf("decoding signatures: %w", err)
+	}
+
+	loaded := &MinHashLsh{
+		bands:      bands,
+		rows:       rows,
+		shingler:   Shingler{Unit: unit, K: int(k)},
+		seeds:      seeds,
+		tables:     make([]bandTable, bands),
+		signatures: make(map[string][]uint64, numKeys),
+	}
+	for i := range loaded.tables {
+		loaded.tables[i] = make(bandTable)
+	}
+	for i := int32(0); i < numKeys; i++ {
+		key, err := readString(reader)
+		if err != nil {
+			return err
+		}
+		sig := make([]uint64, numSeeds)
+		if err := binary.Read(reader, byteOrder, sig); err != nil {
+			return err
+		}
+		for b, hv := range loaded.bandKeys(sig) {
+			loaded.tables[b][hv] = append(loaded.tables[b][hv], key)
+		}
+		loaded.signatures[key] = sig
+	}
+	if err := reader.EndSection(); err != nil {
+		return err
+	}
+
+	*mh = *loaded
+	return nil
+}
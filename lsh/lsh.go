@@ -1,11 +1,13 @@
 package lsh
 
 import (
+	"fmt"
 	"math/rand"
 	"sort"
 	"strconv"
 	"sync"
 	"sync/atomic"
+	"vectorDb/distance"
 )
 
 // hyperplanes represents a collection of hyperplanes.
@@ -39,7 +41,7 @@ func newHyperplanes(d, s int32) hyperplanes {
 	for i := range d {
 		v := make([]float32, s) // Each hyperplane is a vector of 's' dimensions.
 		for j := range s {
-			n := rand.Float32() // Generate a random number from a normal (Gaussian) distribution.
+			n := float32(rand.NormFloat64()) // Generate a random number from a normal (Gaussian) distribution.
 			v[j] = n                // Assign this random number as a coordinate in the hyperplane vector.
 		}
 		hs[i] = v // Add the generated hyperplane vector to the set of hyperplanes.
@@ -48,22 +50,8 @@ func newHyperplanes(d, s int32) hyperplanes {
 }
 
 // DistanceFunc is a function type for calculating the distance between two vectors.
-// It takes two float64 slices (representing vectors) and returns a float64 (the distance).
-type DistanceFunc func(p1 []float32, p2 []float32) float32
-
-// euclideanDistSquare calculates the squared Euclidean distance between two vectors.
-// It's computationally cheaper than Euclidean distance and often sufficient for comparisons.
-func euclideanDistSquare(p1 []float32, p2 []float32) (sum float32) {
-	for i := range p1 {
-		d := p2[i] - p1[i] // Calculate the difference between corresponding coordinates.
-		sum += d * d       // Square the difference and add to the sum.
-	}
-	return sum
-}
-
-var dFuncMap = map[string]DistanceFunc{
-	"euclidean": euclideanDistSquare,
-}
+// The available functions are registered in the distance package.
+type DistanceFunc = distance.Func
 
 // hashTableBucket is a bucket in the hash table.
 // It's a slice of Points that hash to the same key.
@@ -97,10 +85,7 @@ func newSimhash(hs hyperplanes, e []float32) *simhash {
 func newSignature(hyperplanes hyperplanes, e []float32) signature {
 	sigarr := make([]uint8, len(hyperplanes)) // Initialize a slice to store the signature bits (0 or 1).
 	for hix, h := range hyperplanes {         // Iterate through each hyperplane.
-		var dp float32        // Initialize a variable to store the dot product.
-		for k, v := range e { // Calculate the dot product of the hyperplane and the input vector.
-			dp += h[k] * float32(v) // Multiply corresponding coordinates and sum them up.
-		}
+		dp := distance.Dot(e, h) // Calculate the dot product of the hyperplane and the input vector.
 		if dp >= 0 { // If the dot product is non-negative, the point is on one side of the hyperplane.
 			sigarr[hix] = uint8(1) // Assign 1 to the signature bit.
 		} else { // Otherwise, the point is on the other side.
@@ -144,6 +129,7 @@ type cosineLshParam struct {
 	hyperplanes [][]float32 // The set of randomly generated hyperplanes used for hashing.
 	h           int32         // Total number of hyperplanes (l * m).
 	dFunc       string      // Function to calculate the distance between vectors.
+	distance    DistanceFunc // The distance function registered under dFunc.
 }
 
 // NewLshParams initializes the LSH settings.
@@ -152,15 +138,71 @@ type cosineLshParam struct {
 // m: Number of hash functions per table.
 // h: Total number of hash functions.
 // hyperplanes: Pre-generated hyperplanes.
-func newCosineLshParam(dim, l, m, h int32, dFunc string, hyperplanes [][]float32) *cosineLshParam {
+func newCosineLshParam(dim, l, m, h int32, dFunc string, hyperplanes [][]float32) (*cosineLshParam, error) {
+	fn, ok := distance.Lookup(dFunc)
+	if !ok {
+		return nil, fmt.Errorf("unknown distance function %q", dFunc)
+	}
 	return &cosineLshParam{
 		dim:         dim,         // Set the dimensionality.
 		l:           l,           // Set the number of hash tables.
 		m:           m,           // Set the number of hash functions per table.
 		hyperplanes: hyperplanes, // Assign the generated hyperplanes.
 		h:           h,           // Set the total number of hyperplanes.
-		dFunc:       dFunc,       // Use squared Euclidean distance as the default distance function.
+		dFunc:       dFunc,       // Name of the distance function, euclidean if empty.
+		distance:    fn,
+	}, nil
+}
+
+// Len returns the number of points in the index.
+func (lsh *CosineLsh) Len() int {
+	n := 0
+	if len(lsh.tables) > 0 {
+		for _, bucket := range lsh.tables[0] {
+			n += len(bucket)
+		}
 	}
+	return n
+}
+
+// Each calls fn with the extra data and vector of every point in insertion
+// order, stopping at and returning the first error fn returns. The vector
+// must not be modified.
+func (lsh *CosineLsh) Each(fn func(extraData string, vector []float32) error) error {
+	if len(lsh.tables) == 0 {
+		return nil
+	}
+	points := make([]Point, 0, lsh.Len())
+	for _, bucket := range lsh.tables[0] {
+		points = append(points, bucket...)
+	}
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].ID < points[j].ID
+	})
+	for _, point := range points {
+		if err := fn(point.ExtraData, point.Vector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Dims returns the dimension of the hyperplanes of the index.
+func (lsh *CosineLsh) Dims() int {
+	return int(lsh.dim)
+}
+
+// Metric returns the name of the distance function of the index.
+func (lsh *CosineLsh) Metric() string {
+	return distance.Canonical(lsh.dFunc)
+}
+
+// prepare normalises the point to unit length if the distance function expects it.
+func (lsh *CosineLsh) prepare(point []float32) []float32 {
+	if distance.NeedsNormalization(lsh.dFunc) {
+		return distance.Normalize(point)
+	}
+	return point
 }
 
 
@@ -168,23 +210,29 @@ func newCosineLshParam(dim, l, m, h int32, dFunc string, hyperplanes [][]float32
 // dim is the number of dimensions of the input points.
 // l is the number of hash tables.
 // m is the number of hash values in each hash table (length of hash key for each table).
-func NewCosineLsh(dim, l, m int32, dfunc string) *CosineLsh {
+// It fails if dfunc names no registered distance function.
+func NewCosineLsh(dim, l, m int32, dfunc string) (*CosineLsh, error) {
 	h := m * l                            // Calculate the total number of hyperplanes needed.
 	hyperplanes := newHyperplanes(h, dim) // Generate 'h' hyperplanes of 'dim' dimensions.
 	tables := make([]hashTable, l)        // Create 'l' hash tables.
 	for i := range tables {
 		tables[i] = make(hashTable) // Initialize each hash table as an empty map.
 	}
-	return &CosineLsh{
-		cosineLshParam: newCosineLshParam(dim, l, m, h, dfunc, hyperplanes), // Initialize LSH parameters.
-		tables:         tables,                                              // Assign the created hash tables.
+	param, err := newCosineLshParam(dim, l, m, h, dfunc, hyperplanes) // Initialize LSH parameters.
+	if err != nil {
+		return nil, err
 	}
+	return &CosineLsh{
+		cosineLshParam: param,  // Assign the LSH parameters.
+		tables:         tables, // Assign the created hash tables.
+	}, nil
 }
 
 // Insert adds a new data point to the Cosine LSH index.
 // point is the data point (vector) to be inserted.
 // extraData is any additional data to be stored with the point.
 func (lsh *CosineLsh) Insert(point []float32, extraData string) {
+	point = lsh.prepare(point)
 	// Apply hash functions to generate hash keys for the point in each hash table.
 	hvs := lsh.toBasicHashTableKeys(lsh.hash(point))
 	// Insert the point into all hash tables.
@@ -209,6 +257,7 @@ func (lsh *CosineLsh) Insert(point []float32, extraData string) {
 // point is the data point (vector) to be removed.
 // extraData is any additional data which is stored with the point.
 func (lsh *CosineLsh) Delete(point []float32, extraData string) {
+	point = lsh.prepare(point)
 	// Apply hash functions to generate hash keys for the point in each hash table.
 	hvs := lsh.toBasicHashTableKeys(lsh.hash(point))
 	var wg sync.WaitGroup         // WaitGroup to manage concurrent insertions into hash tables.
@@ -244,6 +293,7 @@ func (lsh *CosineLsh) Delete(point []float32, extraData string) {
 
 
 func (lsh *CosineLsh) Lookup(point []float32, extraData string) (bool) {
+	point = lsh.prepare(point)
 	// Apply hash functions to generate hash keys for the point in each hash table.
 	hvs := lsh.toBasicHashTableKeys(lsh.hash(point))
 	for i := range lsh.tables { // Iterate through each hash table.
@@ -282,6 +332,7 @@ func vectorsEqual(a, b []float32) bool {
 // q is the query point (vector).
 // maxResult is the maximum number of results to return (if > 0, returns top 'maxResult' nearest neighbours).
 func (lsh *CosineLsh) Search(q []float32, maxResult int) []QueryResult {
+	q = lsh.prepare(q)
 	// Apply hash functions to the query point to get hash keys for each hash table.
 	hvs := lsh.toBasicHashTableKeys(lsh.hash(q))
 	// Keep track of points seen to avoid duplicates (across different hash tables).
@@ -299,7 +350,7 @@ func (lsh *CosineLsh) Search(q []float32, maxResult int) []QueryResult {
 
 	distances := make([]QueryResult, 0, len(seen)) // Create a slice to store QueryResults.
 	for _, value := range seen {                   // Iterate through the unique points found in the hash tables.
-		dist := dFuncMap[lsh.dFunc](q, value.Vector) // Calculate the distance between the query point and the candidate point.
+		dist := lsh.distance(q, value.Vector) // Calculate the distance between the query point and the candidate point.
 		queryResult := QueryResult{Distance: dist}     // Create a QueryResult struct.
 		queryResult.Point = value                      // Assign the Point to the QueryResult.
 		distances = append(distances, queryResult)     // Add the QueryResult to the slice.
@@ -315,6 +366,21 @@ func (lsh *CosineLsh) Search(q []float32, maxResult int) []QueryResult {
 	return distances // Return all QueryResults if maxResult is not specified or if there are fewer results.
 }
 
+// SearchRange returns the candidate points within radius of the query point,
+// nearest first. Distances are those of the index's distance function,
+// smaller meaning closer. maxResult caps the number of results if > 0.
+func (lsh *CosineLsh) SearchRange(q []float32, radius float32, maxResult int) []QueryResult {
+	candidates := lsh.Search(q, 0)
+	// Candidates are sorted, so the ones within radius come first.
+	n := sort.Search(len(candidates), func(i int) bool {
+		return candidates[i].Distance > radius
+	})
+	if maxResult > 0 && n > maxResult {
+		n = maxResult
+	}
+	return candidates[:n]
+}
+
 // toBasicHashTableKeys converts hashTableKey (slice of uint8) to uint64.
 // This is needed because Go maps use comparable keys, and slices are not directly comparable.
 // Converting the binary hash key (slice of 0s and 1s) to a uint64 allows it to be used as a map key.
@@ -0,0 +1,253 @@
+package lsh
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	// ShingleWord splits text into overlapping runs of k words.
+	ShingleWord = "word"
+	// ShingleChar splits text into overlapping runs of k characters.
+	ShingleChar = "char"
+)
+
+// Shingler turns text into the set of shingles that MinHash operates on.
+// Unit is either ShingleWord or ShingleChar and K is the shingle length.
+type Shingler struct {
+	Unit string
+	K    int
+}
+
+// Shingles returns the distinct shingles of text.
+// Text shorter than K units produces a single shingle holding the whole text.
+func (s Shingler) Shingles(text string) []string {
+	k := s.K
+	if k <= 0 {
+		k = 1
+	}
+	var units []string
+	sep := ""
+	switch s.Unit {
+	case ShingleWord:
+		units = strings.Fields(strings.ToLower(text))
+		sep = " "
+	default:
+		text = strings.Join(strings.Fields(strings.ToLower(text)), " ")
+		units = make([]string, 0, utf8.RuneCountInString(text))
+		for _, r := range text {
+			units = append(units, string(r))
+		}
+	}
+	if len(units) == 0 {
+		return nil
+	}
+	if len(units) <= k {
+		return []string{strings.Join(units, sep)}
+	}
+
+	seen := make(map[string]struct{}, len(units)-k+1)
+	shingles := make([]string, 0, len(units)-k+1)
+	for i := 0; i+k <= len(units); i++ {
+		shingle := strings.Join(units[i:i+k], sep)
+		if _, ok := seen[shingle]; ok {
+			continue
+		}
+		seen[shingle] = struct{}{}
+		shingles = append(shingles, shingle)
+	}
+	return shingles
+}
+
+// MinHashResult is a single MinHash query result.
+// Similarity is the estimated Jaccard similarity between the query set and Key's set.
+type MinHashResult struct {
+	Key        string
+	Similarity float32
+}
+
+// bandTable maps the hash of one band of a signature to the keys sharing it.
+type bandTable map[uint64][]string
+
+// MinHashLsh is a MinHash index with banding, used to find sets with a high
+// Jaccard similarity such as near-duplicate documents.
+// https://en.wikipedia.org/wiki/MinHash#Locality-sensitive_hashing
+//
+// Each signature has bands*rows hash values. Two sets become candidates when
+// every row of at least one band agrees, which happens with probability
+// 1-(1-s^rows)^bands for Jaccard similarity s.
+type MinHashLsh struct {
+	bands    int32
+	rows     int32
+	shingler Shingler
+	// seeds holds the (a, b) multipliers of each of the bands*rows hash functions.
+	seeds      [][2]uint64
+	tables     []bandTable
+	signatures map[string][]uint64
+}
+
+// NewMinHashLsh creates an empty MinHash index.
+// bands is the number of band tables.
+// rows is the number of hash values in each band.
+// shingler is used by InsertText and SearchText to turn text into token sets.
+func NewMinHashLsh(bands, rows int32, shingler Shingler) *MinHashLsh {
+	seeds := make([][2]uint64, bands*rows)
+	for i := range seeds {
+		seeds[i] = [2]uint64{rand.Uint64() | 1, rand.Uint64()}
+	}
+	tables := make([]bandTable, bands)
+	for i := range tables {
+		tables[i] = make(bandTable)
+	}
+	return &MinHashLsh{
+		bands:      bands,
+		rows:       rows,
+		shingler:   shingler,
+		seeds:      seeds,
+		tables:     tables,
+		signatures: make(map[string][]uint64),
+	}
+}
+
+// Len returns the number of keys in the index.
+func (mh *MinHashLsh) Len() int {
+	return len(mh.signatures)
+}
+
+// signature computes the MinHash signature of a token set.
+func (mh *MinHashLsh) signature(tokens []string) []uint64 {
+	sig := make([]uint64, len(mh.seeds))
+	for i := range sig {
+		sig[i] = math.MaxUint64
+	}
+	for _, token := range tokens {
+		h := fnv.New64a()
+		h.Write([]byte(token))
+		x := h.Sum64()
+		for i, seed := range mh.seeds {
+			// Multiply-add hashing modulo 2^64 gives a cheap family of
+			// independent permutations of the token hash.
+			if v := seed[0]*x + seed[1]; v < sig[i] {
+				sig[i] = v
+			}
+		}
+	}
+	return sig
+}
+
+// bandKeys folds each band of the signature into a single table key.
+func (mh *MinHashLsh) bandKeys(sig []uint64) []uint64 {
+	keys := make([]uint64, mh.bands)
+	var buf [8]byte
+	for b := range keys {
+		h := fnv.New64a()
+		for _, v := range sig[int32(b)*mh.rows : int32(b+1)*mh.rows] {
+			binary.LittleEndian.PutUint64(buf[:], v)
+			h.Write(buf[:])
+		}
+		keys[b] = h.Sum64()
+	}
+	return keys
+}
+
+// Insert adds the token set under key.
+// If key is already present its previous set is replaced.
+func (mh *MinHashLsh) Insert(tokens []string, key string) {
+	mh.Delete(key)
+	sig := mh.signature(tokens)
+	for b, hv := range mh.bandKeys(sig) {
+		mh.tables[b][hv] = append(mh.tables[b][hv], key)
+	}
+	mh.signatures[key] = sig
+}
+
+// InsertText shingles text and adds the resulting set under key.
+func (mh *MinHashLsh) InsertText(text string, key string) {
+	mh.Insert(mh.shingler.Shingles(text), key)
+}
+
+// Delete removes key from the index and reports whether it was present.
+func (mh *MinHashLsh) Delete(key string) bool {
+	sig, ok := mh.signatures[key]
+	if !ok {
+		return false
+	}
+	for b, hv := range mh.bandKeys(sig) {
+		bucket := mh.tables[b][hv]
+		for i, k := range bucket {
+			if k == key {
+				bucket = append(bucket[:i], bucket[i+1:]...)
+				break
+			}
+		}
+		if len(bucket) == 0 {
+			delete(mh.tables[b], hv)
+		} else {
+			mh.tables[b][hv] = bucket
+		}
+	}
+	delete(mh.signatures, key)
+	return true
+}
+
+// Lookup reports whether key is present in the index.
+func (mh *MinHashLsh) Lookup(key string) bool {
+	_, ok := mh.signatures[key]
+	return ok
+}
+
+// Search returns the keys sharing at least one band with the token set,
+// ordered by estimated Jaccard similarity, most similar first.
+// maxResult limits the number of results if it is greater than 0.
+func (mh *MinHashLsh) Search(tokens []string, maxResult int) []MinHashResult {
+	sig := mh.signature(tokens)
+	seen := make(map[string]struct{})
+	results := make([]MinHashResult, 0)
+	for b, hv := range mh.bandKeys(sig) {
+		for _, key := range mh.tables[b][hv] {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			results = append(results, MinHashResult{
+				Key:        key,
+				Similarity: estimateJaccard(sig, mh.signatures[key]),
+			})
+		}
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Similarity != results[j].Similarity {
+			return results[i].Similarity > results[j].Similarity
+		}
+		return results[i].Key < results[j].Key
+	})
+	if maxResult > 0 && len(results) > maxResult {
+		return results[:maxResult]
+	}
+	return results
+}
+
+// SearchText shingles text and searches for similar sets.
+func (mh *MinHashLsh) SearchText(text string, maxResult int) []MinHashResult {
+	return mh.Search(mh.shingler.Shingles(text), maxResult)
+}
+
+// estimateJaccard returns the fraction of signature positions that agree,
+// an unbiased estimate of the Jaccard similarity of the underlying sets.
+func estimateJaccard(a, b []uint64) float32 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	equal := 0
+	for i := range a {
+		if a[i] == b[i] {
+			equal++
+		}
+	}
+	return float32(equal) / float32(len(a))
+}
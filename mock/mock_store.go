@@ -11,6 +11,7 @@ package mock
 
 import (
 	reflect "reflect"
+	store "vectorDb/store"
 
 	gomock "go.uber.org/mock/gomock"
 )
@@ -39,6 +40,20 @@ func (m *MockStore) EXPECT() *MockStoreMockRecorder {
 	return m.recorder
 }
 
+// CreateCollection mocks base method.
+func (m *MockStore) CreateCollection(storeName string, config store.CollectionConfig) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateCollection", storeName, config)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateCollection indicates an expected call of CreateCollection.
+func (mr *MockStoreMockRecorder) CreateCollection(storeName, config any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCollection", reflect.TypeOf((*MockStore)(nil).CreateCollection), storeName, config)
+}
+
 // Delete mocks base method.
 func (m *MockStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
 	m.ctrl.T.Helper()
@@ -54,6 +69,35 @@ func (mr *MockStoreMockRecorder) Delete(storeName, embedding, key any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockStore)(nil).Delete), storeName, embedding, key)
 }
 
+// DescribeCollection mocks base method.
+func (m *MockStore) DescribeCollection(storeName string) (store.CollectionInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DescribeCollection", storeName)
+	ret0, _ := ret[0].(store.CollectionInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// DescribeCollection indicates an expected call of DescribeCollection.
+func (mr *MockStoreMockRecorder) DescribeCollection(storeName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DescribeCollection", reflect.TypeOf((*MockStore)(nil).DescribeCollection), storeName)
+}
+
+// DropCollection mocks base method.
+func (m *MockStore) DropCollection(storeName string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DropCollection", storeName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DropCollection indicates an expected call of DropCollection.
+func (mr *MockStoreMockRecorder) DropCollection(storeName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DropCollection", reflect.TypeOf((*MockStore)(nil).DropCollection), storeName)
+}
+
 // Insert mocks base method.
 func (m *MockStore) Insert(storeName string, embedding []float32, key string) error {
 	m.ctrl.T.Helper()
@@ -68,6 +112,20 @@ func (mr *MockStoreMockRecorder) Insert(storeName, embedding, key any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockStore)(nil).Insert), storeName, embedding, key)
 }
 
+// ListCollections mocks base method.
+func (m *MockStore) ListCollections() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListCollections")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// ListCollections indicates an expected call of ListCollections.
+func (mr *MockStoreMockRecorder) ListCollections() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListCollections", reflect.TypeOf((*MockStore)(nil).ListCollections))
+}
+
 // Load mocks base method.
 func (m *MockStore) Load(storeName string) error {
 	m.ctrl.T.Helper()
@@ -97,6 +155,20 @@ func (mr *MockStoreMockRecorder) Lookup(storeName, embedding, key any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Lookup", reflect.TypeOf((*MockStore)(nil).Lookup), storeName, embedding, key)
 }
 
+// RenameCollection mocks base method.
+func (m *MockStore) RenameCollection(oldName, newName string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RenameCollection", oldName, newName)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameCollection indicates an expected call of RenameCollection.
+func (mr *MockStoreMockRecorder) RenameCollection(oldName, newName any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameCollection", reflect.TypeOf((*MockStore)(nil).RenameCollection), oldName, newName)
+}
+
 // Save mocks base method.
 func (m *MockStore) Save(storeName string) error {
 	m.ctrl.T.Helper()
@@ -125,3 +197,18 @@ func (mr *MockStoreMockRecorder) Search(storeName, query, limit any) *gomock.Cal
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Search", reflect.TypeOf((*MockStore)(nil).Search), storeName, query, limit)
 }
+
+// SearchRange mocks base method.
+func (m *MockStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchRange", storeName, query, radius, maxResults)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchRange indicates an expected call of SearchRange.
+func (mr *MockStoreMockRecorder) SearchRange(storeName, query, radius, maxResults any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchRange", reflect.TypeOf((*MockStore)(nil).SearchRange), storeName, query, radius, maxResults)
+}
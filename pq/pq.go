@@ -0,0 +1,241 @@
+// Package pq implements product quantization.
+//
+// A vector is split into M contiguous sub-vectors, and each sub-vector is
+// replaced by the index of its nearest centroid in that subspace's codebook.
+// With 256 centroids per codebook a vector of any dimension is stored in M
+// bytes. Distances between a query and encoded vectors are computed with
+// asymmetric distance computation (ADC): the query stays in full precision
+// and the distance from each of its sub-vectors to every centroid is looked
+// up from a table built once per query.
+package pq
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"vectorDb/distance"
+	"vectorDb/kmeans"
+)
+
+// MaxCentroids is the largest codebook size, so that a code fits in a byte.
+const MaxCentroids = 256
+
+var byteOrder = binary.LittleEndian
+
+// Quantizer is a product quantizer with trainable sub-codebooks.
+type Quantizer struct {
+	dim  int
+	m    int
+	ksub int
+	// inner selects negated inner-product tables instead of squared L2 tables.
+	inner bool
+	// codebooks[s] holds the ksub centroids of subspace s back to back,
+	// each of dim/m values.
+	codebooks [][]float32
+}
+
+// NewQuantizer creates an untrained quantizer for vectors of dim dimensions.
+// m is the number of subspaces and must divide dim. ksub is the number of
+// centroids per subspace, at most MaxCentroids. distanceFunc selects the
+// distance that tables compute: inner product metrics sum negated sub-vector
+// inner products, so that like every distance smaller is closer; any other
+// name uses squared Euclidean distance.
+func NewQuantizer(dim, m, ksub int, distanceFunc string) (*Quantizer, error) {
+	if dim <= 0 || m <= 0 || dim%m != 0 {
+		return nil, fmt.Errorf("number of subspaces %d must divide the dimension %d", m, dim)
+	}
+	if ksub <= 0 || ksub > MaxCentroids {
+		return nil, fmt.Errorf("number of centroids %d must be between 1 and %d", ksub, MaxCentroids)
+	}
+	return &Quantizer{dim: dim, m: m, ksub: ksub, inner: distance.IsInnerProduct(distanceFunc)}, nil
+}
+
+// Dims returns the dimension of the vectors the quantizer encodes.
+func (q *Quantizer) Dims() int {
+	return q.dim
+}
+
+// CodeSize returns the number of bytes in a code.
+func (q *Quantizer) CodeSize() int {
+	return q.m
+}
+
+// Trained reports whether the codebooks have been trained.
+func (q *Quantizer) Trained() bool {
+	return len(q.codebooks) == q.m
+}
+
+func (q *Quantizer) dsub() int {
+	return q.dim / q.m
+}
+
+func (q *Quantizer) centroid(s, c int) []float32 {
+	d := q.dsub()
+	return q.codebooks[s][c*d : (c+1)*d : (c+1)*d]
+}
+
+// Train learns one codebook per subspace by running k-means on the
+// corresponding sub-vectors of the sample.
+func (q *Quantizer) Train(sample [][]float32, rng *rand.Rand) error {
+	if len(sample) == 0 {
+		return errors.New("cannot train on an empty sample")
+	}
+	for _, v := range sample {
+		if len(v) != q.dim {
+			return fmt.Errorf("embedding dimension mismatch: %d != %d", q.dim, len(v))
+		}
+	}
+	d := q.dsub()
+	ksub := min(q.ksub, len(sample))
+	codebooks := make([][]float32, q.m)
+	sub := make([][]float32, len(sample))
+	for s := range q.m {
+		for i, v := range sample {
+			sub[i] = v[s*d : (s+1)*d]
+		}
+		centroids := kmeans.Train(sub, ksub, distance.SquareDistance, rng)
+		codebooks[s] = make([]float32, 0, q.ksub*d)
+		for _, c := range centroids {
+			codebooks[s] = append(codebooks[s], c...)
+		}
+		// Pad small samples so every code value decodes to something.
+		for len(codebooks[s]) < q.ksub*d {
+			codebooks[s] = append(codebooks[s], centroids[0]...)
+		}
+	}
+	q.codebooks = codebooks
+	return nil
+}
+
+// Encode returns the code of v: the nearest centroid of each sub-vector.
+func (q *Quantizer) Encode(v []float32) []byte {
+	d := q.dsub()
+	code := make([]byte, q.m)
+	for s := range q.m {
+		sub := v[s*d : (s+1)*d]
+		best, bestDist := 0, float32(math.Inf(1))
+		for c := range q.ksub {
+			if dist := distance.SquareDistance(sub, q.centroid(s, c)); dist < bestDist {
+				best, bestDist = c, dist
+			}
+		}
+		code[s] = byte(best)
+	}
+	return code
+}
+
+// Decode returns the approximation of the vector a code stands for.
+func (q *Quantizer) Decode(code []byte) []float32 {
+	v := make([]float32, 0, q.dim)
+	for s, c := range code {
+		v = append(v, q.centroid(s, int(c))...)
+	}
+	return v
+}
+
+// DistanceTable holds the distances from each sub-vector of a query to every
+// centroid of the matching codebook.
+type DistanceTable struct {
+	ksub   int
+	values []float32
+}
+
+// DistanceTable builds the ADC lookup table for a query.
+func (q *Quantizer) DistanceTable(query []float32) *DistanceTable {
+	d := q.dsub()
+	t := &DistanceTable{ksub: q.ksub, values: make([]float32, q.m*q.ksub)}
+	for s := range q.m {
+		sub := query[s*d : (s+1)*d]
+		for c := range q.ksub {
+			if q.inner {
+				t.values[s*q.ksub+c] = -distance.Dot(sub, q.centroid(s, c))
+			} else {
+				t.values[s*q.ksub+c] = distance.SquareDistance(sub, q.centroid(s, c))
+			}
+		}
+	}
+	return t
+}
+
+// Distance returns the approximate distance between the table's query and
+// the vector encoded by code, as a sum of table lookups.
+func (t *DistanceTable) Distance(code []byte) float32 {
+	var sum float32
+	for s, c := range code {
+		sum += t.values[s*t.ksub+int(c)]
+	}
+	return sum
+}
+
+// CodeDistance returns the approximate distance between the vectors two
+// codes stand for, on the scale of DistanceTable: the distance between
+// their decoded forms, computed subspace by subspace.
+func (q *Quantizer) CodeDistance(a, b []byte) float32 {
+	var sum float32
+	for s := range a {
+		ca, cb := q.centroid(s, int(a[s])), q.centroid(s, int(b[s]))
+		if q.inner {
+			sum -= distance.Dot(ca, cb)
+		} else {
+			sum += distance.SquareDistance(ca, cb)
+		}
+	}
+	return sum
+}
+
+// WriteTo writes the quantizer's parameters and codebooks.
+func (q *Quantizer) WriteTo(w io.Writer) (int64, error) {
+	inner := uint8(0)
+	if q.inner {
+		inner = 1
+	}
+	header := []int32{int32(q.dim), int32(q.m), int32(q.ksub), int32(len(q.codebooks))}
+	if err := binary.Write(w, byteOrder, header); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(w, byteOrder, inner); err != nil {
+		return 0, err
+	}
+	n := int64(binary.Size(header) + 1)
+	for _, codebook := range q.codebooks {
+		if err := binary.Write(w, byteOrder, codebook); err != nil {
+			return n, err
+		}
+		n += int64(binary.Size(codebook))
+	}
+	return n, nil
+}
+
+// ReadFrom reads a quantizer written by WriteTo.
+func (q *Quantizer) ReadFrom(r io.Reader) (int64, error) {
+	header := make([]int32, 4)
+	var inner uint8
+	if err := binary.Read(r, byteOrder, header); err != nil {
+		return 0, err
+	}
+	if err := binary.Read(r, byteOrder, &inner); err != nil {
+		return 0, err
+	}
+	n := int64(binary.Size(header) + 1)
+	dim, m, ksub, trained := int(header[0]), int(header[1]), int(header[2]), int(header[3])
+	if dim <= 0 || m <= 0 || dim%m != 0 || ksub <= 0 || ksub > MaxCentroids || (trained != 0 && trained != m) {
+		return n, errors.New("invalid product quantizer header")
+	}
+	// Guard against a corrupt header before allocating the codebooks.
+	if l, ok := r.(interface{ Len() int }); ok && int64(trained)*int64(ksub)*int64(dim/m) > int64(l.Len())/4 {
+		return n, io.ErrUnexpectedEOF
+	}
+	codebooks := make([][]float32, trained)
+	for s := range codebooks {
+		codebooks[s] = make([]float32, ksub*dim/m)
+		if err := binary.Read(r, byteOrder, codebooks[s]); err != nil {
+			return n, err
+		}
+		n += int64(binary.Size(codebooks[s]))
+	}
+	*q = Quantizer{dim: dim, m: m, ksub: ksub, inner: inner == 1, codebooks: codebooks}
+	return n, nil
+}
@@ -0,0 +1,182 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+)
+
+// ErrCollectionNotFound is returned by the operations on a collection that
+// the store does not hold. Only Insert and Load create a missing collection,
+// with the store's default configuration.
+var ErrCollectionNotFound = errors.New("collection not found")
+
+// ErrCollectionExists is returned when creating or renaming a collection
+// over one that already exists.
+var ErrCollectionExists = errors.New("collection already exists")
+
+// CollectionConfig is the schema of a collection made by CreateCollection.
+// Zero fields keep the store's defaults.
+type CollectionConfig struct {
+	// Metric names the distance function, see the distance package. Stores
+	// that index text ignore it.
+	Metric string
+	// Dim, when not zero, is the dimension every inserted vector must have.
+	// Otherwise the first insert fixes it.
+	Dim int
+	// Params holds the parameters of the index by name, such as m for
+	// hnsw; each store documents those it takes and rejects the others.
+	Params map[string]string
+}
+
+// CollectionInfo describes a collection of a store.
+type CollectionInfo struct {
+	Name   string
+	Metric string
+	// Dim is the dimension of the vectors, or 0 while there are none and
+	// the collection was not created with one.
+	Dim int
+	// Len is the number of vectors in the collection.
+	Len int
+	// Params holds the parameters the collection was created with.
+	Params map[string]string
+}
+
+// notFound returns ErrCollectionNotFound for the named collection.
+func notFound(storeName string) error {
+	return fmt.Errorf("%w: %s", ErrCollectionNotFound, storeName)
+}
+
+// collection returns the named collection of a store, or
+// ErrCollectionNotFound.
+func collection[T any](collections map[string]T, storeName string) (T, error) {
+	index, present := collections[storeName]
+	if !present {
+		return index, notFound(storeName)
+	}
+	return index, nil
+}
+
+// listCollections returns the names of the collections of a store, sorted.
+func listCollections[T any](collections map[string]T) []string {
+	return slices.Sorted(maps.Keys(collections))
+}
+
+// describable is implemented by the indexes of the collections.
+type describable interface {
+	Len() int
+	Dims() int
+	Metric() string
+}
+
+// describeCollection describes the named collection of a store.
+func describeCollection[T describable](collections map[string]T, configs configs, storeName string) (CollectionInfo, error) {
+	index, err := collection(collections, storeName)
+	if err != nil {
+		return CollectionInfo{}, err
+	}
+	info := CollectionInfo{
+		Name:   storeName,
+		Metric: index.Metric(),
+		Dim:    index.Dims(),
+		Len:    index.Len(),
+		Params: configs[storeName].Params,
+	}
+	if info.Dim == 0 {
+		info.Dim = configs[storeName].Dim
+	}
+	return info, nil
+}
+
+// dropCollection removes the named collection of a store from memory and
+// disk: its store files, their snapshots and its write-ahead log.
+func dropCollection[T any](collections map[string]T, configs configs, logs *logs, storeName string) error {
+	if _, present := collections[storeName]; !present {
+		return notFound(storeName)
+	}
+	if err := logs.close(storeName); err != nil {
+		return err
+	}
+	delete(collections, storeName)
+	delete(configs, storeName)
+	return RemoveFiles(storeName, storeSuffixes(logs.suffix))
+}
+
+// renameCollection renames the named collection of a store and its files.
+func renameCollection[T any](collections map[string]T, configs configs, logs *logs, oldName, newName string) error {
+	index, present := collections[oldName]
+	if !present {
+		return notFound(oldName)
+	}
+	if _, present := collections[newName]; present {
+		return fmt.Errorf("%w: %s", ErrCollectionExists, newName)
+	}
+	if err := logs.close(oldName); err != nil {
+		return err
+	}
+	if err := RenameFiles(oldName, newName, storeSuffixes(logs.suffix)); err != nil {
+		return err
+	}
+	delete(collections, oldName)
+	collections[newName] = index
+	if config, ok := configs[oldName]; ok {
+		delete(configs, oldName)
+		configs[newName] = config
+	}
+	return nil
+}
+
+// configs holds the configurations the collections of a store were created
+// with by CreateCollection.
+type configs map[string]CollectionConfig
+
+// canCreate returns an error unless a collection can be created with
+// config, present telling whether one of that name exists.
+func canCreate(storeName string, present bool, config CollectionConfig) error {
+	if present {
+		return fmt.Errorf("%w: %s", ErrCollectionExists, storeName)
+	}
+	if config.Dim < 0 {
+		return fmt.Errorf("invalid dimension %d", config.Dim)
+	}
+	return nil
+}
+
+// checkDim returns an error if the collection was created with a dimension
+// other than that of embedding.
+func (c configs) checkDim(storeName string, embedding []float32) error {
+	if dim := c[storeName].Dim; dim != 0 && len(embedding) != dim {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", dim, len(embedding))
+	}
+	return nil
+}
+
+// parseParams sets the fields named in params, pointers to ints or
+// float32s, from their values. It fails on names not in fields.
+func parseParams(params map[string]string, fields map[string]any) error {
+	for _, name := range slices.Sorted(maps.Keys(params)) {
+		field, ok := fields[name]
+		if !ok {
+			return fmt.Errorf("unknown parameter %q", name)
+		}
+		var err error
+		switch field := field.(type) {
+		case *int:
+			*field, err = strconv.Atoi(params[name])
+		case *int32:
+			var v int64
+			v, err = strconv.ParseInt(params[name], 10, 32)
+			*field = int32(v)
+		case *float32:
+			var v float64
+			v, err = strconv.ParseFloat(params[name], 32)
+			*field = float32(v)
+		}
+		if err != nil {
+			return fmt.Errorf("invalid parameter %s: %w", name, err)
+		}
+	}
+	return nil
+}
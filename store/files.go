@@ -0,0 +1,91 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+	"vectorDb/storefile"
+)
+
+// FileSuffixes lists the suffixes the stores append to a collection's
+// storeName to name its files, <storeName><suffix>.store for the saved
+// index and <storeName><suffix>.wal for the write-ahead log.
+var FileSuffixes = []string{"_hnsw", "_hnswmap", "_lsh", "_minhash", "_flat", "_flatmap", "_ivf", "_ivfpq", "_vamana"}
+
+// Suffixes returns the suffixes of the files of a collection of the named
+// store type, as given to the use command: that of the store, and that of
+// the mapped layout it can save the collection in.
+func Suffixes(typ string) []string {
+	return storeSuffixes("_" + strings.TrimSuffix(typ, "-mapped"))
+}
+
+// storeSuffixes returns the suffixes of the files of a collection of the
+// store that names its files with suffix.
+func storeSuffixes(suffix string) []string {
+	switch suffix {
+	case "_hnsw", "_flat":
+		return []string{suffix, suffix + "map"}
+	}
+	return []string{suffix}
+}
+
+// RemoveFiles removes the files of the collection saved under storeName
+// with the given suffixes: its store files, their snapshots and its
+// write-ahead logs. The files of collections of other types sharing the
+// name are left alone. Stores holding the collection in memory still do,
+// and would write it back on Save.
+func RemoveFiles(storeName string, suffixes []string) error {
+	var paths []string
+	for _, suffix := range suffixes {
+		path := storeName + suffix + ".store"
+		paths = append(paths, path, storeName+suffix+".wal")
+		paths = append(paths, storefile.Snapshots(path)...)
+	}
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RenameFiles renames the files of the collection saved under oldName with
+// the given suffixes to the names of newName. It fails with
+// ErrCollectionExists if newName has files already. If a rename fails, the
+// files already renamed are renamed back.
+func RenameFiles(oldName, newName string, suffixes []string) error {
+	type rename struct{ from, to string }
+	var renames []rename
+	for _, suffix := range suffixes {
+		for _, ext := range []string{".store", ".wal"} {
+			if _, err := os.Stat(newName + suffix + ext); err == nil {
+				return fmt.Errorf("%w: %s", ErrCollectionExists, newName)
+			}
+			renames = append(renames, rename{oldName + suffix + ext, newName + suffix + ext})
+		}
+		path := oldName + suffix + ".store"
+		for n := range len(storefile.Snapshots(path)) {
+			renames = append(renames, rename{storefile.Snapshot(path, n+1), storefile.Snapshot(newName+suffix+".store", n+1)})
+		}
+	}
+	var done []rename
+	for _, r := range renames {
+		err := os.Rename(r.from, r.to)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			for _, r := range slices.Backward(done) {
+				if undoErr := os.Rename(r.to, r.from); undoErr != nil {
+					return errors.Join(err, undoErr)
+				}
+			}
+			return err
+		}
+		done = append(done, r)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"vectorDb/flat"
+	"vectorDb/wal"
+)
+
+type FlatStore struct {
+	store   map[string]*flat.FlatIndex
+	configs configs
+	logs    logs
+}
+
+func NewFlatStore() (Store, error) {
+	flatStore := &FlatStore{
+		store:   map[string]*flat.FlatIndex{},
+		configs: configs{},
+		logs:    newLogs("_flat"),
+	}
+	return flatStore, nil
+}
+
+func (flatStore *FlatStore) initialize(storeName string) {
+	flatStore.store[storeName] = flatStore.orDefault(storeName)
+}
+
+// orDefault returns the collection storeName, or a new one with the
+// default parameters, not yet added to the store, if there is none.
+func (flatStore *FlatStore) orDefault(storeName string) *flat.FlatIndex {
+	index, present := flatStore.store[storeName]
+	if !present {
+		// The default distance function is always registered.
+		index, _ = flat.NewFlatIndex("")
+	}
+	return index
+}
+
+// CreateCollection creates an empty collection. Flat indexes take no
+// parameters.
+func (flatStore *FlatStore) CreateCollection(storeName string, config CollectionConfig) error {
+	_, present := flatStore.store[storeName]
+	if err := canCreate(storeName, present, config); err != nil {
+		return err
+	}
+	if err := parseParams(config.Params, nil); err != nil {
+		return err
+	}
+	index, err := flat.NewFlatIndex(config.Metric)
+	if err != nil {
+		return err
+	}
+	flatStore.store[storeName] = index
+	flatStore.configs[storeName] = config
+	return nil
+}
+
+func (flatStore *FlatStore) ListCollections() []string {
+	return listCollections(flatStore.store)
+}
+
+func (flatStore *FlatStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	return describeCollection(flatStore.store, flatStore.configs, storeName)
+}
+
+func (flatStore *FlatStore) DropCollection(storeName string) error {
+	return dropCollection(flatStore.store, flatStore.configs, &flatStore.logs, storeName)
+}
+
+func (flatStore *FlatStore) RenameCollection(oldName string, newName string) error {
+	return renameCollection(flatStore.store, flatStore.configs, &flatStore.logs, oldName, newName)
+}
+
+func (flatStore *FlatStore) Search(storeName string, query []float32, limit int) ([]string, error) {
+	index, err := collection(flatStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results, nil
+}
+
+// SearchRange returns the keys within radius of the query, nearest first.
+func (flatStore *FlatStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	index, err := collection(flatStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.SearchRange(query, radius, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results, nil
+}
+
+func (flatStore *FlatStore) Insert(storeName string, embedding []float32, key string) error {
+	flatStore.initialize(storeName)
+	if err := flatStore.configs.checkDim(storeName, embedding); err != nil {
+		return err
+	}
+	// Check the dimension before logging, so that the log only holds
+	// inserts the index accepts.
+	if index := flatStore.store[storeName]; index.Len() > 0 && len(embedding) != index.Dims() {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", index.Dims(), len(embedding))
+	}
+	if err := flatStore.logs.append(storeName, insertRecord(key, embedding)); err != nil {
+		return err
+	}
+	return flatStore.store[storeName].Insert(key, embedding)
+}
+
+func (flatStore *FlatStore) Lookup(storeName string, embedding []float32, key string) ([]float32, error) {
+	index, err := collection(flatStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	embeddingFound, present := index.Lookup(key)
+	if !present {
+		return nil, errors.New("key not present in the database")
+	}
+	return embeddingFound, nil
+}
+
+// Export passes every key of the collection and its vector to fn.
+func (flatStore *FlatStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	index, err := collection(flatStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.Each(fn)
+}
+
+func (flatStore *FlatStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
+	index, err := collection(flatStore.store, storeName)
+	if err != nil {
+		return false, err
+	}
+	if err := flatStore.logs.append(storeName, deleteRecord(key, embedding)); err != nil {
+		return false, err
+	}
+	deleted := index.Delete(key)
+	return deleted, nil
+}
+
+func (flatStore *FlatStore) Load(storeName string) error {
+	index := flatStore.orDefault(storeName)
+	err := index.Load(storeName)
+	if err != nil {
+		return err
+	}
+	flatStore.store[storeName] = index
+	return flatStore.logs.replay(storeName, func(record wal.Record) {
+		flatStore.apply(storeName, record)
+	})
+}
+
+func (flatStore *FlatStore) Save(storeName string) error {
+	index, err := collection(flatStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	err = index.Save(storeName)
+	if err != nil {
+		return err
+	}
+	return flatStore.logs.checkpoint(storeName)
+}
+
+// apply replays a logged mutation on the collection. Errors were already
+// returned when the mutation was made, so they are ignored.
+func (flatStore *FlatStore) apply(storeName string, record wal.Record) {
+	switch record.Op {
+	case wal.OpInsert:
+		flatStore.store[storeName].Insert(record.Key, record.Embedding)
+	case wal.OpDelete:
+		flatStore.store[storeName].Delete(record.Key)
+	}
+}
+
+// SaveMapped saves the collection in the layout of MappedFlatStore.
+func (flatStore *FlatStore) SaveMapped(storeName string) error {
+	index, err := collection(flatStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.SaveMapped(storeName)
+}
@@ -2,30 +2,100 @@ package store
 
 import (
 	"fmt"
+	"vectorDb/distance"
 	"vectorDb/hnsw"
+	"vectorDb/wal"
 )
 
 type HnswStore struct {
-	store map[string]*hnsw.HNSWGraph[string]
+	store   map[string]*hnsw.HNSWGraph[string]
+	configs configs
+	logs    logs
 }
 
 func NewHnswStore() (Store, error) {
 	hnswStore := &HnswStore{
-		store: map[string]*hnsw.HNSWGraph[string]{},
+		store:   map[string]*hnsw.HNSWGraph[string]{},
+		configs: configs{},
+		logs:    newLogs("_hnsw"),
 	}
 
 	return hnswStore, nil
 }
 
-func (hnswStore *HnswStore) initialize(storeName string){
-	_,present:=hnswStore.store[storeName]
-	if !present{
-		hnswStore.store[storeName]=hnsw.NewHNSWGraph[string]("")
+func (hnswStore *HnswStore) initialize(storeName string) {
+	hnswStore.store[storeName] = hnswStore.orDefault(storeName)
+}
+
+// orDefault returns the collection storeName, or a new one with the
+// default parameters, not yet added to the store, if there is none.
+func (hnswStore *HnswStore) orDefault(storeName string) *hnsw.HNSWGraph[string] {
+	graph, present := hnswStore.store[storeName]
+	if !present {
+		graph = hnsw.NewHNSWGraph[string]("")
+	}
+	return graph
+}
+
+// CreateCollection creates an empty collection. It takes the parameters
+// m, the maximum number of neighbours per node, efConstruction, the number
+// of candidates considered on insert, and efSearch, the default number
+// considered on search; left out or zero, they keep the defaults of
+// hnsw.NewHNSWGraph. Loading a saved collection afterwards replaces them
+// with the saved ones.
+func (hnswStore *HnswStore) CreateCollection(storeName string, config CollectionConfig) error {
+	_, present := hnswStore.store[storeName]
+	if err := canCreate(storeName, present, config); err != nil {
+		return err
+	}
+	if _, ok := distance.Lookup(config.Metric); !ok {
+		return fmt.Errorf("unknown distance function %q", config.Metric)
+	}
+	var m, efConstruction, efSearch int
+	fields := map[string]any{"m": &m, "efConstruction": &efConstruction, "efSearch": &efSearch}
+	if err := parseParams(config.Params, fields); err != nil {
+		return err
+	}
+	if m < 0 || efConstruction < 0 || efSearch < 0 {
+		return fmt.Errorf("hnsw parameters must not be negative")
+	}
+	graph := hnsw.NewHNSWGraph[string](config.Metric)
+	if m > 0 {
+		graph.M = m
 	}
+	if efConstruction > 0 {
+		graph.EfConstruction = efConstruction
+	}
+	if efSearch > 0 {
+		graph.EfSearch = efSearch
+	}
+	hnswStore.store[storeName] = graph
+	hnswStore.configs[storeName] = config
+	return nil
+}
+
+func (hnswStore *HnswStore) ListCollections() []string {
+	return listCollections(hnswStore.store)
+}
+
+func (hnswStore *HnswStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	return describeCollection(hnswStore.store, hnswStore.configs, storeName)
+}
+
+func (hnswStore *HnswStore) DropCollection(storeName string) error {
+	return dropCollection(hnswStore.store, hnswStore.configs, &hnswStore.logs, storeName)
+}
+
+func (hnswStore *HnswStore) RenameCollection(oldName string, newName string) error {
+	return renameCollection(hnswStore.store, hnswStore.configs, &hnswStore.logs, oldName, newName)
 }
+
 func (hnswStore *HnswStore) Search(storeName string,query []float32, limit int) ([]string,error) {
-	hnswStore.initialize(storeName)
-	neighborNodes:=hnswStore.store[storeName].Search(query,limit)
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	neighborNodes:=graph.Search(query,limit)
 	neighbors:=make([]string,0)
 	for _,hnswNode:=range(neighborNodes){
 		neighbors=append(neighbors, hnswNode.Key)
@@ -34,38 +104,220 @@ func (hnswStore *HnswStore) Search(storeName string,query []float32, limit int)
 	return neighbors,nil
 }
 
+// SearchEf is like Search with an explicit number of candidates to
+// consider, which must be at least limit.
+func (hnswStore *HnswStore) SearchEf(storeName string, query []float32, limit int, ef int) ([]string, error) {
+	if ef < limit {
+		return nil, fmt.Errorf("ef %d must be at least the limit %d", ef, limit)
+	}
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	neighborNodes := graph.SearchEf(query, limit, ef)
+	neighbors := make([]string, 0, len(neighborNodes))
+	for _, hnswNode := range neighborNodes {
+		neighbors = append(neighbors, hnswNode.Key)
+	}
+	return neighbors, nil
+}
+
+// SearchRange returns the keys within radius of the query, nearest first.
+func (hnswStore *HnswStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	neighborNodes := graph.SearchRange(query, radius, maxResults)
+	neighbors := make([]string, 0, len(neighborNodes))
+	for _, hnswNode := range neighborNodes {
+		neighbors = append(neighbors, hnswNode.Key)
+	}
+	return neighbors, nil
+}
+
 func (hnswStore *HnswStore) Insert(storeName string,embedding []float32,key string) (error){
 	hnswStore.initialize(storeName)
+	if err := hnswStore.configs.checkDim(storeName, embedding); err != nil {
+		return err
+	}
+	// Check the dimension before logging, as the graph panics on a mismatch.
+	if dims := hnswStore.store[storeName].Dims(); dims != 0 && len(embedding) != dims {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", dims, len(embedding))
+	}
+	if err := hnswStore.logs.append(storeName, insertRecord(key, embedding)); err != nil {
+		return err
+	}
 	hnswStore.store[storeName].Insert(hnsw.Node[string]{Key: key,Embed: embedding})
 	return nil
 }
 
+// BulkInsert inserts the embeddings under the matching keys with a worker
+// per CPU. A key repeated in the batch keeps its last embedding.
+func (hnswStore *HnswStore) BulkInsert(storeName string, embeddings [][]float32, keys []string, progress func(done, total int)) error {
+	if len(embeddings) != len(keys) {
+		return fmt.Errorf("got %d embeddings for %d keys", len(embeddings), len(keys))
+	}
+	hnswStore.initialize(storeName)
+	dims := hnswStore.store[storeName].Dims()
+	if dims == 0 {
+		dims = hnswStore.configs[storeName].Dim
+	}
+	nodes := make([]hnsw.Node[string], len(keys))
+	records := make([]wal.Record, len(keys))
+	for i, key := range keys {
+		if dims == 0 {
+			dims = len(embeddings[i])
+		} else if len(embeddings[i]) != dims {
+			return fmt.Errorf("embedding dimension mismatch for %q: %d != %d", key, dims, len(embeddings[i]))
+		}
+		nodes[i] = hnsw.MakeNode(key, embeddings[i])
+		records[i] = insertRecord(key, embeddings[i])
+	}
+	if err := hnswStore.logs.append(storeName, records...); err != nil {
+		return err
+	}
+	hnswStore.store[storeName].BulkInsert(nodes, hnsw.BulkOptions{Progress: progress})
+	return nil
+}
 
 func (hnswStore *HnswStore) Lookup(storeName string,embedding []float32,key string) ([]float32,error) {
-	hnswStore.initialize(storeName)
-	embeddingFound,present:=hnswStore.store[storeName].Lookup(key)
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	embeddingFound,present:=graph.Lookup(key)
 	if !present{
 		return nil,fmt.Errorf("key not present in the database")
 	}
 	return embeddingFound,nil
 }
 
+// Export passes every key of the collection and its vector to fn.
+func (hnswStore *HnswStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return graph.Each(func(key string, embedding hnsw.Embedding) error {
+		return fn(key, embedding)
+	})
+}
+
 func (hnswStore *HnswStore) Delete(storeName string,embdedding []float32,key string) (bool,error) {
-	hnswStore.initialize(storeName)
-	deleted:=hnswStore.store[storeName].Delete(key);
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return false, err
+	}
+	if err := hnswStore.logs.append(storeName, deleteRecord(key, embdedding)); err != nil {
+		return false, err
+	}
+	deleted:=graph.Delete(key);
 	return deleted,nil
 }
 
-func (hnswStore *HnswStore) Load(storeName string) (error) {
-	hnswStore.initialize(storeName)
-	err:=hnswStore.store[storeName].Load(storeName);
-	return err
+func (hnswStore *HnswStore) Load(storeName string) error {
+	graph := hnswStore.orDefault(storeName)
+	err := graph.Load(storeName)
+	if err != nil {
+		return err
+	}
+	hnswStore.store[storeName] = graph
+	return hnswStore.logs.replay(storeName, func(record wal.Record) {
+		hnswStore.apply(storeName, record)
+	})
 }
 
 func (hnswStore *HnswStore) Save(storeName string) (error) {
-	hnswStore.initialize(storeName)
-	err:=hnswStore.store[storeName].Save(storeName);
-	return err
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	err=graph.Save(storeName);
+	if err != nil {
+		return err
+	}
+	return hnswStore.logs.checkpoint(storeName)
+}
+
+// apply replays a logged mutation on the collection.
+func (hnswStore *HnswStore) apply(storeName string, record wal.Record) {
+	graph := hnswStore.store[storeName]
+	switch record.Op {
+	case wal.OpInsert:
+		if dims := graph.Dims(); dims == 0 || len(record.Embedding) == dims {
+			graph.Insert(hnsw.MakeNode(record.Key, record.Embedding))
+		}
+	case wal.OpDelete:
+		graph.Delete(record.Key)
+	}
+}
+
+
+// CompressPQ compresses the collection's graph with a product quantizer of
+// the given number of subspaces, trained on the vectors inserted so far.
+func (hnswStore *HnswStore) CompressPQ(storeName string, subspaces int) error {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return graph.CompressPQ(subspaces)
+}
+
+// Quantize compresses the collection's graph with int8 or binary
+// quantization, trained on the vectors inserted so far.
+func (hnswStore *HnswStore) Quantize(storeName string, mode string) error {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return graph.Quantize(mode)
+}
+
+// Stats returns the size and tombstone metrics of the collection's graph.
+func (hnswStore *HnswStore) Stats(storeName string) (hnsw.Stats, error) {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return hnsw.Stats{}, err
+	}
+	return graph.Stats(), nil
+}
+
+// Compact removes the tombstones left by deletes from the collection's
+// graph without waiting for its compaction threshold.
+func (hnswStore *HnswStore) Compact(storeName string) error {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	graph.Compact()
+	return nil
+}
+
+// Validate checks the connectivity of the collection's graph.
+func (hnswStore *HnswStore) Validate(storeName string) (hnsw.Report[string], error) {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return hnsw.Report[string]{}, err
+	}
+	return graph.Validate(), nil
 }
 
+// Repair fixes the problems Validate reports in the collection's graph.
+func (hnswStore *HnswStore) Repair(storeName string) (hnsw.Repaired, error) {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return hnsw.Repaired{}, err
+	}
+	return graph.Repair(), nil
+}
 
+// SaveMapped saves the collection's graph in the layout of
+// MappedHnswStore. The graph itself stays as it is.
+func (hnswStore *HnswStore) SaveMapped(storeName string) error {
+	graph, err := collection(hnswStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return hnsw.SaveMapped(graph, storeName)
+}
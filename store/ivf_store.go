@@ -0,0 +1,195 @@
+package store
+
+import (
+	"errors"
+	"vectorDb/ivf"
+	"vectorDb/wal"
+)
+
+type IvfStore struct {
+	store   map[string]*ivf.IVFFlat
+	configs configs
+	logs    logs
+}
+
+func NewIvfStore() (Store, error) {
+	ivfStore := &IvfStore{
+		store:   map[string]*ivf.IVFFlat{},
+		configs: configs{},
+		logs:    newLogs("_ivf"),
+	}
+	return ivfStore, nil
+}
+
+func (ivfStore *IvfStore) initialize(storeName string) {
+	ivfStore.store[storeName] = ivfStore.orDefault(storeName)
+}
+
+// orDefault returns the collection storeName, or a new one with the
+// default parameters, not yet added to the store, if there is none.
+func (ivfStore *IvfStore) orDefault(storeName string) *ivf.IVFFlat {
+	index, present := ivfStore.store[storeName]
+	if !present {
+		// The default parameters are always valid.
+		index, _ = ivf.NewIVFFlat(100, 8, "")
+	}
+	return index
+}
+
+// CreateCollection creates an empty collection. It takes the parameters
+// nlist and nprobe, 100 and 8 by default.
+func (ivfStore *IvfStore) CreateCollection(storeName string, config CollectionConfig) error {
+	_, present := ivfStore.store[storeName]
+	if err := canCreate(storeName, present, config); err != nil {
+		return err
+	}
+	nlist, nprobe := 100, 8
+	if err := parseParams(config.Params, map[string]any{"nlist": &nlist, "nprobe": &nprobe}); err != nil {
+		return err
+	}
+	index, err := ivf.NewIVFFlat(nlist, nprobe, config.Metric)
+	if err != nil {
+		return err
+	}
+	ivfStore.store[storeName] = index
+	ivfStore.configs[storeName] = config
+	return nil
+}
+
+func (ivfStore *IvfStore) ListCollections() []string {
+	return listCollections(ivfStore.store)
+}
+
+func (ivfStore *IvfStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	return describeCollection(ivfStore.store, ivfStore.configs, storeName)
+}
+
+func (ivfStore *IvfStore) DropCollection(storeName string) error {
+	return dropCollection(ivfStore.store, ivfStore.configs, &ivfStore.logs, storeName)
+}
+
+func (ivfStore *IvfStore) RenameCollection(oldName string, newName string) error {
+	return renameCollection(ivfStore.store, ivfStore.configs, &ivfStore.logs, oldName, newName)
+}
+
+func (ivfStore *IvfStore) Search(storeName string, query []float32, limit int) ([]string, error) {
+	index, err := collection(ivfStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results, nil
+}
+
+// SearchRange returns the keys within radius of the query, nearest first.
+func (ivfStore *IvfStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	index, err := collection(ivfStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.SearchRange(query, radius, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results, nil
+}
+
+func (ivfStore *IvfStore) Insert(storeName string, embedding []float32, key string) error {
+	ivfStore.initialize(storeName)
+	if err := ivfStore.configs.checkDim(storeName, embedding); err != nil {
+		return err
+	}
+	if err := ivfStore.logs.append(storeName, insertRecord(key, embedding)); err != nil {
+		return err
+	}
+	return ivfStore.store[storeName].Add(key, embedding)
+}
+
+func (ivfStore *IvfStore) Lookup(storeName string, embedding []float32, key string) ([]float32, error) {
+	index, err := collection(ivfStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	embeddingFound, present := index.Lookup(key)
+	if !present {
+		return nil, errors.New("key not present in the database")
+	}
+	return embeddingFound, nil
+}
+
+// Export passes every key of the collection and its vector to fn.
+func (ivfStore *IvfStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	index, err := collection(ivfStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.Each(fn)
+}
+
+func (ivfStore *IvfStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
+	index, err := collection(ivfStore.store, storeName)
+	if err != nil {
+		return false, err
+	}
+	if err := ivfStore.logs.append(storeName, deleteRecord(key, embedding)); err != nil {
+		return false, err
+	}
+	deleted := index.Delete(key)
+	return deleted, nil
+}
+
+// Train trains the collection's coarse quantizer on the vectors inserted so
+// far, without waiting for the automatic training threshold.
+func (ivfStore *IvfStore) Train(storeName string) error {
+	index, err := collection(ivfStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.Train(nil)
+}
+
+func (ivfStore *IvfStore) Load(storeName string) error {
+	index := ivfStore.orDefault(storeName)
+	err := index.Load(storeName)
+	if err != nil {
+		return err
+	}
+	ivfStore.store[storeName] = index
+	return ivfStore.logs.replay(storeName, func(record wal.Record) {
+		ivfStore.apply(storeName, record)
+	})
+}
+
+func (ivfStore *IvfStore) Save(storeName string) error {
+	index, err := collection(ivfStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	err = index.Save(storeName)
+	if err != nil {
+		return err
+	}
+	return ivfStore.logs.checkpoint(storeName)
+}
+
+// apply replays a logged mutation on the collection. Errors were already
+// returned when the mutation was made, so they are ignored.
+func (ivfStore *IvfStore) apply(storeName string, record wal.Record) {
+	switch record.Op {
+	case wal.OpInsert:
+		ivfStore.store[storeName].Add(record.Key, record.Embedding)
+	case wal.OpDelete:
+		ivfStore.store[storeName].Delete(record.Key)
+	}
+}
@@ -0,0 +1,197 @@
+package store
+
+import (
+	"errors"
+	"vectorDb/ivf"
+	"vectorDb/wal"
+)
+
+type IvfPqStore struct {
+	store   map[string]*ivf.IVFPQ
+	configs configs
+	logs    logs
+}
+
+func NewIvfPqStore() (Store, error) {
+	ivfPqStore := &IvfPqStore{
+		store:   map[string]*ivf.IVFPQ{},
+		configs: configs{},
+		logs:    newLogs("_ivfpq"),
+	}
+	return ivfPqStore, nil
+}
+
+func (ivfPqStore *IvfPqStore) initialize(storeName string) {
+	ivfPqStore.store[storeName] = ivfPqStore.orDefault(storeName)
+}
+
+// orDefault returns the collection storeName, or a new one with the
+// default parameters, not yet added to the store, if there is none.
+func (ivfPqStore *IvfPqStore) orDefault(storeName string) *ivf.IVFPQ {
+	index, present := ivfPqStore.store[storeName]
+	if !present {
+		// The default parameters are always valid.
+		index, _ = ivf.NewIVFPQ(100, 8, 0, "")
+	}
+	return index
+}
+
+// CreateCollection creates an empty collection. It takes the parameters
+// nlist, nprobe and subspaces, 100, 8 and 0 by default; 0 subspaces picks
+// a number from the dimension.
+func (ivfPqStore *IvfPqStore) CreateCollection(storeName string, config CollectionConfig) error {
+	_, present := ivfPqStore.store[storeName]
+	if err := canCreate(storeName, present, config); err != nil {
+		return err
+	}
+	nlist, nprobe, subspaces := 100, 8, 0
+	if err := parseParams(config.Params, map[string]any{"nlist": &nlist, "nprobe": &nprobe, "subspaces": &subspaces}); err != nil {
+		return err
+	}
+	index, err := ivf.NewIVFPQ(nlist, nprobe, subspaces, config.Metric)
+	if err != nil {
+		return err
+	}
+	ivfPqStore.store[storeName] = index
+	ivfPqStore.configs[storeName] = config
+	return nil
+}
+
+func (ivfPqStore *IvfPqStore) ListCollections() []string {
+	return listCollections(ivfPqStore.store)
+}
+
+func (ivfPqStore *IvfPqStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	return describeCollection(ivfPqStore.store, ivfPqStore.configs, storeName)
+}
+
+func (ivfPqStore *IvfPqStore) DropCollection(storeName string) error {
+	return dropCollection(ivfPqStore.store, ivfPqStore.configs, &ivfPqStore.logs, storeName)
+}
+
+func (ivfPqStore *IvfPqStore) RenameCollection(oldName string, newName string) error {
+	return renameCollection(ivfPqStore.store, ivfPqStore.configs, &ivfPqStore.logs, oldName, newName)
+}
+
+func (ivfPqStore *IvfPqStore) Search(storeName string, query []float32, limit int) ([]string, error) {
+	index, err := collection(ivfPqStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results, nil
+}
+
+// SearchRange returns the keys within radius of the query, nearest first.
+func (ivfPqStore *IvfPqStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	index, err := collection(ivfPqStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.SearchRange(query, radius, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results, nil
+}
+
+func (ivfPqStore *IvfPqStore) Insert(storeName string, embedding []float32, key string) error {
+	ivfPqStore.initialize(storeName)
+	if err := ivfPqStore.configs.checkDim(storeName, embedding); err != nil {
+		return err
+	}
+	if err := ivfPqStore.logs.append(storeName, insertRecord(key, embedding)); err != nil {
+		return err
+	}
+	return ivfPqStore.store[storeName].Add(key, embedding)
+}
+
+func (ivfPqStore *IvfPqStore) Lookup(storeName string, embedding []float32, key string) ([]float32, error) {
+	index, err := collection(ivfPqStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	embeddingFound, present := index.Lookup(key)
+	if !present {
+		return nil, errors.New("key not present in the database")
+	}
+	return embeddingFound, nil
+}
+
+// Export passes every key of the collection and its vector to fn. The
+// vectors of a trained index are reconstructed from their codes.
+func (ivfPqStore *IvfPqStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	index, err := collection(ivfPqStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.Each(fn)
+}
+
+func (ivfPqStore *IvfPqStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
+	index, err := collection(ivfPqStore.store, storeName)
+	if err != nil {
+		return false, err
+	}
+	if err := ivfPqStore.logs.append(storeName, deleteRecord(key, embedding)); err != nil {
+		return false, err
+	}
+	deleted := index.Delete(key)
+	return deleted, nil
+}
+
+// Train trains the collection's coarse quantizer on the vectors inserted so
+// far, without waiting for the automatic training threshold.
+func (ivfPqStore *IvfPqStore) Train(storeName string) error {
+	index, err := collection(ivfPqStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.Train(nil)
+}
+
+func (ivfPqStore *IvfPqStore) Load(storeName string) error {
+	index := ivfPqStore.orDefault(storeName)
+	err := index.Load(storeName)
+	if err != nil {
+		return err
+	}
+	ivfPqStore.store[storeName] = index
+	return ivfPqStore.logs.replay(storeName, func(record wal.Record) {
+		ivfPqStore.apply(storeName, record)
+	})
+}
+
+func (ivfPqStore *IvfPqStore) Save(storeName string) error {
+	index, err := collection(ivfPqStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	err = index.Save(storeName)
+	if err != nil {
+		return err
+	}
+	return ivfPqStore.logs.checkpoint(storeName)
+}
+
+// apply replays a logged mutation on the collection. Errors were already
+// returned when the mutation was made, so they are ignored.
+func (ivfPqStore *IvfPqStore) apply(storeName string, record wal.Record) {
+	switch record.Op {
+	case wal.OpInsert:
+		ivfPqStore.store[storeName].Add(record.Key, record.Embedding)
+	case wal.OpDelete:
+		ivfPqStore.store[storeName].Delete(record.Key)
+	}
+}
@@ -2,30 +2,96 @@ package store
 
 import (
 	"errors"
+	"fmt"
+	"io/fs"
 	"vectorDb/lsh"
+	"vectorDb/wal"
 )
 
 type LshStore struct {
-	store map[string]*lsh.CosineLsh
+	store   map[string]*lsh.CosineLsh
+	configs configs
+	logs    logs
 }
 
 func NewLshStore() (Store, error) {
 	lshStore := &LshStore{
 		store:map[string]*lsh.CosineLsh{},
+		configs: configs{},
+		logs: newLogs("_lsh"),
 	}
 	return lshStore, nil
 }
 
-func (lshStore *LshStore) initialize(storeName string){
-	_,present:=lshStore.store[storeName]
-	if !present{
-		lshStore.store[storeName]=lsh.NewCosineLsh(20,15,15,"euclidean")
+func (lshStore *LshStore) initialize(storeName string) {
+	lshStore.store[storeName] = lshStore.orDefault(storeName)
+}
+
+// orDefault returns the collection storeName, or a new one with the
+// default parameters, not yet added to the store, if there is none.
+func (lshStore *LshStore) orDefault(storeName string) *lsh.CosineLsh {
+	index, present := lshStore.store[storeName]
+	if !present {
+		// The default distance function is always registered.
+		index, _ = lsh.NewCosineLsh(20, 15, 15, "euclidean")
+	}
+	return index
+}
+
+// CreateCollection creates an empty collection. Its hyperplanes have the
+// configured dimension, 20 by default. It takes the parameters l, the
+// number of hash tables, and m, the number of hyperplanes per table, both
+// 15 by default.
+func (lshStore *LshStore) CreateCollection(storeName string, config CollectionConfig) error {
+	_, present := lshStore.store[storeName]
+	if err := canCreate(storeName, present, config); err != nil {
+		return err
 	}
+	dim, l, m := int32(20), int32(15), int32(15)
+	if config.Dim > 0 {
+		dim = int32(config.Dim)
+	}
+	if err := parseParams(config.Params, map[string]any{"l": &l, "m": &m}); err != nil {
+		return err
+	}
+	if l <= 0 || m <= 0 {
+		return fmt.Errorf("l and m must be greater than 0")
+	}
+	metric := config.Metric
+	if metric == "" {
+		metric = "euclidean"
+	}
+	index, err := lsh.NewCosineLsh(dim, l, m, metric)
+	if err != nil {
+		return err
+	}
+	lshStore.store[storeName] = index
+	lshStore.configs[storeName] = config
+	return nil
+}
+
+func (lshStore *LshStore) ListCollections() []string {
+	return listCollections(lshStore.store)
+}
+
+func (lshStore *LshStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	return describeCollection(lshStore.store, lshStore.configs, storeName)
+}
+
+func (lshStore *LshStore) DropCollection(storeName string) error {
+	return dropCollection(lshStore.store, lshStore.configs, &lshStore.logs, storeName)
+}
+
+func (lshStore *LshStore) RenameCollection(oldName string, newName string) error {
+	return renameCollection(lshStore.store, lshStore.configs, &lshStore.logs, oldName, newName)
 }
 
 func (lshStore *LshStore) Search(storeName string,query []float32, limit int) ([]string,error) {
-	lshStore.initialize(storeName)
-	searchResults:=lshStore.store[storeName].Search(query,limit)
+	index, err := collection(lshStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults:=index.Search(query,limit)
 	results:=make([]string,0)
 	for _,result:=range(searchResults){
 		results=append(results, result.ExtraData)
@@ -34,38 +100,101 @@ func (lshStore *LshStore) Search(storeName string,query []float32, limit int) ([
 	return results,nil
 }
 
+// SearchRange returns the keys within radius of the query, nearest first.
+func (lshStore *LshStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	index, err := collection(lshStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults := index.SearchRange(query, radius, maxResults)
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.ExtraData)
+	}
+	return results, nil
+}
+
 func (lshStore *LshStore) Insert(storeName string,embedding []float32,key string) (error){
 	lshStore.initialize(storeName)
+	if err := lshStore.configs.checkDim(storeName, embedding); err != nil {
+		return err
+	}
+	if err := lshStore.logs.append(storeName, insertRecord(key, embedding)); err != nil {
+		return err
+	}
 	lshStore.store[storeName].Insert(embedding,key)
 	return nil
 }
 
 
 func (lshStore *LshStore) Lookup(storeName string,embedding []float32,key string) ([]float32,error) {
-	lshStore.initialize(storeName)
-	present:=lshStore.store[storeName].Lookup(embedding,key)
+	index, err := collection(lshStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	present:=index.Lookup(embedding,key)
 	if !present{
 		return nil,errors.New("key not present in the database")
 	}
 	return embedding,nil
 }
 
+// Export passes every key of the collection and its vector to fn.
+func (lshStore *LshStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	index, err := collection(lshStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.Each(fn)
+}
+
 func (lshStore *LshStore) Delete(storeName string,embdedding []float32,key string) (bool,error) {
-	lshStore.initialize(storeName)
-	lshStore.store[storeName].Delete(embdedding,key);
+	index, err := collection(lshStore.store, storeName)
+	if err != nil {
+		return false, err
+	}
+	if err := lshStore.logs.append(storeName, deleteRecord(key, embdedding)); err != nil {
+		return false, err
+	}
+	index.Delete(embdedding,key);
 	return true,nil
 }
 
-func (lshStore *LshStore) Load(storeName string) (error) {
-	lshStore.initialize(storeName)
-	err:=lshStore.store[storeName].Load(storeName);
-	return err
+func (lshStore *LshStore) Load(storeName string) error {
+	index := lshStore.orDefault(storeName)
+	err := index.Load(storeName)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = lshStore.logs.missing(storeName)
+	}
+	if err != nil {
+		return err
+	}
+	lshStore.store[storeName] = index
+	return lshStore.logs.replay(storeName, func(record wal.Record) {
+		lshStore.apply(storeName, record)
+	})
 }
 
 func (lshStore *LshStore) Save(storeName string) (error) {
-	lshStore.initialize(storeName)
-	err:=lshStore.store[storeName].Save(storeName);
-	return err
+	index, err := collection(lshStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	err=index.Save(storeName);
+	if err != nil {
+		return err
+	}
+	return lshStore.logs.checkpoint(storeName)
+}
+
+// apply replays a logged mutation on the collection.
+func (lshStore *LshStore) apply(storeName string, record wal.Record) {
+	switch record.Op {
+	case wal.OpInsert:
+		lshStore.store[storeName].Insert(record.Embedding, record.Key)
+	case wal.OpDelete:
+		lshStore.store[storeName].Delete(record.Embedding, record.Key)
+	}
 }
 
 
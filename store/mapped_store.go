@@ -0,0 +1,299 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+)
+
+// ErrReadOnly is returned by the mutations of the read-only stores.
+var ErrReadOnly = errors.New("the store is read-only")
+
+// MappedHnswStore serves searches from the graphs saved by
+// HnswStore.SaveMapped, mapped into memory rather than loaded. It starts
+// almost at once whatever the size of the graphs, but inserts, deletes and
+// saves fail with ErrReadOnly.
+type MappedHnswStore struct {
+	store map[string]*hnsw.MappedGraph
+}
+
+func NewMappedHnswStore() (Store, error) {
+	return &MappedHnswStore{store: map[string]*hnsw.MappedGraph{}}, nil
+}
+
+// graph returns the collection's graph, mapping it on first use.
+func (mappedStore *MappedHnswStore) graph(storeName string) (*hnsw.MappedGraph, error) {
+	if graph, present := mappedStore.store[storeName]; present {
+		return graph, nil
+	}
+	if err := mappedStore.Load(storeName); err != nil {
+		return nil, err
+	}
+	return mappedStore.store[storeName], nil
+}
+
+// query returns the collection's graph after checking the dimension of the
+// query, as the graph panics on a mismatch.
+func (mappedStore *MappedHnswStore) query(storeName string, query []float32) (*hnsw.MappedGraph, error) {
+	graph, err := mappedStore.graph(storeName)
+	if err != nil {
+		return nil, err
+	}
+	if dims := graph.Dims(); dims != 0 && len(query) != dims {
+		return nil, fmt.Errorf("embedding dimension mismatch: %d != %d", dims, len(query))
+	}
+	return graph, nil
+}
+
+func (mappedStore *MappedHnswStore) Search(storeName string, query []float32, limit int) ([]string, error) {
+	graph, err := mappedStore.query(storeName, query)
+	if err != nil {
+		return nil, err
+	}
+	return nodeKeys(graph.Search(query, limit)), nil
+}
+
+// SearchRange returns the keys within radius of the query, nearest first.
+func (mappedStore *MappedHnswStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	graph, err := mappedStore.query(storeName, query)
+	if err != nil {
+		return nil, err
+	}
+	return nodeKeys(graph.SearchRange(query, radius, maxResults)), nil
+}
+
+func nodeKeys(nodes []hnsw.Node[string]) []string {
+	keys := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		keys = append(keys, node.Key)
+	}
+	return keys
+}
+
+func (mappedStore *MappedHnswStore) Lookup(storeName string, embedding []float32, key string) ([]float32, error) {
+	graph, err := mappedStore.graph(storeName)
+	if err != nil {
+		return nil, err
+	}
+	embeddingFound, present := graph.Lookup(key)
+	if !present {
+		return nil, errors.New("key not present in the database")
+	}
+	return embeddingFound, nil
+}
+
+// Export passes every key of the collection and its vector to fn.
+func (mappedStore *MappedHnswStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	graph, err := mappedStore.graph(storeName)
+	if err != nil {
+		return err
+	}
+	return graph.Each(func(key string, embedding hnsw.Embedding) error {
+		return fn(key, embedding)
+	})
+}
+
+func (mappedStore *MappedHnswStore) Insert(storeName string, embedding []float32, key string) error {
+	return ErrReadOnly
+}
+
+func (mappedStore *MappedHnswStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
+	return false, ErrReadOnly
+}
+
+func (mappedStore *MappedHnswStore) Save(storeName string) error {
+	return ErrReadOnly
+}
+
+// Load maps the collection's saved graph, replacing the one mapped before,
+// which picks up a graph saved again since.
+func (mappedStore *MappedHnswStore) Load(storeName string) error {
+	graph, err := hnsw.OpenMapped(storeName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return notFound(storeName)
+	}
+	if err != nil {
+		return err
+	}
+	if old, present := mappedStore.store[storeName]; present {
+		old.Close()
+	}
+	mappedStore.store[storeName] = graph
+	return nil
+}
+
+func (mappedStore *MappedHnswStore) CreateCollection(storeName string, config CollectionConfig) error {
+	return ErrReadOnly
+}
+
+// ListCollections returns the collections mapped so far.
+func (mappedStore *MappedHnswStore) ListCollections() []string {
+	return listCollections(mappedStore.store)
+}
+
+func (mappedStore *MappedHnswStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	graph, err := mappedStore.graph(storeName)
+	if err != nil {
+		return CollectionInfo{}, err
+	}
+	return CollectionInfo{Name: storeName, Metric: graph.Metric(), Dim: graph.Dims(), Len: graph.Len()}, nil
+}
+
+func (mappedStore *MappedHnswStore) DropCollection(storeName string) error {
+	return ErrReadOnly
+}
+
+func (mappedStore *MappedHnswStore) RenameCollection(oldName string, newName string) error {
+	return ErrReadOnly
+}
+
+// Thaw converts the collection's mapped graph back to a mutable one and
+// saves it where HnswStore loads it from.
+func (mappedStore *MappedHnswStore) Thaw(storeName string) error {
+	graph, err := mappedStore.graph(storeName)
+	if err != nil {
+		return err
+	}
+	return graph.Graph().Save(storeName)
+}
+
+// MappedFlatStore serves exact searches from the indexes saved by
+// FlatStore.SaveMapped, mapped into memory rather than loaded. Inserts,
+// deletes and saves fail with ErrReadOnly.
+type MappedFlatStore struct {
+	store map[string]*flat.MappedIndex
+}
+
+func NewMappedFlatStore() (Store, error) {
+	return &MappedFlatStore{store: map[string]*flat.MappedIndex{}}, nil
+}
+
+// index returns the collection's index, mapping it on first use.
+func (mappedStore *MappedFlatStore) index(storeName string) (*flat.MappedIndex, error) {
+	if index, present := mappedStore.store[storeName]; present {
+		return index, nil
+	}
+	if err := mappedStore.Load(storeName); err != nil {
+		return nil, err
+	}
+	return mappedStore.store[storeName], nil
+}
+
+func (mappedStore *MappedFlatStore) Search(storeName string, query []float32, limit int) ([]string, error) {
+	index, err := mappedStore.index(storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return resultKeys(searchResults), nil
+}
+
+// SearchRange returns the keys within radius of the query, nearest first.
+func (mappedStore *MappedFlatStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	index, err := mappedStore.index(storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.SearchRange(query, radius, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	return resultKeys(searchResults), nil
+}
+
+func resultKeys(searchResults []flat.Result) []string {
+	keys := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		keys = append(keys, result.Key)
+	}
+	return keys
+}
+
+func (mappedStore *MappedFlatStore) Lookup(storeName string, embedding []float32, key string) ([]float32, error) {
+	index, err := mappedStore.index(storeName)
+	if err != nil {
+		return nil, err
+	}
+	embeddingFound, present := index.Lookup(key)
+	if !present {
+		return nil, errors.New("key not present in the database")
+	}
+	return embeddingFound, nil
+}
+
+// Export passes every key of the collection and its vector to fn.
+func (mappedStore *MappedFlatStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	index, err := mappedStore.index(storeName)
+	if err != nil {
+		return err
+	}
+	return index.Each(fn)
+}
+
+func (mappedStore *MappedFlatStore) Insert(storeName string, embedding []float32, key string) error {
+	return ErrReadOnly
+}
+
+func (mappedStore *MappedFlatStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
+	return false, ErrReadOnly
+}
+
+func (mappedStore *MappedFlatStore) Save(storeName string) error {
+	return ErrReadOnly
+}
+
+// Load maps the collection's saved index, replacing the one mapped before.
+func (mappedStore *MappedFlatStore) Load(storeName string) error {
+	index, err := flat.OpenMapped(storeName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return notFound(storeName)
+	}
+	if err != nil {
+		return err
+	}
+	if old, present := mappedStore.store[storeName]; present {
+		old.Close()
+	}
+	mappedStore.store[storeName] = index
+	return nil
+}
+
+func (mappedStore *MappedFlatStore) CreateCollection(storeName string, config CollectionConfig) error {
+	return ErrReadOnly
+}
+
+// ListCollections returns the collections mapped so far.
+func (mappedStore *MappedFlatStore) ListCollections() []string {
+	return listCollections(mappedStore.store)
+}
+
+func (mappedStore *MappedFlatStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	index, err := mappedStore.index(storeName)
+	if err != nil {
+		return CollectionInfo{}, err
+	}
+	return CollectionInfo{Name: storeName, Metric: index.Metric(), Dim: index.Dims(), Len: index.Len()}, nil
+}
+
+func (mappedStore *MappedFlatStore) DropCollection(storeName string) error {
+	return ErrReadOnly
+}
+
+func (mappedStore *MappedFlatStore) RenameCollection(oldName string, newName string) error {
+	return ErrReadOnly
+}
+
+// Thaw converts the collection's mapped index back to a mutable one and
+// saves it where FlatStore loads it from.
+func (mappedStore *MappedFlatStore) Thaw(storeName string) error {
+	index, err := mappedStore.index(storeName)
+	if err != nil {
+		return err
+	}
+	return index.Index().Save(storeName)
+}
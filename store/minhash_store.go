@@ -0,0 +1,169 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"vectorDb/lsh"
+	"vectorDb/wal"
+)
+
+type MinHashStore struct {
+	store   map[string]*lsh.MinHashLsh
+	configs configs
+	logs    logs
+}
+
+func NewMinHashStore() (TextStore, error) {
+	minHashStore := &MinHashStore{
+		store:   map[string]*lsh.MinHashLsh{},
+		configs: configs{},
+		logs:    newLogs("_minhash"),
+	}
+	return minHashStore, nil
+}
+
+func (minHashStore *MinHashStore) initialize(storeName string) {
+	minHashStore.store[storeName] = minHashStore.orDefault(storeName)
+}
+
+// orDefault returns the collection storeName, or a new one with the
+// default parameters, not yet added to the store, if there is none.
+func (minHashStore *MinHashStore) orDefault(storeName string) *lsh.MinHashLsh {
+	index, present := minHashStore.store[storeName]
+	if !present {
+		index = lsh.NewMinHashLsh(20, 5, lsh.Shingler{Unit: lsh.ShingleChar, K: 3})
+	}
+	return index
+}
+
+// CreateCollection creates an empty collection. It takes the parameters
+// bands and rows of the signatures, 20 and 5 by default; the metric and
+// dimension are ignored, as keys are compared by the Jaccard similarity of
+// their shingles.
+func (minHashStore *MinHashStore) CreateCollection(storeName string, config CollectionConfig) error {
+	_, present := minHashStore.store[storeName]
+	if err := canCreate(storeName, present, config); err != nil {
+		return err
+	}
+	bands, rows := int32(20), int32(5)
+	if err := parseParams(config.Params, map[string]any{"bands": &bands, "rows": &rows}); err != nil {
+		return err
+	}
+	if bands <= 0 || rows <= 0 {
+		return errors.New("bands and rows must be greater than 0")
+	}
+	minHashStore.store[storeName] = lsh.NewMinHashLsh(bands, rows, lsh.Shingler{Unit: lsh.ShingleChar, K: 3})
+	minHashStore.configs[storeName] = config
+	return nil
+}
+
+func (minHashStore *MinHashStore) ListCollections() []string {
+	return listCollections(minHashStore.store)
+}
+
+func (minHashStore *MinHashStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	index, err := collection(minHashStore.store, storeName)
+	if err != nil {
+		return CollectionInfo{}, err
+	}
+	return CollectionInfo{Name: storeName, Metric: "jaccard", Len: index.Len(), Params: minHashStore.configs[storeName].Params}, nil
+}
+
+func (minHashStore *MinHashStore) DropCollection(storeName string) error {
+	return dropCollection(minHashStore.store, minHashStore.configs, &minHashStore.logs, storeName)
+}
+
+func (minHashStore *MinHashStore) RenameCollection(oldName string, newName string) error {
+	return renameCollection(minHashStore.store, minHashStore.configs, &minHashStore.logs, oldName, newName)
+}
+
+func (minHashStore *MinHashStore) Search(storeName string, query []float32, limit int) ([]string, error) {
+	return nil, errors.New("minhash stores are searched by text, not by embedding")
+}
+
+func (minHashStore *MinHashStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	return nil, errors.New("minhash stores are searched by text, not by embedding")
+}
+
+func (minHashStore *MinHashStore) SearchText(storeName string, query string, limit int) ([]string, error) {
+	index, err := collection(minHashStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults := index.SearchText(query, limit)
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results, nil
+}
+
+func (minHashStore *MinHashStore) Insert(storeName string, embedding []float32, key string) error {
+	minHashStore.initialize(storeName)
+	if err := minHashStore.logs.append(storeName, insertRecord(key, embedding)); err != nil {
+		return err
+	}
+	minHashStore.store[storeName].InsertText(key, key)
+	return nil
+}
+
+func (minHashStore *MinHashStore) Lookup(storeName string, embedding []float32, key string) ([]float32, error) {
+	index, err := collection(minHashStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	present := index.Lookup(key)
+	if !present {
+		return nil, errors.New("key not present in the database")
+	}
+	return nil, nil
+}
+
+func (minHashStore *MinHashStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
+	index, err := collection(minHashStore.store, storeName)
+	if err != nil {
+		return false, err
+	}
+	if err := minHashStore.logs.append(storeName, deleteRecord(key, embedding)); err != nil {
+		return false, err
+	}
+	deleted := index.Delete(key)
+	return deleted, nil
+}
+
+func (minHashStore *MinHashStore) Load(storeName string) error {
+	index := minHashStore.orDefault(storeName)
+	err := index.Load(storeName)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = minHashStore.logs.missing(storeName)
+	}
+	if err != nil {
+		return err
+	}
+	minHashStore.store[storeName] = index
+	return minHashStore.logs.replay(storeName, func(record wal.Record) {
+		minHashStore.apply(storeName, record)
+	})
+}
+
+func (minHashStore *MinHashStore) Save(storeName string) error {
+	index, err := collection(minHashStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	err = index.Save(storeName)
+	if err != nil {
+		return err
+	}
+	return minHashStore.logs.checkpoint(storeName)
+}
+
+// apply replays a logged mutation on the collection.
+func (minHashStore *MinHashStore) apply(storeName string, record wal.Record) {
+	switch record.Op {
+	case wal.OpInsert:
+		minHashStore.store[storeName].InsertText(record.Key, record.Key)
+	case wal.OpDelete:
+		minHashStore.store[storeName].Delete(record.Key)
+	}
+}
@@ -3,8 +3,77 @@ package store
 type Store interface{
 	Insert(storeName string,embedding []float32,key string) (error)
 	Search(storeName string,query []float32, limit int) ([]string,error)
+	// SearchRange returns the keys of the vectors within radius of the
+	// query, nearest first, and at most maxResults of them if maxResults > 0.
+	// The radius is in the units of the store's distance function, where
+	// smaller is closer: 1 - cosine similarity for the cosine metrics and
+	// the negated inner product for innerProduct.
+	SearchRange(storeName string,query []float32, radius float32, maxResults int) ([]string,error)
 	Delete(storeName string,embedding []float32,key string) (bool,error)
 	Load(storeName string) (error)
 	Save(storeName string) (error)
 	Lookup(storeName string,embedding []float32,key string) ([]float32,error)
-}
\ No newline at end of file
+	// CreateCollection creates an empty collection with the given schema.
+	// It fails with ErrCollectionExists if the store holds one of that name.
+	CreateCollection(storeName string, config CollectionConfig) error
+	// ListCollections returns the names of the collections the store
+	// holds, created or loaded, sorted.
+	ListCollections() []string
+	DescribeCollection(storeName string) (CollectionInfo, error)
+	// DropCollection removes the collection from the store and deletes its
+	// files.
+	DropCollection(storeName string) error
+	// RenameCollection renames the collection and its files.
+	RenameCollection(oldName string, newName string) error
+}
+
+// TextStore is a Store that indexes the key text itself rather than its
+// embedding. The embedding arguments of the Store methods are ignored, and
+// queries go through SearchText, so no embedding client is needed.
+type TextStore interface {
+	Store
+	SearchText(storeName string, query string, limit int) ([]string, error)
+}
+
+// Trainer is implemented by stores whose indexes must be trained on a
+// sample of the data before they partition it, such as IvfStore.
+type Trainer interface {
+	Train(storeName string) error
+}
+
+// BulkInserter is implemented by stores that insert many vectors at once
+// faster than one by one, such as HnswStore. progress, if not nil, is called
+// with the number of vectors inserted so far and the total.
+type BulkInserter interface {
+	BulkInsert(storeName string, embeddings [][]float32, keys []string, progress func(done, total int)) error
+}
+
+// Freezer is implemented by stores that can also save a collection in a
+// read-only layout searched in place from a memory mapped file, for
+// replicas that only serve searches, such as HnswStore.
+type Freezer interface {
+	SaveMapped(storeName string) error
+}
+
+// Thawer is implemented by the read-only stores of frozen collections,
+// which can convert a collection back and save it for the matching mutable
+// store, such as MappedHnswStore.
+type Thawer interface {
+	Thaw(storeName string) error
+}
+
+// Spiller is implemented by stores that hold the vectors inserted since the
+// last save in memory until Save writes them to disk, such as VamanaStore.
+// Pending returns their number, so that bulk loads can save before they
+// outgrow memory.
+type Spiller interface {
+	Pending(storeName string) int
+}
+
+// Exporter is implemented by stores that can list the vectors of a
+// collection, to export it. Export passes every key of the collection and
+// its vector to fn, stopping at and returning the first error fn returns.
+// The vector must not be modified or kept past the call.
+type Exporter interface {
+	Export(storeName string, fn func(key string, embedding []float32) error) error
+}
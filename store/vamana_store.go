@@ -0,0 +1,229 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"vectorDb/vamana"
+	"vectorDb/wal"
+)
+
+// VamanaStore keeps each collection in a disk-resident Vamana graph, for
+// collections too large to hold in memory. Only the quantization codes and
+// keys of saved vectors stay in memory; searches read the vectors and links
+// from disk. Save merges the changes made since the last save into the
+// graph, reading every record once, so it is best called after batches of
+// inserts rather than after each one.
+//
+// Inserts stay in memory until the next Save, and the first Save of a
+// collection builds its whole graph in memory, so the inserts between two
+// saves must fit in memory. Bulk loads save every so often through
+// Spiller, as db.Import does.
+type VamanaStore struct {
+	store   map[string]*vamana.Index
+	configs configs
+	logs    logs
+}
+
+func NewVamanaStore() (Store, error) {
+	vamanaStore := &VamanaStore{
+		store:   map[string]*vamana.Index{},
+		configs: configs{},
+		logs:    newLogs("_vamana"),
+	}
+	return vamanaStore, nil
+}
+
+func (vamanaStore *VamanaStore) initialize(storeName string) {
+	vamanaStore.store[storeName] = vamanaStore.orDefault(storeName)
+}
+
+// orDefault returns the collection storeName, or a new one with the
+// default parameters, not yet added to the store, if there is none.
+func (vamanaStore *VamanaStore) orDefault(storeName string) *vamana.Index {
+	index, present := vamanaStore.store[storeName]
+	if !present {
+		// The default distance function and parameters are always valid.
+		index, _ = vamana.NewIndex("", vamana.DefaultParams())
+	}
+	return index
+}
+
+// CreateCollection creates an empty collection. It takes the parameters
+// r, l, alpha, subspaces, searchL and beamWidth of vamana.Params, with the
+// defaults of vamana.DefaultParams.
+func (vamanaStore *VamanaStore) CreateCollection(storeName string, config CollectionConfig) error {
+	_, present := vamanaStore.store[storeName]
+	if err := canCreate(storeName, present, config); err != nil {
+		return err
+	}
+	params := vamana.DefaultParams()
+	fields := map[string]any{
+		"r": &params.R, "l": &params.L, "alpha": &params.Alpha,
+		"subspaces": &params.Subspaces, "searchL": &params.SearchL, "beamWidth": &params.BeamWidth,
+	}
+	if err := parseParams(config.Params, fields); err != nil {
+		return err
+	}
+	index, err := vamana.NewIndex(config.Metric, params)
+	if err != nil {
+		return err
+	}
+	vamanaStore.store[storeName] = index
+	vamanaStore.configs[storeName] = config
+	return nil
+}
+
+func (vamanaStore *VamanaStore) ListCollections() []string {
+	return listCollections(vamanaStore.store)
+}
+
+func (vamanaStore *VamanaStore) DescribeCollection(storeName string) (CollectionInfo, error) {
+	return describeCollection(vamanaStore.store, vamanaStore.configs, storeName)
+}
+
+// DropCollection closes the collection's file before deleting it.
+func (vamanaStore *VamanaStore) DropCollection(storeName string) error {
+	if index, present := vamanaStore.store[storeName]; present {
+		if err := index.Close(); err != nil {
+			return err
+		}
+	}
+	return dropCollection(vamanaStore.store, vamanaStore.configs, &vamanaStore.logs, storeName)
+}
+
+// RenameCollection renames the collection and its files. The index keeps
+// reading its file under the new name until the next save.
+func (vamanaStore *VamanaStore) RenameCollection(oldName string, newName string) error {
+	return renameCollection(vamanaStore.store, vamanaStore.configs, &vamanaStore.logs, oldName, newName)
+}
+
+func (vamanaStore *VamanaStore) Search(storeName string, query []float32, limit int) ([]string, error) {
+	index, err := collection(vamanaStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.Search(query, limit)
+	if err != nil {
+		return nil, err
+	}
+	return vamanaKeys(searchResults), nil
+}
+
+// SearchRange returns the keys within radius of the query, nearest first.
+func (vamanaStore *VamanaStore) SearchRange(storeName string, query []float32, radius float32, maxResults int) ([]string, error) {
+	index, err := collection(vamanaStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	searchResults, err := index.SearchRange(query, radius, maxResults)
+	if err != nil {
+		return nil, err
+	}
+	return vamanaKeys(searchResults), nil
+}
+
+func vamanaKeys(searchResults []vamana.Result) []string {
+	results := make([]string, 0, len(searchResults))
+	for _, result := range searchResults {
+		results = append(results, result.Key)
+	}
+	return results
+}
+
+func (vamanaStore *VamanaStore) Insert(storeName string, embedding []float32, key string) error {
+	vamanaStore.initialize(storeName)
+	if err := vamanaStore.configs.checkDim(storeName, embedding); err != nil {
+		return err
+	}
+	// Check the dimension before logging, so that the log only holds
+	// inserts the index accepts.
+	if index := vamanaStore.store[storeName]; index.Len() > 0 && len(embedding) != index.Dims() {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", index.Dims(), len(embedding))
+	}
+	if err := vamanaStore.logs.append(storeName, insertRecord(key, embedding)); err != nil {
+		return err
+	}
+	return vamanaStore.store[storeName].Insert(key, embedding)
+}
+
+// Pending returns the number of vectors inserted in the collection since it
+// was last saved.
+func (vamanaStore *VamanaStore) Pending(storeName string) int {
+	index, err := collection(vamanaStore.store, storeName)
+	if err != nil {
+		return 0
+	}
+	return index.Pending()
+}
+
+func (vamanaStore *VamanaStore) Lookup(storeName string, embedding []float32, key string) ([]float32, error) {
+	index, err := collection(vamanaStore.store, storeName)
+	if err != nil {
+		return nil, err
+	}
+	embeddingFound, present, err := index.Lookup(key)
+	if err != nil {
+		return nil, err
+	}
+	if !present {
+		return nil, errors.New("key not present in the database")
+	}
+	return embeddingFound, nil
+}
+
+// Export passes every key of the collection and its vector to fn,
+// reading the saved vectors from disk block by block.
+func (vamanaStore *VamanaStore) Export(storeName string, fn func(key string, embedding []float32) error) error {
+	index, err := collection(vamanaStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	return index.Each(fn)
+}
+
+func (vamanaStore *VamanaStore) Delete(storeName string, embedding []float32, key string) (bool, error) {
+	index, err := collection(vamanaStore.store, storeName)
+	if err != nil {
+		return false, err
+	}
+	if err := vamanaStore.logs.append(storeName, deleteRecord(key, embedding)); err != nil {
+		return false, err
+	}
+	deleted := index.Delete(key)
+	return deleted, nil
+}
+
+func (vamanaStore *VamanaStore) Load(storeName string) error {
+	index := vamanaStore.orDefault(storeName)
+	err := index.Load(storeName)
+	if err != nil {
+		return err
+	}
+	vamanaStore.store[storeName] = index
+	return vamanaStore.logs.replay(storeName, func(record wal.Record) {
+		vamanaStore.apply(storeName, record)
+	})
+}
+
+func (vamanaStore *VamanaStore) Save(storeName string) error {
+	index, err := collection(vamanaStore.store, storeName)
+	if err != nil {
+		return err
+	}
+	err = index.Save(storeName)
+	if err != nil {
+		return err
+	}
+	return vamanaStore.logs.checkpoint(storeName)
+}
+
+// apply replays a logged mutation on the collection. Errors were already
+// returned when the mutation was made, so they are ignored.
+func (vamanaStore *VamanaStore) apply(storeName string, record wal.Record) {
+	switch record.Op {
+	case wal.OpInsert:
+		vamanaStore.store[storeName].Insert(record.Key, record.Embedding)
+	case wal.OpDelete:
+		vamanaStore.store[storeName].Delete(record.Key)
+	}
+}
@@ -0,0 +1,145 @@
+package store
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"vectorDb/wal"
+)
+
+// WriteAheadLog, when not nil, makes every store log each insert and delete
+// to <storeName>_<type>.wal before applying it. Load replays the log on top
+// of the snapshot and Save checkpoints it. The options apply to the logs
+// opened afterwards. Nil, the default, disables logging.
+//
+// Replay applies the records again when a crash hit between a Save and its
+// checkpoint, which is harmless as inserts replace and deletes are
+// idempotent.
+//
+// A log left by an earlier run is replayed by Load even with logging off,
+// and stays in use until the next Save checkpoints and removes it, so that
+// turning logging off never drops the records of a crash.
+var WriteAheadLog *wal.Options
+
+// leftoverLog holds the options of a log replayed while logging is off.
+var leftoverLog = wal.Options{Sync: wal.SyncAlways}
+
+// logs holds the open write-ahead logs of the collections of a store.
+type logs struct {
+	suffix string
+	open   map[string]*wal.Log
+}
+
+func newLogs(suffix string) logs {
+	return logs{suffix: suffix, open: map[string]*wal.Log{}}
+}
+
+// path returns the file of the collection's log, next to its store file.
+func (l *logs) path(storeName string) string {
+	return storeName + l.suffix + ".wal"
+}
+
+// append logs records to the collection's log when logging is on, opening
+// the log if needed, or when a leftover log is open.
+func (l *logs) append(storeName string, records ...wal.Record) error {
+	log, ok := l.open[storeName]
+	if !ok && WriteAheadLog == nil {
+		return nil
+	}
+	if !ok {
+		var err error
+		log, err = wal.Open(l.path(storeName), *WriteAheadLog, nil)
+		if err != nil {
+			return err
+		}
+		l.open[storeName] = log
+	}
+	return log.AppendAll(records)
+}
+
+// replay passes the records logged since the last save to apply, and keeps
+// the log open for the next mutations. Load calls it once the snapshot is
+// read.
+func (l *logs) replay(storeName string, apply func(wal.Record)) error {
+	if log, ok := l.open[storeName]; ok {
+		delete(l.open, storeName)
+		if err := log.Close(); err != nil {
+			return err
+		}
+	}
+	options := WriteAheadLog
+	if options == nil {
+		exists, err := l.exists(storeName)
+		if !exists || err != nil {
+			return err
+		}
+		options = &leftoverLog
+	}
+	log, err := wal.Open(l.path(storeName), *options, func(record wal.Record) error {
+		apply(record)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	l.open[storeName] = log
+	return nil
+}
+
+// exists reports whether the collection has a log on disk.
+func (l *logs) exists(storeName string) (bool, error) {
+	_, err := os.Stat(l.path(storeName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// missing returns the error of loading a collection without a store file:
+// ErrCollectionNotFound, unless its log holds the records of one inserted
+// into but never saved.
+func (l *logs) missing(storeName string) error {
+	exists, err := l.exists(storeName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFound(storeName)
+	}
+	return nil
+}
+
+// checkpoint empties the collection's log, or removes a leftover one when
+// logging is off. Save calls it once the snapshot is on disk.
+func (l *logs) checkpoint(storeName string) error {
+	log, ok := l.open[storeName]
+	if !ok {
+		return nil
+	}
+	if WriteAheadLog != nil {
+		return log.Checkpoint()
+	}
+	if err := l.close(storeName); err != nil {
+		return err
+	}
+	return os.Remove(l.path(storeName))
+}
+
+// close closes the collection's log, if open, for a collection dropped or
+// renamed. The next append opens it again.
+func (l *logs) close(storeName string) error {
+	log, ok := l.open[storeName]
+	if !ok {
+		return nil
+	}
+	delete(l.open, storeName)
+	return log.Close()
+}
+
+func insertRecord(key string, embedding []float32) wal.Record {
+	return wal.Record{Op: wal.OpInsert, Key: key, Embedding: embedding}
+}
+
+func deleteRecord(key string, embedding []float32) wal.Record {
+	return wal.Record{Op: wal.OpDelete, Key: key, Embedding: embedding}
+}
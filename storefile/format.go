@@ -0,0 +1,386 @@
+package storefile
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// Magic starts every store file written with a header. Files without it
+// were written before headers existed, and are read as legacy files when
+// they match the legacy layout of their index.
+const Magic = "VECTORDB"
+
+// legacyPrefixSize is the number of bytes of a file without Magic that
+// NewReader passes to the legacy layout check.
+const legacyPrefixSize = 64
+
+// formatVersion is the version of the header and section framing.
+const formatVersion = 1
+
+// sectionHeaderSize is the size of the length and checksum before each
+// section.
+const sectionHeaderSize = 12
+
+var byteOrder = binary.LittleEndian
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrChecksum is returned when a section does not match its checksum.
+var ErrChecksum = errors.New("section checksum mismatch")
+
+// Header describes the index held by a store file.
+//
+// On disk the header follows Magic as the first section. Every section is
+// its length as a uint64, the CRC-32C of its payload as a uint32, then the
+// payload, so each part of the file is checked before it is decoded.
+type Header struct {
+	// Type names the index: hnsw, lsh, minhash, flat, ivf or ivfpq.
+	Type string
+	// Version is the version of the index's own encoding.
+	Version int
+	// Dim is the dimension of the vectors, or 0 when there are none yet.
+	Dim int
+	// Metric names the distance function.
+	Metric string
+}
+
+// Writer buffers a section of a store file until EndSection writes it out
+// with its length and checksum.
+type Writer struct {
+	w       *bufio.Writer
+	section bytes.Buffer
+	// offset is the number of bytes written to the file so far.
+	offset int64
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	return w.section.Write(p)
+}
+
+// EndSection writes out the bytes written since the previous section.
+func (w *Writer) EndSection() error {
+	var header [sectionHeaderSize]byte
+	byteOrder.PutUint64(header[:8], uint64(w.section.Len()))
+	byteOrder.PutUint32(header[8:], crc32.Checksum(w.section.Bytes(), castagnoli))
+	if _, err := w.w.Write(header[:]); err != nil {
+		return err
+	}
+	n, err := w.section.WriteTo(w.w)
+	w.offset += sectionHeaderSize + n
+	return err
+}
+
+// Align pads the current section with zeros up to the next file offset that
+// is a multiple of n, so that an array written next can be used in place
+// once the file is mapped; see Cursor.
+func (w *Writer) Align(n int) {
+	pos := w.offset + sectionHeaderSize + int64(w.section.Len())
+	w.section.Write(make([]byte, (int64(n)-pos%int64(n))%int64(n)))
+}
+
+// writeHeader writes Magic and the header section.
+func (w *Writer) writeHeader(header Header) error {
+	if _, err := w.w.WriteString(Magic); err != nil {
+		return err
+	}
+	w.offset += int64(len(Magic))
+	buf := binary.AppendUvarint(nil, formatVersion)
+	buf = appendString(buf, header.Type)
+	buf = binary.AppendUvarint(buf, uint64(header.Version))
+	buf = binary.AppendUvarint(buf, uint64(header.Dim))
+	buf = appendString(buf, header.Metric)
+	w.section.Write(buf)
+	return w.EndSection()
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Reader reads a store file section by section. Reads return io.EOF at the
+// end of the current section, until EndSection moves to the next one.
+//
+// Legacy files, written before headers, are read as one unchecked section
+// and their Header is zero.
+type Reader struct {
+	Header Header
+	r      *bufio.Reader
+	// size is the size of the file and left the number of bytes of it not
+	// read yet.
+	size    int64
+	left    int64
+	legacy  bool
+	section bytes.Reader
+}
+
+// NewReader reads the header of the store file of size bytes read from r,
+// checks that it holds an index of type typ encoded with a version up to
+// version, and loads its first section.
+//
+// A file without Magic is read as a legacy file only if legacy, given the
+// first bytes of the file, reports that they match the layout typ had
+// before headers. Otherwise the file is corrupt or holds something else,
+// and NewReader fails. Types without legacy files pass a nil legacy.
+func NewReader(r io.Reader, size int64, typ string, version int, legacy func(prefix []byte) bool) (*Reader, error) {
+	reader := &Reader{r: bufio.NewReader(r), size: size, left: size}
+	magic, _ := reader.r.Peek(len(Magic))
+	if string(magic) != Magic {
+		prefix, _ := reader.r.Peek(int(min(size, legacyPrefixSize)))
+		if legacy == nil || !legacy(prefix) {
+			return nil, fmt.Errorf("no header: not a %s store file", typ)
+		}
+		reader.legacy = true
+		data, err := io.ReadAll(io.LimitReader(reader.r, size))
+		if err != nil {
+			return nil, err
+		}
+		reader.section.Reset(data)
+		return reader, nil
+	}
+	reader.r.Discard(len(Magic))
+	reader.left -= int64(len(Magic))
+
+	if err := reader.next(); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	if err := reader.readHeader(); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	if reader.Header.Type != typ {
+		return nil, fmt.Errorf("file holds a %s index, not %s", reader.Header.Type, typ)
+	}
+	if reader.Header.Version > version {
+		return nil, fmt.Errorf("incompatible %s encoding version: %d", typ, reader.Header.Version)
+	}
+	if err := reader.EndSection(); err != nil {
+		return nil, err
+	}
+	return reader, nil
+}
+
+func (r *Reader) readHeader() error {
+	version, err := binary.ReadUvarint(&r.section)
+	if err != nil {
+		return err
+	}
+	if version != formatVersion {
+		return fmt.Errorf("unsupported format version %d", version)
+	}
+	var indexVersion, dim uint64
+	if r.Header.Type, err = readString(&r.section); err != nil {
+		return err
+	}
+	if indexVersion, err = binary.ReadUvarint(&r.section); err != nil {
+		return err
+	}
+	if dim, err = binary.ReadUvarint(&r.section); err != nil {
+		return err
+	}
+	if r.Header.Metric, err = readString(&r.section); err != nil {
+		return err
+	}
+	if indexVersion > 1<<31 || dim > 1<<31 {
+		return errors.New("invalid header")
+	}
+	r.Header.Version, r.Header.Dim = int(indexVersion), int(dim)
+	return nil
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	if n > uint64(r.Len()) {
+		return "", io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return string(b), err
+}
+
+// Legacy reports whether the file was written before headers existed.
+func (r *Reader) Legacy() bool {
+	return r.legacy
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	return r.section.Read(p)
+}
+
+func (r *Reader) ReadByte() (byte, error) {
+	return r.section.ReadByte()
+}
+
+// Len returns the number of bytes left in the current section.
+func (r *Reader) Len() int {
+	return r.section.Len()
+}
+
+// EndSection checks that the current section was read to its end and loads
+// the next one, if any. It does nothing for legacy files, which have no
+// sections and may end with stale bytes.
+func (r *Reader) EndSection() error {
+	if r.legacy {
+		return nil
+	}
+	if r.section.Len() > 0 {
+		return fmt.Errorf("%d bytes left unread in section", r.section.Len())
+	}
+	return r.next()
+}
+
+// Skip ends the current section like EndSection, but only reads the header
+// of the next one and returns the file offset and size of its payload. It
+// is meant for a last section too large to load, which the caller reads in
+// place, such as with ReadAt; its checksum is left to Verify. The reader
+// must not be used afterwards.
+func (r *Reader) Skip() (offset, size int64, err error) {
+	if r.legacy {
+		return 0, 0, errors.New("legacy files have no sections")
+	}
+	if r.section.Len() > 0 {
+		return 0, 0, fmt.Errorf("%d bytes left unread in section", r.section.Len())
+	}
+	var header [sectionHeaderSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return 0, 0, fmt.Errorf("decoding section header: %w", noEOF(err))
+	}
+	r.left -= sectionHeaderSize
+	size = int64(byteOrder.Uint64(header[:8]))
+	if size < 0 || size > r.left {
+		return 0, 0, fmt.Errorf("section of %d bytes overruns the file: %w", size, io.ErrUnexpectedEOF)
+	}
+	if size < r.left {
+		return 0, 0, fmt.Errorf("%d bytes after the last section", r.left-size)
+	}
+	return r.size - r.left, size, nil
+}
+
+// next loads the next section and checks it against its checksum. At the
+// end of the file it leaves the section empty.
+func (r *Reader) next() error {
+	r.section.Reset(nil)
+	if r.left == 0 {
+		return nil
+	}
+	var header [sectionHeaderSize]byte
+	if _, err := io.ReadFull(r.r, header[:]); err != nil {
+		return fmt.Errorf("decoding section header: %w", noEOF(err))
+	}
+	r.left -= sectionHeaderSize
+	size, sum := byteOrder.Uint64(header[:8]), byteOrder.Uint32(header[8:])
+	if size > uint64(max(r.left, 0)) {
+		return fmt.Errorf("section of %d bytes overruns the file: %w", size, io.ErrUnexpectedEOF)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return noEOF(err)
+	}
+	r.left -= int64(size)
+	if crc32.Checksum(data, castagnoli) != sum {
+		return ErrChecksum
+	}
+	r.section.Reset(data)
+	return nil
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for reads that must not
+// come up empty.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// CheckLen returns an error unless n elements of size bytes each can be
+// read from r: when n is negative, or when r reports fewer bytes left with
+// a Len method, as Reader does. Decoders call it before allocating for a
+// count read from the file, so that a corrupt count fails cleanly.
+func CheckLen(r io.Reader, n, size int64) error {
+	if n < 0 {
+		return fmt.Errorf("invalid length %d", n)
+	}
+	if l, ok := r.(interface{ Len() int }); ok && size > 0 && n > int64(l.Len())/size {
+		return fmt.Errorf("length %d overruns the data: %w", n, io.ErrUnexpectedEOF)
+	}
+	return nil
+}
+
+// Info describes a store file checked by Verify.
+type Info struct {
+	Header Header
+	// Sections lists the size of each section after the header.
+	Sections []int64
+}
+
+// Verify checks the store file at path: its magic, its header and the
+// checksum of every section. It does not decode the index.
+func Verify(path string) (Info, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Info{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return Info{}, err
+	}
+	r, err := openHeader(f, stat.Size())
+	if err != nil {
+		return Info{}, err
+	}
+	info := Info{Header: r.Header}
+	for r.left > 0 {
+		if err := r.next(); err != nil {
+			return info, fmt.Errorf("section %d: %w", len(info.Sections), err)
+		}
+		info.Sections = append(info.Sections, int64(r.section.Len()))
+	}
+	return info, nil
+}
+
+// ReadHeader returns the header of the store file at path, whatever the
+// index it holds, without reading the rest of the file.
+func ReadHeader(path string) (Header, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Header{}, err
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return Header{}, err
+	}
+	r, err := openHeader(f, stat.Size())
+	if err != nil {
+		return Header{}, err
+	}
+	return r.Header, nil
+}
+
+// openHeader checks the magic of the store file of size bytes read from f
+// and decodes its header, leaving the reader before the next section.
+func openHeader(f io.Reader, size int64) (*Reader, error) {
+	r := &Reader{r: bufio.NewReader(f), size: size, left: size}
+	magic := make([]byte, len(Magic))
+	if _, err := io.ReadFull(r.r, magic); err != nil || string(magic) != Magic {
+		return nil, errors.New("no header: not a store file, or written by an older version")
+	}
+	r.left -= int64(len(Magic))
+	if err := r.next(); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	if err := r.readHeader(); err != nil {
+		return nil, fmt.Errorf("decoding header: %w", err)
+	}
+	return r, nil
+}
@@ -0,0 +1,230 @@
+package storefile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// arrayAlign is the file offset alignment of the arrays written by
+// WriteArray, enough for any element type they hold.
+const arrayAlign = 8
+
+// littleEndian reports whether the machine stores numbers in the byte order
+// of the files, in which case mapped arrays are used in place.
+var littleEndian = binary.NativeEndian.Uint16([]byte{1, 0}) == 1
+
+// WriteArray writes a slice of fixed-size numbers to the current section,
+// aligned so that Cursor can use it in place once the file is mapped.
+func (w *Writer) WriteArray(data any) error {
+	w.Align(arrayAlign)
+	return binary.Write(w, byteOrder, data)
+}
+
+// Mapping is a store file mapped read-only into memory. Its sections are
+// read in place through cursors, without decoding the whole file first.
+//
+// Only the header is checked when the file is mapped: checking every
+// section would read the whole file, which is what mapping avoids. Verify
+// checks the rest. A file replaced by Write after it was mapped stays
+// mapped as it was until Close.
+type Mapping struct {
+	Header   Header
+	data     []byte
+	sections []mappedSection
+	unmap    func() error
+}
+
+type mappedSection struct {
+	offset, size int64
+}
+
+// Map maps the store file at path, which must hold an index of type typ
+// encoded with a version up to version.
+func Map(path string, typ string, version int) (*Mapping, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() == 0 {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	data, unmap, err := mapFile(f, info.Size())
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{data: data, unmap: unmap}
+	if err := m.parse(typ, version); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// parse reads the header and locates the sections of the mapped file.
+func (m *Mapping) parse(typ string, version int) error {
+	if len(m.data) < len(Magic) || string(m.data[:len(Magic)]) != Magic {
+		return errors.New("no header: not a store file, or written by an older version")
+	}
+	offset := int64(len(Magic))
+	for offset < int64(len(m.data)) {
+		if int64(len(m.data))-offset < sectionHeaderSize {
+			return fmt.Errorf("decoding section header: %w", io.ErrUnexpectedEOF)
+		}
+		size := byteOrder.Uint64(m.data[offset:])
+		if size > uint64(int64(len(m.data))-offset-sectionHeaderSize) {
+			return fmt.Errorf("section of %d bytes overruns the file: %w", size, io.ErrUnexpectedEOF)
+		}
+		m.sections = append(m.sections, mappedSection{offset: offset + sectionHeaderSize, size: int64(size)})
+		offset += sectionHeaderSize + int64(size)
+	}
+	if len(m.sections) == 0 {
+		return fmt.Errorf("decoding header: %w", io.ErrUnexpectedEOF)
+	}
+
+	header := m.sections[0]
+	payload := m.data[header.offset : header.offset+header.size]
+	if crc32.Checksum(payload, castagnoli) != byteOrder.Uint32(m.data[header.offset-4:]) {
+		return fmt.Errorf("decoding header: %w", ErrChecksum)
+	}
+	r := &Reader{}
+	r.section.Reset(payload)
+	if err := r.readHeader(); err != nil {
+		return fmt.Errorf("decoding header: %w", err)
+	}
+	if r.Header.Type != typ {
+		return fmt.Errorf("file holds a %s index, not %s", r.Header.Type, typ)
+	}
+	if r.Header.Version > version {
+		return fmt.Errorf("incompatible %s encoding version: %d", typ, r.Header.Version)
+	}
+	m.Header = r.Header
+	m.sections = m.sections[1:]
+	return nil
+}
+
+// Sections returns the number of sections after the header.
+func (m *Mapping) Sections() int {
+	return len(m.sections)
+}
+
+// Section returns a cursor at the start of the i-th section after the
+// header, or an error if the file has fewer sections.
+func (m *Mapping) Section(i int) (*Cursor, error) {
+	if i >= len(m.sections) {
+		return nil, fmt.Errorf("missing section %d: %w", i, io.ErrUnexpectedEOF)
+	}
+	s := m.sections[i]
+	return &Cursor{data: m.data[s.offset : s.offset+s.size], offset: s.offset}, nil
+}
+
+// Close unmaps the file. The arrays returned by the cursors of the mapping
+// must not be used afterwards.
+func (m *Mapping) Close() error {
+	if m.unmap == nil {
+		return nil
+	}
+	err := m.unmap()
+	m.data, m.sections, m.unmap = nil, nil, nil
+	return err
+}
+
+// Cursor reads a section of a mapped file. Besides the io.Reader methods,
+// it returns the arrays written by WriteArray as slices of the mapping
+// itself, so that they cost nothing to load.
+type Cursor struct {
+	data []byte
+	// offset is the file offset of data[0], which arrays are aligned to.
+	offset int64
+	pos    int
+}
+
+func (c *Cursor) Read(p []byte) (int, error) {
+	if c.pos >= len(c.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, c.data[c.pos:])
+	c.pos += n
+	return n, nil
+}
+
+func (c *Cursor) ReadByte() (byte, error) {
+	if c.pos >= len(c.data) {
+		return 0, io.EOF
+	}
+	c.pos++
+	return c.data[c.pos-1], nil
+}
+
+// Len returns the number of bytes left in the section.
+func (c *Cursor) Len() int {
+	return len(c.data) - c.pos
+}
+
+// array skips the alignment padding written by WriteArray and returns the
+// next n elements of size bytes each.
+func (c *Cursor) array(n, size int) ([]byte, error) {
+	c.pos += int((arrayAlign - (c.offset+int64(c.pos))%arrayAlign) % arrayAlign)
+	if c.pos > len(c.data) || CheckLen(c, int64(n), int64(size)) != nil {
+		return nil, fmt.Errorf("decoding array: %w", io.ErrUnexpectedEOF)
+	}
+	b := c.data[c.pos : c.pos+n*size : c.pos+n*size]
+	c.pos += n * size
+	return b, nil
+}
+
+// Bytes returns the next n bytes written by WriteArray.
+func (c *Cursor) Bytes(n int) ([]byte, error) {
+	return c.array(n, 1)
+}
+
+// Float32s returns the next n float32s written by WriteArray.
+func (c *Cursor) Float32s(n int) ([]float32, error) {
+	b, err := c.array(n, 4)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	if littleEndian && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*float32)(unsafe.Pointer(&b[0])), n), nil
+	}
+	out := make([]float32, n)
+	_, err = binary.Decode(b, byteOrder, out)
+	return out, err
+}
+
+// Uint32s returns the next n uint32s written by WriteArray.
+func (c *Cursor) Uint32s(n int) ([]uint32, error) {
+	b, err := c.array(n, 4)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	if littleEndian && uintptr(unsafe.Pointer(&b[0]))%4 == 0 {
+		return unsafe.Slice((*uint32)(unsafe.Pointer(&b[0])), n), nil
+	}
+	out := make([]uint32, n)
+	_, err = binary.Decode(b, byteOrder, out)
+	return out, err
+}
+
+// Uint64s returns the next n uint64s written by WriteArray.
+func (c *Cursor) Uint64s(n int) ([]uint64, error) {
+	b, err := c.array(n, 8)
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	if littleEndian && uintptr(unsafe.Pointer(&b[0]))%8 == 0 {
+		return unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), n), nil
+	}
+	out := make([]uint64, n)
+	_, err = binary.Decode(b, byteOrder, out)
+	return out, err
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package storefile
+
+import (
+	"io"
+	"os"
+	"unsafe"
+)
+
+// mapFile reads the first size bytes of f into memory, on systems without
+// mmap. The buffer is allocated as uint64s so that its arrays are aligned
+// like those of a mapping.
+func mapFile(f *os.File, size int64) ([]byte, func() error, error) {
+	words := make([]uint64, (size+7)/8)
+	data := unsafe.Slice((*byte)(unsafe.Pointer(unsafe.SliceData(words))), size)
+	if _, err := io.ReadFull(f, data); err != nil {
+		return nil, nil, err
+	}
+	return data, func() error { return nil }, nil
+}
@@ -0,0 +1,175 @@
+// Package storefile writes the .store files of the indexes atomically.
+//
+// A file is written to a temporary file in the same directory, synced,
+// renamed over the previous version and the directory synced, so a crash
+// at any point leaves either the old file or the new one, never a mix of
+// the two. Previous versions can be kept as numbered snapshots.
+//
+// Every file starts with Magic and a Header naming the index type, its
+// encoding version, dimension and metric, followed by the sections of the
+// index, each checked by its own CRC-32C when read.
+package storefile
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Retention is the number of previous versions of a file that Write keeps,
+// as <path>.1 (the newest) up to <path>.<Retention> (the oldest). Older
+// snapshots are removed. Zero, the default, keeps none.
+var Retention = 0
+
+// Write replaces the file at path with the header followed by the sections
+// write writes to w. Bytes written after the last EndSection form a final
+// section. The file is only replaced once write returned nil and the data
+// reached the disk; otherwise the previous file is left untouched and the
+// error returned.
+func Write(path string, header Header, write func(w *Writer) error) error {
+	return replace(path, Retention, func(out *bufio.Writer) error {
+		w := &Writer{w: out}
+		if err := w.writeHeader(header); err != nil {
+			return err
+		}
+		if err := write(w); err != nil {
+			return err
+		}
+		if w.section.Len() > 0 {
+			return w.EndSection()
+		}
+		return nil
+	})
+}
+
+// WriteFile atomically replaces the file at path with data, like Write but
+// without a header, sections or snapshots, for small files such as the
+// catalog of a data directory.
+func WriteFile(path string, data []byte) error {
+	return replace(path, 0, func(out *bufio.Writer) error {
+		_, err := out.Write(data)
+		return err
+	})
+}
+
+// replace writes a temporary file with write, syncs it and renames it over
+// path, keeping keep snapshots of the previous versions.
+func replace(path string, keep int, write func(out *bufio.Writer) error) (err error) {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	closed := false
+	defer func() {
+		if err != nil {
+			if !closed {
+				tmp.Close()
+			}
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	out := bufio.NewWriter(tmp)
+	if err = write(out); err != nil {
+		return err
+	}
+	if err = out.Flush(); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	closed = true
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	if err = rotate(path, keep); err != nil {
+		return fmt.Errorf("keep snapshot: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// Snapshot returns the path of the n-th previous version of path, 1 being
+// the newest.
+func Snapshot(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Snapshots returns the paths of the snapshots kept for path, newest first.
+func Snapshots(path string) []string {
+	var out []string
+	for n := 1; ; n++ {
+		snapshot := Snapshot(path, n)
+		if _, err := os.Stat(snapshot); err != nil {
+			return out
+		}
+		out = append(out, snapshot)
+	}
+}
+
+// rotate shifts the snapshots of path up by one, dropping those beyond
+// keep, and links the current file as snapshot 1. The current file stays
+// in place until the new version is renamed over it.
+func rotate(path string, keep int) error {
+	for n := len(Snapshots(path)); n >= keep && n > 0; n-- {
+		if err := os.Remove(Snapshot(path, n)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if keep <= 0 {
+		return nil
+	}
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	for n := keep - 1; n >= 1; n-- {
+		if err := os.Rename(Snapshot(path, n), Snapshot(path, n+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	if err := os.Link(path, Snapshot(path, 1)); err != nil {
+		return copyFile(path, Snapshot(path, 1))
+	}
+	return nil
+}
+
+// copyFile copies src to dst, for file systems without hard links.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// syncDir syncs a directory so that a rename in it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
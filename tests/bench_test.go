@@ -0,0 +1,117 @@
+package tests
+
+import (
+	"encoding/binary"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"testing"
+	"vectorDb/bench"
+	"vectorDb/store"
+	"vectorDb/vecio"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeVecs writes vectors in the TEXMEX layout, encoding each component
+// with encode.
+func writeVecs[T any](t *testing.T, path string, vectors [][]T, encode func([]byte, T) []byte) {
+	var data []byte
+	for _, vector := range vectors {
+		data = binary.LittleEndian.AppendUint32(data, uint32(len(vector)))
+		for _, x := range vector {
+			data = encode(data, x)
+		}
+	}
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+func appendFloat32(data []byte, x float32) []byte {
+	return binary.LittleEndian.AppendUint32(data, math.Float32bits(x))
+}
+
+func appendInt32(data []byte, x int32) []byte {
+	return binary.LittleEndian.AppendUint32(data, uint32(x))
+}
+
+func TestTexmexLoaders(t *testing.T) {
+	dir := t.TempDir()
+	vectors := [][]float32{{1, 2, 3}, {4, 5, 6}, {7, 8, 9}}
+	writeVecs(t, filepath.Join(dir, "base.fvecs"), vectors, appendFloat32)
+	read, err := vecio.ReadFvecs(filepath.Join(dir, "base.fvecs"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, vectors, read)
+	read, err = vecio.ReadFvecs(filepath.Join(dir, "base.fvecs"), 2)
+	require.NoError(t, err)
+	assert.Equal(t, vectors[:2], read)
+
+	ids := [][]int32{{2, 0}, {1, 2}}
+	writeVecs(t, filepath.Join(dir, "truth.ivecs"), ids, appendInt32)
+	readIds, err := vecio.ReadIvecs(filepath.Join(dir, "truth.ivecs"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, ids, readIds)
+
+	writeVecs(t, filepath.Join(dir, "base.bvecs"), [][]byte{{0, 128, 255}}, func(data []byte, x byte) []byte {
+		return append(data, x)
+	})
+	read, err = vecio.ReadBvecs(filepath.Join(dir, "base.bvecs"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]float32{{0, 128, 255}}, read)
+
+	// Truncated files and vectors of different dimensions are errors.
+	writeVecs(t, filepath.Join(dir, "mixed.fvecs"), [][]float32{{1, 2}, {1, 2, 3}}, appendFloat32)
+	_, err = vecio.ReadFvecs(filepath.Join(dir, "mixed.fvecs"), 0)
+	assert.Error(t, err)
+	data, err := os.ReadFile(filepath.Join(dir, "base.fvecs"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "truncated.fvecs"), data[:len(data)-2], 0o600))
+	_, err = vecio.ReadFvecs(filepath.Join(dir, "truncated.fvecs"), 0)
+	assert.Error(t, err)
+}
+
+func TestBench(t *testing.T) {
+	dir := t.TempDir()
+	rng := rand.New(rand.NewPCG(1, 2))
+	data := generateClusteredData(rng, 1050, 16, 10)
+	base, queries := data[:1000], data[1000:]
+	neighbors, err := bench.GroundTruth(base, queries, 10, "euclidean")
+	require.NoError(t, err)
+	writeVecs(t, filepath.Join(dir, "base.fvecs"), base, appendFloat32)
+	writeVecs(t, filepath.Join(dir, "queries.fvecs"), queries, appendFloat32)
+	writeVecs(t, filepath.Join(dir, "truth.ivecs"), neighbors, appendInt32)
+
+	dataset, err := bench.LoadDataset(filepath.Join(dir, "base.fvecs"), filepath.Join(dir, "queries.fvecs"), filepath.Join(dir, "truth.ivecs"))
+	require.NoError(t, err)
+	assert.Equal(t, neighbors, dataset.Neighbors)
+	_, err = bench.LoadDataset(filepath.Join(dir, "base.fvecs"), filepath.Join(dir, "truth.ivecs"), "")
+	assert.Error(t, err)
+
+	assert.Equal(t, 0.5, bench.Recall([]string{"1", "7", "3"}, []int32{1, 2, 3, 4, 5, 6}, 4))
+
+	for name, test := range map[string]struct {
+		newStore  func() (store.Store, error)
+		minRecall float64
+	}{
+		"flat": {store.NewFlatStore, 1},
+		"hnsw": {store.NewHnswStore, 0.9},
+		"ivf":  {store.NewIvfStore, 0.5},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, err := test.newStore()
+			require.NoError(t, err)
+			storeName := filepath.Join(dir, name)
+			result, err := bench.Run(s, storeName, dataset, 10, "euclidean")
+			require.NoError(t, err)
+			assert.GreaterOrEqual(t, result.Recall, test.minRecall)
+			assert.Positive(t, result.QPS)
+			assert.LessOrEqual(t, result.P50, result.P99)
+			assert.Positive(t, result.Build)
+			assert.Empty(t, s.ListCollections())
+			matches, err := filepath.Glob(storeName + "_*")
+			require.NoError(t, err)
+			assert.Empty(t, matches)
+		})
+	}
+}
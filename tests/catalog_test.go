@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"vectorDb/catalog"
+	"vectorDb/store"
+	"vectorDb/storefile"
+	"vectorDb/wal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalog(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "data")
+	c, err := catalog.Open(dir)
+	require.NoError(t, err)
+	assert.Empty(t, c.List())
+	assert.Equal(t, filepath.Join(dir, "docs"), c.Path("docs"))
+
+	created := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	docs := catalog.Collection{
+		Name: "docs", Type: "hnsw", Metric: "euclidean", Dim: 768,
+		Params: map[string]string{"m": "32"}, Model: "model", Created: created,
+	}
+	require.NoError(t, c.Put(docs))
+	require.NoError(t, c.Put(catalog.Collection{Name: "words", Type: "minhash", Created: created}))
+	assert.Error(t, c.Put(catalog.Collection{Type: "flat"}))
+	// Names that would put the files outside the data directory are
+	// rejected.
+	for _, name := range []string{"../docs", "a/b", `a\b`, ".."} {
+		assert.Error(t, c.Put(catalog.Collection{Name: name, Type: "flat"}), name)
+		_, err := c.Rename("docs", name)
+		assert.Error(t, err, name)
+	}
+
+	// The catalog is saved on every change.
+	reopened, err := catalog.Open(dir)
+	require.NoError(t, err)
+	found, ok := reopened.Get("docs")
+	require.True(t, ok)
+	assert.Equal(t, docs, found)
+	assert.Equal(t, []string{"docs", "words"}, collectionNames(reopened.List()))
+
+	removed, err := reopened.Remove("words")
+	require.NoError(t, err)
+	assert.True(t, removed)
+	removed, err = reopened.Remove("words")
+	require.NoError(t, err)
+	assert.False(t, removed)
+	reopened, err = catalog.Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"docs"}, collectionNames(reopened.List()))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, catalog.FileName), []byte("{"), 0o600))
+	_, err = catalog.Open(dir)
+	assert.Error(t, err)
+}
+
+func collectionNames(collections []catalog.Collection) []string {
+	names := make([]string, len(collections))
+	for i, collection := range collections {
+		names[i] = collection.Name
+	}
+	return names
+}
+
+func TestRemoveFiles(t *testing.T) {
+	defer func(options *wal.Options) { store.WriteAheadLog = options }(store.WriteAheadLog)
+	store.WriteAheadLog = &wal.Options{Sync: wal.SyncAlways}
+	defer func(n int) { storefile.Retention = n }(storefile.Retention)
+	storefile.Retention = 2
+
+	dir := t.TempDir()
+	// "a_flat" would match a careless glob for the files of "a".
+	for _, name := range []string{"a", "a_flat"} {
+		s, err := store.NewFlatStore()
+		require.NoError(t, err)
+		storeName := filepath.Join(dir, name)
+		for i := range 3 {
+			require.NoError(t, s.Insert(storeName, generateRandomFloat32Array(4), strconv.Itoa(i)))
+			require.NoError(t, s.Save(storeName))
+		}
+		require.NoError(t, s.Insert(storeName, generateRandomFloat32Array(4), "unsaved"))
+	}
+	header, err := storefile.ReadHeader(filepath.Join(dir, "a_flat.store"))
+	require.NoError(t, err)
+	assert.Equal(t, storefile.Header{Type: "flat", Version: header.Version, Dim: 4, Metric: "euclidean"}, header)
+
+	// An hnsw collection named "a" is not a flat one.
+	require.NoError(t, store.RemoveFiles(filepath.Join(dir, "a"), store.Suffixes("hnsw")))
+	_, err = os.Stat(filepath.Join(dir, "a_flat.store"))
+	require.NoError(t, err)
+
+	require.NoError(t, store.RemoveFiles(filepath.Join(dir, "a"), store.Suffixes("flat")))
+	left, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	for i := range left {
+		left[i] = filepath.Base(left[i])
+	}
+	assert.ElementsMatch(t, []string{"a_flat_flat.store", "a_flat_flat.store.1", "a_flat_flat.store.2", "a_flat_flat.wal"}, left)
+}
+
+func TestRenameFiles(t *testing.T) {
+	defer func(options *wal.Options) { store.WriteAheadLog = options }(store.WriteAheadLog)
+	store.WriteAheadLog = &wal.Options{Sync: wal.SyncAlways}
+	defer func(n int) { storefile.Retention = n }(storefile.Retention)
+	storefile.Retention = 2
+
+	dir := t.TempDir()
+	for _, newStore := range []func() (store.Store, error){store.NewFlatStore, store.NewHnswStore} {
+		s, err := newStore()
+		require.NoError(t, err)
+		for i := range 3 {
+			require.NoError(t, s.Insert(filepath.Join(dir, "a"), generateRandomFloat32Array(4), strconv.Itoa(i)))
+			require.NoError(t, s.Save(filepath.Join(dir, "a")))
+		}
+	}
+	files := func() []string {
+		left, err := filepath.Glob(filepath.Join(dir, "*"))
+		require.NoError(t, err)
+		for i := range left {
+			left[i] = filepath.Base(left[i])
+		}
+		return left
+	}
+	before := files()
+
+	// A rename that fails halfway, here on a snapshot, is undone.
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "b_flat.store.1", "taken"), 0o700))
+	assert.Error(t, store.RenameFiles(filepath.Join(dir, "a"), filepath.Join(dir, "b"), store.Suffixes("flat")))
+	assert.ElementsMatch(t, append(before, "b_flat.store.1"), files())
+	require.NoError(t, os.RemoveAll(filepath.Join(dir, "b_flat.store.1")))
+
+	// Only the files of the given type are renamed.
+	require.NoError(t, store.RenameFiles(filepath.Join(dir, "a"), filepath.Join(dir, "b"), store.Suffixes("flat")))
+	assert.ElementsMatch(t, []string{
+		"a_hnsw.store", "a_hnsw.store.1", "a_hnsw.store.2", "a_hnsw.wal",
+		"b_flat.store", "b_flat.store.1", "b_flat.store.2", "b_flat.wal",
+	}, files())
+}
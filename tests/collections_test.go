@@ -0,0 +1,133 @@
+package tests
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectionLifecycle(t *testing.T) {
+	stores := map[string]struct {
+		newStore func() (store.Store, error)
+		metric   string
+		params   map[string]string
+	}{
+		"flat":   {newStore: store.NewFlatStore, metric: "cosine"},
+		"hnsw":   {newStore: store.NewHnswStore, metric: "cosine", params: map[string]string{"m": "8", "efSearch": "32"}},
+		"ivf":    {newStore: store.NewIvfStore, metric: "cosine", params: map[string]string{"nlist": "2", "nprobe": "2"}},
+		"ivfpq":  {newStore: store.NewIvfPqStore, metric: "euclidean", params: map[string]string{"nlist": "2", "subspaces": "4"}},
+		"lsh":    {newStore: store.NewLshStore, metric: "cosine", params: map[string]string{"l": "4", "m": "4"}},
+		"vamana": {newStore: store.NewVamanaStore, metric: "cosine", params: map[string]string{"r": "8", "alpha": "1.5"}},
+		"minhash": {
+			newStore: func() (store.Store, error) { return store.NewMinHashStore() },
+			metric:   "jaccard",
+			params:   map[string]string{"bands": "10", "rows": "2"},
+		},
+	}
+	for name, test := range stores {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			storeName := filepath.Join(dir, "test")
+			s, err := test.newStore()
+			require.NoError(t, err)
+
+			config := store.CollectionConfig{Metric: test.metric, Dim: 8, Params: test.params}
+			require.NoError(t, s.CreateCollection(storeName, config))
+			assert.ErrorIs(t, s.CreateCollection(storeName, config), store.ErrCollectionExists)
+			err = s.CreateCollection(filepath.Join(dir, "other"), store.CollectionConfig{Params: map[string]string{"unknown": "1"}})
+			assert.Error(t, err)
+			assert.Equal(t, []string{storeName}, s.ListCollections())
+
+			// Only Insert and Load create a missing collection.
+			missing := filepath.Join(dir, "missing")
+			if name != "minhash" {
+				_, err = s.Search(missing, generateRandomFloat32Array(8), 1)
+				assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+			}
+			_, err = s.Lookup(missing, nil, "0")
+			assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+			_, err = s.Delete(missing, nil, "0")
+			assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+			assert.ErrorIs(t, s.Save(missing), store.ErrCollectionNotFound)
+			_, err = s.DescribeCollection(missing)
+			assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+
+			embeddings := make([][]float32, 5)
+			for i := range embeddings {
+				embeddings[i] = generateRandomFloat32Array(8)
+				require.NoError(t, s.Insert(storeName, embeddings[i], "key"+strconv.Itoa(i)))
+			}
+			if name != "minhash" {
+				assert.Error(t, s.Insert(storeName, generateRandomFloat32Array(4), "short"))
+			}
+
+			info, err := s.DescribeCollection(storeName)
+			require.NoError(t, err)
+			assert.Equal(t, storeName, info.Name)
+			assert.Equal(t, 5, info.Len)
+			assert.Equal(t, test.params, info.Params)
+			assert.Equal(t, test.metric, info.Metric)
+			if name != "minhash" {
+				assert.Equal(t, 8, info.Dim)
+			}
+
+			// Renaming moves the files and the collection.
+			require.NoError(t, s.Save(storeName))
+			renamed := filepath.Join(dir, "renamed")
+			require.NoError(t, s.RenameCollection(storeName, renamed))
+			assert.Equal(t, []string{renamed}, s.ListCollections())
+			matches, err := filepath.Glob(storeName + "_*")
+			require.NoError(t, err)
+			assert.Empty(t, matches)
+			matches, err = filepath.Glob(renamed + "_*")
+			require.NoError(t, err)
+			assert.NotEmpty(t, matches)
+			_, err = s.Lookup(storeName, embeddings[0], "key0")
+			assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+			_, err = s.Lookup(renamed, embeddings[0], "key0")
+			assert.NoError(t, err)
+
+			reloaded, err := test.newStore()
+			require.NoError(t, err)
+			require.NoError(t, reloaded.Load(renamed))
+			_, err = reloaded.Lookup(renamed, embeddings[1], "key1")
+			assert.NoError(t, err)
+
+			// Dropping deletes them.
+			require.NoError(t, s.DropCollection(renamed))
+			assert.Empty(t, s.ListCollections())
+			matches, err = filepath.Glob(renamed + "_*")
+			require.NoError(t, err)
+			assert.Empty(t, matches)
+			assert.ErrorIs(t, s.DropCollection(renamed), store.ErrCollectionNotFound)
+		})
+	}
+}
+
+func TestMappedCollections(t *testing.T) {
+	storeName := filepath.Join(t.TempDir(), "test")
+	hnswStore, err := store.NewHnswStore()
+	require.NoError(t, err)
+	for i := range 10 {
+		require.NoError(t, hnswStore.Insert(storeName, generateRandomFloat32Array(8), strconv.Itoa(i)))
+	}
+	require.NoError(t, hnswStore.(*store.HnswStore).SaveMapped(storeName))
+
+	mapped, err := store.NewMappedHnswStore()
+	require.NoError(t, err)
+	info, err := mapped.DescribeCollection(storeName)
+	require.NoError(t, err)
+	assert.Equal(t, 10, info.Len)
+	assert.Equal(t, 8, info.Dim)
+	assert.Equal(t, []string{storeName}, mapped.ListCollections())
+
+	_, err = mapped.Search(storeName+"-missing", generateRandomFloat32Array(8), 1)
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+	assert.ErrorIs(t, mapped.CreateCollection("other", store.CollectionConfig{}), store.ErrReadOnly)
+	assert.ErrorIs(t, mapped.DropCollection(storeName), store.ErrReadOnly)
+	assert.ErrorIs(t, mapped.RenameCollection(storeName, "other"), store.ErrReadOnly)
+}
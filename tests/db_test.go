@@ -5,6 +5,7 @@ import (
 	"testing"
 	"vectorDb/db"
 	"vectorDb/mock"
+	"vectorDb/store"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -186,4 +187,28 @@ func TestDbInsert(t *testing.T) {
 }
 
 
-// TODO : Implement testing for Lookup and Search
\ No newline at end of file
+func TestDbTextStore(t *testing.T) {
+
+	t.Run("testing that a text store never calls the embedding client ", func(t *testing.T) {
+		controller := gomock.NewController(t)
+		defer controller.Finish()
+
+		mockClient:=mock.NewMockClient(controller)
+		minHashStore,err:=store.NewMinHashStore()
+		require.NoError(t,err)
+		db:=db.NewVectorDbWithClientAndStore(mockClient,minHashStore)
+
+		testStore:="testStore"
+		err=db.Insert(testStore,"vectordatabase")
+		assert.NoError(t,err)
+		results,err:=db.Search(testStore,"vectordatabase",-1)
+		assert.NoError(t,err)
+		assert.Equal(t,[]string{"vectordatabase"},results)
+		deleted,err:=db.Delete(testStore,"vectordatabase")
+		assert.NoError(t,err)
+		assert.True(t,deleted)
+	})
+}
+
+
+// TODO : Implement testing for Lookup and Search
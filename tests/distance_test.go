@@ -0,0 +1,144 @@
+package tests
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+	"vectorDb/distance"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/ivf"
+	"vectorDb/pq"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDistanceFunctions(t *testing.T) {
+	a := []float32{1, 0, 2}
+	b := []float32{0, 2, 2}
+
+	assert.InDelta(t, 5, distance.SquareDistance(a, b), 1e-6)
+	assert.InDelta(t, 2.2360679, distance.Euclidean(a, b), 1e-6)
+	assert.InDelta(t, 3, distance.Manhattan(a, b), 1e-6)
+	assert.InDelta(t, -4, distance.NegativeInnerProduct(a, b), 1e-6)
+	assert.InDelta(t, 2, distance.Hamming(a, b), 1e-6)
+	// cos = 4 / (sqrt(5) * sqrt(8))
+	assert.InDelta(t, 1-4/(2.2360679*2.8284271), distance.Cosine(a, b), 1e-6)
+	assert.InDelta(t, 1, distance.Cosine(a, []float32{0, 0, 0}), 1e-6)
+
+	// Normalized cosine on unit vectors matches cosine on the originals.
+	assert.InDelta(t, distance.Cosine(a, b), distance.NormalizedCosine(distance.Normalize(a), distance.Normalize(b)), 1e-6)
+	assert.InDelta(t, 1, distance.Dot(distance.Normalize(a), distance.Normalize(a)), 1e-6)
+	assert.Equal(t, []float32{0, 0}, distance.Normalize([]float32{0, 0}))
+}
+
+func TestDistanceLookup(t *testing.T) {
+	for alias, name := range map[string]string{
+		"":       distance.NameEuclidean,
+		"l2":     distance.NameEuclidean,
+		"l2sq":   distance.NameSquareDistance,
+		"l1":     distance.NameManhattan,
+		"ip":     distance.NameInnerProduct,
+		"cosine": distance.NameCosine,
+	} {
+		assert.Equal(t, name, distance.Canonical(alias))
+		fn, ok := distance.Lookup(alias)
+		require.True(t, ok, alias)
+		found, ok := distance.Name(fn)
+		assert.True(t, ok)
+		assert.Equal(t, name, found)
+	}
+	_, ok := distance.Lookup("unknown")
+	assert.False(t, ok)
+	// Old hnsw files ranked by the raw dot product under this name, the
+	// opposite of the inner product metric, so it must not alias it.
+	_, ok = distance.Lookup("dotProduct")
+	assert.False(t, ok)
+	assert.True(t, distance.IsInnerProduct("ip"))
+	assert.True(t, distance.IsInnerProduct("normalizedCosine"))
+	assert.False(t, distance.IsInnerProduct("cosine"))
+}
+
+func TestNormalizedCosineRanksLikeCosine(t *testing.T) {
+	cosine, err := flat.NewFlatIndex("cosine")
+	require.NoError(t, err)
+	normalized, err := flat.NewFlatIndex("normalizedCosine")
+	require.NoError(t, err)
+	graph := hnsw.NewHNSWGraph[string]("normalizedCosine")
+
+	for i := range 500 {
+		// Scale vectors so that only their direction matters.
+		embedding := generateRandomFloat32Array(8)
+		scale := float32(1 + rand.Intn(100))
+		for j := range embedding {
+			embedding[j] *= scale
+		}
+		require.NoError(t, cosine.Insert(strconv.Itoa(i), embedding))
+		require.NoError(t, normalized.Insert(strconv.Itoa(i), embedding))
+		graph.Insert(hnsw.MakeNode(strconv.Itoa(i), embedding))
+	}
+
+	stored, _ := normalized.Lookup("3")
+	assert.InDelta(t, 1, distance.Dot(stored, stored), 1e-5)
+
+	query := generateRandomFloat32Array(8)
+	expected, err := cosine.Search(query, 5)
+	require.NoError(t, err)
+	actual, err := normalized.Search(query, 5)
+	require.NoError(t, err)
+	for i := range expected {
+		assert.Equal(t, expected[i].Key, actual[i].Key)
+		assert.InDelta(t, expected[i].Distance, actual[i].Distance, 1e-5)
+	}
+
+	results := graph.Search(query, 1)
+	require.Len(t, results, 1)
+	assert.InDelta(t, 1, distance.Dot(results[0].Embed, results[0].Embed), 1e-5)
+}
+
+func TestPQInnerProductTable(t *testing.T) {
+	quantizer, err := pq.NewQuantizer(8, 2, 16, "innerProduct")
+	require.NoError(t, err)
+	sample := make([][]float32, 200)
+	for i := range sample {
+		sample[i] = generateRandomFloat32Array(8)
+	}
+	require.NoError(t, quantizer.Train(sample, rand.New(rand.NewSource(1))))
+
+	// Tables hold distances, so the inner product is negated.
+	query := generateRandomFloat32Array(8)
+	table := quantizer.DistanceTable(query)
+	for _, v := range sample[:20] {
+		code := quantizer.Encode(v)
+		assert.InDelta(t, distance.NegativeInnerProduct(query, quantizer.Decode(code)), table.Distance(code), 1e-5)
+	}
+}
+
+func TestIVFPQInnerProduct(t *testing.T) {
+	_, err := ivf.NewIVFPQ(4, 4, 4, "cosine")
+	assert.Error(t, err)
+	_, err = ivf.NewIVFPQ(4, 4, 4, "manhattan")
+	assert.Error(t, err)
+
+	index, err := ivf.NewIVFPQ(4, 4, 4, "innerProduct")
+	require.NoError(t, err)
+	for i := range 1000 {
+		require.NoError(t, index.Add(strconv.Itoa(i), generateRandomFloat32Array(16)))
+	}
+	require.True(t, index.Trained())
+
+	// Reported distances estimate the negated inner product with the
+	// reconstructed vector, and come back nearest first.
+	query := generateRandomFloat32Array(16)
+	results, err := index.Search(query, 10)
+	require.NoError(t, err)
+	require.Len(t, results, 10)
+	for i, result := range results {
+		reconstructed, _ := index.Lookup(result.Key)
+		assert.InDelta(t, distance.NegativeInnerProduct(query, reconstructed), result.Distance, 1e-4)
+		if i > 0 {
+			assert.LessOrEqual(t, results[i-1].Distance, result.Distance)
+		}
+	}
+}
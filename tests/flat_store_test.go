@@ -0,0 +1,74 @@
+package tests
+
+import (
+	"os"
+	"testing"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlatStoreInsertSearch(t *testing.T) {
+	storeName := "test_store"
+	flatStore, err := store.NewFlatStore()
+	assert.NoError(t, err)
+
+	embeddings := make([][]float32, 0)
+	for i := 'a'; i <= 'z'; i++ {
+		embedding := generateRandomFloat32Array(8)
+		embeddings = append(embeddings, embedding)
+		err := flatStore.Insert(storeName, embedding, string(i))
+		assert.NoError(t, err)
+	}
+
+	// Exact search always finds the query vector itself first.
+	results, err := flatStore.Search(storeName, embeddings[7], 3)
+	assert.NoError(t, err)
+	assert.Len(t, results, 3)
+	assert.Equal(t, "h", results[0])
+}
+
+func TestFlatStoreInsertDelete(t *testing.T) {
+	storeName := "test_store"
+	flatStore, err := store.NewFlatStore()
+	assert.NoError(t, err)
+
+	embedding := generateRandomFloat32Array(8)
+	err = flatStore.Insert(storeName, embedding, "a")
+	assert.NoError(t, err)
+
+	found, err := flatStore.Lookup(storeName, nil, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, embedding, found)
+
+	deleted, err := flatStore.Delete(storeName, embedding, "a")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	_, err = flatStore.Lookup(storeName, nil, "a")
+	assert.Error(t, err)
+}
+
+func TestFlatStoreSaveLoad(t *testing.T) {
+	storeName := "test_store"
+	flatStore, err := store.NewFlatStore()
+	assert.NoError(t, err)
+	defer os.Remove(storeName + "_flat" + ".store")
+
+	for i := 'a'; i <= 'e'; i++ {
+		err := flatStore.Insert(storeName, generateRandomFloat32Array(8), string(i))
+		assert.NoError(t, err)
+	}
+	err = flatStore.Save(storeName)
+	assert.NoError(t, err)
+
+	reloaded, err := store.NewFlatStore()
+	assert.NoError(t, err)
+	err = reloaded.Load(storeName)
+	assert.NoError(t, err)
+	for i := 'a'; i <= 'e'; i++ {
+		embedding, err := reloaded.Lookup(storeName, nil, string(i))
+		assert.NoError(t, err)
+		assert.Equal(t, 8, len(embedding))
+	}
+}
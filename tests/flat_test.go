@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"testing"
+	"vectorDb/distance"
+	"vectorDb/flat"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlatInsertLookupDelete(t *testing.T) {
+	index, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+
+	vectors := make(map[string][]float32)
+	for i := 'a'; i <= 'z'; i++ {
+		vectors[string(i)] = generateRandomFloat32Array(8)
+		assert.NoError(t, index.Insert(string(i), vectors[string(i)]))
+	}
+	assert.Equal(t, 26, index.Len())
+	assert.Equal(t, 8, index.Dims())
+	assert.Error(t, index.Insert("bad", generateRandomFloat32Array(4)))
+
+	assert.True(t, index.Delete("c"))
+	assert.False(t, index.Delete("c"))
+	_, present := index.Lookup("c")
+	assert.False(t, present)
+
+	// Deleting moves the last row; every remaining key must still resolve.
+	for key, vector := range vectors {
+		if key == "c" {
+			continue
+		}
+		found, present := index.Lookup(key)
+		assert.True(t, present)
+		assert.Equal(t, vector, found)
+	}
+}
+
+func TestFlatSearchIsExact(t *testing.T) {
+	for _, distName := range []string{"euclidean", "squareDistance", "innerProduct", "cosine", "manhattan"} {
+		t.Run(distName, func(t *testing.T) {
+			index, err := flat.NewFlatIndex(distName)
+			require.NoError(t, err)
+			dFunc, _ := distance.Lookup(distName)
+
+			// Enough vectors to take the parallel path.
+			type scored struct {
+				key  string
+				dist float32
+			}
+			query := generateRandomFloat32Array(16)
+			expected := make([]scored, 0)
+			for i := range 5000 {
+				key := strconv.Itoa(i)
+				vector := generateRandomFloat32Array(16)
+				require.NoError(t, index.Insert(key, vector))
+				expected = append(expected, scored{key, dFunc(query, vector)})
+			}
+			sort.Slice(expected, func(i, j int) bool { return expected[i].dist < expected[j].dist })
+
+			results, err := index.Search(query, 10)
+			require.NoError(t, err)
+			require.Len(t, results, 10)
+			for i, result := range results {
+				assert.Equal(t, expected[i].dist, result.Distance)
+			}
+		})
+	}
+}
+
+func TestFlatSearchFilter(t *testing.T) {
+	index, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+	for i := 'a'; i <= 'z'; i++ {
+		require.NoError(t, index.Insert(string(i), generateRandomFloat32Array(8)))
+	}
+	results, err := index.SearchFilter(generateRandomFloat32Array(8), 5, func(key string) bool {
+		return key < "d"
+	})
+	require.NoError(t, err)
+	assert.Len(t, results, 3)
+	for _, result := range results {
+		assert.Less(t, result.Key, "d")
+	}
+}
+
+func TestFlatSaveLoad(t *testing.T) {
+	index, err := flat.NewFlatIndex("squareDistance")
+	require.NoError(t, err)
+	testFile := "test"
+	defer os.Remove(testFile + "_flat" + ".store")
+
+	for i := 'a'; i <= 'z'; i++ {
+		require.NoError(t, index.Insert(string(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, index.Save(testFile))
+
+	loaded, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(testFile))
+	assert.Equal(t, index.Len(), loaded.Len())
+
+	query := generateRandomFloat32Array(8)
+	expected, _ := index.Search(query, 5)
+	actual, _ := loaded.Search(query, 5)
+	assert.Equal(t, expected, actual)
+}
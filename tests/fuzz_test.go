@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"vectorDb/hnsw"
+	"vectorDb/lsh"
+)
+
+// fuzzLoad fuzzes the decoder behind load with files derived from one saved
+// by save. Whatever the bytes, loading must return, with or without an
+// error, and never panic.
+func fuzzLoad(f *testing.F, suffix string, save, load func(prefix string) error) {
+	prefix := filepath.Join(f.TempDir(), "seed")
+	if err := save(prefix); err != nil {
+		f.Fatal(err)
+	}
+	data, err := os.ReadFile(prefix + suffix)
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(data)
+	f.Add(data[:len(data)/2])
+	// The payload alone looks like a file written before headers.
+	f.Add(data[len(data)/3:])
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		prefix := filepath.Join(t.TempDir(), "fuzz")
+		if err := os.WriteFile(prefix+suffix, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		load(prefix)
+	})
+}
+
+func FuzzHNSWLoad(f *testing.F) {
+	graph := hnsw.NewHNSWGraph[string]("")
+	for i := range 20 {
+		graph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(4)))
+	}
+	graph.Delete("0")
+	fuzzLoad(f, "_hnsw.store", graph.Save, func(prefix string) error {
+		return hnsw.NewHNSWGraph[string]("").Load(prefix)
+	})
+}
+
+func FuzzLSHLoad(f *testing.F) {
+	index := newCosineLsh(f, 4, 2, 2)
+	for i := range 20 {
+		index.Insert(generateRandomFloat32Array(4), strconv.Itoa(i))
+	}
+	fuzzLoad(f, "_lsh.store", index.Save, func(prefix string) error {
+		loaded, err := lsh.NewCosineLsh(4, 2, 2, "euclidean")
+		if err != nil {
+			return err
+		}
+		return loaded.Load(prefix)
+	})
+}
+
+func FuzzMinHashLoad(f *testing.F) {
+	index := lsh.NewMinHashLsh(4, 2, lsh.Shingler{Unit: lsh.ShingleWord, K: 1})
+	for i := range 20 {
+		index.Insert([]string{"doc", strconv.Itoa(i)}, strconv.Itoa(i))
+	}
+	fuzzLoad(f, "_minhash.store", index.Save, func(prefix string) error {
+		return lsh.NewMinHashLsh(4, 2, lsh.Shingler{Unit: lsh.ShingleWord, K: 1}).Load(prefix)
+	})
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"math/rand/v2"
+	"strconv"
+	"testing"
+	"vectorDb/db"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/mock"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestHNSWBulkInsertRecall(t *testing.T) {
+	rng := rand.New(rand.NewPCG(7, 8))
+	data := generateClusteredData(rng, 2000, 16, 20)
+	nodes := make([]hnsw.Node[string], len(data))
+	exact, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+	for i, v := range data {
+		nodes[i] = hnsw.MakeNode(strconv.Itoa(i), v)
+		require.NoError(t, exact.Insert(strconv.Itoa(i), v))
+	}
+
+	sequential := hnsw.NewHNSWGraphWithSeed[string]("", 1)
+	sequential.Insert(nodes...)
+	bulk := hnsw.NewHNSWGraphWithSeed[string]("", 1)
+	var calls, last int
+	bulk.BulkInsert(nodes, hnsw.BulkOptions{Workers: 8, Progress: func(done, total int) {
+		assert.Greater(t, done, last)
+		assert.Equal(t, len(nodes), total)
+		calls++
+		last = done
+	}})
+	assert.Equal(t, len(nodes), last)
+	assert.LessOrEqual(t, calls, 101)
+	assert.Equal(t, len(nodes), bulk.Len())
+
+	queries := generateClusteredData(rng, 100, 16, 20)
+	sequentialRecall := hnswRecall(sequential, exact, queries, 40)
+	bulkRecall := hnswRecall(bulk, exact, queries, 40)
+	t.Logf("recall@10: %.3f sequential, %.3f bulk", sequentialRecall, bulkRecall)
+	assert.Greater(t, bulkRecall, sequentialRecall-0.05)
+	for _, node := range nodes {
+		results := bulk.Search(node.Embed, 1)
+		require.Len(t, results, 1)
+	}
+}
+
+func TestHNSWBulkInsertReplaces(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	old := generateRandomFloat32Array(8)
+	hnswGraph.Insert(hnsw.MakeNode("a", old))
+
+	embeddings := make([][]float32, 100)
+	nodes := make([]hnsw.Node[string], 0, len(embeddings)+2)
+	for i := range embeddings {
+		embeddings[i] = generateRandomFloat32Array(8)
+		nodes = append(nodes, hnsw.MakeNode(strconv.Itoa(i), embeddings[i]))
+	}
+	// The last node of a key wins, whether the key is in the graph already
+	// or repeated in the batch.
+	nodes = append(nodes, hnsw.MakeNode("a", embeddings[1]), hnsw.MakeNode("0", embeddings[2]))
+	hnswGraph.BulkInsert(nodes, hnsw.BulkOptions{})
+
+	assert.Equal(t, 101, hnswGraph.Len())
+	embedding, present := hnswGraph.Lookup("a")
+	assert.True(t, present)
+	assert.Equal(t, embeddings[1], []float32(embedding))
+	embedding, present = hnswGraph.Lookup("0")
+	assert.True(t, present)
+	assert.Equal(t, embeddings[2], []float32(embedding))
+
+	assert.Panics(t, func() {
+		hnswGraph.BulkInsert([]hnsw.Node[string]{hnsw.MakeNode("x", generateRandomFloat32Array(4))}, hnsw.BulkOptions{})
+	})
+}
+
+func TestDbBulkInsert(t *testing.T) {
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	mockClient := mock.NewMockClient(controller)
+	mockClient.EXPECT().Embed(gomock.Any()).DoAndReturn(func(key string) ([]float32, error) {
+		return generateRandomFloat32Array(8), nil
+	}).AnyTimes()
+
+	storeName := "test_store"
+	keys := make([]string, 200)
+	for i := range keys {
+		keys[i] = strconv.Itoa(i)
+	}
+
+	// HnswStore inserts the batch at once.
+	hnswStore, err := store.NewHnswStore()
+	require.NoError(t, err)
+	vectorDb := db.NewVectorDbWithClientAndStore(mockClient, hnswStore)
+	var last int
+	require.NoError(t, vectorDb.BulkInsert(storeName, keys, func(done, total int) { last = done }))
+	assert.Equal(t, len(keys), last)
+	results, err := hnswStore.Search(storeName, generateRandomFloat32Array(8), 10)
+	require.NoError(t, err)
+	assert.Len(t, results, 10)
+	assert.Error(t, hnswStore.(store.BulkInserter).BulkInsert(storeName, nil, keys, nil))
+
+	// Other stores fall back to one insert per key.
+	mockStore := mock.NewMockStore(controller)
+	mockStore.EXPECT().Insert(storeName, gomock.Any(), gomock.Any()).Return(nil).Times(len(keys))
+	vectorDb = db.NewVectorDbWithClientAndStore(mockClient, mockStore)
+	require.NoError(t, vectorDb.BulkInsert(storeName, keys, nil))
+}
@@ -0,0 +1,68 @@
+package tests
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/hnsw"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHNSWValidate(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	report := hnswGraph.Validate()
+	assert.True(t, report.OK())
+	assert.Nil(t, report.Entry)
+	assert.Empty(t, report.Levels)
+
+	for i := range 500 {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	// Deletes and the compactions they trigger keep the graph connected.
+	for i := 0; i < 500; i += 3 {
+		hnswGraph.Delete(strconv.Itoa(i))
+	}
+
+	report = hnswGraph.Validate()
+	assert.True(t, report.OK())
+	require.NotNil(t, report.Entry)
+	require.NotEmpty(t, report.Levels)
+	for i, level := range report.Levels {
+		assert.Equal(t, level.Nodes, level.Reachable, "level %d", i)
+		total := 0
+		for degree, count := range level.Degrees {
+			if level.Nodes > 1 {
+				assert.NotZero(t, degree, "level %d", i)
+			}
+			total += count
+		}
+		assert.Equal(t, level.Nodes, total, "level %d", i)
+	}
+	assert.Equal(t, hnsw.Repaired{}, hnswGraph.Repair())
+}
+
+func TestHnswStoreValidate(t *testing.T) {
+	storeName := "test_check"
+	defer os.Remove(storeName + "_hnsw" + ".store")
+
+	s, err := store.NewHnswStore()
+	require.NoError(t, err)
+	for i := range 100 {
+		require.NoError(t, s.Insert(storeName, generateRandomFloat32Array(8), strconv.Itoa(i)))
+	}
+	require.NoError(t, s.Save(storeName))
+
+	loaded, err := store.NewHnswStore()
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(storeName))
+	report, err := loaded.(*store.HnswStore).Validate(storeName)
+	require.NoError(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, 100, report.Levels[0].Reachable)
+	repaired, err := loaded.(*store.HnswStore).Repair(storeName)
+	require.NoError(t, err)
+	assert.Equal(t, hnsw.Repaired{}, repaired)
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hnswRecall returns the fraction of the exact 10 nearest neighbours of
+// queries that the graph finds when searching with the given ef.
+func hnswRecall(g *hnsw.HNSWGraph[string], exact *flat.FlatIndex, queries [][]float32, ef int) float64 {
+	found, total := 0, 0
+	for _, query := range queries {
+		expected, _ := exact.Search(query, 10)
+		keys := make(map[string]bool)
+		for _, node := range g.SearchEf(query, 10, ef) {
+			keys[node.Key] = true
+		}
+		for _, result := range expected {
+			total++
+			if keys[result.Key] {
+				found++
+			}
+		}
+	}
+	return float64(found) / float64(total)
+}
+
+func TestHNSWSearchEf(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	exact, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+	for i := range 2000 {
+		embedding := generateRandomFloat32Array(16)
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), embedding))
+		require.NoError(t, exact.Insert(strconv.Itoa(i), embedding))
+	}
+
+	// ef below k is raised to k, so k results always come back.
+	query := generateRandomFloat32Array(16)
+	assert.Len(t, hnswGraph.SearchEf(query, 50, 1), 50)
+	assert.Len(t, hnswGraph.Search(query, 50), 50)
+	assert.Empty(t, hnswGraph.SearchEf(query, 0, 10))
+
+	queries := make([][]float32, 50)
+	for i := range queries {
+		queries[i] = generateRandomFloat32Array(16)
+	}
+	low := hnswRecall(hnswGraph, exact, queries, 10)
+	high := hnswRecall(hnswGraph, exact, queries, 200)
+	t.Logf("recall@10: %.3f at ef=10, %.3f at ef=200", low, high)
+	assert.GreaterOrEqual(t, high, low)
+	assert.Greater(t, high, 0.9)
+}
+
+func TestHNSWEfConstructionSaveLoad(t *testing.T) {
+	testFile := "test"
+	defer os.Remove(testFile + "_hnsw" + ".store")
+
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	hnswGraph.EfConstruction = 42
+	hnswGraph.EfSearch = 33
+	for i := range 20 {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, hnswGraph.Save(testFile))
+
+	loaded := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, loaded.Load(testFile))
+	assert.Equal(t, 42, loaded.EfConstruction)
+	assert.Equal(t, 33, loaded.EfSearch)
+	assert.Equal(t, 20, loaded.Len())
+}
+
+func TestHnswStoreCreateSearchEf(t *testing.T) {
+	storeName := "test_store"
+	s, err := store.NewHnswStore()
+	require.NoError(t, err)
+	hnswStore := s.(*store.HnswStore)
+
+	assert.Error(t, hnswStore.CreateCollection(storeName, store.CollectionConfig{Metric: "unknown"}))
+	assert.Error(t, hnswStore.CreateCollection(storeName, store.CollectionConfig{Params: map[string]string{"m": "-1"}}))
+	params := map[string]string{"m": "8", "efConstruction": "50", "efSearch": "10"}
+	require.NoError(t, hnswStore.CreateCollection(storeName, store.CollectionConfig{Params: params}))
+	assert.Error(t, hnswStore.CreateCollection(storeName, store.CollectionConfig{}))
+
+	for i := range 100 {
+		require.NoError(t, hnswStore.Insert(storeName, generateRandomFloat32Array(8), strconv.Itoa(i)))
+	}
+	query := generateRandomFloat32Array(8)
+	_, err = hnswStore.SearchEf(storeName, query, 20, 10)
+	assert.Error(t, err)
+	results, err := hnswStore.SearchEf(storeName, query, 20, 40)
+	assert.NoError(t, err)
+	assert.Len(t, results, 20)
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/hnsw"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// nodeKeys returns the keys of search results in order.
+func nodeKeys(nodes []hnsw.Node[string]) []string {
+	keys := make([]string, len(nodes))
+	for i, node := range nodes {
+		keys[i] = node.Key
+	}
+	return keys
+}
+
+func TestHNSWSearchIsDeterministic(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	for i := range 500 {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	query := generateRandomFloat32Array(8)
+	expected := nodeKeys(hnswGraph.Search(query, 10))
+	for range 20 {
+		assert.Equal(t, expected, nodeKeys(hnswGraph.Search(query, 10)))
+	}
+}
+
+func TestHNSWDeleteEntryPoint(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	embeddings := make([][]float32, 200)
+	for i := range embeddings {
+		embeddings[i] = generateRandomFloat32Array(8)
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), embeddings[i]))
+	}
+
+	// Deleting nodes in any order, the entry point included at some point,
+	// leaves every remaining node findable.
+	for i := range 190 {
+		require.True(t, hnswGraph.Delete(strconv.Itoa(i)))
+		results := hnswGraph.Search(embeddings[199], 1)
+		require.Len(t, results, 1)
+		assert.Equal(t, "199", results[0].Key)
+	}
+	assert.Equal(t, 10, hnswGraph.Len())
+	assert.Equal(t, 8, hnswGraph.Dims())
+
+	for i := 190; i < 200; i++ {
+		require.True(t, hnswGraph.Delete(strconv.Itoa(i)))
+	}
+	assert.Equal(t, 0, hnswGraph.Len())
+	assert.Empty(t, hnswGraph.Search(embeddings[0], 5))
+
+	// The emptied graph accepts new nodes.
+	hnswGraph.Insert(hnsw.MakeNode("a", embeddings[0]))
+	results := hnswGraph.Search(embeddings[0], 5)
+	require.Len(t, results, 1)
+	assert.Equal(t, "a", results[0].Key)
+}
+
+func TestHNSWEntryPointSaveLoad(t *testing.T) {
+	testFile := "test"
+	defer os.Remove(testFile + "_hnsw" + ".store")
+
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	for i := range 300 {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, hnswGraph.Save(testFile))
+
+	loaded := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, loaded.Load(testFile))
+	for range 20 {
+		query := generateRandomFloat32Array(8)
+		assert.Equal(t, nodeKeys(hnswGraph.Search(query, 5)), nodeKeys(loaded.Search(query, 5)))
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHNSWClusteredRecall(t *testing.T) {
+	for _, tc := range []struct {
+		name               string
+		extend, keepPruned bool
+	}{
+		{"heuristic", false, false},
+		{"extendCandidates", true, false},
+		{"keepPrunedConnections", false, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rng := rand.New(rand.NewPCG(1, 2))
+			data := generateClusteredData(rng, 1300, 16, 20)
+			hnswGraph := hnsw.NewHNSWGraph[string]("")
+			hnswGraph.ExtendCandidates = tc.extend
+			hnswGraph.KeepPrunedConnections = tc.keepPruned
+			exact, err := flat.NewFlatIndex("")
+			require.NoError(t, err)
+			for i, v := range data[:1200] {
+				hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), v))
+				require.NoError(t, exact.Insert(strconv.Itoa(i), v))
+			}
+
+			recall := hnswRecall(hnswGraph, exact, data[1200:], 20)
+			t.Logf("recall@10 at ef=20: %.3f", recall)
+			assert.Greater(t, recall, 0.95)
+		})
+	}
+}
+
+func TestHNSWDeleteReplenishesWithMetric(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	data := generateClusteredData(rng, 1000, 16, 10)
+	hnswGraph := hnsw.NewHNSWGraph[string]("cosine")
+	exact, err := flat.NewFlatIndex("cosine")
+	require.NoError(t, err)
+	for i, v := range data {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), v))
+		require.NoError(t, exact.Insert(strconv.Itoa(i), v))
+	}
+	// Deleting half the nodes leaves the rest reachable.
+	for i := 0; i < len(data); i += 2 {
+		assert.True(t, hnswGraph.Delete(strconv.Itoa(i)))
+		assert.True(t, exact.Delete(strconv.Itoa(i)))
+	}
+	assert.Equal(t, 500, hnswGraph.Len())
+
+	queries := generateClusteredData(rng, 50, 16, 10)
+	for _, query := range queries {
+		for _, node := range hnswGraph.Search(query, 10) {
+			key, _ := strconv.Atoi(node.Key)
+			assert.Equal(t, 1, key%2)
+		}
+	}
+	assert.Greater(t, hnswRecall(hnswGraph, exact, queries, 50), 0.9)
+}
+
+func TestHNSWNeighbourSelectionSaveLoad(t *testing.T) {
+	testFile := "test"
+	defer os.Remove(testFile + "_hnsw" + ".store")
+
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	hnswGraph.M0 = 24
+	hnswGraph.ExtendCandidates = true
+	hnswGraph.KeepPrunedConnections = true
+	for i := range 50 {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, hnswGraph.Save(testFile))
+
+	loaded := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, loaded.Load(testFile))
+	assert.Equal(t, 24, loaded.M0)
+	assert.True(t, loaded.ExtendCandidates)
+	assert.True(t, loaded.KeepPrunedConnections)
+	assert.Equal(t, 50, loaded.Len())
+}
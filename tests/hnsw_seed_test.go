@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/hnsw"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildSeededGraph(seed int64, embeddings [][]float32) *hnsw.HNSWGraph[string] {
+	hnswGraph := hnsw.NewHNSWGraphWithSeed[string]("", seed)
+	for i, embedding := range embeddings {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), embedding))
+	}
+	// Replacing and deleting must not introduce map-order dependence either.
+	hnswGraph.Insert(hnsw.MakeNode("3", embeddings[4]))
+	for i := 0; i < len(embeddings); i += 7 {
+		hnswGraph.Delete(strconv.Itoa(i))
+	}
+	return hnswGraph
+}
+
+func TestHNSWSeededBuildIsReproducible(t *testing.T) {
+	first, second := "test_seed_1", "test_seed_2"
+	defer os.Remove(first + "_hnsw" + ".store")
+	defer os.Remove(second + "_hnsw" + ".store")
+
+	embeddings := make([][]float32, 500)
+	for i := range embeddings {
+		embeddings[i] = generateRandomFloat32Array(8)
+	}
+	a := buildSeededGraph(42, embeddings)
+	b := buildSeededGraph(42, embeddings)
+
+	for range 20 {
+		query := generateRandomFloat32Array(8)
+		assert.Equal(t, nodeKeys(a.Search(query, 10)), nodeKeys(b.Search(query, 10)))
+	}
+
+	// Identical graphs serialize to identical files.
+	require.NoError(t, a.Save(first))
+	require.NoError(t, b.Save(second))
+	aBytes, err := os.ReadFile(first + "_hnsw" + ".store")
+	require.NoError(t, err)
+	bBytes, err := os.ReadFile(second + "_hnsw" + ".store")
+	require.NoError(t, err)
+	assert.Equal(t, aBytes, bBytes)
+
+	loaded := hnsw.NewHNSWGraph[string]("")
+	_, seeded := loaded.Seed()
+	assert.False(t, seeded)
+	require.NoError(t, loaded.Load(first))
+	seed, seeded := loaded.Seed()
+	assert.True(t, seeded)
+	assert.Equal(t, int64(42), seed)
+}
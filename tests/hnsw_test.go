@@ -70,10 +70,12 @@ func TestHNSWInsertDelete(t *testing.T) {
 // Tests the Load Functionality
 func TestHNSWLoad(t *testing.T) {
 	hnswGraph := hnsw.NewHNSWGraph[string]("")
-	testFile := "test"
-	defer os.Remove(testFile + "_hnsw" + ".store")
+	testFile := "test_missing"
 	err := hnswGraph.Load(testFile)
 	assert.Equal(t, nil, err)
+	// Loading a graph never saved leaves no file behind.
+	_, err = os.Stat(testFile + "_hnsw" + ".store")
+	assert.ErrorIs(t, err, os.ErrNotExist)
 }
 
 // Tests the Insert and Save Functionality
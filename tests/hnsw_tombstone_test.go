@@ -0,0 +1,188 @@
+package tests
+
+import (
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHNSWTombstones(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	hnswGraph.CompactionThreshold = 0
+	embeddings := make([][]float32, 200)
+	for i := range embeddings {
+		embeddings[i] = generateRandomFloat32Array(8)
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), embeddings[i]))
+	}
+	for i := 0; i < 200; i += 4 {
+		require.True(t, hnswGraph.Delete(strconv.Itoa(i)))
+	}
+	assert.False(t, hnswGraph.Delete("0"))
+	_, present := hnswGraph.Lookup("0")
+	assert.False(t, present)
+
+	stats := hnswGraph.Stats()
+	assert.Equal(t, 150, stats.Nodes)
+	assert.Equal(t, 50, stats.Tombstones)
+	assert.InDelta(t, 0.25, stats.TombstoneRatio, 1e-9)
+	assert.Equal(t, 150, hnswGraph.Len())
+
+	// Tombstones are traversed but never returned, even for their own
+	// embedding.
+	for i := 0; i < 200; i += 4 {
+		results := hnswGraph.Search(embeddings[i], 5)
+		assert.Len(t, results, 5)
+		for _, node := range results {
+			key, _ := strconv.Atoi(node.Key)
+			assert.NotZero(t, key%4)
+		}
+	}
+
+	// A deleted key can be inserted again.
+	hnswGraph.Insert(hnsw.MakeNode("0", embeddings[0]))
+	assert.Equal(t, 49, hnswGraph.Stats().Tombstones)
+	results := hnswGraph.Search(embeddings[0], 1)
+	require.Len(t, results, 1)
+	assert.Equal(t, "0", results[0].Key)
+
+	hnswGraph.Compact()
+	stats = hnswGraph.Stats()
+	assert.Equal(t, 151, stats.Nodes)
+	assert.Zero(t, stats.Tombstones)
+	assert.Equal(t, 1, stats.Compactions)
+	for i := 1; i < 200; i += 4 {
+		results := hnswGraph.Search(embeddings[i], 1)
+		require.Len(t, results, 1)
+		assert.Equal(t, strconv.Itoa(i), results[0].Key)
+	}
+}
+
+func TestHNSWCompactionThreshold(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	hnswGraph.CompactionThreshold = 0.1
+	embeddings := make([][]float32, 100)
+	for i := range embeddings {
+		embeddings[i] = generateRandomFloat32Array(8)
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), embeddings[i]))
+	}
+	for i := range 9 {
+		hnswGraph.Delete(strconv.Itoa(i))
+	}
+	assert.Equal(t, 9, hnswGraph.Stats().Tombstones)
+	assert.Zero(t, hnswGraph.Stats().Compactions)
+
+	// The tenth delete reaches a tenth of the nodes and starts a
+	// compaction in the background, which searches wait for.
+	hnswGraph.Delete("9")
+	for i := range 10 {
+		for _, node := range hnswGraph.Search(embeddings[i], 5) {
+			assert.NotEqual(t, strconv.Itoa(i), node.Key)
+		}
+	}
+	hnswGraph.WaitCompaction()
+	stats := hnswGraph.Stats()
+	assert.Zero(t, stats.Tombstones)
+	assert.Zero(t, stats.TombstoneRatio)
+	assert.Equal(t, 1, stats.Compactions)
+	assert.Equal(t, 90, stats.Nodes)
+}
+
+func TestHNSWCompactionRecall(t *testing.T) {
+	for _, rebuild := range []bool{false, true} {
+		rng := rand.New(rand.NewPCG(5, 6))
+		data := generateClusteredData(rng, 1000, 16, 10)
+		hnswGraph := hnsw.NewHNSWGraphWithSeed[string]("", 1)
+		hnswGraph.RebuildOnCompaction = rebuild
+		exact, err := flat.NewFlatIndex("")
+		require.NoError(t, err)
+		for i, v := range data {
+			hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), v))
+			require.NoError(t, exact.Insert(strconv.Itoa(i), v))
+		}
+		// Deleting most of the graph compacts it several times over.
+		for i := range 800 {
+			require.True(t, hnswGraph.Delete(strconv.Itoa(i)))
+			require.True(t, exact.Delete(strconv.Itoa(i)))
+			hnswGraph.WaitCompaction()
+		}
+		assert.Equal(t, 200, hnswGraph.Len())
+		assert.Greater(t, hnswGraph.Stats().Compactions, 1)
+
+		queries := generateClusteredData(rng, 50, 16, 10)
+		recall := hnswRecall(hnswGraph, exact, queries, 50)
+		t.Logf("rebuild=%v recall@10: %.3f", rebuild, recall)
+		assert.Greater(t, recall, 0.9)
+	}
+}
+
+func TestHNSWTombstonesSaveLoad(t *testing.T) {
+	testFile := "test"
+	defer os.Remove(testFile + "_hnsw" + ".store")
+
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	hnswGraph.CompactionThreshold = 0.5
+	hnswGraph.RebuildOnCompaction = true
+	for i := range 50 {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	for i := range 10 {
+		require.True(t, hnswGraph.Delete(strconv.Itoa(i)))
+	}
+	require.NoError(t, hnswGraph.Save(testFile))
+
+	loaded := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, loaded.Load(testFile))
+	assert.Equal(t, 0.5, loaded.CompactionThreshold)
+	assert.True(t, loaded.RebuildOnCompaction)
+	assert.Equal(t, 40, loaded.Len())
+	assert.Equal(t, 10, loaded.Stats().Tombstones)
+	_, present := loaded.Lookup("0")
+	assert.False(t, present)
+	for range 20 {
+		query := generateRandomFloat32Array(8)
+		assert.Equal(t, nodeKeys(hnswGraph.Search(query, 5)), nodeKeys(loaded.Search(query, 5)))
+	}
+}
+
+func TestHnswStoreStatsCompact(t *testing.T) {
+	storeName := "test_store"
+	s, err := store.NewHnswStore()
+	require.NoError(t, err)
+	hnswStore := s.(*store.HnswStore)
+	for i := range 100 {
+		require.NoError(t, hnswStore.Insert(storeName, generateRandomFloat32Array(8), strconv.Itoa(i)))
+	}
+	for i := range 5 {
+		deleted, err := hnswStore.Delete(storeName, nil, strconv.Itoa(i))
+		require.NoError(t, err)
+		require.True(t, deleted)
+	}
+	stats, err := hnswStore.Stats(storeName)
+	require.NoError(t, err)
+	assert.Equal(t, 95, stats.Nodes)
+	assert.Equal(t, 5, stats.Tombstones)
+
+	require.NoError(t, hnswStore.Compact(storeName))
+	stats, err = hnswStore.Stats(storeName)
+	require.NoError(t, err)
+	assert.Equal(t, 95, stats.Nodes)
+	assert.Zero(t, stats.Tombstones)
+	assert.Equal(t, 1, stats.Compactions)
+
+	// Missing collections are reported, not created.
+	_, err = hnswStore.Stats("missing")
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+	assert.ErrorIs(t, hnswStore.Compact("missing"), store.ErrCollectionNotFound)
+	_, err = hnswStore.Validate("missing")
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+	_, err = hnswStore.Repair("missing")
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+	assert.NotContains(t, hnswStore.ListCollections(), "missing")
+}
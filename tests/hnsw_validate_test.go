@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"encoding/binary"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"testing"
+	"vectorDb/hnsw"
+	"vectorDb/storefile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// hnswFile encodes the files of hnsw.HNSWGraph, so that tests can save
+// graphs the package never builds, such as corrupted ones.
+type hnswFile []byte
+
+func (f hnswFile) int(v int) hnswFile {
+	return binary.AppendVarint(f, int64(v))
+}
+
+func (f hnswFile) bool(v bool) hnswFile {
+	if v {
+		return append(f, 1)
+	}
+	return append(f, 0)
+}
+
+func (f hnswFile) int64(v int64) hnswFile {
+	return binary.LittleEndian.AppendUint64(f, uint64(v))
+}
+
+func (f hnswFile) float64(v float64) hnswFile {
+	return binary.LittleEndian.AppendUint64(f, math.Float64bits(v))
+}
+
+func (f hnswFile) string(s string) hnswFile {
+	return append(f.int(len(s)), s...)
+}
+
+func (f hnswFile) embedding(e []float32) hnswFile {
+	f = f.int(len(e))
+	for _, v := range e {
+		f = binary.LittleEndian.AppendUint32(f, math.Float32bits(v))
+	}
+	return f
+}
+
+// hnswSections encodes the sections of a single level euclidean graph with
+// the given entry point, nodes and links, which are written as they are:
+// the parameters, the level and the tombstones.
+func hnswSections(version int, entry string, embeddings map[string][]float32, links map[string][]string) []hnswFile {
+	// The encoding version, M, Ml, EfSearch, EfConstruction, M0, the
+	// neighbour selection, seed and compaction parameters, the metric, then
+	// Rerank and no compression.
+	params := hnswFile(nil).int(version).int(16).float64(0.25).int(20).int(100).
+		int(0).bool(false).bool(false).bool(false).int64(0).float64(0).bool(false).
+		string("euclidean").int(0).string("")
+	level := hnswFile(nil).int(1).string(entry).int(len(embeddings))
+	for _, key := range slices.Sorted(maps.Keys(embeddings)) {
+		level = level.string(key).embedding(embeddings[key]).int(len(links[key]))
+		for _, neighbour := range links[key] {
+			level = level.string(neighbour)
+		}
+	}
+	tombstones := hnswFile(nil).int(0)
+	return []hnswFile{params, level, tombstones}
+}
+
+// writeHnswGraph saves the graph of hnswSections in the current layout.
+func writeHnswGraph(t *testing.T, storeName string, entry string, embeddings map[string][]float32, links map[string][]string) {
+	header := storefile.Header{Type: "hnsw", Version: 8, Dim: 2, Metric: "euclidean"}
+	err := storefile.Write(storeName+"_hnsw.store", header, func(w *storefile.Writer) error {
+		for _, section := range hnswSections(8, entry, embeddings, links) {
+			if _, err := w.Write(section); err != nil {
+				return err
+			}
+			if err := w.EndSection(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestHNSWRepairCorruptedGraph(t *testing.T) {
+	storeName := "test_corrupted"
+	defer os.Remove(storeName + "_hnsw" + ".store")
+
+	embeddings := map[string][]float32{
+		"a": {0, 0}, "b": {1, 0}, "c": {2, 0}, "d": {3, 0}, "e": {4, 0},
+		// An orphan: a node with every link cut.
+		"f": {10, 10},
+	}
+	links := map[string][]string{
+		"a": {"b"},
+		// b links to d, which doesn't link back.
+		"b": {"a", "c", "d"},
+		// c links to a node that is not in the level.
+		"c": {"b", "d", "missing"},
+		"d": {"c", "e"},
+		"e": {"d"},
+	}
+	writeHnswGraph(t, storeName, "a", embeddings, links)
+
+	graph := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, graph.Load(storeName))
+	report := graph.Validate()
+	assert.False(t, report.OK())
+	require.Len(t, report.Levels, 1)
+	assert.Equal(t, []string{"f"}, report.Levels[0].Orphans)
+	assert.Equal(t, []hnsw.Edge[string]{{From: "b", To: "d"}}, report.Levels[0].Asymmetric)
+	assert.Equal(t, []hnsw.Edge[string]{{From: "c", To: "missing"}}, report.Levels[0].Dangling)
+	assert.Equal(t, 5, report.Levels[0].Reachable)
+	assert.Equal(t, 1, report.Levels[0].Degrees[0])
+
+	repaired := graph.Repair()
+	assert.Equal(t, hnsw.Repaired{Dangling: 1, Asymmetric: 1, Orphans: 1}, repaired)
+	assert.True(t, graph.Validate().OK())
+	results := graph.Search(embeddings["f"], 1)
+	require.Len(t, results, 1)
+	assert.Equal(t, "f", results[0].Key)
+}
+
+// A file that fails to decode past its levels leaves the graph as it was.
+func TestHNSWLoadFailureKeepsGraph(t *testing.T) {
+	storeName := "test_bad_entry"
+	defer os.Remove(storeName + "_hnsw" + ".store")
+	embeddings := map[string][]float32{"a": {0, 0}, "b": {1, 0}}
+	links := map[string][]string{"a": {"b"}, "b": {"a"}}
+	// The entry point is not in the graph.
+	writeHnswGraph(t, storeName, "z", embeddings, links)
+
+	graph := hnsw.NewHNSWGraph[string]("")
+	graph.M = 8
+	graph.Insert(hnsw.MakeNode("x", []float32{5, 5}))
+	require.Error(t, graph.Load(storeName))
+	assert.Equal(t, 1, graph.Len())
+	assert.Equal(t, 8, graph.M)
+	assert.Equal(t, "x", graph.Search([]float32{0, 0}, 1)[0].Key)
+	assert.True(t, graph.Validate().OK())
+}
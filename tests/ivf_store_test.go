@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"os"
+	"testing"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIvfStoreInsertSearchTrain(t *testing.T) {
+	storeName := "test_store"
+	ivfStore, err := store.NewIvfStore()
+	assert.NoError(t, err)
+
+	embeddings := make([][]float32, 0)
+	for i := 'a'; i <= 'z'; i++ {
+		embedding := generateRandomFloat32Array(8)
+		embeddings = append(embeddings, embedding)
+		err := ivfStore.Insert(storeName, embedding, string(i))
+		assert.NoError(t, err)
+	}
+
+	trainer, ok := ivfStore.(store.Trainer)
+	require.True(t, ok)
+	assert.NoError(t, trainer.Train(storeName))
+
+	// The query vector's own list is always probed, so it is found first.
+	results, err := ivfStore.Search(storeName, embeddings[3], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, results)
+}
+
+func TestIvfStoreInsertDelete(t *testing.T) {
+	storeName := "test_store"
+	ivfStore, err := store.NewIvfStore()
+	assert.NoError(t, err)
+
+	embedding := generateRandomFloat32Array(8)
+	err = ivfStore.Insert(storeName, embedding, "a")
+	assert.NoError(t, err)
+
+	found, err := ivfStore.Lookup(storeName, nil, "a")
+	assert.NoError(t, err)
+	assert.Equal(t, embedding, found)
+
+	deleted, err := ivfStore.Delete(storeName, embedding, "a")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	_, err = ivfStore.Lookup(storeName, nil, "a")
+	assert.Error(t, err)
+}
+
+func TestIvfStoreSaveLoad(t *testing.T) {
+	storeName := "test_store"
+	ivfStore, err := store.NewIvfStore()
+	assert.NoError(t, err)
+	defer os.Remove(storeName + "_ivf" + ".store")
+
+	for i := 'a'; i <= 'e'; i++ {
+		err := ivfStore.Insert(storeName, generateRandomFloat32Array(8), string(i))
+		assert.NoError(t, err)
+	}
+	err = ivfStore.Save(storeName)
+	assert.NoError(t, err)
+
+	reloaded, err := store.NewIvfStore()
+	assert.NoError(t, err)
+	err = reloaded.Load(storeName)
+	assert.NoError(t, err)
+	for i := 'a'; i <= 'e'; i++ {
+		embedding, err := reloaded.Lookup(storeName, nil, string(i))
+		assert.NoError(t, err)
+		assert.Equal(t, 8, len(embedding))
+	}
+}
+
+func TestIvfPqStoreInsertSearch(t *testing.T) {
+	storeName := "test_store"
+	ivfPqStore, err := store.NewIvfPqStore()
+	assert.NoError(t, err)
+
+	embeddings := make([][]float32, 0)
+	for i := 'a'; i <= 'z'; i++ {
+		embedding := generateRandomFloat32Array(8)
+		embeddings = append(embeddings, embedding)
+		err := ivfPqStore.Insert(storeName, embedding, string(i))
+		assert.NoError(t, err)
+	}
+
+	// Untrained, the store keeps full vectors and searches exactly.
+	results, err := ivfPqStore.Search(storeName, embeddings[3], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"d"}, results)
+
+	assert.NoError(t, ivfPqStore.(store.Trainer).Train(storeName))
+	results, err = ivfPqStore.Search(storeName, embeddings[3], 26)
+	assert.NoError(t, err)
+	assert.Contains(t, results, "d")
+}
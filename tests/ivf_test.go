@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/flat"
+	"vectorDb/ivf"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIVFUntrainedSearchIsExact(t *testing.T) {
+	index, err := ivf.NewIVFFlat(4, 1, "")
+	require.NoError(t, err)
+	index.TrainSize = 0
+
+	embeddings := make([][]float32, 0)
+	for i := range 50 {
+		embedding := generateRandomFloat32Array(8)
+		embeddings = append(embeddings, embedding)
+		require.NoError(t, index.Add(strconv.Itoa(i), embedding))
+	}
+	assert.False(t, index.Trained())
+
+	results, err := index.Search(embeddings[10], 1)
+	require.NoError(t, err)
+	assert.Equal(t, "10", results[0].Key)
+}
+
+func TestIVFAutoTrain(t *testing.T) {
+	index, err := ivf.NewIVFFlat(4, 4, "")
+	require.NoError(t, err)
+	index.TrainSize = 40
+
+	for i := range 39 {
+		require.NoError(t, index.Add(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	assert.False(t, index.Trained())
+	require.NoError(t, index.Add("39", generateRandomFloat32Array(8)))
+	assert.True(t, index.Trained())
+	assert.Equal(t, 40, index.Len())
+
+	// Vectors keep resolving after they have been moved into inverted lists.
+	for i := range 40 {
+		_, present := index.Lookup(strconv.Itoa(i))
+		assert.True(t, present)
+	}
+	assert.Error(t, index.Train(nil))
+}
+
+func TestIVFFullProbeMatchesFlat(t *testing.T) {
+	index, err := ivf.NewIVFFlat(8, 8, "")
+	require.NoError(t, err)
+	exact, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+
+	for i := range 500 {
+		embedding := generateRandomFloat32Array(16)
+		require.NoError(t, index.Add(strconv.Itoa(i), embedding))
+		require.NoError(t, exact.Insert(strconv.Itoa(i), embedding))
+	}
+	require.True(t, index.Trained())
+
+	query := generateRandomFloat32Array(16)
+	expected, err := exact.Search(query, 10)
+	require.NoError(t, err)
+	actual, err := index.SearchNProbe(query, 10, 8)
+	require.NoError(t, err)
+	require.Len(t, actual, len(expected))
+	for i := range expected {
+		assert.Equal(t, expected[i].Key, actual[i].Key)
+		assert.Equal(t, expected[i].Distance, actual[i].Distance)
+	}
+}
+
+func TestIVFDelete(t *testing.T) {
+	index, err := ivf.NewIVFFlat(4, 4, "")
+	require.NoError(t, err)
+	index.TrainSize = 20
+	for i := range 30 {
+		require.NoError(t, index.Add(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	for i := range 30 {
+		if i%3 == 0 {
+			assert.True(t, index.Delete(strconv.Itoa(i)))
+		}
+	}
+	assert.Equal(t, 20, index.Len())
+	for i := range 30 {
+		_, present := index.Lookup(strconv.Itoa(i))
+		assert.Equal(t, i%3 != 0, present)
+	}
+}
+
+func TestIVFSaveLoad(t *testing.T) {
+	index, err := ivf.NewIVFFlat(4, 2, "")
+	require.NoError(t, err)
+	index.TrainSize = 20
+	testFile := "test"
+	defer os.Remove(testFile + "_ivf" + ".store")
+
+	for i := range 30 {
+		require.NoError(t, index.Add(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, index.Save(testFile))
+
+	loaded, err := ivf.NewIVFFlat(1, 1, "")
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(testFile))
+	assert.Equal(t, index.Len(), loaded.Len())
+	assert.True(t, loaded.Trained())
+	assert.Equal(t, 2, loaded.NProbe)
+
+	query := generateRandomFloat32Array(8)
+	expected, _ := index.Search(query, 5)
+	actual, _ := loaded.Search(query, 5)
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"testing"
+	"vectorDb/distance"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Scalar references the kernels are checked against.
+
+func scalarDot(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += a[i] * b[i]
+	}
+	return sum
+}
+
+func scalarSquareDistance(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		d := a[i] - b[i]
+		sum += d * d
+	}
+	return sum
+}
+
+func scalarManhattan(a, b []float32) float32 {
+	var sum float32
+	for i := range a {
+		sum += float32(math.Abs(float64(a[i] - b[i])))
+	}
+	return sum
+}
+
+func signedRandomVector(rng *rand.Rand, n int) []float32 {
+	v := make([]float32, n)
+	for i := range v {
+		v[i] = rng.Float32()*2 - 1
+	}
+	return v
+}
+
+func TestKernelsMatchScalar(t *testing.T) {
+	kernels := []struct {
+		name      string
+		kernel    distance.Func
+		reference distance.Func
+	}{
+		{"Dot", distance.Dot, scalarDot},
+		{"DotGeneric", distance.DotGeneric, scalarDot},
+		{"SquareDistance", distance.SquareDistance, scalarSquareDistance},
+		{"SquareDistanceGeneric", distance.SquareDistanceGeneric, scalarSquareDistance},
+		{"Manhattan", distance.Manhattan, scalarManhattan},
+		{"ManhattanGeneric", distance.ManhattanGeneric, scalarManhattan},
+	}
+	rng := rand.New(rand.NewSource(1))
+	// Cover every tail length around the unrolled and vector widths.
+	for n := range 130 {
+		a, b := signedRandomVector(rng, n), signedRandomVector(rng, n)
+		for _, k := range kernels {
+			expected := k.reference(a, b)
+			// Summation order differs, so allow a small relative error.
+			assert.InDelta(t, expected, k.kernel(a, b), 1e-5*float64(n+1), "%s n=%d", k.name, n)
+		}
+	}
+}
+
+func TestKernelsOnSubslices(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	a, b := signedRandomVector(rng, 100), signedRandomVector(rng, 100)
+	// Unaligned starts must not matter to the vector loads.
+	for offset := range 8 {
+		x, y := a[offset:offset+67], b[offset:offset+67]
+		assert.InDelta(t, scalarDot(x, y), distance.Dot(x, y), 1e-4)
+		assert.InDelta(t, scalarSquareDistance(x, y), distance.SquareDistance(x, y), 1e-4)
+	}
+	// A longer b is fine; only len(a) elements are read.
+	assert.InDelta(t, scalarDot(a[:10], b), distance.Dot(a[:10], b), 1e-5)
+}
+
+func TestKernelsPanicOnShortInput(t *testing.T) {
+	a, b := make([]float32, 16), make([]float32, 15)
+	assert.Panics(t, func() { distance.Dot(a, b) })
+	assert.Panics(t, func() { distance.SquareDistance(a, b) })
+	assert.Panics(t, func() { distance.DotGeneric(a, b) })
+	assert.Panics(t, func() { distance.Manhattan(a, b) })
+}
+
+func TestKernelImplementation(t *testing.T) {
+	assert.Contains(t, []string{"avx2", "generic"}, distance.Implementation())
+}
+
+func BenchmarkDistanceKernels(b *testing.B) {
+	kernels := []struct {
+		name   string
+		kernel distance.Func
+	}{
+		{"Dot/scalar", scalarDot},
+		{"Dot/generic", distance.DotGeneric},
+		{"Dot/" + distance.Implementation(), distance.Dot},
+		{"SquareDistance/scalar", scalarSquareDistance},
+		{"SquareDistance/generic", distance.SquareDistanceGeneric},
+		{"SquareDistance/" + distance.Implementation(), distance.SquareDistance},
+		{"Manhattan/scalar", scalarManhattan},
+		{"Manhattan/generic", distance.ManhattanGeneric},
+		{"Cosine", distance.Cosine},
+	}
+	rng := rand.New(rand.NewSource(1))
+	for _, dims := range []int{128, 768, 1536} {
+		x, y := signedRandomVector(rng, dims), signedRandomVector(rng, dims)
+		for _, k := range kernels {
+			b.Run(fmt.Sprintf("%s/%d", k.name, dims), func(b *testing.B) {
+				b.SetBytes(int64(8 * dims))
+				var sink float32
+				for range b.N {
+					sink += k.kernel(x, y)
+				}
+				_ = sink
+			})
+		}
+	}
+}
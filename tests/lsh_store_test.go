@@ -2,10 +2,12 @@ package tests
 
 import (
 	"os"
+	"path/filepath"
 	"testing"
 	"vectorDb/store"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 // Helper function to create a test store with a named index
@@ -178,3 +180,30 @@ func TestLshStoreMultipleStores(t *testing.T) {
 	_, err = lshStore.Lookup(store2, embeddings1[0], "a")
 	assert.Error(t, err)
 }
+
+// Tests that loading a collection never saved creates neither a file nor
+// the collection
+func TestLshStoreLoadMissing(t *testing.T) {
+	storeName := filepath.Join(t.TempDir(), "test")
+	lshStore, err := store.NewLshStore()
+	require.NoError(t, err)
+
+	err = lshStore.Load(storeName)
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+	assert.NoFileExists(t, storeName+"_lsh.store")
+	assert.Empty(t, lshStore.ListCollections())
+}
+
+// Tests that a file that fails to load leaves the store without the
+// collection
+func TestLshStoreLoadFailure(t *testing.T) {
+	storeName := filepath.Join(t.TempDir(), "test")
+	require.NoError(t, os.WriteFile(storeName+"_lsh.store", []byte("not an lsh index"), 0o600))
+	lshStore, err := store.NewLshStore()
+	require.NoError(t, err)
+
+	assert.Error(t, lshStore.Load(storeName))
+	assert.Empty(t, lshStore.ListCollections())
+	_, err = lshStore.Search(storeName, generateRandomFloat32Array(20), 1)
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+}
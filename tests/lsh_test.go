@@ -1,15 +1,30 @@
 package tests
 
 import (
+	"io/fs"
 	"os"
 	"testing"
 	"vectorDb/lsh"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// newCosineLsh returns an empty euclidean CosineLsh.
+func newCosineLsh(tb testing.TB, dim, l, m int32) *lsh.CosineLsh {
+	tb.Helper()
+	index, err := lsh.NewCosineLsh(dim, l, m, "euclidean")
+	require.NoError(tb, err)
+	return index
+}
+
+func TestLSHUnknownDistance(t *testing.T) {
+	_, err := lsh.NewCosineLsh(4, 2, 2, "chebyshev")
+	assert.ErrorContains(t, err, `unknown distance function "chebyshev"`)
+}
+
 func TestLSHInsert(t *testing.T) {
-	lshIndex:=lsh.NewCosineLsh(20,15,15,"euclidean")
+	lshIndex:=newCosineLsh(t, 20, 15, 15)
 	embedding := generateRandomFloat32Array(20)
 	lshIndex.Insert(embedding,"a")
 	present:=lshIndex.Lookup(embedding,"a")
@@ -17,7 +32,7 @@ func TestLSHInsert(t *testing.T) {
 }
 
 func TestLSHDelete(t *testing.T) {
-	lshIndex:=lsh.NewCosineLsh(20,15,15,"euclidean")
+	lshIndex:=newCosineLsh(t, 20, 15, 15)
 	embedding := generateRandomFloat32Array(20)
 	lshIndex.Insert(embedding,"a")
 	present:=lshIndex.Lookup(embedding,"a")
@@ -29,38 +44,35 @@ func TestLSHDelete(t *testing.T) {
 }
 
 func TestLSHLoad(t *testing.T) {
-	lshIndex:=lsh.NewCosineLsh(20,15,15,"euclidean")
+	lshIndex:=newCosineLsh(t, 20, 15, 15)
 	testFile := "test"
 	defer os.Remove(testFile + "_lsh" + ".store")
 	err:=lshIndex.Load(testFile)
-	assert.Equal(t,nil,err)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.NoFileExists(t, testFile+"_lsh"+".store")
 }
 
 func TestLSHSave(t *testing.T) {
-	lshIndex:=lsh.NewCosineLsh(20,15,15,"euclidean")
+	lshIndex:=newCosineLsh(t, 20, 15, 15)
 	testFile := "test"
 	defer os.Remove(testFile + "_lsh" + ".store")
-	err:=lshIndex.Load(testFile)
-	assert.Equal(t,nil,err)
 	embedding := generateRandomFloat32Array(20)
 	lshIndex.Insert(embedding,"a")
-	err=lshIndex.Save(testFile)
+	err:=lshIndex.Save(testFile)
 	assert.Equal(t,nil,err)
 }
 
 func TestLSHSaveAndThenLoad1 (t *testing.T) {
-	lshIndex:=lsh.NewCosineLsh(20,15,15,"euclidean")
+	lshIndex:=newCosineLsh(t, 20, 15, 15)
 	testFile := "test"
 	defer os.Remove(testFile + "_lsh" + ".store")
-	err:=lshIndex.Load(testFile)
-	assert.Equal(t,nil,err)
 	embeddings:=make([][]float32,0)
 	for i := 'a'; i <= 'z'; i++ {
 		embedding := generateRandomFloat32Array(8)
 		embeddings = append(embeddings,embedding )
 		lshIndex.Insert(embedding,string(i))
 	}
-	err=lshIndex.Save(testFile)
+	err:=lshIndex.Save(testFile)
 	assert.Equal(t,nil,err)
 
 	for i := 'a'; i <= 'z'; i++ {
@@ -87,14 +99,12 @@ func TestLSHSaveAndThenLoad1 (t *testing.T) {
 
 
 func TestLSHSaveAndThenLoad2 (t *testing.T) {
-	lshIndex:=lsh.NewCosineLsh(20,15,15,"euclidean")
+	lshIndex:=newCosineLsh(t, 20, 15, 15)
 	testFile := "test"
 	defer os.Remove(testFile + "_lsh" + ".store")
-	err:=lshIndex.Load(testFile)
-	assert.Equal(t,nil,err)
 	embedding := generateRandomFloat32Array(20)
 	lshIndex.Insert(embedding,"a")
-	err=lshIndex.Save(testFile)
+	err:=lshIndex.Save(testFile)
 	assert.Equal(t,nil,err)
 
 	lshIndex.Delete(embedding,"a")
@@ -0,0 +1,226 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMappedGraphMatchesGraph(t *testing.T) {
+	for _, metric := range []string{"euclidean", "cosine"} {
+		t.Run(metric, func(t *testing.T) {
+			graph := hnsw.NewHNSWGraphWithSeed[string](metric, 1)
+			graph.CompactionThreshold = 0
+			for i := range 500 {
+				graph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(16)))
+			}
+			for i := 0; i < 500; i += 7 {
+				graph.Delete(strconv.Itoa(i))
+			}
+
+			prefix := filepath.Join(t.TempDir(), "test")
+			require.NoError(t, hnsw.SaveMapped(graph, prefix))
+			mapped, err := hnsw.OpenMapped(prefix)
+			require.NoError(t, err)
+			defer mapped.Close()
+
+			assert.Equal(t, graph.Len(), mapped.Len())
+			assert.Equal(t, graph.Dims(), mapped.Dims())
+			for i := range 500 {
+				key := strconv.Itoa(i)
+				expected, ok := graph.Lookup(key)
+				found, mappedOk := mapped.Lookup(key)
+				assert.Equal(t, ok, mappedOk, key)
+				assert.Equal(t, expected, found, key)
+			}
+
+			// The mapped graph walks the same links in the same order, so
+			// it finds exactly the same nodes.
+			for range 20 {
+				query := generateRandomFloat32Array(16)
+				assert.Equal(t, nodeKeys(graph.Search(query, 10)), nodeKeys(mapped.Search(query, 10)))
+				assert.Equal(t, nodeKeys(graph.SearchEf(query, 5, 50)), nodeKeys(mapped.SearchEf(query, 5, 50)))
+				assert.Equal(t, nodeKeys(graph.SearchRange(query, 1, 0)), nodeKeys(mapped.SearchRange(query, 1, 0)))
+			}
+
+			// Converting back gives a graph that searches and saves like the
+			// original, and can be modified again.
+			thawed := mapped.Graph()
+			require.NoError(t, mapped.Close())
+			assert.Equal(t, graph.Stats(), thawed.Stats())
+			for range 20 {
+				query := generateRandomFloat32Array(16)
+				assert.Equal(t, nodeKeys(graph.Search(query, 10)), nodeKeys(thawed.Search(query, 10)))
+			}
+			assert.True(t, thawed.Validate().OK())
+			require.NoError(t, graph.Save(prefix+"_original"))
+			require.NoError(t, thawed.Save(prefix+"_thawed"))
+			original, err := os.ReadFile(prefix + "_original_hnsw.store")
+			require.NoError(t, err)
+			saved, err := os.ReadFile(prefix + "_thawed_hnsw.store")
+			require.NoError(t, err)
+			assert.Equal(t, original, saved)
+			thawed.Insert(hnsw.MakeNode("new", generateRandomFloat32Array(16)))
+			assert.Equal(t, graph.Len()+1, thawed.Len())
+		})
+	}
+}
+
+func TestMappedGraphEmpty(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	require.NoError(t, hnsw.SaveMapped(hnsw.NewHNSWGraph[string](""), prefix))
+	mapped, err := hnsw.OpenMapped(prefix)
+	require.NoError(t, err)
+	defer mapped.Close()
+	assert.Zero(t, mapped.Len())
+	assert.Empty(t, mapped.Search(generateRandomFloat32Array(4), 3))
+	assert.Zero(t, mapped.Graph().Len())
+}
+
+func TestMappedFlatIndexMatchesIndex(t *testing.T) {
+	index, err := flat.NewFlatIndex("cosine")
+	require.NoError(t, err)
+	for i := range 300 {
+		require.NoError(t, index.Insert(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	index.Delete("42")
+
+	prefix := filepath.Join(t.TempDir(), "test")
+	require.NoError(t, index.SaveMapped(prefix))
+	mapped, err := flat.OpenMapped(prefix)
+	require.NoError(t, err)
+	defer mapped.Close()
+
+	assert.Equal(t, index.Len(), mapped.Len())
+	assert.Equal(t, index.Dims(), mapped.Dims())
+	for i := range 300 {
+		key := strconv.Itoa(i)
+		expected, ok := index.Lookup(key)
+		found, mappedOk := mapped.Lookup(key)
+		assert.Equal(t, ok, mappedOk, key)
+		assert.Equal(t, expected, found, key)
+	}
+	for range 10 {
+		query := generateRandomFloat32Array(8)
+		expected, err := index.Search(query, 10)
+		require.NoError(t, err)
+		found, err := mapped.Search(query, 10)
+		require.NoError(t, err)
+		assert.Equal(t, expected, found)
+	}
+	_, err = mapped.Search(generateRandomFloat32Array(3), 10)
+	assert.Error(t, err)
+
+	thawed := mapped.Index()
+	require.NoError(t, mapped.Close())
+	query := generateRandomFloat32Array(8)
+	expected, _ := index.Search(query, 10)
+	found, _ := thawed.Search(query, 10)
+	assert.Equal(t, expected, found)
+	require.NoError(t, thawed.Insert("new", generateRandomFloat32Array(8)))
+	assert.Equal(t, index.Len()+1, thawed.Len())
+}
+
+func TestMappedFilesFailCleanly(t *testing.T) {
+	graph := hnsw.NewHNSWGraph[string]("")
+	index, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+	for i := range 50 {
+		vector := generateRandomFloat32Array(8)
+		graph.Insert(hnsw.MakeNode(strconv.Itoa(i), vector))
+		require.NoError(t, index.Insert(strconv.Itoa(i), vector))
+	}
+	prefix := filepath.Join(t.TempDir(), "test")
+	require.NoError(t, hnsw.SaveMapped(graph, prefix))
+	require.NoError(t, index.SaveMapped(prefix))
+
+	open := map[string]func() error{
+		"_hnswmap.store": func() error {
+			mapped, err := hnsw.OpenMapped(prefix)
+			if err == nil {
+				mapped.Search(generateRandomFloat32Array(8), 5)
+				mapped.Close()
+			}
+			return err
+		},
+		"_flatmap.store": func() error {
+			mapped, err := flat.OpenMapped(prefix)
+			if err == nil {
+				mapped.Search(generateRandomFloat32Array(8), 5)
+				mapped.Close()
+			}
+			return err
+		},
+	}
+	for suffix, open := range open {
+		data, err := os.ReadFile(prefix + suffix)
+		require.NoError(t, err)
+		for n := 0; n < len(data); n += 7 {
+			require.NoError(t, os.WriteFile(prefix+suffix, data[:n], 0o600))
+			assert.Error(t, open(), "%s truncated to %d bytes", suffix, n)
+		}
+		// A mutable store file is not a mapped one.
+		require.NoError(t, graph.Save(prefix))
+		require.NoError(t, os.Rename(prefix+"_hnsw.store", prefix+suffix))
+		assert.ErrorContains(t, open(), "file holds a hnsw index")
+	}
+}
+
+func TestMappedStores(t *testing.T) {
+	stores := map[string]struct {
+		mutable func() (store.Store, error)
+		mapped  func() (store.Store, error)
+	}{
+		"hnsw": {store.NewHnswStore, store.NewMappedHnswStore},
+		"flat": {store.NewFlatStore, store.NewMappedFlatStore},
+	}
+	for name, stores := range stores {
+		t.Run(name, func(t *testing.T) {
+			storeName := filepath.Join(t.TempDir(), "test")
+			mutable, err := stores.mutable()
+			require.NoError(t, err)
+			embeddings := make(map[string][]float32)
+			for i := range 100 {
+				key := strconv.Itoa(i)
+				embeddings[key] = generateRandomFloat32Array(8)
+				require.NoError(t, mutable.Insert(storeName, embeddings[key], key))
+			}
+			require.NoError(t, mutable.(store.Freezer).SaveMapped(storeName))
+
+			mapped, err := stores.mapped()
+			require.NoError(t, err)
+			query := generateRandomFloat32Array(8)
+			expected, err := mutable.Search(storeName, query, 5)
+			require.NoError(t, err)
+			found, err := mapped.Search(storeName, query, 5)
+			require.NoError(t, err)
+			assert.Equal(t, expected, found)
+			vector, err := mapped.Lookup(storeName, nil, "7")
+			require.NoError(t, err)
+			assert.Equal(t, embeddings["7"], vector)
+			_, err = mapped.Search(storeName, generateRandomFloat32Array(3), 5)
+			assert.Error(t, err)
+
+			assert.ErrorIs(t, mapped.Insert(storeName, query, "new"), store.ErrReadOnly)
+			_, err = mapped.Delete(storeName, nil, "7")
+			assert.ErrorIs(t, err, store.ErrReadOnly)
+			assert.ErrorIs(t, mapped.Save(storeName), store.ErrReadOnly)
+
+			// Thawing saves the collection for the mutable store.
+			require.NoError(t, mapped.(store.Thawer).Thaw(storeName))
+			reloaded, err := stores.mutable()
+			require.NoError(t, err)
+			require.NoError(t, reloaded.Load(storeName))
+			found, err = reloaded.Search(storeName, query, 5)
+			require.NoError(t, err)
+			assert.Equal(t, expected, found)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"os"
+	"testing"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMinHashStoreInsertSearch(t *testing.T) {
+	storeName := "test_store"
+	minHashStore, err := store.NewMinHashStore()
+	assert.NoError(t, err)
+
+	for _, key := range []string{"vectordatabase", "vectordatabases", "hashtable"} {
+		err := minHashStore.Insert(storeName, nil, key)
+		assert.NoError(t, err)
+	}
+
+	results, err := minHashStore.SearchText(storeName, "vectordatabase", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"vectordatabase", "vectordatabases"}, results)
+
+	_, err = minHashStore.Search(storeName, generateRandomFloat32Array(8), 2)
+	assert.Error(t, err)
+}
+
+func TestMinHashStoreLookupDelete(t *testing.T) {
+	storeName := "test_store"
+	minHashStore, err := store.NewMinHashStore()
+	assert.NoError(t, err)
+
+	err = minHashStore.Insert(storeName, nil, "a")
+	assert.NoError(t, err)
+	_, err = minHashStore.Lookup(storeName, nil, "a")
+	assert.NoError(t, err)
+
+	deleted, err := minHashStore.Delete(storeName, nil, "a")
+	assert.NoError(t, err)
+	assert.True(t, deleted)
+
+	_, err = minHashStore.Lookup(storeName, nil, "a")
+	assert.Error(t, err)
+}
+
+func TestMinHashStoreSaveLoad(t *testing.T) {
+	storeName := "test_store"
+	minHashStore, err := store.NewMinHashStore()
+	assert.NoError(t, err)
+	defer os.Remove(storeName + "_minhash" + ".store")
+
+	for i := 'a'; i <= 'e'; i++ {
+		err := minHashStore.Insert(storeName, nil, "key"+string(i))
+		assert.NoError(t, err)
+	}
+	err = minHashStore.Save(storeName)
+	assert.NoError(t, err)
+
+	reloaded, err := store.NewMinHashStore()
+	assert.NoError(t, err)
+	err = reloaded.Load(storeName)
+	assert.NoError(t, err)
+	for i := 'a'; i <= 'e'; i++ {
+		_, err := reloaded.Lookup(storeName, nil, "key"+string(i))
+		assert.NoError(t, err)
+	}
+}
+
+func TestMinHashStoreLoadMissing(t *testing.T) {
+	storeName := "test_missing"
+	minHashStore, err := store.NewMinHashStore()
+	assert.NoError(t, err)
+	defer os.Remove(storeName + "_minhash" + ".store")
+
+	err = minHashStore.Load(storeName)
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+	assert.NoFileExists(t, storeName+"_minhash"+".store")
+	assert.Empty(t, minHashStore.ListCollections())
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"io/fs"
+	"os"
+	"testing"
+	"vectorDb/lsh"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShingles(t *testing.T) {
+	words := lsh.Shingler{Unit: lsh.ShingleWord, K: 2}
+	assert.Equal(t, []string{"the quick", "quick fox"}, words.Shingles("The quick  fox"))
+	assert.Equal(t, []string{"fox"}, words.Shingles("fox"))
+
+	chars := lsh.Shingler{Unit: lsh.ShingleChar, K: 3}
+	assert.Equal(t, []string{"aba", "bab"}, chars.Shingles("ababa"))
+	assert.Nil(t, chars.Shingles("   "))
+}
+
+func TestMinHashInsertLookupDelete(t *testing.T) {
+	index := lsh.NewMinHashLsh(20, 5, lsh.Shingler{Unit: lsh.ShingleWord, K: 1})
+	index.Insert([]string{"a", "b", "c"}, "doc")
+	assert.True(t, index.Lookup("doc"))
+	assert.Equal(t, 1, index.Len())
+
+	assert.True(t, index.Delete("doc"))
+	assert.False(t, index.Lookup("doc"))
+	assert.False(t, index.Delete("doc"))
+	assert.Empty(t, index.Search([]string{"a", "b", "c"}, 0))
+}
+
+func TestMinHashSearch(t *testing.T) {
+	index := lsh.NewMinHashLsh(20, 5, lsh.Shingler{Unit: lsh.ShingleWord, K: 3})
+	index.InsertText("the quick brown fox jumps over the lazy dog near the river bank", "original")
+	index.InsertText("the quick brown fox jumps over the lazy dog near the river bend", "near-duplicate")
+	index.InsertText("completely unrelated text about vector databases and indexes", "other")
+
+	results := index.SearchText("the quick brown fox jumps over the lazy dog near the river bank", 0)
+	assert.NotEmpty(t, results)
+	assert.Equal(t, "original", results[0].Key)
+	assert.Equal(t, float32(1), results[0].Similarity)
+	for _, result := range results {
+		assert.NotEqual(t, "other", result.Key)
+	}
+
+	// Re-inserting a key replaces its set.
+	index.InsertText("completely unrelated text about vector databases and indexes", "original")
+	results = index.SearchText("completely unrelated text about vector databases and indexes", 1)
+	assert.Len(t, results, 1)
+	assert.Equal(t, float32(1), results[0].Similarity)
+	assert.Equal(t, 3, index.Len())
+}
+
+func TestMinHashSaveLoad(t *testing.T) {
+	index := lsh.NewMinHashLsh(10, 4, lsh.Shingler{Unit: lsh.ShingleChar, K: 3})
+	testFile := "test"
+	defer os.Remove(testFile + "_minhash" + ".store")
+
+	for i := 'a'; i <= 'z'; i++ {
+		index.InsertText("document "+string(i)+string(i)+string(i), string(i))
+	}
+	err := index.Save(testFile)
+	assert.Equal(t, nil, err)
+
+	loaded := lsh.NewMinHashLsh(1, 1, lsh.Shingler{})
+	err = loaded.Load(testFile)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, index.Len(), loaded.Len())
+
+	// The hash functions and shingler are restored, so queries behave the same.
+	assert.Equal(t, index.SearchText("document qqq", 3), loaded.SearchText("document qqq", 3))
+}
+
+func TestMinHashLoadMissing(t *testing.T) {
+	index := lsh.NewMinHashLsh(10, 4, lsh.Shingler{Unit: lsh.ShingleChar, K: 3})
+	testFile := "test_missing"
+	defer os.Remove(testFile + "_minhash" + ".store")
+
+	err := index.Load(testFile)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	assert.NoFileExists(t, testFile+"_minhash"+".store")
+}
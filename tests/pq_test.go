@@ -0,0 +1,195 @@
+package tests
+
+import (
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"testing"
+	"vectorDb/distance"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/ivf"
+	"vectorDb/pq"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPQEncodeDecode(t *testing.T) {
+	_, err := pq.NewQuantizer(10, 3, 256, "")
+	assert.Error(t, err)
+
+	quantizer, err := pq.NewQuantizer(16, 4, 256, "")
+	require.NoError(t, err)
+	assert.False(t, quantizer.Trained())
+
+	sample := make([][]float32, 1000)
+	for i := range sample {
+		sample[i] = generateRandomFloat32Array(16)
+	}
+	require.NoError(t, quantizer.Train(sample, rand.New(rand.NewSource(1))))
+	assert.True(t, quantizer.Trained())
+
+	// Reconstructions are much closer to the originals than random vectors.
+	var reconstructionError, baselineError float32
+	for _, v := range sample[:100] {
+		code := quantizer.Encode(v)
+		assert.Len(t, code, 4)
+		reconstructionError += distance.SquareDistance(v, quantizer.Decode(code))
+		baselineError += distance.SquareDistance(v, generateRandomFloat32Array(16))
+	}
+	assert.Less(t, reconstructionError, baselineError/4)
+}
+
+func TestPQDistanceTable(t *testing.T) {
+	quantizer, err := pq.NewQuantizer(8, 2, 16, "")
+	require.NoError(t, err)
+	sample := make([][]float32, 200)
+	for i := range sample {
+		sample[i] = generateRandomFloat32Array(8)
+	}
+	require.NoError(t, quantizer.Train(sample, rand.New(rand.NewSource(1))))
+
+	// ADC is exact with respect to the decoded vector.
+	query := generateRandomFloat32Array(8)
+	table := quantizer.DistanceTable(query)
+	for _, v := range sample[:20] {
+		code := quantizer.Encode(v)
+		assert.InDelta(t, distance.SquareDistance(query, quantizer.Decode(code)), table.Distance(code), 1e-5)
+	}
+}
+
+func TestIVFPQSearch(t *testing.T) {
+	index, err := ivf.NewIVFPQ(4, 4, 4, "")
+	require.NoError(t, err)
+	exact, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+
+	for i := range 2000 {
+		embedding := generateRandomFloat32Array(16)
+		require.NoError(t, index.Add(strconv.Itoa(i), embedding))
+		require.NoError(t, exact.Insert(strconv.Itoa(i), embedding))
+	}
+	require.True(t, index.Trained())
+	assert.Equal(t, 2000, index.Len())
+
+	// Compressed search recovers most of the exact neighbours.
+	found := 0
+	for range 20 {
+		query := generateRandomFloat32Array(16)
+		expected, _ := exact.Search(query, 10)
+		actual, err := index.Search(query, 50)
+		require.NoError(t, err)
+		keys := make(map[string]bool)
+		for _, result := range actual {
+			keys[result.Key] = true
+		}
+		for _, result := range expected {
+			if keys[result.Key] {
+				found++
+			}
+		}
+	}
+	assert.Greater(t, found, 20*10*7/10)
+
+	assert.True(t, index.Delete("7"))
+	_, present := index.Lookup("7")
+	assert.False(t, present)
+	embedding, present := index.Lookup("8")
+	assert.True(t, present)
+	assert.Len(t, embedding, 16)
+}
+
+func TestIVFPQSaveLoad(t *testing.T) {
+	index, err := ivf.NewIVFPQ(4, 2, 0, "")
+	require.NoError(t, err)
+	testFile := "test"
+	defer os.Remove(testFile + "_ivfpq" + ".store")
+
+	for i := range 300 {
+		require.NoError(t, index.Add(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.True(t, index.Trained())
+	require.NoError(t, index.Add("pending", generateRandomFloat32Array(8)))
+	require.NoError(t, index.Save(testFile))
+
+	loaded, err := ivf.NewIVFPQ(1, 1, 0, "")
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(testFile))
+	assert.Equal(t, index.Len(), loaded.Len())
+	assert.Equal(t, 2, loaded.Subspaces)
+
+	query := generateRandomFloat32Array(8)
+	expected, _ := index.Search(query, 5)
+	actual, _ := loaded.Search(query, 5)
+	assert.Equal(t, expected, actual)
+}
+
+func TestHNSWCompressPQ(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	testFile := "test"
+	defer os.Remove(testFile + "_hnsw" + ".store")
+
+	assert.Error(t, hnswGraph.CompressPQ(4))
+	embeddings := make([][]float32, 0)
+	for i := range 300 {
+		embedding := generateRandomFloat32Array(16)
+		embeddings = append(embeddings, embedding)
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), embedding))
+	}
+	require.NoError(t, hnswGraph.CompressPQ(4))
+	hnswGraph.Insert(hnsw.MakeNode("extra", generateRandomFloat32Array(16)))
+
+	// Re-ranking on full vectors puts exact matches first whenever the
+	// traversal reaches them.
+	exactMatches := func(g *hnsw.HNSWGraph[string]) int {
+		found := 0
+		for i := range 30 {
+			results := g.Search(embeddings[i], 5)
+			if len(results) > 0 && results[0].Key == strconv.Itoa(i) {
+				found++
+			}
+		}
+		return found
+	}
+	assert.GreaterOrEqual(t, exactMatches(hnswGraph), 15)
+
+	require.NoError(t, hnswGraph.Save(testFile))
+	loaded := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, loaded.Load(testFile))
+	assert.GreaterOrEqual(t, exactMatches(loaded), 15)
+}
+
+// heapAlloc returns the bytes of live heap objects after a collection.
+func heapAlloc() uint64 {
+	runtime.GC()
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
+
+// buildWideGraph builds a graph of large embeddings that only the graph
+// references, so that compressing it can free them.
+func buildWideGraph() *hnsw.HNSWGraph[string] {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	for i := range 1000 {
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(512)))
+	}
+	return hnswGraph
+}
+
+func TestHNSWCompressPQFreesEmbeddings(t *testing.T) {
+	hnswGraph := buildWideGraph()
+	before := heapAlloc()
+	require.NoError(t, hnswGraph.CompressPQ(16))
+	after := heapAlloc()
+	// The embeddings alone take 2MB; the codes 16KB and the quantizer
+	// 512KB.
+	assert.Less(t, after+1<<20, before)
+
+	vector, ok := hnswGraph.Lookup("0")
+	require.True(t, ok)
+	assert.Len(t, vector, 512)
+	assert.Equal(t, "0", hnswGraph.Search(vector, 1)[0].Key)
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"vectorDb/hnsw"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func buildQuantizedGraph(t *testing.T, mode string) (*hnsw.HNSWGraph[string], [][]float32) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	embeddings := make([][]float32, 0)
+	for i := range 300 {
+		embedding := generateRandomFloat32Array(32)
+		embeddings = append(embeddings, embedding)
+		hnswGraph.Insert(hnsw.MakeNode(strconv.Itoa(i), embedding))
+	}
+	require.NoError(t, hnswGraph.Quantize(mode))
+	assert.Equal(t, mode, hnswGraph.Quantization())
+	return hnswGraph, embeddings
+}
+
+// countExactMatches counts the queries whose own key comes back first.
+func countExactMatches(g *hnsw.HNSWGraph[string], embeddings [][]float32, queries int) int {
+	found := 0
+	for i := range queries {
+		results := g.Search(embeddings[i], 5)
+		if len(results) > 0 && results[0].Key == strconv.Itoa(i) {
+			found++
+		}
+	}
+	return found
+}
+
+func TestHNSWQuantizeUnknownMode(t *testing.T) {
+	hnswGraph := hnsw.NewHNSWGraph[string]("")
+	assert.Error(t, hnswGraph.Quantize(hnsw.QuantizationInt8))
+	hnswGraph.Insert(hnsw.MakeNode("a", generateRandomFloat32Array(8)))
+	assert.Error(t, hnswGraph.Quantize("int4"))
+	assert.Equal(t, "", hnswGraph.Quantization())
+}
+
+func TestHNSWQuantizeSearch(t *testing.T) {
+	for _, mode := range []string{hnsw.QuantizationInt8, hnsw.QuantizationBinary} {
+		t.Run(mode, func(t *testing.T) {
+			hnswGraph, embeddings := buildQuantizedGraph(t, mode)
+			hnswGraph.Insert(hnsw.MakeNode("extra", generateRandomFloat32Array(32)))
+			assert.GreaterOrEqual(t, countExactMatches(hnswGraph, embeddings, 30), 10)
+
+			hnswGraph.SkipRerank = true
+			results := hnswGraph.Search(embeddings[0], 5)
+			assert.LessOrEqual(t, len(results), 5)
+		})
+	}
+}
+
+func TestHNSWQuantizeSaveLoad(t *testing.T) {
+	testFile := "test"
+	defer os.Remove(testFile + "_hnsw" + ".store")
+	for _, mode := range []string{hnsw.QuantizationInt8, hnsw.QuantizationBinary} {
+		t.Run(mode, func(t *testing.T) {
+			hnswGraph, embeddings := buildQuantizedGraph(t, mode)
+			require.NoError(t, hnswGraph.Save(testFile))
+
+			loaded := hnsw.NewHNSWGraph[string]("")
+			require.NoError(t, loaded.Load(testFile))
+			assert.Equal(t, mode, loaded.Quantization())
+			assert.GreaterOrEqual(t, countExactMatches(loaded, embeddings, 30), 10)
+		})
+	}
+}
+
+func TestHNSWQuantizeFreesEmbeddings(t *testing.T) {
+	for _, mode := range []string{hnsw.QuantizationInt8, hnsw.QuantizationBinary} {
+		t.Run(mode, func(t *testing.T) {
+			hnswGraph := buildWideGraph()
+			before := heapAlloc()
+			require.NoError(t, hnswGraph.Quantize(mode))
+			after := heapAlloc()
+			// The embeddings take 2MB; the int8 codes 512KB.
+			assert.Less(t, after+1<<20, before)
+
+			vector, ok := hnswGraph.Lookup("0")
+			require.True(t, ok)
+			assert.Equal(t, "0", hnswGraph.Search(vector, 1)[0].Key)
+		})
+	}
+}
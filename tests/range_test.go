@@ -0,0 +1,140 @@
+package tests
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"strconv"
+	"testing"
+	"vectorDb/db"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/ivf"
+	"vectorDb/mock"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/mock/gomock"
+)
+
+func TestSearchRangeAcrossMetrics(t *testing.T) {
+	for _, metric := range []string{"euclidean", "squareDistance", "cosine", "innerProduct"} {
+		t.Run(metric, func(t *testing.T) {
+			exact, err := flat.NewFlatIndex(metric)
+			require.NoError(t, err)
+			exhaustive, err := ivf.NewIVFFlat(8, 8, metric)
+			require.NoError(t, err)
+			// Seeded so that the approximate results are reproducible.
+			rng := rand.New(rand.NewSource(1))
+			graph := hnsw.NewHNSWGraphWithSeed[string](metric, 1)
+			for i := range 1000 {
+				embedding := signedRandomVector(rng, 8)
+				key := strconv.Itoa(i)
+				require.NoError(t, exact.Insert(key, embedding))
+				require.NoError(t, exhaustive.Add(key, embedding))
+				graph.Insert(hnsw.MakeNode(key, embedding))
+			}
+
+			// The radius takes in exactly the 50 nearest vectors.
+			query := signedRandomVector(rng, 8)
+			nearest, err := exact.Search(query, 51)
+			require.NoError(t, err)
+			radius := (nearest[49].Distance + nearest[50].Distance) / 2
+			expected := make([]string, 50)
+			for i := range expected {
+				expected[i] = nearest[i].Key
+			}
+
+			results, err := exact.SearchRange(query, radius, 0)
+			require.NoError(t, err)
+			assert.Equal(t, expected, flatKeys(results))
+			results, err = exact.SearchRange(query, radius, 10)
+			require.NoError(t, err)
+			assert.Equal(t, expected[:10], flatKeys(results))
+
+			ivfResults, err := exhaustive.SearchRange(query, radius, 0)
+			require.NoError(t, err)
+			require.Len(t, ivfResults, 50)
+			for i, result := range ivfResults {
+				assert.Equal(t, expected[i], result.Key)
+			}
+
+			// The graph widens its search past EfSearch to cover the radius.
+			found := nodeKeys(graph.SearchRange(query, radius, 0))
+			t.Logf("found %d of the 50 vectors within radius", len(found))
+			assert.Subset(t, expected, found)
+			assert.GreaterOrEqual(t, len(found), 45)
+			assert.Len(t, graph.SearchRange(query, radius, 5), 5)
+			assert.Empty(t, graph.SearchRange(query, nearest[0].Distance-1, 0))
+		})
+	}
+}
+
+func flatKeys(results []flat.Result) []string {
+	keys := make([]string, len(results))
+	for i, result := range results {
+		keys[i] = result.Key
+	}
+	return keys
+}
+
+func TestLSHSearchRange(t *testing.T) {
+	lshIndex := newCosineLsh(t, 20, 5, 4)
+	for i := range 300 {
+		lshIndex.Insert(generateRandomFloat32Array(20), strconv.Itoa(i))
+	}
+	query := generateRandomFloat32Array(20)
+	candidates := lshIndex.Search(query, 0)
+	require.NotEmpty(t, candidates)
+	radius := candidates[len(candidates)/2].Distance
+
+	results := lshIndex.SearchRange(query, radius, 0)
+	assert.NotEmpty(t, results)
+	assert.True(t, sort.SliceIsSorted(results, func(i, j int) bool {
+		return results[i].Distance < results[j].Distance
+	}))
+	for _, result := range results {
+		assert.LessOrEqual(t, result.Distance, radius)
+	}
+	assert.Len(t, lshIndex.SearchRange(query, radius, 1), 1)
+}
+
+func TestStoreSearchRange(t *testing.T) {
+	storeName := "test_store"
+	hnswStore, err := store.NewHnswStore()
+	require.NoError(t, err)
+	flatStore, err := store.NewFlatStore()
+	require.NoError(t, err)
+	for i := range 200 {
+		embedding := generateRandomFloat32Array(8)
+		require.NoError(t, hnswStore.Insert(storeName, embedding, strconv.Itoa(i)))
+		require.NoError(t, flatStore.Insert(storeName, embedding, strconv.Itoa(i)))
+	}
+	query := generateRandomFloat32Array(8)
+	expected, err := flatStore.SearchRange(storeName, query, 0.5, 0)
+	require.NoError(t, err)
+	actual, err := hnswStore.SearchRange(storeName, query, 0.5, 0)
+	require.NoError(t, err)
+	assert.Subset(t, expected, actual)
+
+	minHashStore, err := store.NewMinHashStore()
+	require.NoError(t, err)
+	_, err = minHashStore.SearchRange(storeName, query, 0.5, 0)
+	assert.Error(t, err)
+
+	controller := gomock.NewController(t)
+	defer controller.Finish()
+	mockClient := mock.NewMockClient(controller)
+	mockStore := mock.NewMockStore(controller)
+	vectorDb := db.NewVectorDbWithClientAndStore(mockClient, mockStore)
+	mockClient.EXPECT().Embed("query").Return(query, nil)
+	mockStore.EXPECT().SearchRange(storeName, query, float32(0.5), 3).Return([]string{"a"}, nil)
+	results, err := vectorDb.SearchRange(storeName, "query", 0.5, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, results)
+
+	mockClient.EXPECT().Embed("query").Return(nil, errors.New("embedding failed"))
+	_, err = vectorDb.SearchRange(storeName, "query", 0.5, 3)
+	assert.Error(t, err)
+}
@@ -0,0 +1,163 @@
+package tests
+
+import (
+	"math/rand/v2"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"vectorDb/bench"
+	"vectorDb/distance"
+	"vectorDb/hnsw"
+	"vectorDb/store"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// The recall harness measures how many of the true nearest neighbours a
+// search finds, on clustered synthetic data drawn with a fixed seed and
+// scored against the exact neighbours found by brute force. The minimums
+// leave room for the indexes that build with a random seed, and fail on a
+// regression in neighbour selection, hashing or quantization.
+
+// recallK is the number of neighbours recall is measured at.
+const recallK = 10
+
+// recallMetrics lists the metrics the indexes are measured with. Hamming
+// distance is left out: it is meant for binary vectors, on which its ties
+// make the true neighbours ambiguous.
+var recallMetrics = []string{
+	distance.NameEuclidean,
+	distance.NameSquareDistance,
+	distance.NameManhattan,
+	distance.NameCosine,
+	distance.NameNormalizedCosine,
+	distance.NameInnerProduct,
+}
+
+// recallDataset returns 1000 base vectors and 100 queries drawn from the
+// same 20 clusters, with the recallK exact nearest neighbours of each query
+// under metric.
+func recallDataset(t *testing.T, metric string) bench.Dataset {
+	rng := rand.New(rand.NewPCG(42, 42))
+	data := generateClusteredData(rng, 1100, 16, 20)
+	// Centre the clusters on the origin, so that they point in different
+	// directions for the angular metrics.
+	for _, vector := range data {
+		for i := range vector {
+			vector[i] -= 5
+		}
+	}
+	dataset := bench.Dataset{Base: data[:1000], Queries: data[1000:]}
+	var err error
+	dataset.Neighbors, err = bench.GroundTruth(dataset.Base, dataset.Queries, recallK, metric)
+	require.NoError(t, err)
+	return dataset
+}
+
+// measureRecall returns the mean recall@recallK of search over the queries
+// of the dataset.
+func measureRecall(dataset bench.Dataset, search func(query []float32, k int) []string) float64 {
+	recall := 0.0
+	for i, query := range dataset.Queries {
+		recall += bench.Recall(search(query, recallK), dataset.Neighbors[i], recallK)
+	}
+	return recall / float64(len(dataset.Queries))
+}
+
+// minRecall is the minimum recall of an index, with overrides for the
+// metrics it does worse on.
+type minRecall struct {
+	all      float64
+	byMetric map[string]float64
+}
+
+func (m minRecall) of(metric string) float64 {
+	if recall, ok := m.byMetric[metric]; ok {
+		return recall
+	}
+	return m.all
+}
+
+func TestStoreRecall(t *testing.T) {
+	stores := map[string]struct {
+		newStore func() (store.Store, error)
+		min      minRecall
+		// metrics lists the metrics the index supports, all if nil.
+		metrics []string
+	}{
+		"flat": {newStore: store.NewFlatStore, min: minRecall{all: 1}},
+		"hnsw": {newStore: store.NewHnswStore, min: minRecall{all: 0.95}},
+		"ivf":  {newStore: store.NewIvfStore, min: minRecall{all: 0.95}},
+		"ivfpq": {
+			newStore: store.NewIvfPqStore,
+			// Product quantization tells unit vectors apart poorly.
+			min: minRecall{all: 0.7, byMetric: map[string]float64{distance.NameNormalizedCosine: 0.4}},
+			metrics: []string{
+				distance.NameEuclidean, distance.NameSquareDistance,
+				distance.NameNormalizedCosine, distance.NameInnerProduct,
+			},
+		},
+		"lsh":    {newStore: store.NewLshStore, min: minRecall{all: 0.9}},
+		"vamana": {newStore: store.NewVamanaStore, min: minRecall{all: 0.95}},
+	}
+	for _, metric := range recallMetrics {
+		dataset := recallDataset(t, metric)
+		for name, test := range stores {
+			if test.metrics != nil && !slices.Contains(test.metrics, metric) {
+				continue
+			}
+			t.Run(name+"/"+metric, func(t *testing.T) {
+				t.Parallel()
+				s, err := test.newStore()
+				require.NoError(t, err)
+				result, err := bench.Run(s, filepath.Join(t.TempDir(), "recall"), dataset, recallK, metric)
+				require.NoError(t, err)
+				t.Logf("recall@%d: %.3f", recallK, result.Recall)
+				assert.GreaterOrEqual(t, result.Recall, test.min.of(metric))
+			})
+		}
+	}
+}
+
+func TestHNSWQuantizedRecall(t *testing.T) {
+	modes := map[string]minRecall{
+		hnsw.QuantizationPQ:     {all: 0.8, byMetric: map[string]float64{distance.NameNormalizedCosine: 0.5}},
+		hnsw.QuantizationInt8:   {all: 0.9},
+		hnsw.QuantizationBinary: {all: 0.75},
+	}
+	for _, metric := range recallMetrics {
+		t.Run(metric, func(t *testing.T) {
+			t.Parallel()
+			dataset := recallDataset(t, metric)
+			graph := hnsw.NewHNSWGraph[string](metric)
+			for id, vector := range dataset.Base {
+				graph.Insert(hnsw.MakeNode(strconv.Itoa(id), vector))
+			}
+			// Compressing re-encodes the full-precision embeddings, so the
+			// modes can share the graph.
+			for _, mode := range []string{hnsw.QuantizationPQ, hnsw.QuantizationInt8, hnsw.QuantizationBinary} {
+				if mode == hnsw.QuantizationPQ {
+					require.NoError(t, graph.CompressPQ(4))
+				} else {
+					require.NoError(t, graph.Quantize(mode))
+				}
+				// 16 bits tell few neighbours apart, so binary codes only
+				// shortlist candidates for a wider rerank.
+				if mode == hnsw.QuantizationBinary {
+					graph.Rerank = 4 * recallK
+				}
+				recall := measureRecall(dataset, func(query []float32, k int) []string {
+					keys := make([]string, 0, k)
+					for _, node := range graph.Search(query, k) {
+						keys = append(keys, node.Key)
+					}
+					return keys
+				})
+				t.Logf("%s recall@%d: %.3f", mode, recallK, recall)
+				assert.GreaterOrEqual(t, recall, modes[mode].of(metric), mode)
+			}
+		})
+	}
+}
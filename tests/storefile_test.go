@@ -0,0 +1,303 @@
+package tests
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"testing"
+	"vectorDb/flat"
+	"vectorDb/hnsw"
+	"vectorDb/ivf"
+	"vectorDb/lsh"
+	"vectorDb/storefile"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testHeader = storefile.Header{Type: "test", Version: 1}
+
+func writeString(s string) func(w *storefile.Writer) error {
+	return func(w *storefile.Writer) error {
+		_, err := io.WriteString(w, s)
+		return err
+	}
+}
+
+// readStoreFile returns the first section of a store file written with
+// testHeader.
+func readStoreFile(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	info, err := f.Stat()
+	require.NoError(t, err)
+	r, err := storefile.NewReader(f, info.Size(), testHeader.Type, testHeader.Version, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.EndSection())
+	return string(data)
+}
+
+func TestStorefileFailedWriteKeepsOriginal(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test.store")
+	require.NoError(t, storefile.Write(path, testHeader, writeString("original")))
+
+	// The write fails halfway, as when the process dies or the disk fills.
+	err := storefile.Write(path, testHeader, func(w *storefile.Writer) error {
+		io.WriteString(w, "trunc")
+		w.EndSection()
+		return errors.New("disk full")
+	})
+	assert.EqualError(t, err, "disk full")
+	assert.Equal(t, "original", readStoreFile(t, path))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary file must be removed")
+}
+
+func TestStorefileRetention(t *testing.T) {
+	defer func(keep int) { storefile.Retention = keep }(storefile.Retention)
+	path := filepath.Join(t.TempDir(), "test.store")
+
+	storefile.Retention = 2
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, storefile.Write(path, testHeader, writeString("v"+strconv.Itoa(i))))
+	}
+	assert.Equal(t, []string{path + ".1", path + ".2"}, storefile.Snapshots(path))
+	for file, expected := range map[string]string{path: "v4", path + ".1": "v3", path + ".2": "v2"} {
+		assert.Equal(t, expected, readStoreFile(t, file), file)
+	}
+
+	// A failed write rotates nothing.
+	require.Error(t, storefile.Write(path, testHeader, func(w *storefile.Writer) error { return errors.New("fail") }))
+	assert.Equal(t, "v3", readStoreFile(t, path+".1"))
+
+	// Lowering the retention drops the extra snapshots on the next write.
+	storefile.Retention = 0
+	require.NoError(t, storefile.Write(path, testHeader, writeString("v5")))
+	assert.Empty(t, storefile.Snapshots(path))
+}
+
+func TestSaveSmallerIndexLeavesNoTrailingBytes(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+
+	large := hnsw.NewHNSWGraph[string]("")
+	for i := range 200 {
+		large.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, large.Save(prefix))
+	small := hnsw.NewHNSWGraph[string]("")
+	for i := range 5 {
+		small.Insert(hnsw.MakeNode(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, small.Save(prefix))
+	loaded := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, loaded.Load(prefix))
+	assert.Equal(t, 5, loaded.Len())
+
+	largeLsh := newCosineLsh(t, 8, 4, 4)
+	for i := range 200 {
+		largeLsh.Insert(generateRandomFloat32Array(8), strconv.Itoa(i))
+	}
+	require.NoError(t, largeLsh.Save(prefix))
+	smallLsh := newCosineLsh(t, 8, 4, 4)
+	point := generateRandomFloat32Array(8)
+	smallLsh.Insert(point, "a")
+	require.NoError(t, smallLsh.Save(prefix))
+
+	// The file must be the same as one saved from scratch.
+	fresh := filepath.Join(t.TempDir(), "fresh")
+	require.NoError(t, smallLsh.Save(fresh))
+	overwritten, err := os.Stat(prefix + "_lsh.store")
+	require.NoError(t, err)
+	expected, err := os.Stat(fresh + "_lsh.store")
+	require.NoError(t, err)
+	assert.Equal(t, expected.Size(), overwritten.Size())
+	loadedLsh := newCosineLsh(t, 8, 4, 4)
+	require.NoError(t, loadedLsh.Load(prefix))
+	assert.True(t, loadedLsh.Lookup(point, "a"))
+}
+
+// persisted is an index saved to and loaded from <prefix><suffix>.
+type persisted struct {
+	suffix string
+	save   func(prefix string) error
+	load   func(prefix string) error
+}
+
+func persistedIndexes(t *testing.T) map[string]persisted {
+	vectors := make([][]float32, 100)
+	for i := range vectors {
+		vectors[i] = generateRandomFloat32Array(8)
+	}
+
+	graph := hnsw.NewHNSWGraph[string]("")
+	cosineLsh := newCosineLsh(t, 8, 4, 4)
+	minHash := lsh.NewMinHashLsh(10, 2, lsh.Shingler{Unit: lsh.ShingleWord, K: 1})
+	exact, err := flat.NewFlatIndex("")
+	require.NoError(t, err)
+	ivfFlat, err := ivf.NewIVFFlat(4, 2, "")
+	require.NoError(t, err)
+	ivfPQ, err := ivf.NewIVFPQ(4, 2, 4, "")
+	require.NoError(t, err)
+	require.NoError(t, ivfFlat.Train(vectors))
+	require.NoError(t, ivfPQ.Train(vectors))
+	for i, vector := range vectors {
+		key := strconv.Itoa(i)
+		graph.Insert(hnsw.MakeNode(key, vector))
+		cosineLsh.Insert(vector, key)
+		minHash.Insert([]string{"doc", key}, key)
+		require.NoError(t, exact.Insert(key, vector))
+		require.NoError(t, ivfFlat.Add(key, vector))
+		require.NoError(t, ivfPQ.Add(key, vector))
+	}
+
+	return map[string]persisted{
+		"hnsw": {"_hnsw.store", graph.Save, func(prefix string) error {
+			return hnsw.NewHNSWGraph[string]("").Load(prefix)
+		}},
+		"lsh": {"_lsh.store", cosineLsh.Save, func(prefix string) error {
+			return newCosineLsh(t, 8, 4, 4).Load(prefix)
+		}},
+		"minhash": {"_minhash.store", minHash.Save, func(prefix string) error {
+			return lsh.NewMinHashLsh(10, 2, lsh.Shingler{Unit: lsh.ShingleWord, K: 1}).Load(prefix)
+		}},
+		"flat": {"_flat.store", exact.Save, func(prefix string) error {
+			index, _ := flat.NewFlatIndex("")
+			return index.Load(prefix)
+		}},
+		"ivf": {"_ivf.store", ivfFlat.Save, func(prefix string) error {
+			index, _ := ivf.NewIVFFlat(4, 2, "")
+			return index.Load(prefix)
+		}},
+		"ivfpq": {"_ivfpq.store", ivfPQ.Save, func(prefix string) error {
+			index, _ := ivf.NewIVFPQ(4, 2, 4, "")
+			return index.Load(prefix)
+		}},
+	}
+}
+
+// A store file cut short by a crash in the middle of a non-atomic write
+// must fail to load rather than load part of the index.
+func TestTruncatedStoreFilesFailToLoad(t *testing.T) {
+	for name, index := range persistedIndexes(t) {
+		t.Run(name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "test")
+			path := prefix + index.suffix
+			require.NoError(t, index.save(prefix))
+			require.NoError(t, index.load(prefix))
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			size := info.Size()
+
+			for _, cut := range []int64{size - 1, size / 2, 3} {
+				require.NoError(t, os.Truncate(path, cut))
+				assert.Error(t, index.load(prefix), "truncated to %d of %d bytes", cut, size)
+				require.NoError(t, index.save(prefix))
+			}
+		})
+	}
+}
+
+// Saves go through a temporary file, so a crash before the rename leaves
+// the previous file in place and loadable.
+func TestInterruptedSaveKeepsPreviousFile(t *testing.T) {
+	for name, index := range persistedIndexes(t) {
+		t.Run(name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "test")
+			path := prefix + index.suffix
+			require.NoError(t, index.save(prefix))
+			previous, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			// Simulate a crash after part of the next version was written.
+			require.NoError(t, os.WriteFile(path+".tmp123", previous[:len(previous)/2], 0o600))
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, previous, data)
+			assert.NoError(t, index.load(prefix))
+		})
+	}
+}
+
+func TestStoreFileHeader(t *testing.T) {
+	indexes := persistedIndexes(t)
+	for name, index := range indexes {
+		t.Run(name, func(t *testing.T) {
+			prefix := filepath.Join(t.TempDir(), "test")
+			path := prefix + index.suffix
+			require.NoError(t, index.save(prefix))
+
+			info, err := storefile.Verify(path)
+			require.NoError(t, err)
+			assert.Equal(t, name, info.Header.Type)
+			assert.NotEmpty(t, info.Sections)
+			data, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, storefile.Magic, string(data[:len(storefile.Magic)]))
+
+			// A flipped bit in the data is caught by the checksum.
+			corrupt := slices.Clone(data)
+			corrupt[len(corrupt)-1] ^= 1
+			require.NoError(t, os.WriteFile(path, corrupt, 0o600))
+			assert.ErrorIs(t, index.load(prefix), storefile.ErrChecksum)
+			_, err = storefile.Verify(path)
+			assert.ErrorIs(t, err, storefile.ErrChecksum)
+
+			// A corrupted magic is not taken for a legacy file.
+			corrupt = slices.Clone(data)
+			corrupt[0] ^= 1
+			require.NoError(t, os.WriteFile(path, corrupt, 0o600))
+			assert.ErrorContains(t, index.load(prefix), "no header")
+
+			// So are bytes left after the last section.
+			require.NoError(t, os.WriteFile(path, append(slices.Clone(data), 0, 0, 0), 0o600))
+			assert.Error(t, index.load(prefix))
+
+			// The file of another index type is rejected before decoding.
+			for other, otherIndex := range indexes {
+				if other == name {
+					continue
+				}
+				require.NoError(t, os.WriteFile(prefix+otherIndex.suffix, data, 0o600))
+				assert.ErrorContains(t, otherIndex.load(prefix), "file holds a "+name+" index", other)
+			}
+		})
+	}
+}
+
+// Files written before headers still load when they match the legacy
+// layout of their index.
+func TestLegacyStoreFile(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	embeddings := map[string][]float32{"a": {0, 0}, "b": {1, 0}}
+	links := map[string][]string{"a": {"b"}, "b": {"a"}}
+	// Version 7 wrote the same fields, without the header and sections.
+	require.NoError(t, os.WriteFile(prefix+"_hnsw.store", slices.Concat(hnswSections(7, "a", embeddings, links)...), 0o600))
+
+	graph := hnsw.NewHNSWGraph[string]("")
+	require.NoError(t, graph.Load(prefix))
+	assert.Equal(t, 2, graph.Len())
+	assert.True(t, graph.Validate().OK())
+}
+
+// Version 1 graphs named the raw dot product "dotProduct". It ranked the
+// largest products farthest, so such files are refused rather than read
+// with the inner product metric.
+func TestLegacyDotProductGraph(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	// The encoding version, M, Ml, EfSearch and the metric, then no levels.
+	file := hnswFile(nil).int(1).int(16).float64(0.25).int(20).string("dotProduct").int(0)
+	require.NoError(t, os.WriteFile(prefix+"_hnsw.store", file, 0o600))
+
+	graph := hnsw.NewHNSWGraph[string]("")
+	err := graph.Load(prefix)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "dotProduct")
+}
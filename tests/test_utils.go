@@ -8,4 +8,26 @@ func generateRandomFloat32Array(n int) []float32 {
 		arr[i] = rand.Float32() // Generate random float64 between 0.0 and 1.0
 	}
 	return arr
-}
\ No newline at end of file
+}
+
+// generateClusteredData returns n vectors drawn around the given number of
+// random centres, the kind of data where greedy graph search gets stuck in
+// the wrong cluster.
+func generateClusteredData(rng *rand.Rand, n, dims, clusters int) [][]float32 {
+	centres := make([][]float32, clusters)
+	for i := range centres {
+		centres[i] = make([]float32, dims)
+		for j := range centres[i] {
+			centres[i][j] = rng.Float32() * 10
+		}
+	}
+	data := make([][]float32, n)
+	for i := range data {
+		centre := centres[rng.IntN(clusters)]
+		data[i] = make([]float32, dims)
+		for j := range data[i] {
+			data[i][j] = centre[j] + float32(rng.NormFloat64())*0.5
+		}
+	}
+	return data
+}
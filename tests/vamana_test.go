@@ -0,0 +1,242 @@
+package tests
+
+import (
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"vectorDb/flat"
+	"vectorDb/storefile"
+	"vectorDb/vamana"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func vamanaResultKeys(results []vamana.Result) []string {
+	keys := make([]string, len(results))
+	for i, r := range results {
+		keys[i] = r.Key
+	}
+	return keys
+}
+
+func TestVamanaRecall(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	data := generateClusteredData(rng, 4000, 16, 20)
+	index, err := vamana.NewIndex("euclidean", vamana.DefaultParams())
+	require.NoError(t, err)
+	exact, err := flat.NewFlatIndex("euclidean")
+	require.NoError(t, err)
+	for i, v := range data {
+		require.NoError(t, index.Insert(strconv.Itoa(i), v))
+		require.NoError(t, exact.Insert(strconv.Itoa(i), v))
+	}
+	require.NoError(t, index.Save(filepath.Join(t.TempDir(), "test")))
+	defer index.Close()
+
+	hits, reads := 0, index.BlockReads()
+	const queries, k = 50, 10
+	for range queries {
+		query := generateClusteredData(rng, 1, 16, 20)[0]
+		found, err := index.Search(query, k)
+		require.NoError(t, err)
+		require.Len(t, found, k)
+		expected, err := exact.Search(query, k)
+		require.NoError(t, err)
+		for _, e := range expected {
+			for _, f := range found {
+				if e.Key == f.Key {
+					hits++
+				}
+			}
+		}
+	}
+	assert.GreaterOrEqual(t, float64(hits)/(queries*k), 0.9)
+
+	// Searches only read the blocks of the nodes they expand: 4000 records
+	// of 196 bytes fill 200 blocks.
+	perQuery := float64(index.BlockReads()-reads) / queries
+	assert.Less(t, perQuery, 100.0)
+}
+
+func TestVamanaMergeOnSave(t *testing.T) {
+	rng := rand.New(rand.NewPCG(3, 4))
+	prefix := filepath.Join(t.TempDir(), "test")
+	index, err := vamana.NewIndex("euclidean", vamana.DefaultParams())
+	require.NoError(t, err)
+	defer index.Close()
+	vectors := map[string][]float32{}
+	for i, v := range generateClusteredData(rng, 2000, 16, 20) {
+		vectors[strconv.Itoa(i)] = v
+		require.NoError(t, index.Insert(strconv.Itoa(i), v))
+	}
+	require.NoError(t, index.Save(prefix))
+
+	// Delete a quarter of the nodes, insert as many new ones, and merge
+	// them into the graph on disk.
+	for i := 0; i < 2000; i += 4 {
+		require.True(t, index.Delete(strconv.Itoa(i)))
+		delete(vectors, strconv.Itoa(i))
+	}
+	for i, v := range generateClusteredData(rng, 500, 16, 20) {
+		vectors[strconv.Itoa(2000+i)] = v
+		require.NoError(t, index.Insert(strconv.Itoa(2000+i), v))
+	}
+	require.NoError(t, index.Save(prefix))
+	assert.Equal(t, len(vectors), index.Len())
+
+	exact, err := flat.NewFlatIndex("euclidean")
+	require.NoError(t, err)
+	for key, v := range vectors {
+		require.NoError(t, exact.Insert(key, v))
+	}
+	hits := 0
+	const queries, k = 50, 10
+	for range queries {
+		query := generateClusteredData(rng, 1, 16, 20)[0]
+		found, err := index.Search(query, k)
+		require.NoError(t, err)
+		require.Len(t, found, k)
+		expected, err := exact.Search(query, k)
+		require.NoError(t, err)
+		for _, e := range expected {
+			for _, f := range found {
+				if e.Key == f.Key {
+					hits++
+				}
+			}
+		}
+	}
+	assert.GreaterOrEqual(t, float64(hits)/(queries*k), 0.9)
+
+	loaded, err := vamana.NewIndex("", vamana.DefaultParams())
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(prefix))
+	defer loaded.Close()
+	for key, expected := range vectors {
+		found, err := loaded.Search(expected, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []string{key}, vamanaResultKeys(found))
+	}
+
+	// The search starts elsewhere once all but one saved node, and so
+	// almost surely the medoid, are deleted.
+	for key := range vectors {
+		if key != "1" {
+			require.True(t, loaded.Delete(key))
+		}
+	}
+	require.NoError(t, loaded.Insert("new", vectors["2"]))
+	require.NoError(t, loaded.Save(prefix))
+	for _, key := range []string{"1", "new"} {
+		expected, _, err := loaded.Lookup(key)
+		require.NoError(t, err)
+		found, err := loaded.Search(expected, 2)
+		require.NoError(t, err)
+		assert.Equal(t, key, found[0].Key)
+		assert.Len(t, found, 2)
+	}
+}
+
+func TestVamanaMutations(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	index, err := vamana.NewIndex("euclidean", vamana.DefaultParams())
+	require.NoError(t, err)
+	found, err := index.Search(generateRandomFloat32Array(8), 5)
+	require.NoError(t, err)
+	assert.Empty(t, found)
+	require.NoError(t, index.Save(prefix))
+
+	vectors := map[string][]float32{}
+	for i := range 300 {
+		vectors[strconv.Itoa(i)] = generateRandomFloat32Array(8)
+		require.NoError(t, index.Insert(strconv.Itoa(i), vectors[strconv.Itoa(i)]))
+	}
+	assert.Error(t, index.Insert("bad", generateRandomFloat32Array(4)))
+	require.NoError(t, index.Save(prefix))
+	defer index.Close()
+
+	// Changes after the save are searched alongside the graph.
+	for i := 0; i < 300; i += 3 {
+		assert.True(t, index.Delete(strconv.Itoa(i)))
+		delete(vectors, strconv.Itoa(i))
+	}
+	assert.False(t, index.Delete("0"))
+	for i := 300; i < 350; i++ {
+		vectors[strconv.Itoa(i)] = generateRandomFloat32Array(8)
+		require.NoError(t, index.Insert(strconv.Itoa(i), vectors[strconv.Itoa(i)]))
+	}
+	vectors["1"] = generateRandomFloat32Array(8)
+	require.NoError(t, index.Insert("1", vectors["1"]))
+	assert.Equal(t, len(vectors), index.Len())
+
+	check := func(index *vamana.Index) {
+		assert.Equal(t, len(vectors), index.Len())
+		assert.Equal(t, 8, index.Dims())
+		for key, expected := range vectors {
+			v, ok, err := index.Lookup(key)
+			require.NoError(t, err)
+			assert.True(t, ok, key)
+			assert.Equal(t, expected, v, key)
+			found, err := index.Search(expected, 1)
+			require.NoError(t, err)
+			assert.Equal(t, []string{key}, vamanaResultKeys(found))
+		}
+		_, ok, err := index.Lookup("0")
+		require.NoError(t, err)
+		assert.False(t, ok)
+		found, err := index.SearchRange(vectors["1"], 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"1"}, vamanaResultKeys(found))
+	}
+	check(index)
+
+	// Saving merges them into the graph, and loading reads it back.
+	require.NoError(t, index.Save(prefix))
+	check(index)
+	loaded, err := vamana.NewIndex("", vamana.DefaultParams())
+	require.NoError(t, err)
+	require.NoError(t, loaded.Load(prefix))
+	defer loaded.Close()
+	check(loaded)
+	for range 10 {
+		query := generateRandomFloat32Array(8)
+		expected, err := index.Search(query, 10)
+		require.NoError(t, err)
+		found, err := loaded.Search(query, 10)
+		require.NoError(t, err)
+		assert.Equal(t, expected, found)
+	}
+
+	info, err := storefile.Verify(prefix + "_vamana.store")
+	require.NoError(t, err)
+	assert.Equal(t, "vamana", info.Header.Type)
+}
+
+func TestVamanaFilesFailCleanly(t *testing.T) {
+	prefix := filepath.Join(t.TempDir(), "test")
+	index, err := vamana.NewIndex("euclidean", vamana.DefaultParams())
+	require.NoError(t, err)
+	for i := range 50 {
+		require.NoError(t, index.Insert(strconv.Itoa(i), generateRandomFloat32Array(8)))
+	}
+	require.NoError(t, index.Save(prefix))
+	require.NoError(t, index.Close())
+	data, err := os.ReadFile(prefix + "_vamana.store")
+	require.NoError(t, err)
+
+	// Truncated files are rejected when loaded, whatever part is missing.
+	for _, size := range []int{0, 10, len(data) / 4, len(data) / 2, len(data) - 1} {
+		require.NoError(t, os.WriteFile(prefix+"_vamana.store", data[:size], 0o600))
+		loaded, err := vamana.NewIndex("euclidean", vamana.DefaultParams())
+		require.NoError(t, err)
+		if size == 0 {
+			assert.NoError(t, loaded.Load(prefix))
+			assert.Zero(t, loaded.Len())
+			continue
+		}
+		assert.Error(t, loaded.Load(prefix), size)
+	}
+}
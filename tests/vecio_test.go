@@ -0,0 +1,286 @@
+package tests
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"vectorDb/db"
+	"vectorDb/store"
+	"vectorDb/vecio"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func vecioRecords(n, dim int) []vecio.Record {
+	records := make([]vecio.Record, n)
+	for i := range records {
+		records[i] = vecio.Record{Key: "key, \"" + strconv.Itoa(i) + "\"", Vector: generateRandomFloat32Array(dim)}
+	}
+	return records
+}
+
+func readAll(t *testing.T, path string, format vecio.Format) []vecio.Record {
+	reader, err := vecio.Open(path, format)
+	require.NoError(t, err)
+	defer reader.Close()
+	var records []vecio.Record
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records
+		}
+		require.NoError(t, err)
+		records = append(records, record)
+	}
+}
+
+func TestVecioRoundTrip(t *testing.T) {
+	for _, format := range vecio.Formats {
+		t.Run(string(format), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "vectors."+string(format))
+			detected, ok := vecio.FormatOf(path)
+			require.True(t, ok)
+			assert.Equal(t, format, detected)
+
+			records := vecioRecords(50, 7)
+			writer, err := vecio.Create(path, format)
+			require.NoError(t, err)
+			for _, record := range records {
+				require.NoError(t, writer.Write(record))
+			}
+			assert.Error(t, writer.Write(vecio.Record{Key: "short", Vector: []float32{1}}))
+			require.NoError(t, writer.Close())
+
+			assert.Equal(t, records, readAll(t, path, format))
+		})
+	}
+	_, err := vecio.ParseFormat("parquet")
+	assert.Error(t, err)
+}
+
+func TestVecioNumPyArray(t *testing.T) {
+	// A float64 array as numpy.save writes it, without a keys file.
+	path := filepath.Join(t.TempDir(), "vectors.npy")
+	dict := "{'descr': '<f8', 'fortran_order': False, 'shape': (3, 2), }"
+	for (10+len(dict)+1)%64 != 0 {
+		dict += " "
+	}
+	data := []byte("\x93NUMPY\x01\x00")
+	data = binary.LittleEndian.AppendUint16(data, uint16(len(dict)+1))
+	data = append(data, dict+"\n"...)
+	for i := range 6 {
+		data = binary.LittleEndian.AppendUint64(data, math.Float64bits(float64(i)/2))
+	}
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	expected := []vecio.Record{
+		{Key: "0", Vector: []float32{0, 0.5}},
+		{Key: "1", Vector: []float32{1, 1.5}},
+		{Key: "2", Vector: []float32{2, 2.5}},
+	}
+	assert.Equal(t, expected, readAll(t, path, vecio.NPY))
+
+	// A keys file gives the keys, and must hold one per vector.
+	require.NoError(t, os.WriteFile(vecio.KeysPath(path), []byte("a\nb\nc\n"), 0o600))
+	records := readAll(t, path, vecio.NPY)
+	require.Len(t, records, 3)
+	assert.Equal(t, "c", records[2].Key)
+
+	require.NoError(t, os.WriteFile(vecio.KeysPath(path), []byte("a\n"), 0o600))
+	reader, err := vecio.Open(path, vecio.NPY)
+	require.NoError(t, err)
+	defer reader.Close()
+	_, err = reader.Read()
+	require.NoError(t, err)
+	_, err = reader.Read()
+	assert.Error(t, err)
+}
+
+func TestVecioCSVWithoutHeader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "vectors.csv")
+	require.NoError(t, os.WriteFile(path, []byte("a,1,2\nb,3,4\n"), 0o600))
+	expected := []vecio.Record{{Key: "a", Vector: []float32{1, 2}}, {Key: "b", Vector: []float32{3, 4}}}
+	assert.Equal(t, expected, readAll(t, path, vecio.CSV))
+
+	require.NoError(t, os.WriteFile(path, []byte("a,1,2\nb,3\n"), 0o600))
+	reader, err := vecio.Open(path, vecio.CSV)
+	require.NoError(t, err)
+	defer reader.Close()
+	_, err = reader.Read()
+	require.NoError(t, err)
+	_, err = reader.Read()
+	assert.Error(t, err)
+}
+
+func TestDbExportImport(t *testing.T) {
+	dir := t.TempDir()
+	storeName := filepath.Join(dir, "source")
+	flatStore, err := store.NewFlatStore()
+	require.NoError(t, err)
+	source := db.NewVectorDbWithStore(flatStore)
+	records := vecioRecords(25, 4)
+	for _, record := range records {
+		require.NoError(t, flatStore.Insert(storeName, record.Vector, record.Key))
+	}
+
+	for _, format := range vecio.Formats {
+		t.Run(string(format), func(t *testing.T) {
+			path := filepath.Join(dir, "export."+string(format))
+			writer, err := vecio.Create(path, format)
+			require.NoError(t, err)
+			n, err := source.Export(storeName, writer)
+			require.NoError(t, err)
+			require.NoError(t, writer.Close())
+			assert.Equal(t, len(records), n)
+
+			// Into a store that inserts in bulk and one that does not.
+			for _, newStore := range []func() (store.Store, error){store.NewHnswStore, store.NewFlatStore} {
+				s, err := newStore()
+				require.NoError(t, err)
+				reader, err := vecio.Open(path, format)
+				require.NoError(t, err)
+				var batches []int
+				n, err = db.NewVectorDbWithStore(s).Import("target", reader, 10, func(done int) {
+					batches = append(batches, done)
+				})
+				require.NoError(t, reader.Close())
+				require.NoError(t, err)
+				assert.Equal(t, len(records), n)
+				assert.Equal(t, []int{10, 20, 25}, batches)
+				for _, record := range records {
+					vector, err := s.Lookup("target", nil, record.Key)
+					require.NoError(t, err)
+					assert.Equal(t, record.Vector, []float32(vector))
+				}
+			}
+		})
+	}
+
+	_, err = source.Export(filepath.Join(dir, "missing"), nil)
+	assert.ErrorIs(t, err, store.ErrCollectionNotFound)
+	minHashStore, err := store.NewMinHashStore()
+	require.NoError(t, err)
+	_, err = db.NewVectorDbWithStore(minHashStore).Export(storeName, nil)
+	assert.Error(t, err)
+}
+
+func TestStoreExport(t *testing.T) {
+	stores := map[string]func() (store.Store, error){
+		"flat":   store.NewFlatStore,
+		"hnsw":   store.NewHnswStore,
+		"ivf":    store.NewIvfStore,
+		"ivfpq":  store.NewIvfPqStore,
+		"lsh":    store.NewLshStore,
+		"vamana": store.NewVamanaStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			storeName := filepath.Join(t.TempDir(), "test")
+			s, err := newStore()
+			require.NoError(t, err)
+			expected := map[string][]float32{}
+			for i := range 30 {
+				key := strconv.Itoa(i)
+				expected[key] = generateRandomFloat32Array(20)
+				require.NoError(t, s.Insert(storeName, expected[key], key))
+			}
+			// The vamana store reads saved vectors back from disk.
+			require.NoError(t, s.Save(storeName))
+			reloaded, err := newStore()
+			require.NoError(t, err)
+			require.NoError(t, reloaded.Load(storeName))
+
+			exported := map[string][]float32{}
+			err = reloaded.(store.Exporter).Export(storeName, func(key string, embedding []float32) error {
+				exported[key] = append([]float32(nil), embedding...)
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, expected, exported)
+		})
+	}
+
+	storeName := filepath.Join(t.TempDir(), "test")
+	hnswStore, err := store.NewHnswStore()
+	require.NoError(t, err)
+	flatStore, err := store.NewFlatStore()
+	require.NoError(t, err)
+	for i := range 10 {
+		embedding := generateRandomFloat32Array(8)
+		require.NoError(t, hnswStore.Insert(storeName, embedding, strconv.Itoa(i)))
+		require.NoError(t, flatStore.Insert(storeName, embedding, strconv.Itoa(i)))
+	}
+	require.NoError(t, hnswStore.(store.Freezer).SaveMapped(storeName))
+	require.NoError(t, flatStore.(store.Freezer).SaveMapped(storeName))
+	for _, newStore := range []func() (store.Store, error){store.NewMappedHnswStore, store.NewMappedFlatStore} {
+		mapped, err := newStore()
+		require.NoError(t, err)
+		n := 0
+		err = mapped.(store.Exporter).Export(storeName, func(key string, embedding []float32) error {
+			n++
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 10, n)
+	}
+}
+
+// recordReader reads records from a slice.
+type recordReader struct {
+	records []vecio.Record
+}
+
+func (r *recordReader) Read() (vecio.Record, error) {
+	if len(r.records) == 0 {
+		return vecio.Record{}, io.EOF
+	}
+	record := r.records[0]
+	r.records = r.records[1:]
+	return record, nil
+}
+
+func (r *recordReader) Close() error {
+	return nil
+}
+
+// spillingStore is a store.Spiller counting its inserts since the last
+// save, and its saves.
+type spillingStore struct {
+	store.Store
+	pending, saves int
+}
+
+func (s *spillingStore) Insert(storeName string, embedding []float32, key string) error {
+	s.pending++
+	return s.Store.Insert(storeName, embedding, key)
+}
+
+func (s *spillingStore) Save(storeName string) error {
+	s.pending = 0
+	s.saves++
+	return s.Store.Save(storeName)
+}
+
+func (s *spillingStore) Pending(storeName string) int {
+	return s.pending
+}
+
+func TestDbImportSpills(t *testing.T) {
+	flatStore, err := store.NewFlatStore()
+	require.NoError(t, err)
+	s := &spillingStore{Store: flatStore}
+	records := make([]vecio.Record, 2*db.SpillSize+5)
+	for i := range records {
+		records[i] = vecio.Record{Key: strconv.Itoa(i), Vector: []float32{float32(i), 1}}
+	}
+	n, err := db.NewVectorDbWithStore(s).Import(filepath.Join(t.TempDir(), "test"), &recordReader{records: records}, db.ImportBatchSize, nil)
+	require.NoError(t, err)
+	assert.Equal(t, len(records), n)
+	assert.Equal(t, 2, s.saves)
+	assert.Equal(t, 5, s.pending)
+}
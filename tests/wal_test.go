@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+	"vectorDb/store"
+	"vectorDb/wal"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func walRecords(n int) []wal.Record {
+	records := make([]wal.Record, n)
+	for i := range records {
+		records[i] = wal.Record{Op: wal.OpInsert, Key: strconv.Itoa(i), Embedding: generateRandomFloat32Array(4)}
+	}
+	records[n-1] = wal.Record{Op: wal.OpDelete, Key: "0"}
+	return records
+}
+
+// replayWAL opens the log at path and returns the records it holds.
+func replayWAL(t *testing.T, path string) []wal.Record {
+	var records []wal.Record
+	log, err := wal.Open(path, wal.Options{}, func(record wal.Record) error {
+		records = append(records, record)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NoError(t, log.Close())
+	return records
+}
+
+func TestWALAppendReplay(t *testing.T) {
+	for _, name := range []string{"always", "interval", "none"} {
+		t.Run(name, func(t *testing.T) {
+			policy, err := wal.ParseSyncPolicy(name)
+			require.NoError(t, err)
+			path := filepath.Join(t.TempDir(), "test.wal")
+			log, err := wal.Open(path, wal.Options{Sync: policy, Interval: 10 * time.Millisecond}, nil)
+			require.NoError(t, err)
+			records := walRecords(5)
+			require.NoError(t, log.Append(records[0]))
+			require.NoError(t, log.AppendAll(records[1:]))
+			require.NoError(t, log.Close())
+
+			assert.Equal(t, records, replayWAL(t, path))
+		})
+	}
+	_, err := wal.ParseSyncPolicy("sometimes")
+	assert.Error(t, err)
+}
+
+func TestWALTornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	log, err := wal.Open(path, wal.Options{}, nil)
+	require.NoError(t, err)
+	records := walRecords(3)
+	require.NoError(t, log.AppendAll(records))
+	require.NoError(t, log.Close())
+
+	// A crash in the middle of the last append leaves part of its record.
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	require.NoError(t, os.Truncate(path, info.Size()-3))
+	assert.Equal(t, records[:2], replayWAL(t, path))
+
+	// The torn record is cut off, so the next ones are not lost behind it.
+	log, err = wal.Open(path, wal.Options{}, nil)
+	require.NoError(t, err)
+	require.NoError(t, log.Append(records[2]))
+	require.NoError(t, log.Close())
+	assert.Equal(t, records, replayWAL(t, path))
+}
+
+func TestWALCorruptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	log, err := wal.Open(path, wal.Options{}, nil)
+	require.NoError(t, err)
+	records := walRecords(3)
+	require.NoError(t, log.AppendAll(records))
+	require.NoError(t, log.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+	assert.Equal(t, records[:2], replayWAL(t, path))
+}
+
+func TestWALCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.wal")
+	log, err := wal.Open(path, wal.Options{}, nil)
+	require.NoError(t, err)
+	records := walRecords(3)
+	require.NoError(t, log.AppendAll(records))
+	require.NoError(t, log.Checkpoint())
+	require.NoError(t, log.Append(records[0]))
+	require.NoError(t, log.Close())
+	assert.Equal(t, records[:1], replayWAL(t, path))
+}
+
+func TestStoreWriteAheadLog(t *testing.T) {
+	defer func(options *wal.Options) { store.WriteAheadLog = options }(store.WriteAheadLog)
+	store.WriteAheadLog = &wal.Options{Sync: wal.SyncAlways}
+
+	stores := map[string]func() (store.Store, error){
+		"flat":   store.NewFlatStore,
+		"hnsw":   store.NewHnswStore,
+		"ivf":    store.NewIvfStore,
+		"lsh":    store.NewLshStore,
+		"vamana": store.NewVamanaStore,
+		"minhash": func() (store.Store, error) {
+			return store.NewMinHashStore()
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			storeName := filepath.Join(t.TempDir(), "test")
+			embeddings := make(map[string][]float32)
+			for i := range 20 {
+				embeddings[strconv.Itoa(i)] = generateRandomFloat32Array(20)
+			}
+
+			// Mutations made without a save survive a restart.
+			s, err := newStore()
+			require.NoError(t, err)
+			for i := range 10 {
+				key := strconv.Itoa(i)
+				require.NoError(t, s.Insert(storeName, embeddings[key], key))
+			}
+			_, err = s.Delete(storeName, embeddings["0"], "0")
+			require.NoError(t, err)
+
+			restarted, err := newStore()
+			require.NoError(t, err)
+			require.NoError(t, restarted.Load(storeName))
+			_, err = restarted.Lookup(storeName, embeddings["0"], "0")
+			assert.Error(t, err)
+			_, err = restarted.Lookup(storeName, embeddings["9"], "9")
+			assert.NoError(t, err)
+
+			// Saving checkpoints the log; later mutations go in a new tail.
+			require.NoError(t, restarted.Save(storeName))
+			matches, err := filepath.Glob(storeName + "_*.wal")
+			require.NoError(t, err)
+			require.Len(t, matches, 1)
+			info, err := os.Stat(matches[0])
+			require.NoError(t, err)
+			assert.Zero(t, info.Size())
+			for i := 10; i < 20; i++ {
+				key := strconv.Itoa(i)
+				require.NoError(t, restarted.Insert(storeName, embeddings[key], key))
+			}
+
+			reloaded, err := newStore()
+			require.NoError(t, err)
+			require.NoError(t, reloaded.Load(storeName))
+			for i := 1; i < 20; i++ {
+				key := strconv.Itoa(i)
+				_, err := reloaded.Lookup(storeName, embeddings[key], key)
+				assert.NoError(t, err, key)
+			}
+		})
+	}
+}
+
+// An insert the index rejects is not logged, so the log still replays.
+func TestStoreWriteAheadLogRejectedInsert(t *testing.T) {
+	defer func(options *wal.Options) { store.WriteAheadLog = options }(store.WriteAheadLog)
+	store.WriteAheadLog = &wal.Options{Sync: wal.SyncAlways}
+
+	stores := map[string]func() (store.Store, error){
+		"flat":   store.NewFlatStore,
+		"vamana": store.NewVamanaStore,
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			storeName := filepath.Join(t.TempDir(), "test")
+			s, err := newStore()
+			require.NoError(t, err)
+			require.NoError(t, s.Insert(storeName, generateRandomFloat32Array(4), "a"))
+			assert.Error(t, s.Insert(storeName, generateRandomFloat32Array(3), "b"))
+			matches, err := filepath.Glob(storeName + "_*.wal")
+			require.NoError(t, err)
+			require.Len(t, matches, 1)
+			assert.Len(t, replayWAL(t, matches[0]), 1)
+
+			restarted, err := newStore()
+			require.NoError(t, err)
+			require.NoError(t, restarted.Load(storeName))
+			_, err = restarted.Lookup(storeName, nil, "a")
+			assert.NoError(t, err)
+			_, err = restarted.Lookup(storeName, nil, "b")
+			assert.Error(t, err)
+		})
+	}
+}
+
+// A log left with logging on is replayed after it is turned off, and the
+// next save removes it.
+func TestStoreLeftoverWriteAheadLog(t *testing.T) {
+	defer func(options *wal.Options) { store.WriteAheadLog = options }(store.WriteAheadLog)
+	store.WriteAheadLog = &wal.Options{Sync: wal.SyncAlways}
+
+	storeName := filepath.Join(t.TempDir(), "test")
+	s, err := store.NewFlatStore()
+	require.NoError(t, err)
+	require.NoError(t, s.Insert(storeName, generateRandomFloat32Array(4), "a"))
+
+	store.WriteAheadLog = nil
+	restarted, err := store.NewFlatStore()
+	require.NoError(t, err)
+	require.NoError(t, restarted.Load(storeName))
+	_, err = restarted.Lookup(storeName, nil, "a")
+	require.NoError(t, err)
+	// The leftover log records mutations until the save.
+	require.NoError(t, restarted.Insert(storeName, generateRandomFloat32Array(4), "b"))
+	assert.Len(t, replayWAL(t, storeName+"_flat.wal"), 2)
+
+	require.NoError(t, restarted.Save(storeName))
+	_, err = os.Stat(storeName + "_flat.wal")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	reloaded, err := store.NewFlatStore()
+	require.NoError(t, err)
+	require.NoError(t, reloaded.Load(storeName))
+	_, err = reloaded.Lookup(storeName, nil, "b")
+	assert.NoError(t, err)
+}
+
+func TestStoreWriteAheadLogUnsavedLsh(t *testing.T) {
+	defer func(options *wal.Options) { store.WriteAheadLog = options }(store.WriteAheadLog)
+	store.WriteAheadLog = &wal.Options{Sync: wal.SyncAlways}
+
+	storeName := filepath.Join(t.TempDir(), "test")
+	s, err := store.NewLshStore()
+	require.NoError(t, err)
+	embedding := generateRandomFloat32Array(20)
+	require.NoError(t, s.Insert(storeName, embedding, "a"))
+
+	// The collection was never saved, but its log holds the insert.
+	restarted, err := store.NewLshStore()
+	require.NoError(t, err)
+	require.NoError(t, restarted.Load(storeName))
+	_, err = restarted.Lookup(storeName, embedding, "a")
+	assert.NoError(t, err)
+	assert.NoFileExists(t, storeName+"_lsh.store")
+}
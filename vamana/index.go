@@ -0,0 +1,733 @@
+package vamana
+
+import (
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"vectorDb/distance"
+	"vectorDb/pq"
+	"vectorDb/storefile"
+)
+
+var byteOrder = binary.LittleEndian
+
+// encodingVersion is the version of the layout written by Save.
+const encodingVersion = 1
+
+// pageSize is the alignment and the unit of size of the blocks of records,
+// that of the pages of most SSDs and file systems.
+const pageSize = 4096
+
+// trainingSample is the largest number of vectors the quantizer is trained
+// on.
+const trainingSample = 20000
+
+// Result is a single search result with its distance to the query.
+type Result struct {
+	Key      string
+	Distance float32
+}
+
+// Index is a Vamana graph index kept on disk.
+//
+// Save builds the graph and writes it to <storeName>_vamana.store, and Load
+// opens a saved index without reading the records, which searches then
+// read from disk as they need them. Inserts and deletes made since the last
+// save are kept in memory: inserted vectors are searched exhaustively
+// alongside the graph, and deleted nodes are skipped, until Save merges
+// them into the graph on disk.
+type Index struct {
+	params   Params
+	metric   string
+	distance distance.Func
+	dim      int
+
+	// disk is the index opened by the last Load or Save, or nil.
+	disk *diskIndex
+	// pending holds the vectors inserted since the last save.
+	pending map[string][]float32
+	// deleted holds the keys of the nodes on disk deleted or replaced since
+	// the last save.
+	deleted map[string]bool
+}
+
+// NewIndex creates an empty index using the named distance function.
+func NewIndex(distanceFunc string, params Params) (*Index, error) {
+	distanceFunc = distance.Canonical(distanceFunc)
+	dFunc, ok := distance.Lookup(distanceFunc)
+	if !ok {
+		return nil, fmt.Errorf("unknown distance function %q", distanceFunc)
+	}
+	if params.R <= 0 || params.L <= 0 || params.Alpha < 1 || params.Subspaces < 0 || params.SearchL <= 0 || params.BeamWidth <= 0 {
+		return nil, fmt.Errorf("invalid vamana parameters %+v", params)
+	}
+	return &Index{
+		params:   params,
+		metric:   distanceFunc,
+		distance: dFunc,
+		pending:  map[string][]float32{},
+		deleted:  map[string]bool{},
+	}, nil
+}
+
+// Len returns the number of vectors in the index.
+func (x *Index) Len() int {
+	n := len(x.pending)
+	if x.disk != nil {
+		// deleted only holds keys on disk, and pending none that are live
+		// there.
+		n += len(x.disk.keys) - len(x.deleted)
+	}
+	return n
+}
+
+// Pending returns the number of vectors inserted since the last save, which
+// the index holds in memory until Save writes them to disk.
+func (x *Index) Pending() int {
+	return len(x.pending)
+}
+
+// Dims returns the dimension of the vectors, or 0 if the index is empty.
+func (x *Index) Dims() int {
+	return x.dim
+}
+
+// Metric returns the name of the distance function of the index.
+func (x *Index) Metric() string {
+	return x.metric
+}
+
+// BlockReads returns the number of blocks of records read from disk so
+// far, by searches, lookups and saves.
+func (x *Index) BlockReads() int64 {
+	if x.disk == nil {
+		return 0
+	}
+	return x.disk.reads.Load()
+}
+
+// onDisk reports whether key is a live node of the index on disk.
+func (x *Index) onDisk(key string) bool {
+	if x.disk == nil || x.deleted[key] {
+		return false
+	}
+	_, ok := x.disk.ids[key]
+	return ok
+}
+
+// Insert adds the vector under key, replacing any vector already there.
+func (x *Index) Insert(key string, vector []float32) error {
+	if x.Len() == 0 {
+		x.dim = len(vector)
+	}
+	if len(vector) != x.dim {
+		return fmt.Errorf("embedding dimension mismatch: %d != %d", x.dim, len(vector))
+	}
+	if distance.NeedsNormalization(x.metric) {
+		vector = distance.Normalize(vector)
+	} else {
+		vector = slices.Clone(vector)
+	}
+	if x.onDisk(key) {
+		x.deleted[key] = true
+	}
+	x.pending[key] = vector
+	return nil
+}
+
+// Delete removes key from the index and reports whether it was present.
+func (x *Index) Delete(key string) bool {
+	_, present := x.pending[key]
+	delete(x.pending, key)
+	if x.onDisk(key) {
+		x.deleted[key] = true
+		present = true
+	}
+	return present
+}
+
+// Lookup returns a copy of the vector stored under key, reading it from
+// disk if it was saved.
+func (x *Index) Lookup(key string) ([]float32, bool, error) {
+	if vector, ok := x.pending[key]; ok {
+		return slices.Clone(vector), true, nil
+	}
+	if !x.onDisk(key) {
+		return nil, false, nil
+	}
+	nodes, err := x.disk.readNodes([]uint32{x.disk.ids[key]})
+	if err != nil {
+		return nil, false, err
+	}
+	return nodes[0].vector, true, nil
+}
+
+// Search returns the k vectors closest to the query, nearest first.
+func (x *Index) Search(query []float32, k int) ([]Result, error) {
+	return x.search(query, k, max(x.params.SearchL, k))
+}
+
+// SearchRange returns the vectors within radius of the query, nearest
+// first, and at most maxResults of them if maxResults > 0. Like Search it
+// can miss vectors the graph search doesn't reach; it widens the search
+// until vectors beyond radius make up half of its results.
+func (x *Index) SearchRange(query []float32, radius float32, maxResults int) ([]Result, error) {
+	limit := x.Len()
+	if maxResults > 0 {
+		limit = min(limit, maxResults)
+	}
+	if limit == 0 {
+		return nil, nil
+	}
+	for k := min(x.params.SearchL, limit); ; k = min(2*k, limit) {
+		found, err := x.search(query, k, k)
+		if err != nil {
+			return nil, err
+		}
+		n := slices.IndexFunc(found, func(r Result) bool {
+			return r.Distance > radius
+		})
+		if n < 0 {
+			n = len(found)
+		}
+		if 2*n <= len(found) || len(found) < k || k == limit {
+			return found[:n], nil
+		}
+	}
+}
+
+// search merges the k nearest nodes found on disk with a candidate list of
+// size l with the k nearest pending vectors.
+func (x *Index) search(query []float32, k int, l int) ([]Result, error) {
+	if x.Len() == 0 || k <= 0 {
+		return nil, nil
+	}
+	if len(query) != x.dim {
+		return nil, fmt.Errorf("embedding dimension mismatch: %d != %d", x.dim, len(query))
+	}
+	if distance.NeedsNormalization(x.metric) {
+		query = distance.Normalize(query)
+	}
+
+	var results []Result
+	if x.disk != nil && len(x.disk.keys) > 0 {
+		found, err := x.disk.search(query, k, l, x.params.BeamWidth, x.distance, x.deleted)
+		if err != nil {
+			return nil, err
+		}
+		results = found
+	}
+	for key, vector := range x.pending {
+		results = append(results, Result{Key: key, Distance: x.distance(vector, query)})
+	}
+	slices.SortFunc(results, func(a, b Result) int {
+		return cmp.Or(cmp.Compare(a.Distance, b.Distance), cmp.Compare(a.Key, b.Key))
+	})
+	return results[:min(k, len(results))], nil
+}
+
+// Close closes the file of the index on disk. Searches of the saved nodes
+// fail afterwards, until the next Load.
+func (x *Index) Close() error {
+	if x.disk == nil {
+		return nil
+	}
+	return x.disk.file.Close()
+}
+
+// diskIndex is an index saved by Save: its records stay on disk, and only
+// their keys and quantization codes are held in memory.
+type diskIndex struct {
+	file *os.File
+	path string
+	dim  int
+	// degree is the number of neighbour slots in a record.
+	degree int
+	medoid uint32
+	// recordSize is the size of a record: the vector, the number of
+	// neighbours and the neighbour slots. A block holds perBlock records
+	// in blockSize bytes, a multiple of pageSize.
+	recordSize int
+	perBlock   int
+	blockSize  int
+	// start is the file offset of the first block.
+	start     int64
+	quantizer *pq.Quantizer
+	// codes holds the quantization code of node i at codes[i*m : (i+1)*m].
+	codes []byte
+	keys  []string
+	ids   map[string]uint32
+	reads atomic.Int64
+}
+
+// layout sets the sizes of the records and blocks of the index.
+func (d *diskIndex) layout() {
+	d.recordSize = 4*d.dim + 4 + 4*d.degree
+	d.blockSize = (d.recordSize + pageSize - 1) / pageSize * pageSize
+	d.perBlock = d.blockSize / d.recordSize
+}
+
+func (d *diskIndex) blocks() int {
+	return (len(d.keys) + d.perBlock - 1) / d.perBlock
+}
+
+func (d *diskIndex) code(id uint32) []byte {
+	m := d.quantizer.CodeSize()
+	return d.codes[int(id)*m : (int(id)+1)*m]
+}
+
+// node is a record read from disk.
+type node struct {
+	id        uint32
+	vector    []float32
+	neighbors []uint32
+}
+
+// readNodes reads the records of the given nodes, reading each block that
+// holds any of them once, all blocks at the same time.
+func (d *diskIndex) readNodes(ids []uint32) ([]node, error) {
+	var blocks []int
+	for _, id := range ids {
+		blocks = append(blocks, int(id)/d.perBlock)
+	}
+	slices.Sort(blocks)
+	blocks = slices.Compact(blocks)
+
+	data := make(map[int][]byte, len(blocks))
+	errs := make([]error, len(blocks))
+	buffers := make([][]byte, len(blocks))
+	var wg sync.WaitGroup
+	for i, block := range blocks {
+		buffers[i] = make([]byte, d.blockSize)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = d.file.ReadAt(buffers[i], d.start+int64(block)*int64(d.blockSize))
+		}()
+	}
+	wg.Wait()
+	d.reads.Add(int64(len(blocks)))
+	for i, block := range blocks {
+		if errs[i] != nil {
+			return nil, fmt.Errorf("reading block %d: %w", block, errs[i])
+		}
+		data[block] = buffers[i]
+	}
+
+	nodes := make([]node, 0, len(ids))
+	for _, id := range ids {
+		slot := int(id) % d.perBlock
+		n, err := d.decodeRecord(data[int(id)/d.perBlock][slot*d.recordSize : (slot+1)*d.recordSize])
+		if err != nil {
+			return nil, fmt.Errorf("decoding node %d: %w", id, err)
+		}
+		n.id = id
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+func (d *diskIndex) decodeRecord(record []byte) (node, error) {
+	n := node{vector: make([]float32, d.dim)}
+	for i := range n.vector {
+		n.vector[i] = math.Float32frombits(byteOrder.Uint32(record[4*i:]))
+	}
+	record = record[4*d.dim:]
+	degree := int(byteOrder.Uint32(record))
+	if degree > d.degree {
+		return node{}, fmt.Errorf("%d neighbours in %d slots", degree, d.degree)
+	}
+	n.neighbors = make([]uint32, degree)
+	for i := range n.neighbors {
+		n.neighbors[i] = byteOrder.Uint32(record[4+4*i:])
+		if int(n.neighbors[i]) >= len(d.keys) {
+			return node{}, fmt.Errorf("neighbour %d out of range", n.neighbors[i])
+		}
+	}
+	return n, nil
+}
+
+// search is the beam search of DiskANN. Candidates are ranked by their
+// quantization codes; at each step the beamWidth nearest unexpanded ones
+// are read from disk together, ranked exactly by their vectors, and their
+// neighbours added to the candidates. Deleted nodes are traversed but not
+// returned.
+func (d *diskIndex) search(query []float32, k, l, beamWidth int, dist distance.Func, deleted map[string]bool) ([]Result, error) {
+	table := d.quantizer.DistanceTable(query)
+	list := candidateList{size: max(l, k, 1)}
+	list.offer(candidate{id: d.medoid, dist: table.Distance(d.code(d.medoid))})
+	seen := map[uint32]bool{d.medoid: true}
+	var results []Result
+	for {
+		var beam []uint32
+		for i := range list.items {
+			if !list.items[i].expanded {
+				list.items[i].expanded = true
+				beam = append(beam, list.items[i].id)
+				if len(beam) == beamWidth {
+					break
+				}
+			}
+		}
+		if len(beam) == 0 {
+			break
+		}
+		nodes, err := d.readNodes(beam)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			if key := d.keys[n.id]; !deleted[key] {
+				results = append(results, Result{Key: key, Distance: dist(n.vector, query)})
+			}
+			for _, neighbor := range n.neighbors {
+				if seen[neighbor] {
+					continue
+				}
+				seen[neighbor] = true
+				list.offer(candidate{id: neighbor, dist: table.Distance(d.code(neighbor))})
+			}
+		}
+	}
+	return results, nil
+}
+
+// path returns the file of the index saved under storeName.
+func path(storeName string) string {
+	return storeName + "_vamana" + ".store"
+}
+
+// Save writes the index to <storeName>_vamana.store, which the index then
+// searches. An index saved where it was loaded from with no changes since
+// is left as it is.
+//
+// The first save builds the graph over the inserted vectors, which are all
+// in memory. Later saves merge the changes made since into the graph on
+// disk, as the StreamingMerge of FreshDiskANN does: the inserted vectors
+// are linked into the graph, the links to deleted nodes are replaced, and
+// the records are streamed block by block into the new file. The quantizer
+// trained by the first save is kept and encodes the inserted vectors, so a
+// collection whose data drifts far from it should be exported and
+// reimported into a new one.
+func (x *Index) Save(storeName string) error {
+	if x.disk != nil && x.disk.path == path(storeName) && len(x.pending) == 0 && len(x.deleted) == 0 {
+		return nil
+	}
+	var d *diskIndex
+	var record func(id int) ([]float32, []uint32, error)
+	var err error
+	if x.disk != nil && len(x.disk.keys) > len(x.deleted) {
+		d, record, err = x.merge()
+	} else {
+		d, record, err = x.build()
+	}
+	if err != nil {
+		return err
+	}
+
+	d.path = path(storeName)
+	header := storefile.Header{Type: "vamana", Version: encodingVersion, Dim: x.dim, Metric: x.metric}
+	err = storefile.Write(d.path, header, func(w *storefile.Writer) error {
+		return x.write(w, d, record)
+	})
+	if err != nil {
+		return err
+	}
+	return x.Load(storeName)
+}
+
+// build builds the graph over the pending vectors, when no node on disk
+// is left, and returns it with the function that returns its records.
+func (x *Index) build() (*diskIndex, func(id int) ([]float32, []uint32, error), error) {
+	keys := slices.Sorted(maps.Keys(x.pending))
+	vectors := make([][]float32, len(keys))
+	for i, key := range keys {
+		vectors[i] = x.pending[key]
+	}
+
+	d := &diskIndex{dim: x.dim, degree: x.params.R, keys: keys}
+	d.layout()
+	var neighbors [][]uint32
+	if len(keys) > 0 {
+		var err error
+		d.quantizer, err = x.train(vectors)
+		if err != nil {
+			return nil, nil, err
+		}
+		d.codes = make([]byte, 0, len(keys)*d.quantizer.CodeSize())
+		for _, v := range vectors {
+			d.codes = append(d.codes, d.quantizer.Encode(v)...)
+		}
+		neighbors, d.medoid = build(vectors, x.distance, x.buildParams())
+	}
+	record := func(id int) ([]float32, []uint32, error) {
+		return vectors[id], neighbors[id], nil
+	}
+	return d, record, nil
+}
+
+// buildParams returns the parameters to build the graph with.
+func (x *Index) buildParams() Params {
+	params := x.params
+	// Scaling distances by alpha only makes sense when they are positive,
+	// which inner products aren't.
+	if distance.IsInnerProduct(x.metric) {
+		params.Alpha = 1
+	}
+	return params
+}
+
+// Each calls fn with every key and its vector, those inserted since the
+// last save first, reading those on disk block by block. It stops at and
+// returns the first error fn or a read returns.
+func (x *Index) Each(fn func(key string, vector []float32) error) error {
+	for _, key := range slices.Sorted(maps.Keys(x.pending)) {
+		if err := fn(key, x.pending[key]); err != nil {
+			return err
+		}
+	}
+	if x.disk == nil {
+		return nil
+	}
+	for block := range x.disk.blocks() {
+		ids := make([]uint32, 0, x.disk.perBlock)
+		for id := block * x.disk.perBlock; id < min((block+1)*x.disk.perBlock, len(x.disk.keys)); id++ {
+			if key := x.disk.keys[id]; !x.deleted[key] {
+				ids = append(ids, uint32(id))
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		nodes, err := x.disk.readNodes(ids)
+		if err != nil {
+			return err
+		}
+		for _, n := range nodes {
+			if err := fn(x.disk.keys[n.id], n.vector); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// train returns a product quantizer trained on a sample of the vectors.
+func (x *Index) train(vectors [][]float32) (*pq.Quantizer, error) {
+	quantizer, err := pq.NewQuantizer(x.dim, x.params.subspaces(x.dim), pq.MaxCentroids, x.metric)
+	if err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(1))
+	sample := vectors
+	if len(sample) > trainingSample {
+		sample = make([][]float32, trainingSample)
+		for i, j := range rng.Perm(len(vectors))[:trainingSample] {
+			sample[i] = vectors[j]
+		}
+	}
+	if err := quantizer.Train(sample, rng); err != nil {
+		return nil, err
+	}
+	return quantizer, nil
+}
+
+// write encodes the index in four sections: the parameters, the quantizer
+// and codes, the keys, and the blocks of records, aligned to pageSize.
+// The records are encoded in order, as record returns them.
+func (x *Index) write(w *storefile.Writer, d *diskIndex, record func(id int) ([]float32, []uint32, error)) error {
+	params := []any{
+		int32(x.params.R), int32(x.params.L), x.params.Alpha, int32(x.params.Subspaces),
+		int32(x.params.SearchL), int32(x.params.BeamWidth), int32(len(d.keys)), d.medoid,
+	}
+	for _, v := range params {
+		if err := binary.Write(w, byteOrder, v); err != nil {
+			return err
+		}
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	if d.quantizer != nil {
+		if _, err := d.quantizer.WriteTo(w); err != nil {
+			return err
+		}
+		if _, err := w.Write(d.codes); err != nil {
+			return err
+		}
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	for _, key := range d.keys {
+		if err := binary.Write(w, byteOrder, int32(len(key))); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, key); err != nil {
+			return err
+		}
+	}
+	if err := w.EndSection(); err != nil {
+		return err
+	}
+
+	w.Align(pageSize)
+	block := make([]byte, d.blockSize)
+	for first := 0; first < len(d.keys); first += d.perBlock {
+		clear(block)
+		for id := first; id < min(first+d.perBlock, len(d.keys)); id++ {
+			vector, neighbors, err := record(id)
+			if err != nil {
+				return err
+			}
+			buf := block[(id-first)*d.recordSize:]
+			for i, v := range vector {
+				byteOrder.PutUint32(buf[4*i:], math.Float32bits(v))
+			}
+			buf = buf[4*d.dim:]
+			byteOrder.PutUint32(buf, uint32(len(neighbors)))
+			for i, neighbor := range neighbors {
+				byteOrder.PutUint32(buf[4+4*i:], neighbor)
+			}
+		}
+		if _, err := w.Write(block); err != nil {
+			return err
+		}
+	}
+	return w.EndSection()
+}
+
+// Load opens the index saved under storeName, replacing the contents of
+// the index and its parameters with the saved ones. A missing file leaves
+// the index unchanged. The records are not read: the index keeps the file
+// open to read them as searches need them.
+func (x *Index) Load(storeName string) error {
+	file, err := os.Open(path(storeName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	if info.Size() == 0 {
+		file.Close()
+		return nil
+	}
+	d, params, metric, err := open(file, info.Size())
+	if err != nil {
+		file.Close()
+		return err
+	}
+	dFunc, ok := distance.Lookup(metric)
+	if !ok {
+		file.Close()
+		return fmt.Errorf("unknown distance function %q", metric)
+	}
+	x.Close()
+	d.path = path(storeName)
+	x.disk, x.params, x.dim = d, params, d.dim
+	x.metric, x.distance = metric, dFunc
+	x.pending, x.deleted = map[string][]float32{}, map[string]bool{}
+	return nil
+}
+
+// open decodes the parameters, codes and keys of the index in file, and
+// locates its blocks of records.
+func open(file *os.File, size int64) (*diskIndex, Params, string, error) {
+	// Vamana files always had a header.
+	r, err := storefile.NewReader(file, size, "vamana", encodingVersion, nil)
+	if err != nil {
+		return nil, Params{}, "", err
+	}
+	d := &diskIndex{file: file, dim: r.Header.Dim}
+
+	var params Params
+	var r32, l, subspaces, searchL, beamWidth, n int32
+	for _, v := range []any{&r32, &l, &params.Alpha, &subspaces, &searchL, &beamWidth, &n, &d.medoid} {
+		if err := binary.Read(r, byteOrder, v); err != nil {
+			return nil, Params{}, "", fmt.Errorf("decoding parameters: %w", err)
+		}
+	}
+	params.R, params.L, params.Subspaces = int(r32), int(l), int(subspaces)
+	params.SearchL, params.BeamWidth = int(searchL), int(beamWidth)
+	if params.R <= 0 || params.L <= 0 || !(params.Alpha >= 1) || params.Subspaces < 0 || params.SearchL <= 0 ||
+		params.BeamWidth <= 0 || n < 0 || d.dim < 0 || (n > 0 && (d.dim == 0 || d.medoid >= uint32(n))) {
+		return nil, Params{}, "", errors.New("invalid vamana parameters")
+	}
+	if err := r.EndSection(); err != nil {
+		return nil, Params{}, "", err
+	}
+
+	if n > 0 {
+		d.quantizer = &pq.Quantizer{}
+		if _, err := d.quantizer.ReadFrom(r); err != nil {
+			return nil, Params{}, "", fmt.Errorf("decoding quantizer: %w", err)
+		}
+		if d.quantizer.Dims() != d.dim {
+			return nil, Params{}, "", errors.New("quantizer dimension does not match the index")
+		}
+		if err := storefile.CheckLen(r, int64(n), int64(d.quantizer.CodeSize())); err != nil {
+			return nil, Params{}, "", fmt.Errorf("decoding codes: %w", err)
+		}
+		d.codes = make([]byte, int(n)*d.quantizer.CodeSize())
+		if _, err := io.ReadFull(r, d.codes); err != nil {
+			return nil, Params{}, "", fmt.Errorf("decoding codes: %w", err)
+		}
+	}
+	if err := r.EndSection(); err != nil {
+		return nil, Params{}, "", err
+	}
+
+	if err := storefile.CheckLen(r, int64(n), 4); err != nil {
+		return nil, Params{}, "", fmt.Errorf("decoding keys: %w", err)
+	}
+	d.keys = make([]string, n)
+	d.ids = make(map[string]uint32, n)
+	for i := range d.keys {
+		var length int32
+		if err := binary.Read(r, byteOrder, &length); err != nil {
+			return nil, Params{}, "", fmt.Errorf("decoding keys: %w", err)
+		}
+		if length < 0 || int(length) > r.Len() {
+			return nil, Params{}, "", fmt.Errorf("decoding keys: %w", io.ErrUnexpectedEOF)
+		}
+		key := make([]byte, length)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, Params{}, "", fmt.Errorf("decoding keys: %w", err)
+		}
+		d.keys[i] = string(key)
+		if _, ok := d.ids[d.keys[i]]; ok {
+			return nil, Params{}, "", fmt.Errorf("duplicate key %q", d.keys[i])
+		}
+		d.ids[d.keys[i]] = uint32(i)
+	}
+
+	offset, blocksSize, err := r.Skip()
+	if err != nil {
+		return nil, Params{}, "", fmt.Errorf("decoding records: %w", err)
+	}
+	d.degree = params.R
+	d.layout()
+	d.start = (offset + pageSize - 1) / pageSize * pageSize
+	if blocksSize != d.start-offset+int64(d.blocks())*int64(d.blockSize) {
+		return nil, Params{}, "", fmt.Errorf("decoding records: %d bytes for %d blocks", blocksSize, d.blocks())
+	}
+	return d, params, r.Header.Metric, nil
+}
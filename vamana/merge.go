@@ -0,0 +1,323 @@
+package vamana
+
+import (
+	"maps"
+	"slices"
+	"vectorDb/distance"
+)
+
+// mergeChunk is the number of deleted nodes whose records are read at once.
+const mergeChunk = 256
+
+// merger merges the changes made since the last save into the graph on
+// disk, holding in memory only the changed nodes and the links to them.
+//
+// Nodes on disk keep their ID while the merge runs, and inserted node i
+// takes the ID len(disk.keys)+i. The merged index numbers the surviving
+// nodes first, in the order they had, then the inserted ones.
+type merger struct {
+	disk     *diskIndex
+	distance distance.Func
+	params   Params
+	// deleted holds the neighbours of the deleted nodes on disk.
+	deleted map[uint32][]uint32
+	// survivors holds the IDs of the nodes on disk that are kept, in order.
+	survivors []uint32
+	// keys, vectors, codes and neighbors describe the inserted nodes.
+	keys      []string
+	vectors   [][]float32
+	codes     []byte
+	neighbors [][]uint32
+	// start is the node searches start from, the medoid unless it was
+	// deleted.
+	start uint32
+	// backLinks holds the links from nodes on disk to inserted nodes.
+	backLinks map[uint32][]uint32
+	// batch holds the records of survivors read for the new file.
+	batch map[uint32]node
+}
+
+// merge links the pending vectors into the graph on disk and returns the
+// merged index with the function that returns its records, which reads and
+// patches the records on disk block by block as they are written.
+func (x *Index) merge() (*diskIndex, func(id int) ([]float32, []uint32, error), error) {
+	m := &merger{
+		disk:      x.disk,
+		distance:  x.distance,
+		params:    x.buildParams(),
+		deleted:   make(map[uint32][]uint32, len(x.deleted)),
+		backLinks: map[uint32][]uint32{},
+	}
+	medoid, err := m.consolidate(x.deleted)
+	if err != nil {
+		return nil, nil, err
+	}
+	m.start = m.medoid(medoid)
+	for _, key := range slices.Sorted(maps.Keys(x.pending)) {
+		if err := m.insert(key, x.pending[key]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	d := &diskIndex{dim: x.dim, degree: x.params.R, quantizer: m.disk.quantizer}
+	d.layout()
+	d.keys = make([]string, 0, len(m.survivors)+len(m.keys))
+	d.codes = make([]byte, 0, (len(m.survivors)+len(m.keys))*d.quantizer.CodeSize())
+	for _, id := range m.survivors {
+		d.keys = append(d.keys, m.disk.keys[id])
+		d.codes = append(d.codes, m.disk.code(id)...)
+	}
+	d.keys = append(d.keys, m.keys...)
+	d.codes = append(d.codes, m.codes...)
+	d.medoid = m.final(m.start)
+	return d, m.record, nil
+}
+
+// consolidate reads the neighbours of the deleted nodes and lists the
+// survivors. It returns the vector of the medoid if it was deleted.
+func (m *merger) consolidate(deleted map[string]bool) ([]float32, error) {
+	ids := make([]uint32, 0, len(deleted))
+	for key := range deleted {
+		ids = append(ids, m.disk.ids[key])
+	}
+	slices.Sort(ids)
+	var medoid []float32
+	for chunk := range slices.Chunk(ids, mergeChunk) {
+		nodes, err := m.disk.readNodes(chunk)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			m.deleted[n.id] = n.neighbors
+			if n.id == m.disk.medoid {
+				medoid = n.vector
+			}
+		}
+	}
+	m.survivors = make([]uint32, 0, len(m.disk.keys)-len(ids))
+	for id := range uint32(len(m.disk.keys)) {
+		if _, ok := m.deleted[id]; !ok {
+			m.survivors = append(m.survivors, id)
+		}
+	}
+	return medoid, nil
+}
+
+// medoid returns the node searches start from: the old medoid, or if it
+// was deleted, the survivor whose code is nearest to its vector, so that
+// inserts are linked to live nodes.
+func (m *merger) medoid(deleted []float32) uint32 {
+	if deleted == nil {
+		return m.disk.medoid
+	}
+	table := m.disk.quantizer.DistanceTable(deleted)
+	best, bestDist := m.survivors[0], table.Distance(m.disk.code(m.survivors[0]))
+	for _, id := range m.survivors[1:] {
+		if dist := table.Distance(m.disk.code(id)); dist < bestDist {
+			best, bestDist = id, dist
+		}
+	}
+	return best
+}
+
+// final returns the ID in the merged index of a surviving or inserted node.
+func (m *merger) final(id uint32) uint32 {
+	if n := uint32(len(m.disk.keys)); id >= n {
+		return uint32(len(m.survivors)) + id - n
+	}
+	i, _ := slices.BinarySearch(m.survivors, id)
+	return uint32(i)
+}
+
+func (m *merger) inserted(id uint32) bool {
+	return int(id) >= len(m.disk.keys)
+}
+
+func (m *merger) code(id uint32) []byte {
+	if !m.inserted(id) {
+		return m.disk.code(id)
+	}
+	size := m.disk.quantizer.CodeSize()
+	i := int(id) - len(m.disk.keys)
+	return m.codes[i*size : (i+1)*size]
+}
+
+// approximate returns the vector of an inserted node, or the one decoded
+// from the code of a node on disk, to prune lists without reading them.
+func (m *merger) approximate(id uint32) []float32 {
+	if m.inserted(id) {
+		return m.vectors[int(id)-len(m.disk.keys)]
+	}
+	return m.disk.quantizer.Decode(m.disk.code(id))
+}
+
+// insert links a new node to the nodes a search for it visits, as Vamana
+// does, and links them back. The back links to nodes on disk are kept
+// until their records are written.
+func (m *merger) insert(key string, vector []float32) error {
+	id := uint32(len(m.disk.keys) + len(m.keys))
+	m.keys = append(m.keys, key)
+	m.vectors = append(m.vectors, vector)
+	m.codes = append(m.codes, m.disk.quantizer.Encode(vector)...)
+	m.neighbors = append(m.neighbors, nil)
+
+	visited, vectors, err := m.search(vector)
+	if err != nil {
+		return err
+	}
+	vectorOf := func(id uint32) []float32 {
+		return vectors[id]
+	}
+	neighbors := robustPrune(id, visited, m.params.Alpha, m.params.R, vectorOf, m.distance)
+	m.neighbors[len(m.neighbors)-1] = neighbors
+	for _, j := range neighbors {
+		if !m.inserted(j) {
+			m.backLinks[j] = append(m.backLinks[j], id)
+			continue
+		}
+		i := int(j) - len(m.disk.keys)
+		m.neighbors[i] = append(m.neighbors[i], id)
+		if len(m.neighbors[i]) > m.params.R {
+			m.neighbors[i] = m.prune(j, m.vectors[i], m.neighbors[i])
+		}
+	}
+	return nil
+}
+
+// search is the beam search of diskIndex.search over the graph on disk
+// with the inserted nodes and their links. It returns the live nodes it
+// expanded with their distance to target and their vectors.
+func (m *merger) search(target []float32) ([]candidate, map[uint32][]float32, error) {
+	table := m.disk.quantizer.DistanceTable(target)
+	list := candidateList{size: max(m.params.L, 1)}
+	list.offer(candidate{id: m.start, dist: table.Distance(m.code(m.start))})
+	seen := map[uint32]bool{m.start: true}
+	var visited []candidate
+	vectors := map[uint32][]float32{}
+	for {
+		var onDisk []uint32
+		var nodes []node
+		for i := range list.items {
+			if list.items[i].expanded {
+				continue
+			}
+			list.items[i].expanded = true
+			if id := list.items[i].id; m.inserted(id) {
+				k := int(id) - len(m.disk.keys)
+				nodes = append(nodes, node{id: id, vector: m.vectors[k], neighbors: m.neighbors[k]})
+			} else {
+				onDisk = append(onDisk, id)
+			}
+			if len(onDisk)+len(nodes) == m.params.BeamWidth {
+				break
+			}
+		}
+		if len(onDisk)+len(nodes) == 0 {
+			break
+		}
+		if len(onDisk) > 0 {
+			read, err := m.disk.readNodes(onDisk)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, n := range read {
+				n.neighbors = append(n.neighbors, m.backLinks[n.id]...)
+				nodes = append(nodes, n)
+			}
+		}
+		for _, n := range nodes {
+			if _, deleted := m.deleted[n.id]; !deleted {
+				visited = append(visited, candidate{id: n.id, dist: m.distance(n.vector, target)})
+				vectors[n.id] = n.vector
+			}
+			for _, neighbor := range n.neighbors {
+				if seen[neighbor] {
+					continue
+				}
+				seen[neighbor] = true
+				list.offer(candidate{id: neighbor, dist: table.Distance(m.code(neighbor))})
+			}
+		}
+	}
+	return visited, vectors, nil
+}
+
+// prune prunes the neighbours of node id to R, ranking them by their
+// approximate vectors.
+func (m *merger) prune(id uint32, vector []float32, neighbors []uint32) []uint32 {
+	vectors := map[uint32][]float32{id: vector}
+	candidates := make([]candidate, 0, len(neighbors))
+	for _, j := range neighbors {
+		vectors[j] = m.approximate(j)
+		candidates = append(candidates, candidate{id: j, dist: m.distance(vectors[j], vector)})
+	}
+	vectorOf := func(id uint32) []float32 {
+		return vectors[id]
+	}
+	return robustPrune(id, candidates, m.params.Alpha, m.params.R, vectorOf, m.distance)
+}
+
+// record returns the record of node id of the merged index. Survivors are
+// read from disk a block at a time, in order, and their links to deleted
+// nodes replaced with the neighbours of those nodes, as the delete
+// consolidation of FreshDiskANN does.
+func (m *merger) record(id int) ([]float32, []uint32, error) {
+	if id >= len(m.survivors) {
+		i := id - len(m.survivors)
+		return m.vectors[i], m.remap(m.neighbors[i]), nil
+	}
+	old := m.survivors[id]
+	n, ok := m.batch[old]
+	if !ok {
+		block := int(old) / m.disk.perBlock
+		end := id
+		for end < len(m.survivors) && int(m.survivors[end])/m.disk.perBlock == block {
+			end++
+		}
+		nodes, err := m.disk.readNodes(m.survivors[id:end])
+		if err != nil {
+			return nil, nil, err
+		}
+		m.batch = make(map[uint32]node, len(nodes))
+		for _, n := range nodes {
+			m.batch[n.id] = n
+		}
+		n = m.batch[old]
+	}
+
+	relink := false
+	seen := map[uint32]bool{old: true}
+	var neighbors []uint32
+	add := func(j uint32) {
+		if _, deleted := m.deleted[j]; !deleted && !seen[j] {
+			seen[j] = true
+			neighbors = append(neighbors, j)
+		}
+	}
+	for _, j := range n.neighbors {
+		if replaced, deleted := m.deleted[j]; deleted {
+			relink = true
+			for _, k := range replaced {
+				add(k)
+			}
+			continue
+		}
+		add(j)
+	}
+	for _, j := range m.backLinks[old] {
+		add(j)
+	}
+	if relink || len(neighbors) > m.params.R {
+		neighbors = m.prune(old, n.vector, neighbors)
+	}
+	return n.vector, m.remap(neighbors), nil
+}
+
+// remap returns the IDs of the neighbours in the merged index.
+func (m *merger) remap(neighbors []uint32) []uint32 {
+	remapped := make([]uint32, len(neighbors))
+	for i, j := range neighbors {
+		remapped[i] = m.final(j)
+	}
+	return remapped
+}
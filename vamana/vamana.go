@@ -0,0 +1,252 @@
+// Package vamana implements a disk-resident graph index in the style of
+// DiskANN, for collections whose vectors don't fit in memory.
+//
+// The graph is built with the Vamana algorithm: starting from a random
+// graph, every node is linked to the nodes a greedy search for it visits,
+// pruned with a factor alpha > 1 that keeps some long edges, so that
+// searches reach any region of the graph in few hops. The full vectors and
+// the links are stored on disk in fixed-size records, packed into pages
+// that a search reads a few at a time. Only product quantization codes of
+// the vectors stay in memory, to steer the search towards the pages worth
+// reading; the vectors read from disk then rank the results exactly.
+//
+// Changes made after the graph is saved are kept in memory, and the next
+// save merges them into the graph on disk as FreshDiskANN does, streaming
+// the records rather than rebuilding the graph.
+package vamana
+
+import (
+	"cmp"
+	"math/rand"
+	"slices"
+	"vectorDb/distance"
+)
+
+// Params configures the graph and its searches.
+type Params struct {
+	// R is the maximum number of neighbours of a node.
+	R int
+	// L is the size of the candidate list of the searches run to build the
+	// graph. Larger lists build a better graph, more slowly.
+	L int
+	// Alpha is the pruning factor: a candidate is dropped if a kept
+	// neighbour is alpha times closer to it than the node is. Values above
+	// 1 keep longer edges.
+	Alpha float32
+	// Subspaces is the number of product quantization subspaces, and so of
+	// bytes per vector kept in memory. It must divide the dimension; zero
+	// picks the largest divisor up to a quarter of it.
+	Subspaces int
+	// SearchL is the size of the candidate list of searches, raised to k
+	// if smaller.
+	SearchL int
+	// BeamWidth is the number of nodes a search expands at each step, and
+	// so the number of pages it reads at once.
+	BeamWidth int
+}
+
+// DefaultParams returns parameters that work well for most collections.
+func DefaultParams() Params {
+	return Params{R: 32, L: 64, Alpha: 1.2, SearchL: 64, BeamWidth: 4}
+}
+
+// subspaces returns the number of subspaces to quantize dim dimensions with.
+func (p Params) subspaces(dim int) int {
+	if p.Subspaces > 0 {
+		return p.Subspaces
+	}
+	for m := max(dim/4, 1); m > 1; m-- {
+		if dim%m == 0 {
+			return m
+		}
+	}
+	return 1
+}
+
+// candidate is a node found by a search with its distance to the target.
+type candidate struct {
+	id       uint32
+	dist     float32
+	expanded bool
+}
+
+// compareCandidates orders candidates nearest first, breaking ties by ID so
+// that builds and searches are deterministic.
+func compareCandidates(a, b candidate) int {
+	return cmp.Or(cmp.Compare(a.dist, b.dist), cmp.Compare(a.id, b.id))
+}
+
+// candidateList is the bounded list of best candidates of a greedy search,
+// nearest first.
+type candidateList struct {
+	items []candidate
+	size  int
+}
+
+// offer adds c if the list is not full or c beats its worst candidate, and
+// reports whether it was added.
+func (l *candidateList) offer(c candidate) bool {
+	if len(l.items) == l.size && compareCandidates(c, l.items[len(l.items)-1]) >= 0 {
+		return false
+	}
+	i, _ := slices.BinarySearchFunc(l.items, c, compareCandidates)
+	l.items = slices.Insert(l.items, i, c)
+	if len(l.items) > l.size {
+		l.items = l.items[:l.size]
+	}
+	return true
+}
+
+// next returns the position of the nearest unexpanded candidate, or -1.
+func (l *candidateList) next() int {
+	for i, c := range l.items {
+		if !c.expanded {
+			return i
+		}
+	}
+	return -1
+}
+
+// builder builds a Vamana graph over vectors held in memory.
+type builder struct {
+	vectors   [][]float32
+	distance  distance.Func
+	params    Params
+	neighbors [][]uint32
+}
+
+// build returns the links of a Vamana graph over the vectors and its
+// medoid, the node searches start from. The build is deterministic.
+func build(vectors [][]float32, dist distance.Func, params Params) ([][]uint32, uint32) {
+	b := &builder{vectors: vectors, distance: dist, params: params}
+	n := len(vectors)
+	if n == 0 {
+		return nil, 0
+	}
+	medoid := b.medoid()
+	rng := rand.New(rand.NewSource(1))
+
+	// Start from a random graph of degree R.
+	b.neighbors = make([][]uint32, n)
+	degree := min(params.R, n-1)
+	for i := range b.neighbors {
+		b.neighbors[i] = make([]uint32, 0, params.R)
+		for len(b.neighbors[i]) < degree {
+			j := uint32(rng.Intn(n))
+			if int(j) != i && !slices.Contains(b.neighbors[i], j) {
+				b.neighbors[i] = append(b.neighbors[i], j)
+			}
+		}
+	}
+
+	// A first pass with alpha 1 shortens the paths, and a second one with
+	// alpha adds the long edges, as in the DiskANN paper.
+	for _, alpha := range []float32{1, max(params.Alpha, 1)} {
+		for _, i := range rng.Perm(n) {
+			b.insert(uint32(i), medoid, alpha)
+		}
+	}
+	return b.neighbors, medoid
+}
+
+// medoid returns the node nearest to the centroid of the vectors.
+func (b *builder) medoid() uint32 {
+	centroid := make([]float32, len(b.vectors[0]))
+	for _, v := range b.vectors {
+		for d, x := range v {
+			centroid[d] += x
+		}
+	}
+	for d := range centroid {
+		centroid[d] /= float32(len(b.vectors))
+	}
+	best, bestDist := 0, b.distance(b.vectors[0], centroid)
+	for i, v := range b.vectors[1:] {
+		if dist := b.distance(v, centroid); dist < bestDist {
+			best, bestDist = i+1, dist
+		}
+	}
+	return uint32(best)
+}
+
+// insert relinks node i to the nodes a search for it from start visits,
+// and links them back, pruning any that exceed R neighbours.
+func (b *builder) insert(i, start uint32, alpha float32) {
+	visited := b.search(b.vectors[i], start)
+	for _, j := range b.neighbors[i] {
+		visited = append(visited, candidate{id: j, dist: b.distance(b.vectors[j], b.vectors[i])})
+	}
+	b.neighbors[i] = b.prune(i, visited, alpha)
+	for _, j := range b.neighbors[i] {
+		if slices.Contains(b.neighbors[j], i) {
+			continue
+		}
+		if len(b.neighbors[j]) < b.params.R {
+			b.neighbors[j] = append(b.neighbors[j], i)
+			continue
+		}
+		candidates := make([]candidate, 0, len(b.neighbors[j])+1)
+		for _, k := range append(b.neighbors[j], i) {
+			candidates = append(candidates, candidate{id: k, dist: b.distance(b.vectors[k], b.vectors[j])})
+		}
+		b.neighbors[j] = b.prune(j, candidates, alpha)
+	}
+}
+
+// search runs a greedy search for target from start and returns the nodes
+// it expanded, with their distance to target.
+func (b *builder) search(target []float32, start uint32) []candidate {
+	list := candidateList{size: max(b.params.L, 1)}
+	list.offer(candidate{id: start, dist: b.distance(b.vectors[start], target)})
+	seen := map[uint32]bool{start: true}
+	var visited []candidate
+	for i := list.next(); i >= 0; i = list.next() {
+		list.items[i].expanded = true
+		current := list.items[i]
+		visited = append(visited, current)
+		for _, j := range b.neighbors[current.id] {
+			if seen[j] {
+				continue
+			}
+			seen[j] = true
+			list.offer(candidate{id: j, dist: b.distance(b.vectors[j], target)})
+		}
+	}
+	return visited
+}
+
+// prune is the RobustPrune procedure of the DiskANN paper, see
+// robustPrune.
+func (b *builder) prune(i uint32, candidates []candidate, alpha float32) []uint32 {
+	vector := func(id uint32) []float32 {
+		return b.vectors[id]
+	}
+	return robustPrune(i, candidates, alpha, b.params.R, vector, b.distance)
+}
+
+// robustPrune is the RobustPrune procedure of the DiskANN paper: it keeps
+// the nearest candidate, drops every candidate it is alpha times closer to
+// than node i is, and repeats until r neighbours are kept.
+func robustPrune(i uint32, candidates []candidate, alpha float32, r int, vector func(uint32) []float32, dist distance.Func) []uint32 {
+	slices.SortFunc(candidates, compareCandidates)
+	candidates = slices.CompactFunc(candidates, func(a, b candidate) bool {
+		return a.id == b.id
+	})
+	kept := make([]uint32, 0, r)
+	dropped := make([]bool, len(candidates))
+	for c, keep := range candidates {
+		if dropped[c] || keep.id == i {
+			continue
+		}
+		kept = append(kept, keep.id)
+		if len(kept) == r {
+			break
+		}
+		for o := c + 1; o < len(candidates); o++ {
+			if !dropped[o] && alpha*dist(vector(keep.id), vector(candidates[o].id)) <= candidates[o].dist {
+				dropped[o] = true
+			}
+		}
+	}
+	return kept
+}
@@ -0,0 +1,95 @@
+package vecio
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+type csvWriter struct {
+	file   *os.File
+	writer *csv.Writer
+	dim    dims
+	row    []string
+}
+
+func createCSV(path string) (*csvWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &csvWriter{file: file, writer: csv.NewWriter(file)}, nil
+}
+
+func (w *csvWriter) Write(record Record) error {
+	if err := w.dim.check(record); err != nil {
+		return err
+	}
+	if w.row == nil {
+		// The header names the columns key, v0, v1...
+		w.row = make([]string, 1+len(record.Vector))
+		w.row[0] = "key"
+		for i := range record.Vector {
+			w.row[1+i] = "v" + strconv.Itoa(i)
+		}
+		if err := w.writer.Write(w.row); err != nil {
+			return err
+		}
+	}
+	w.row[0] = record.Key
+	for i, x := range record.Vector {
+		w.row[1+i] = strconv.FormatFloat(float64(x), 'g', -1, 32)
+	}
+	return w.writer.Write(w.row)
+}
+
+func (w *csvWriter) Close() error {
+	w.writer.Flush()
+	return errors.Join(w.writer.Error(), w.file.Close())
+}
+
+type csvReader struct {
+	file   *os.File
+	reader *csv.Reader
+	read   int
+}
+
+func openCSV(path string) (*csvReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	reader := csv.NewReader(file)
+	reader.ReuseRecord = true
+	return &csvReader{file: file, reader: reader}, nil
+}
+
+func (r *csvReader) Read() (Record, error) {
+	row, err := r.reader.Read()
+	// The header row, if any, starts with the column key.
+	if err == nil && r.read == 0 && row[0] == "key" {
+		row, err = r.reader.Read()
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	r.read++
+	if len(row) < 2 {
+		return Record{}, fmt.Errorf("record %d has no vector", r.read)
+	}
+	record := Record{Key: row[0], Vector: make([]float32, len(row)-1)}
+	for i, field := range row[1:] {
+		x, err := strconv.ParseFloat(field, 32)
+		if err != nil {
+			return Record{}, fmt.Errorf("record %d: %w", r.read, err)
+		}
+		record.Vector[i] = float32(x)
+	}
+	return record, nil
+}
+
+func (r *csvReader) Close() error {
+	return r.file.Close()
+}
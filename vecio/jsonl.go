@@ -0,0 +1,70 @@
+package vecio
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+type jsonlWriter struct {
+	file    *os.File
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+	dim     dims
+}
+
+func createJSONL(path string) (*jsonlWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	buffer := bufio.NewWriter(file)
+	return &jsonlWriter{file: file, buffer: buffer, encoder: json.NewEncoder(buffer)}, nil
+}
+
+func (w *jsonlWriter) Write(record Record) error {
+	if err := w.dim.check(record); err != nil {
+		return err
+	}
+	return w.encoder.Encode(record)
+}
+
+func (w *jsonlWriter) Close() error {
+	return errors.Join(w.buffer.Flush(), w.file.Close())
+}
+
+type jsonlReader struct {
+	file    *os.File
+	decoder *json.Decoder
+	read    int
+}
+
+func openJSONL(path string) (*jsonlReader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &jsonlReader{file: file, decoder: json.NewDecoder(bufio.NewReader(file))}, nil
+}
+
+func (r *jsonlReader) Read() (Record, error) {
+	var record Record
+	if err := r.decoder.Decode(&record); err != nil {
+		if err == io.EOF {
+			return Record{}, err
+		}
+		return Record{}, fmt.Errorf("record %d: %w", r.read+1, err)
+	}
+	r.read++
+	if record.Key == "" {
+		return Record{}, fmt.Errorf("record %d has no key", r.read)
+	}
+	return record, nil
+}
+
+func (r *jsonlReader) Close() error {
+	return r.file.Close()
+}
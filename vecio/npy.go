@@ -0,0 +1,245 @@
+package vecio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// npyMagic starts every NumPy array file.
+const npyMagic = "\x93NUMPY"
+
+// npyHeaderSize is the size of the header the writer leaves room for, and
+// rewrites once the shape is known. It fits a shape of two 20 digit
+// numbers and keeps the data 64 byte aligned, as NumPy does.
+const npyHeaderSize = 128
+
+type npyWriter struct {
+	file   *os.File
+	buffer *bufio.Writer
+	keys   *os.File
+	keyBuf *bufio.Writer
+	dim    dims
+	n      int
+	row    []byte
+}
+
+func createNPY(path string) (*npyWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := os.Create(KeysPath(path))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	w := &npyWriter{file: file, buffer: bufio.NewWriter(file), keys: keys, keyBuf: bufio.NewWriter(keys)}
+	if _, err := w.buffer.Write(npyHeader(0, 0)); err != nil {
+		w.file.Close()
+		w.keys.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// npyHeader returns the header of a float32 array of n vectors of dim
+// dimensions, padded to npyHeaderSize bytes.
+func npyHeader(n, dim int) []byte {
+	dict := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", n, dim)
+	header := make([]byte, 0, npyHeaderSize)
+	header = append(header, npyMagic...)
+	header = append(header, 1, 0)
+	header = binary.LittleEndian.AppendUint16(header, npyHeaderSize-10)
+	header = append(header, dict...)
+	for len(header) < npyHeaderSize-1 {
+		header = append(header, ' ')
+	}
+	return append(header, '\n')
+}
+
+func (w *npyWriter) Write(record Record) error {
+	if err := w.dim.check(record); err != nil {
+		return err
+	}
+	if strings.ContainsAny(record.Key, "\r\n") {
+		return fmt.Errorf("key %q has a line break, which the keys file cannot hold", record.Key)
+	}
+	if _, err := w.keyBuf.WriteString(record.Key + "\n"); err != nil {
+		return err
+	}
+	w.row = w.row[:0]
+	for _, x := range record.Vector {
+		w.row = binary.LittleEndian.AppendUint32(w.row, math.Float32bits(x))
+	}
+	if _, err := w.buffer.Write(w.row); err != nil {
+		return err
+	}
+	w.n++
+	return nil
+}
+
+// Close writes the shape of the array in its header.
+func (w *npyWriter) Close() error {
+	err := w.buffer.Flush()
+	if err == nil {
+		_, err = w.file.WriteAt(npyHeader(w.n, int(w.dim)), 0)
+	}
+	return errors.Join(err, w.keyBuf.Flush(), w.file.Close(), w.keys.Close())
+}
+
+type npyReader struct {
+	file    *os.File
+	buffer  *bufio.Reader
+	keys    *bufio.Scanner
+	keyFile *os.File
+	// width is the size of an element, 4 for float32 and 8 for float64.
+	width int
+	n     int
+	dim   int
+	read  int
+	row   []byte
+}
+
+var (
+	npyDescr   = regexp.MustCompile(`'descr':\s*'([^']*)'`)
+	npyFortran = regexp.MustCompile(`'fortran_order':\s*(True|False)`)
+	npyShape   = regexp.MustCompile(`'shape':\s*\(([^)]*)\)`)
+)
+
+func openNPY(path string) (r *npyReader, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r = &npyReader{file: file, buffer: bufio.NewReader(file)}
+	defer func() {
+		if err != nil {
+			r.Close()
+		}
+	}()
+	if err := r.readHeader(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	// Without a keys file, as for an array saved from NumPy, the keys are
+	// the row numbers.
+	keyFile, err := os.Open(KeysPath(path))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		r.keyFile = keyFile
+		r.keys = bufio.NewScanner(keyFile)
+		r.keys.Buffer(nil, 1<<20)
+	}
+	r.row = make([]byte, r.dim*r.width)
+	return r, nil
+}
+
+// readHeader reads the header of the array and checks that it holds the
+// rows of a matrix of floats.
+func (r *npyReader) readHeader() error {
+	prefix := make([]byte, len(npyMagic)+2)
+	if _, err := io.ReadFull(r.buffer, prefix); err != nil || string(prefix[:len(npyMagic)]) != npyMagic {
+		return errors.New("not a NumPy array file")
+	}
+	var size int
+	switch major := prefix[len(npyMagic)]; major {
+	case 1:
+		var size16 uint16
+		if err := binary.Read(r.buffer, binary.LittleEndian, &size16); err != nil {
+			return err
+		}
+		size = int(size16)
+	case 2, 3:
+		var size32 uint32
+		if err := binary.Read(r.buffer, binary.LittleEndian, &size32); err != nil {
+			return err
+		}
+		size = int(size32)
+	default:
+		return fmt.Errorf("unsupported NumPy file version %d", major)
+	}
+	header := make([]byte, size)
+	if _, err := io.ReadFull(r.buffer, header); err != nil {
+		return err
+	}
+
+	descr := npyDescr.FindSubmatch(header)
+	fortran := npyFortran.FindSubmatch(header)
+	shape := npyShape.FindSubmatch(header)
+	if descr == nil || fortran == nil || shape == nil {
+		return errors.New("invalid NumPy array header")
+	}
+	switch string(descr[1]) {
+	case "<f4":
+		r.width = 4
+	case "<f8":
+		r.width = 8
+	default:
+		return fmt.Errorf("unsupported array type %s, expected <f4 or <f8", descr[1])
+	}
+	if string(fortran[1]) == "True" {
+		return errors.New("arrays in Fortran order are not supported")
+	}
+	var dims []int
+	for _, field := range strings.Split(string(shape[1]), ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		d, err := strconv.Atoi(field)
+		if err != nil {
+			return fmt.Errorf("invalid array shape (%s)", shape[1])
+		}
+		dims = append(dims, d)
+	}
+	if len(dims) != 2 {
+		return fmt.Errorf("array of shape (%s) is not a matrix of vectors", shape[1])
+	}
+	r.n, r.dim = dims[0], dims[1]
+	return nil
+}
+
+func (r *npyReader) Read() (Record, error) {
+	if r.read == r.n {
+		return Record{}, io.EOF
+	}
+	if _, err := io.ReadFull(r.buffer, r.row); err != nil {
+		return Record{}, fmt.Errorf("vector %d: %w", r.read, err)
+	}
+	record := Record{Key: strconv.Itoa(r.read), Vector: make([]float32, r.dim)}
+	for i := range record.Vector {
+		if r.width == 8 {
+			record.Vector[i] = float32(math.Float64frombits(binary.LittleEndian.Uint64(r.row[8*i:])))
+		} else {
+			record.Vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(r.row[4*i:]))
+		}
+	}
+	if r.keys != nil {
+		if !r.keys.Scan() {
+			if err := r.keys.Err(); err != nil {
+				return Record{}, err
+			}
+			return Record{}, fmt.Errorf("the keys file ends at vector %d of %d", r.read, r.n)
+		}
+		record.Key = r.keys.Text()
+	}
+	r.read++
+	return record, nil
+}
+
+func (r *npyReader) Close() error {
+	err := r.file.Close()
+	if r.keyFile != nil {
+		err = errors.Join(err, r.keyFile.Close())
+	}
+	return err
+}
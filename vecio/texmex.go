@@ -0,0 +1,82 @@
+package vecio
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// The TEXMEX formats of the SIFT and GIST benchmark datasets store each
+// vector as its dimension, a little endian int32, followed by its
+// components: float32s in .fvecs files, int32s in .ivecs files, which hold
+// the ids of the ground truth neighbours, and bytes in .bvecs files.
+
+// ReadFvecs reads the vectors of a .fvecs file, or its first limit vectors
+// if limit > 0.
+func ReadFvecs(path string, limit int) ([][]float32, error) {
+	return readVecs(path, limit, 4, func(b []byte) float32 {
+		return math.Float32frombits(binary.LittleEndian.Uint32(b))
+	})
+}
+
+// ReadIvecs reads the vectors of an .ivecs file, or its first limit
+// vectors if limit > 0.
+func ReadIvecs(path string, limit int) ([][]int32, error) {
+	return readVecs(path, limit, 4, func(b []byte) int32 {
+		return int32(binary.LittleEndian.Uint32(b))
+	})
+}
+
+// ReadBvecs reads the vectors of a .bvecs file as float32s, or its first
+// limit vectors if limit > 0.
+func ReadBvecs(path string, limit int) ([][]float32, error) {
+	return readVecs(path, limit, 1, func(b []byte) float32 {
+		return float32(b[0])
+	})
+}
+
+// readVecs reads the vectors of a TEXMEX file whose components take size
+// bytes each, decoded by decode. All the vectors must have the same
+// dimension.
+func readVecs[T any](path string, limit int, size int, decode func([]byte) T) ([][]T, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	var vectors [][]T
+	var row []byte
+	for limit <= 0 || len(vectors) < limit {
+		var dim int32
+		err := binary.Read(reader, binary.LittleEndian, &dim)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: vector %d: %w", path, len(vectors), err)
+		}
+		if dim <= 0 || (len(vectors) > 0 && int(dim) != len(vectors[0])) {
+			return nil, fmt.Errorf("%s: vector %d has invalid dimension %d", path, len(vectors), dim)
+		}
+		if row == nil {
+			row = make([]byte, int(dim)*size)
+		}
+		if _, err := io.ReadFull(reader, row); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, fmt.Errorf("%s: vector %d: %w", path, len(vectors), err)
+		}
+		vector := make([]T, dim)
+		for i := range vector {
+			vector[i] = decode(row[i*size:])
+		}
+		vectors = append(vectors, vector)
+	}
+	return vectors, nil
+}
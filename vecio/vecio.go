@@ -0,0 +1,122 @@
+// Package vecio reads and writes the vectors of a collection in formats
+// other tools understand, to migrate collections to and from other vector
+// databases or analyse them offline, with NumPy for instance.
+//
+// Three formats are supported:
+//
+//   - JSONL: one JSON object per line, {"key": "...", "vector": [...]}.
+//     Other fields, such as the metadata other databases export, are
+//     ignored on reading.
+//   - CSV: a header row, then one row per vector holding the key and one
+//     column per dimension.
+//   - NPY: a NumPy array of shape (n, dim), with the keys one per line in
+//     a sidecar file, see KeysPath. Arrays of float32 and float64 are read;
+//     float32 is written.
+//
+// The stores keep no metadata, so a record is a key and its vector.
+package vecio
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Format names a file format.
+type Format string
+
+const (
+	JSONL Format = "jsonl"
+	CSV   Format = "csv"
+	NPY   Format = "npy"
+)
+
+// Formats lists the supported formats.
+var Formats = []Format{JSONL, CSV, NPY}
+
+// ParseFormat returns the format of the given name.
+func ParseFormat(name string) (Format, error) {
+	for _, format := range Formats {
+		if string(format) == strings.ToLower(name) {
+			return format, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q, expected one of %v", name, Formats)
+}
+
+// FormatOf returns the format of a file from its extension, and false if
+// the extension is not that of a supported format.
+func FormatOf(path string) (Format, bool) {
+	format, err := ParseFormat(strings.TrimPrefix(filepath.Ext(path), "."))
+	return format, err == nil
+}
+
+// KeysPath returns the sidecar file holding the keys of the NumPy array at
+// path: vectors.keys for vectors.npy.
+func KeysPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".keys"
+}
+
+// Record is a vector and its key.
+type Record struct {
+	Key    string    `json:"key"`
+	Vector []float32 `json:"vector"`
+}
+
+// Writer writes records to a file. All the vectors must have the same
+// dimension. Close must be called to complete the file.
+type Writer interface {
+	Write(record Record) error
+	Close() error
+}
+
+// Reader reads the records of a file one by one. Read returns io.EOF after
+// the last record.
+type Reader interface {
+	Read() (Record, error)
+	Close() error
+}
+
+// Create creates the file at path, replacing any file there, and returns a
+// writer of records in the given format.
+func Create(path string, format Format) (Writer, error) {
+	switch format {
+	case JSONL:
+		return createJSONL(path)
+	case CSV:
+		return createCSV(path)
+	case NPY:
+		return createNPY(path)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// Open opens the file at path and returns a reader of its records in the
+// given format.
+func Open(path string, format Format) (Reader, error) {
+	switch format {
+	case JSONL:
+		return openJSONL(path)
+	case CSV:
+		return openCSV(path)
+	case NPY:
+		return openNPY(path)
+	}
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// dims checks that every vector written has the dimension of the first.
+type dims int
+
+func (d *dims) check(record Record) error {
+	if *d == 0 {
+		if len(record.Vector) == 0 {
+			return fmt.Errorf("empty vector for %q", record.Key)
+		}
+		*d = dims(len(record.Vector))
+	}
+	if len(record.Vector) != int(*d) {
+		return fmt.Errorf("vector dimension mismatch for %q: %d != %d", record.Key, *d, len(record.Vector))
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+// Package wal implements the write-ahead log that makes inserts and deletes
+// durable between two saves of a collection.
+//
+// A log is a file of records appended in the order the mutations were made.
+// Each record is framed by its length and a CRC-32C checksum of its payload,
+// so a record torn by a crash is detected and dropped on replay. Saving the
+// collection checkpoints the log, which empties it.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+var byteOrder = binary.LittleEndian
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// frameSize is the size of the header before each payload: the payload
+// length and its checksum.
+const frameSize = 8
+
+// maxPayload bounds the length read from a frame, so that a corrupt length
+// is caught before it is allocated.
+const maxPayload = 1 << 30
+
+// Op is the kind of mutation a record logs.
+type Op byte
+
+const (
+	OpInsert Op = iota + 1
+	OpDelete
+)
+
+// Record is one logged mutation.
+type Record struct {
+	Op        Op
+	Key       string
+	Embedding []float32
+}
+
+// SyncPolicy decides when appended records are synced to disk.
+type SyncPolicy int
+
+const (
+	// SyncAlways syncs every record before Append returns, so an
+	// acknowledged mutation survives a power loss.
+	SyncAlways SyncPolicy = iota
+	// SyncInterval syncs the log every Options.Interval. A crash of the
+	// process loses nothing, a power loss up to one interval of records.
+	SyncInterval
+	// SyncNone leaves syncing to the operating system.
+	SyncNone
+)
+
+// Options configures a log.
+type Options struct {
+	Sync SyncPolicy
+	// Interval is the sync period of SyncInterval. Zero means one second.
+	Interval time.Duration
+}
+
+// ParseSyncPolicy returns the policy named always, interval or none.
+func ParseSyncPolicy(name string) (SyncPolicy, error) {
+	switch name {
+	case "always":
+		return SyncAlways, nil
+	case "interval":
+		return SyncInterval, nil
+	case "none":
+		return SyncNone, nil
+	}
+	return 0, fmt.Errorf("unknown sync policy %q, expected always, interval or none", name)
+}
+
+// Log is an open write-ahead log. Its methods are safe for concurrent use.
+type Log struct {
+	mu      sync.Mutex
+	f       *os.File
+	options Options
+	// size is the length of the valid records, where the next one goes.
+	size  int64
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// Open opens the log at path, creating it if needed. If apply is not nil,
+// the records already in the log are passed to it in order first.
+//
+// Replay stops at the first record that is incomplete or fails its
+// checksum, which is where a crash interrupted an append; that record and
+// anything after it are cut off the log before new records are appended.
+func Open(path string, options Options, apply func(Record) error) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	end, err := replay(f, apply)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	l := &Log{f: f, options: options, size: end}
+	if options.Sync == SyncInterval {
+		if l.options.Interval <= 0 {
+			l.options.Interval = time.Second
+		}
+		l.stop, l.done = make(chan struct{}), make(chan struct{})
+		go l.syncEvery(l.options.Interval)
+	}
+	return l, nil
+}
+
+// replay reads the records of f from the start and returns the offset just
+// past the last valid one. Only the errors of reading f and of apply are
+// returned; a torn or corrupt record ends the log.
+func replay(f *os.File, apply func(Record) error) (int64, error) {
+	r := &countingReader{r: bufio.NewReader(f)}
+	var header [frameSize]byte
+	var end int64
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return end, nil
+			}
+			return end, err
+		}
+		size, sum := byteOrder.Uint32(header[:4]), byteOrder.Uint32(header[4:])
+		if size > maxPayload {
+			return end, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				return end, nil
+			}
+			return end, err
+		}
+		if crc32.Checksum(payload, castagnoli) != sum {
+			return end, nil
+		}
+		record, err := decode(payload)
+		if err != nil {
+			return end, nil
+		}
+		if apply != nil {
+			if err := apply(record); err != nil {
+				return end, err
+			}
+		}
+		end = r.n
+	}
+}
+
+// Append writes a record to the end of the log, syncing it first under
+// SyncAlways.
+func (l *Log) Append(record Record) error {
+	return l.AppendAll([]Record{record})
+}
+
+// AppendAll writes records to the end of the log with a single write, and
+// under SyncAlways a single sync.
+func (l *Log) AppendAll(records []Record) error {
+	var buf bytes.Buffer
+	for _, record := range records {
+		payload := encode(record)
+		var header [frameSize]byte
+		byteOrder.PutUint32(header[:4], uint32(len(payload)))
+		byteOrder.PutUint32(header[4:], crc32.Checksum(payload, castagnoli))
+		buf.Write(header[:])
+		buf.Write(payload)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	if _, err := l.f.Write(buf.Bytes()); err != nil {
+		// Cut off what was written, so that later records don't follow a
+		// torn one and get lost on replay.
+		l.f.Truncate(l.size)
+		l.f.Seek(l.size, io.SeekStart)
+		return err
+	}
+	l.size += int64(buf.Len())
+	if l.options.Sync == SyncAlways {
+		return l.f.Sync()
+	}
+	l.dirty = true
+	return nil
+}
+
+// Checkpoint empties the log. Call it once the mutations it holds are in a
+// snapshot that reached the disk.
+func (l *Log) Checkpoint() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := l.f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	l.size, l.dirty = 0, false
+	return l.f.Sync()
+}
+
+// Sync syncs the records appended so far to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sync()
+}
+
+func (l *Log) sync() error {
+	if l.f == nil || !l.dirty {
+		return nil
+	}
+	l.dirty = false
+	return l.f.Sync()
+}
+
+// Close syncs and closes the log.
+func (l *Log) Close() error {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+		l.stop = nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.sync()
+	if closeErr := l.f.Close(); err == nil {
+		err = closeErr
+	}
+	l.f = nil
+	return err
+}
+
+// syncEvery syncs the log every interval until Close.
+func (l *Log) syncEvery(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.Sync()
+		}
+	}
+}
+
+// encode returns the payload of a record: the op, the key and the
+// embedding, lengths as uvarints and floats as little-endian bits.
+func encode(record Record) []byte {
+	buf := make([]byte, 0, 1+2*binary.MaxVarintLen64+len(record.Key)+4*len(record.Embedding))
+	buf = append(buf, byte(record.Op))
+	buf = binary.AppendUvarint(buf, uint64(len(record.Key)))
+	buf = append(buf, record.Key...)
+	buf = binary.AppendUvarint(buf, uint64(len(record.Embedding)))
+	for _, v := range record.Embedding {
+		buf = byteOrder.AppendUint32(buf, math.Float32bits(v))
+	}
+	return buf
+}
+
+var errCorrupt = errors.New("corrupt record")
+
+// decode parses a payload written by encode.
+func decode(payload []byte) (Record, error) {
+	if len(payload) == 0 {
+		return Record{}, errCorrupt
+	}
+	record := Record{Op: Op(payload[0])}
+	if record.Op != OpInsert && record.Op != OpDelete {
+		return Record{}, errCorrupt
+	}
+	rest := payload[1:]
+	n, read := binary.Uvarint(rest)
+	if read <= 0 || n > uint64(len(rest)-read) {
+		return Record{}, errCorrupt
+	}
+	rest = rest[read:]
+	record.Key, rest = string(rest[:n]), rest[n:]
+	n, read = binary.Uvarint(rest)
+	if read <= 0 || n != uint64(len(rest)-read)/4 || uint64(len(rest)-read)%4 != 0 {
+		return Record{}, errCorrupt
+	}
+	rest = rest[read:]
+	if n > 0 {
+		record.Embedding = make([]float32, n)
+		for i := range record.Embedding {
+			record.Embedding[i] = math.Float32frombits(byteOrder.Uint32(rest[4*i:]))
+		}
+	}
+	return record, nil
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}